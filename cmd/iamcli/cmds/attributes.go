@@ -0,0 +1,156 @@
+package cmds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/jedib0t/go-pretty/table"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+var attributeRootCommand = &cobra.Command{
+	Use:     "attributes",
+	Aliases: []string{"attribute", "attrs"},
+	Short:   "Manage the schema of user attributes.",
+}
+
+var listAttributesCommand = &cobra.Command{
+	Use:   "list",
+	Short: "List all attribute definitions.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ac := iamClient.Attributes()
+
+		if jsonSchema, _ := cmd.Flags().GetBool("json-schema"); jsonSchema {
+			schema, err := ac.JSONSchema(context.Background())
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			blob, err := json.MarshalIndent(schema, "", "  ")
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Println(string(blob))
+			return
+		}
+
+		schema, err := ac.Schema(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tw := table.NewWriter()
+		tw.AppendHeader(table.Row{"Name", "Type", "Format", "Required", "Description"})
+
+		for _, def := range schema {
+			tw.AppendRow(table.Row{def.Name, def.Type, def.Format, def.Required, def.Description})
+		}
+
+		tw.SetStyle(table.StyleLight)
+		tw.Style().Options.SeparateColumns = false
+		tw.Style().Options.DrawBorder = false
+
+		fmt.Println(tw.Render())
+	},
+}
+
+var getAttributeCommand = &cobra.Command{
+	Use:     "get",
+	Short:   "Display the definition of an attribute.",
+	Aliases: []string{"load", "show"},
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		def, err := iamClient.Attributes().Load(context.Background(), args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		blob, err := yaml.Marshal(def)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(string(blob))
+	},
+}
+
+var defineAttributeCommand = &cobra.Command{
+	Use:   "define",
+	Short: "Create or replace the definition of an attribute.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		attrType, _ := cmd.Flags().GetString("type")
+		format, _ := cmd.Flags().GetString("format")
+		required, _ := cmd.Flags().GetBool("required")
+		defaultValue, _ := cmd.Flags().GetString("default")
+		enum, _ := cmd.Flags().GetStringSlice("enum")
+		description, _ := cmd.Flags().GetString("description")
+
+		def := iam.AttributeDefinition{
+			Name:        args[0],
+			Type:        iam.AttributeType(attrType),
+			Format:      iam.AttributeFormat(format),
+			Required:    required,
+			Description: description,
+		}
+
+		if defaultValue != "" {
+			def.Default = parseAttrValue(defaultValue)
+		}
+
+		for _, e := range enum {
+			def.Enum = append(def.Enum, parseAttrValue(e))
+		}
+
+		if err := iamClient.Attributes().Define(context.Background(), def); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var deleteAttributeCommand = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete the definition of an attribute.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := iamClient.Attributes().Delete(context.Background(), args[0]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// parseAttrValue parses s as JSON and falls back to s itself if it is
+// not valid JSON so strings don't need to be quoted.
+func parseAttrValue(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(s)), &v); err != nil {
+		return s
+	}
+	return v
+}
+
+func init() {
+	RootCommand.AddCommand(attributeRootCommand)
+
+	listAttributesCommand.Flags().Bool("json-schema", false, "Print the schema as JSON Schema")
+
+	defineAttributeCommand.Flags().StringP("type", "t", "string", "Type of the attribute (string, number, integer, boolean, array or object)")
+	defineAttributeCommand.Flags().StringP("format", "f", "", "Format of string values (email, phone, uri, date or date-time)")
+	defineAttributeCommand.Flags().BoolP("required", "r", false, "Require users to have the attribute set")
+	defineAttributeCommand.Flags().String("default", "", "Default value as JSON or plain string")
+	defineAttributeCommand.Flags().StringSlice("enum", nil, "Allowed values as JSON or plain strings")
+	defineAttributeCommand.Flags().StringP("description", "d", "", "Description of the attribute")
+
+	attributeRootCommand.AddCommand(
+		listAttributesCommand,
+		getAttributeCommand,
+		defineAttributeCommand,
+		deleteAttributeCommand,
+	)
+}
@@ -1,16 +1,91 @@
 package cmds
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
 	"github.com/spf13/cobra"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/client"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
 
 var iamClient *client.IdentityClient
 
+func init() {
+	RootCommand.PersistentFlags().String("cert", "", `Path to a TLS client certificate used to authenticate against IAM.
+If set, no access token is sent unless --access-token is set explicitly.`)
+	RootCommand.PersistentFlags().String("key", "", "Path to the private key for --cert.")
+	RootCommand.PersistentFlags().String("ca", "", "Path to a PEM encoded CA bundle used to verify the IAM server certificate.")
+	RootCommand.PersistentFlags().String("as", "", "Impersonate the given user. Requires the iam:user:impersonate permission.")
+}
+
 func initClient(cmd *cobra.Command) error {
-	iamClient = client.NewIdentityClient(iamServerURL,
+	opts := []client.Option{
 		client.WithTokenLoader(tokenStore),
-	)
+	}
+
+	tlsConfig, err := getClientTLSConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	if tlsConfig != nil {
+		opts = append(opts, client.WithTLSConfig(tlsConfig))
+
+		if len(tlsConfig.Certificates) > 0 && !cmd.Flags().Changed("access-token") {
+			opts = append(opts, client.WithTokenLoader(nil))
+		}
+	}
+
+	if as, _ := cmd.Flags().GetString("as"); as != "" {
+		urn := iam.UserURN(as)
+		if urn.AccountID() == "" {
+			urn = iam.UserURN("urn:iam::user/" + urn)
+		}
+
+		opts = append(opts, client.WithImpersonation(urn))
+	}
+
+	iamClient = client.NewIdentityClient(iamServerURL, opts...)
 
 	return nil
 }
+
+func getClientTLSConfig(cmd *cobra.Command) (*tls.Config, error) {
+	var (
+		cert, _ = cmd.Flags().GetString("cert")
+		key, _  = cmd.Flags().GetString("key")
+		ca, _   = cmd.Flags().GetString("ca")
+	)
+
+	if cert == "" && ca == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+
+	if cert != "" {
+		keyPair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Certificates = []tls.Certificate{keyPair}
+	}
+
+	if ca != "" {
+		bundle, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("%s: no certificates found", ca)
+		}
+	}
+
+	return cfg, nil
+}
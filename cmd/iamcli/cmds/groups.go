@@ -22,17 +22,36 @@ var listGroupsCommand = &cobra.Command{
 	Short: "List all groups stored in IAM.",
 	Run: func(cmd *cobra.Command, args []string) {
 		gc := iamClient.Groups()
+		sortBy, _ := cmd.Flags().GetString("sort")
+		wide, _ := cmd.Flags().GetBool("wide")
 
-		groups, err := gc.Get(context.Background())
+		filter, err := getMetadataFilter(cmd)
 		if err != nil {
 			log.Fatal(err)
 		}
 
+		groups, err := gc.Query(context.Background(), iam.ListQuery{
+			MetadataFilter: filter,
+			SortBy:         iam.SortField(sortBy),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		header := table.Row{"GroupName", "Comment", "URN"}
+		if wide {
+			header = append(header, metadataHeader...)
+		}
+
 		tw := table.NewWriter()
-		tw.AppendHeader(table.Row{"GroupName", "Comment", "URN"})
+		tw.AppendHeader(header)
 
 		for _, g := range groups {
-			tw.AppendRow(table.Row{g.Name, g.Comment, g.ID})
+			row := table.Row{g.Name, g.Comment, g.ID}
+			if wide {
+				row = append(row, metadataColumns(g.Metadata)...)
+			}
+			tw.AppendRow(row)
 		}
 
 		tw.SetStyle(table.StyleLight)
@@ -198,6 +217,9 @@ var deleteMemberCommand = &cobra.Command{
 func init() {
 	RootCommand.AddCommand(groupRootCommand)
 
+	listGroupsCommand.Flags().String("sort", "name", "Sort groups by name, createdAt or updatedAt.")
+	addMetadataFlags(listGroupsCommand)
+
 	createGroupCommand.Flags().StringP("comment", "c", "", "Comment for the new group")
 
 	addMemberCommand.Flags().StringP("user", "u", "", "Username to add to the group.")
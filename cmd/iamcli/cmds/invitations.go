@@ -0,0 +1,161 @@
+package cmds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/jedib0t/go-pretty/table"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var invitationRootCommand = &cobra.Command{
+	Use:     "invitations",
+	Aliases: []string{"invitation", "invites"},
+	Short:   "Invite users and manage pending invitations.",
+}
+
+var createInvitationCommand = &cobra.Command{
+	Use:   "create",
+	Short: "Invite a new user. The invite token is delivered to the invitee.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flagAttrs, _ := cmd.Flags().GetStringSlice("attr")
+		flagGroups, _ := cmd.Flags().GetStringSlice("group")
+
+		attrs := make(map[string]interface{})
+		for _, value := range flagAttrs {
+			parts := strings.SplitN(value, "=", 2)
+			if len(parts) != 2 {
+				log.Fatal("Invalid format in attribute " + value)
+			}
+
+			attrs[parts[0]] = parseAttrValue(parts[1])
+		}
+
+		var groups []iam.GroupURN
+		for _, name := range flagGroups {
+			grp := iam.GroupURN(name)
+			if !grp.IsValid() {
+				grp = iam.GroupURN("urn:iam::group/" + name)
+			}
+			groups = append(groups, grp)
+		}
+
+		inv, err := iamClient.Invitations().Invite(context.Background(), args[0], attrs, groups)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Println(inv.ID)
+	},
+}
+
+var listInvitationsCommand = &cobra.Command{
+	Use:   "list",
+	Short: "List invitations.",
+	Run: func(cmd *cobra.Command, args []string) {
+		state, _ := cmd.Flags().GetString("state")
+
+		invitations, err := iamClient.Invitations().Invitations(context.Background(), state)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tw := table.NewWriter()
+		tw.AppendHeader(table.Row{"ID", "Username", "Groups", "Created By", "Expires", "Expired"})
+
+		now := time.Now()
+		for _, inv := range invitations {
+			tw.AppendRow(table.Row{inv.ID, inv.Username, len(inv.Groups), inv.CreatedBy, inv.ExpiresAt.Local().Format(time.RFC3339), inv.IsExpired(now)})
+		}
+
+		tw.SetStyle(table.StyleLight)
+		tw.Style().Options.SeparateColumns = false
+		tw.Style().Options.DrawBorder = false
+
+		fmt.Println(tw.Render())
+	},
+}
+
+var getInvitationCommand = &cobra.Command{
+	Use:     "get",
+	Short:   "Display an invitation.",
+	Aliases: []string{"load", "show"},
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inv, err := iamClient.Invitations().Load(context.Background(), args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		blob, err := yaml.Marshal(inv)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(string(blob))
+	},
+}
+
+var revokeInvitationCommand = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke a pending or expired invitation.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := iamClient.Invitations().Revoke(context.Background(), args[0]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var acceptInvitationCommand = &cobra.Command{
+	Use:   "accept",
+	Short: "Accept an invitation using the invite token and choose a password.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		password, _ := cmd.Flags().GetString("password")
+
+		if password == "" {
+			fmt.Print("Password: ")
+			pwd, err := terminal.ReadPassword(0)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("")
+
+			password = string(pwd)
+		}
+
+		urn, err := iamClient.Invitations().Accept(context.Background(), args[0], password)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Println(urn)
+	},
+}
+
+func init() {
+	RootCommand.AddCommand(invitationRootCommand)
+
+	createInvitationCommand.Flags().StringSliceP("attr", "a", nil, "Attributes of the invited user using <key>=<value>. Values may be JSON")
+	createInvitationCommand.Flags().StringSliceP("group", "g", nil, "Names or URNs of groups the invited user is added to")
+
+	listInvitationsCommand.Flags().String("state", "", "Only list pending or expired invitations")
+
+	acceptInvitationCommand.Flags().StringP("password", "p", "", "Password of the new user. Prompted for if not set.")
+
+	invitationRootCommand.AddCommand(
+		createInvitationCommand,
+		listInvitationsCommand,
+		getInvitationCommand,
+		revokeInvitationCommand,
+		acceptInvitationCommand,
+	)
+}
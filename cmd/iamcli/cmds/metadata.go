@@ -0,0 +1,58 @@
+package cmds
+
+import (
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// addMetadataFlags adds flags to filter list results by their creation
+// and update metadata and to show the metadata in the output.
+func addMetadataFlags(cmd *cobra.Command) {
+	cmd.Flags().String("created-by", "", "Only list entries created by the subject.")
+	cmd.Flags().String("updated-by", "", "Only list entries last updated by the subject.")
+	cmd.Flags().String("created-after", "", "Only list entries created after the time (RFC 3339).")
+	cmd.Flags().String("created-before", "", "Only list entries created before the time (RFC 3339).")
+	cmd.Flags().String("updated-after", "", "Only list entries last updated after the time (RFC 3339).")
+	cmd.Flags().String("updated-before", "", "Only list entries last updated before the time (RFC 3339).")
+	cmd.Flags().BoolP("wide", "w", false, "Show when and by whom entries have been created and last updated.")
+}
+
+// getMetadataFilter returns the filter configured by the flags added
+// with addMetadataFlags.
+func getMetadataFilter(cmd *cobra.Command) (iam.MetadataFilter, error) {
+	var (
+		createdBy, _     = cmd.Flags().GetString("created-by")
+		updatedBy, _     = cmd.Flags().GetString("updated-by")
+		createdAfter, _  = cmd.Flags().GetString("created-after")
+		createdBefore, _ = cmd.Flags().GetString("created-before")
+		updatedAfter, _  = cmd.Flags().GetString("updated-after")
+		updatedBefore, _ = cmd.Flags().GetString("updated-before")
+	)
+
+	return iam.ParseMetadataFilter(map[string][]string{
+		"createdBy":     {createdBy},
+		"updatedBy":     {updatedBy},
+		"createdAfter":  {createdAfter},
+		"createdBefore": {createdBefore},
+		"updatedAfter":  {updatedAfter},
+		"updatedBefore": {updatedBefore},
+	})
+}
+
+// metadataHeader holds the table columns added by metadataColumns.
+var metadataHeader = table.Row{"Created", "Created By", "Updated", "Updated By"}
+
+// metadataColumns returns the table columns showing m.
+func metadataColumns(m iam.Metadata) table.Row {
+	return table.Row{formatTime(m.CreatedAt), m.CreatedBy, formatTime(m.UpdatedAt), m.UpdatedBy}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC3339)
+}
@@ -3,12 +3,17 @@ package cmds
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/ghodss/yaml"
 	"github.com/jedib0t/go-pretty/table"
 	"github.com/spf13/cobra"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/client"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -25,16 +30,39 @@ var listUsersCommand = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		uc := iamClient.Users()
 
-		users, err := uc.Users(context.Background())
+		q, err := getUserQuery(cmd)
 		if err != nil {
 			log.Fatal(err)
 		}
 
+		wide, _ := cmd.Flags().GetBool("wide")
+
+		header := table.Row{"", "Username", "URN"}
+		if q.Deleted {
+			header = append(header, "Deleted")
+		}
+		if wide {
+			header = append(header, metadataHeader...)
+		}
+
 		tw := table.NewWriter()
-		tw.AppendHeader(table.Row{"", "Username", "URN"})
+		tw.AppendHeader(header)
 
-		for _, u := range users {
-			tw.AppendRow(table.Row{u.AccountID, u.Username, u.ID})
+		it := uc.IterateUsers(context.Background(), q)
+		for it.Next() {
+			u := it.User()
+
+			row := table.Row{u.AccountID, u.Username, u.ID}
+			if q.Deleted && u.DeletedAt != nil {
+				row = append(row, formatTime(*u.DeletedAt))
+			}
+			if wide {
+				row = append(row, metadataColumns(u.Metadata)...)
+			}
+			tw.AppendRow(row)
+		}
+		if err := it.Err(); err != nil {
+			log.Fatal(err)
 		}
 
 		tw.SetStyle(table.StyleLight)
@@ -45,21 +73,84 @@ var listUsersCommand = &cobra.Command{
 	},
 }
 
+func getUserQuery(cmd *cobra.Command) (iam.UserQuery, error) {
+	var (
+		prefix, _   = cmd.Flags().GetString("prefix")
+		group, _    = cmd.Flags().GetString("group")
+		attrs, _    = cmd.Flags().GetStringSlice("attr")
+		sortBy, _   = cmd.Flags().GetString("sort")
+		pageSize, _ = cmd.Flags().GetInt("page-size")
+		deleted, _  = cmd.Flags().GetBool("deleted")
+	)
+
+	filter, err := getMetadataFilter(cmd)
+	if err != nil {
+		return iam.UserQuery{}, err
+	}
+
+	q := iam.UserQuery{
+		MetadataFilter: filter,
+		UsernamePrefix: prefix,
+		Group:          iam.GroupURN(group),
+		SortBy:         iam.UserSortField(sortBy),
+		Limit:          pageSize,
+		Deleted:        deleted,
+	}
+
+	if cmd.Flags().Changed("locked") {
+		locked, _ := cmd.Flags().GetBool("locked")
+		q.Locked = &locked
+	}
+
+	for _, a := range attrs {
+		parts := strings.SplitN(a, "=", 2)
+		if len(parts) != 2 {
+			return q, fmt.Errorf("invalid attribute filter %q", a)
+		}
+
+		if q.Attributes == nil {
+			q.Attributes = make(map[string]string)
+		}
+		q.Attributes[parts[0]] = parts[1]
+	}
+
+	return q, nil
+}
+
 var loadUserCommand = &cobra.Command{
 	Use:     "get",
 	Aliases: []string{"load", "show"},
 	Short:   "Load all data available for a given user.",
-	Args:    cobra.ExactArgs(1),
+	Long:    "Load a user by URN or account ID. Use --username or --attr key=value to look up users by their username or a unique attribute instead.",
+	Args:    cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		uc := iamClient.Users()
 
-		urn := iam.UserURN(args[0])
-
-		if urn.AccountID() == "" {
-			urn = iam.UserURN("urn:iam::user/" + urn)
+		username, _ := cmd.Flags().GetString("username")
+		attr, _ := cmd.Flags().GetString("attr")
+
+		var (
+			user iam.User
+			err  error
+		)
+		switch {
+		case username != "":
+			user, err = uc.LoadUserByUsername(context.Background(), username)
+		case attr != "":
+			parts := strings.SplitN(attr, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("invalid attribute %q, expected key=value", attr)
+			}
+			user, err = uc.LoadUserByAttr(context.Background(), parts[0], parts[1])
+		case len(args) == 1:
+			urn := iam.UserURN(args[0])
+			if urn.AccountID() == "" {
+				urn = iam.UserURN("urn:iam::user/" + urn)
+			}
+			user, err = uc.LoadUser(context.Background(), urn)
+		default:
+			log.Fatal("either a user, --username or --attr is required")
 		}
-
-		user, err := uc.LoadUser(context.Background(), urn)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -75,17 +166,42 @@ var loadUserCommand = &cobra.Command{
 
 var deleteUserCommand = &cobra.Command{
 	Use:   "delete",
-	Short: "Delete an existing user.",
+	Short: "Delete an existing user. The user can be restored until it is purged.",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		uc := iamClient.Users()
+		purge, _ := cmd.Flags().GetBool("purge")
 
 		urn := iam.UserURN(args[0])
 		if urn.AccountID() == "" {
 			urn = iam.UserURN("urn:iam::user/" + urn)
 		}
 
-		if err := uc.DeleteUser(context.Background(), urn); err != nil {
+		var err error
+		if purge {
+			err = uc.PurgeUser(context.Background(), urn)
+		} else {
+			err = uc.DeleteUser(context.Background(), urn)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var restoreUserCommand = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a deleted user.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		uc := iamClient.Users()
+
+		urn := iam.UserURN(args[0])
+		if urn.AccountID() == "" {
+			urn = iam.UserURN("urn:iam::user/" + urn)
+		}
+
+		if err := uc.RestoreUser(context.Background(), urn); err != nil {
 			log.Fatal(err)
 		}
 	},
@@ -152,6 +268,282 @@ var unlockUserCommand = &cobra.Command{
 	},
 }
 
+var setPasswordCommand = &cobra.Command{
+	Use:   "set-password",
+	Short: "Set a new password for a user account.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		password, _ := cmd.Flags().GetString("password")
+		expire, _ := cmd.Flags().GetBool("expire")
+
+		urn := iam.UserURN(args[0])
+		if urn.AccountID() == "" {
+			urn = iam.UserURN("urn:iam::user/" + urn)
+		}
+
+		if password == "" {
+			fmt.Print("Password: ")
+			pwd, err := terminal.ReadPassword(0)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("")
+
+			password = string(pwd)
+		}
+
+		uc := iamClient.Users()
+
+		if err := uc.SetPassword(context.Background(), urn, password); err != nil {
+			log.Fatal(err)
+		}
+
+		if expire {
+			if err := uc.ExpirePassword(context.Background(), urn); err != nil {
+				log.Fatal(err)
+			}
+		}
+	},
+}
+
+var expirePasswordCommand = &cobra.Command{
+	Use:   "expire-password",
+	Short: "Force a user to choose a new password during the next login.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		uc := iamClient.Users()
+
+		urn := iam.UserURN(args[0])
+		if urn.AccountID() == "" {
+			urn = iam.UserURN("urn:iam::user/" + urn)
+		}
+
+		if err := uc.ExpirePassword(context.Background(), urn); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var revokeTokensCommand = &cobra.Command{
+	Use:   "revoke-tokens",
+	Short: "Revoke all access tokens issued to a user account.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		uc := iamClient.Users()
+
+		urn := iam.UserURN(args[0])
+		if urn.AccountID() == "" {
+			urn = iam.UserURN("urn:iam::user/" + urn)
+		}
+
+		if err := uc.RevokeTokens(context.Background(), urn); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var revokeTokenCommand = &cobra.Command{
+	Use:   "revoke-token",
+	Short: "Revoke a single access token by its ID (jti claim).",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		uc := iamClient.Users()
+
+		if err := uc.RevokeToken(context.Background(), args[0]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var renameUserCommand = &cobra.Command{
+	Use:   "rename",
+	Short: "Change the username of a user account.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		uc := iamClient.Users()
+
+		urn := iam.UserURN(args[0])
+		if urn.AccountID() == "" {
+			urn = iam.UserURN("urn:iam::user/" + urn)
+		}
+
+		if err := uc.SetUsername(context.Background(), urn, args[1]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var patchAttrsCommand = &cobra.Command{
+	Use:   "patch-attrs <user> <file>",
+	Short: "Modify the attributes of a user using a JSON merge patch or JSON patch.",
+	Long: `Modify the attributes of a user using a patch read from a JSON or YAML
+file. Use - to read from stdin.
+
+By default the file holds a JSON merge patch (RFC 7396): attributes set
+to null are removed and objects are merged recursively. Use --json-patch
+for a list of JSON patch operations (RFC 6902) whose paths may refer to
+values nested inside attributes, e.g. /address/city.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonPatch, _ := cmd.Flags().GetBool("json-patch")
+		ifMatch, _ := cmd.Flags().GetUint64("if-match")
+
+		urn := iam.UserURN(args[0])
+		if urn.AccountID() == "" {
+			urn = iam.UserURN("urn:iam::user/" + urn)
+		}
+
+		var r io.Reader = os.Stdin
+		if args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		blob, err := ioutil.ReadAll(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var patch iam.AttrPatch
+		if jsonPatch {
+			var ops iam.JSONPatch
+			err = yaml.Unmarshal(blob, &ops)
+			patch = ops
+		} else {
+			var merge iam.MergePatch
+			err = yaml.Unmarshal(blob, &merge)
+			patch = merge
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ctx := context.Background()
+		if cmd.Flags().Changed("if-match") {
+			ctx = client.IfMatch(ctx, ifMatch)
+		}
+
+		if err := iamClient.Users().PatchAttrs(ctx, urn, patch); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var reconcileUsersCommand = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Synchronize IAM users with authn-server accounts.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		adopt, _ := cmd.Flags().GetBool("adopt")
+
+		uc := iamClient.Users()
+
+		report, err := uc.Reconcile(context.Background(), adopt)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		blob, err := yaml.Marshal(report)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(string(blob))
+	},
+}
+
+var importUsersCommand = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Create users from a CSV, JSON or YAML file.",
+	Long: `Create users from a CSV, JSON or YAML file. Use - to read from stdin.
+
+CSV files must start with a header row. The columns username, password,
+invite and groups are reserved, all other columns are user attributes.
+Multiple groups are separated by semicolons. Each user needs either a
+password or invite set to true. Invited users receive an invitation
+and choose their password when accepting it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		if format == "" {
+			format = formatFromPath(args[0])
+		}
+
+		report, err := iamClient.Users().ImportUsers(context.Background(), format, r, dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		blob, err := yaml.Marshal(report)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(string(blob))
+
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var exportUsersCommand = &cobra.Command{
+	Use:   "export",
+	Short: "Export all users in a format accepted by import.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+
+		var w io.Writer = os.Stdout
+		if output != "" && output != "-" {
+			f, err := os.Create(output)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		if format == "" {
+			format = formatFromPath(output)
+		}
+
+		if err := iamClient.Users().ExportUsers(context.Background(), format, w); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// formatFromPath returns the import format matching the extension of
+// path. It defaults to json.
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return "csv"
+	case ".yaml", ".yml":
+		return "yaml"
+	default:
+		return "json"
+	}
+}
+
 func lockUnlock(lockUnlock bool, cmd *cobra.Command, args []string) {
 	uc := iamClient.Users()
 
@@ -168,15 +560,53 @@ func lockUnlock(lockUnlock bool, cmd *cobra.Command, args []string) {
 func init() {
 	RootCommand.AddCommand(userRootCommand)
 
+	loadUserCommand.Flags().StringP("username", "u", "", "Look up the user by username.")
+	loadUserCommand.Flags().StringP("attr", "a", "", "Look up the user by a unique attribute using a format of key=value.")
+
 	createUserCommand.Flags().StringP("password", "p", "", "Password for the new user.")
 	createUserCommand.Flags().StringSliceP("attr", "a", nil, "Set additional attributes for hte new user using a format of key=value.")
 
+	setPasswordCommand.Flags().StringP("password", "p", "", "The new password. Prompted for if not set.")
+	setPasswordCommand.Flags().Bool("expire", false, "Require the user to choose a new password during the next login.")
+
+	listUsersCommand.Flags().String("prefix", "", "Only list users whose username starts with the prefix.")
+	listUsersCommand.Flags().Bool("locked", false, "Only list locked users. Use --locked=false to only list unlocked users.")
+	listUsersCommand.Flags().String("group", "", "Only list members of the group.")
+	listUsersCommand.Flags().StringSlice("attr", nil, "Only list users with the attribute value using a format of key=value.")
+	listUsersCommand.Flags().String("sort", "username", "Sort users by username, accountID, createdAt or updatedAt.")
+	listUsersCommand.Flags().Int("page-size", 100, "Number of users fetched per request.")
+	listUsersCommand.Flags().Bool("deleted", false, "Only list deleted users that have not been purged yet.")
+	addMetadataFlags(listUsersCommand)
+
+	deleteUserCommand.Flags().Bool("purge", false, "Permanently delete the user and archive its authn-server account.")
+
+	importUsersCommand.Flags().StringP("format", "f", "", "Format of the file (csv, json or yaml). Defaults to the file extension.")
+	importUsersCommand.Flags().Bool("dry-run", false, "Only validate the users without creating them.")
+
+	exportUsersCommand.Flags().StringP("format", "f", "", "Output format (csv, json or yaml). Defaults to the extension of --output or json.")
+	exportUsersCommand.Flags().StringP("output", "o", "", "Write to a file instead of stdout.")
+
+	patchAttrsCommand.Flags().Bool("json-patch", false, "The file holds a JSON patch instead of a JSON merge patch.")
+	patchAttrsCommand.Flags().Uint64("if-match", 0, "Only apply the patch if the user still has the given version.")
+
+	reconcileUsersCommand.Flags().Bool("adopt", false, "Create IAM users for authn-server accounts not yet managed by IAM.")
+
 	userRootCommand.AddCommand(
 		listUsersCommand,
 		loadUserCommand,
 		deleteUserCommand,
+		restoreUserCommand,
 		createUserCommand,
 		lockUserCommand,
 		unlockUserCommand,
+		setPasswordCommand,
+		expirePasswordCommand,
+		renameUserCommand,
+		patchAttrsCommand,
+		revokeTokensCommand,
+		revokeTokenCommand,
+		reconcileUsersCommand,
+		importUsersCommand,
+		exportUsersCommand,
 	)
 }
@@ -0,0 +1,33 @@
+package cmds
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+)
+
+var whoamiCommand = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the user, groups and permissions of the current access token.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		profile, err := iamClient.Profile().Me(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		blob, err := yaml.Marshal(profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(string(blob))
+	},
+}
+
+func init() {
+	RootCommand.AddCommand(whoamiCommand)
+}
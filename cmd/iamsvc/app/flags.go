@@ -1,17 +1,79 @@
 package app
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/services/invite"
+	"github.com/tierklinik-dobersberg/identity-server/services/ldap"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 func addHTTPTransportFlags(flags *pflag.FlagSet) {
 	flags.StringP("http.listen", "l", ":8080", "Address to listen for HTTP requests")
+	flags.String("http.tls-cert", "", "Path to the TLS certificate. Enables HTTPS if set")
+	flags.String("http.tls-key", "", "Path to the TLS private key")
+	flags.String("http.client-ca", "", "Path to a PEM encoded CA bundle used to verify client certificates. Requires --http.tls-cert")
+	flags.StringSlice("http.client-cert-paths", nil, "Only accept client certificates for requests with one of the given path prefixes. Defaults to all paths")
+	flags.StringSlice("authn.cert-mapping", nil, "Map client certificates to IAM subjects using cn:<common-name>=<urn> or uri:<san-uri>=<urn>")
+}
+
+// getTLSConfig returns the TLS configuration for the HTTP server or nil
+// if TLS is disabled.
+func getTLSConfig(cmd *cobra.Command) (*tls.Config, error) {
+	f := cmd.Flags()
+
+	var (
+		cert, _     = f.GetString("http.tls-cert")
+		key, _      = f.GetString("http.tls-key")
+		clientCA, _ = f.GetString("http.client-ca")
+	)
+
+	if cert == "" {
+		if clientCA != "" {
+			return nil, errors.New("--http.client-ca requires --http.tls-cert")
+		}
+		return nil, nil
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		bundle, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("%s: no certificates found", clientCA)
+		}
+
+		// client certificates are optional as most clients
+		// authenticate using access tokens.
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return cfg, nil
 }
 
 func addRepoFlags(cmd *cobra.Command) {
@@ -27,21 +89,169 @@ func addAuthNFlags(cmd *cobra.Command) {
 	flags.String("authn.user", "hello", "Username for private authn-server endpoints")
 	flags.String("authn.password", "world", "Password for private authn-server endpoints")
 	flags.String("authn.issuer", "", "Issuer for the authn-server endpoint. Defaults to the value of --authn.server")
-	flags.String("authn.audience", "", "The audience for JWT access tokens")
+	flags.String("authn.origin", "", "Origin used to verify passwords at the authn-server login endpoint. Must be one of its APP_DOMAINS")
+	flags.StringSlice("authn.audience", nil, "The audience for JWT access tokens. May be repeated to accept tokens issued for any of the given audiences")
+	flags.Duration("authn.timeout", authn.DefaultTimeout, "Timeout for a single request to authn-server")
+	flags.Int("authn.retries", authn.DefaultMaxRetries, "Number of retries for idempotent requests if authn-server is unavailable. Set to -1 to disable")
+	flags.Duration("authn.jwks-refresh", authn.DefaultKeySetRefreshInterval, "Age after which the cached key set of authn-server is refreshed")
+	flags.Duration("authn.jwks-grace", authn.DefaultKeySetGracePeriod, "Time a cached key set is still used if authn-server cannot be reached")
+	flags.StringArray("authz.scope", nil, "Map an access token scope to an allowed action pattern using <scope>=<pattern>. May be repeated. Unmapped scopes starting with iam: are used as action patterns, other unmapped scopes are ignored")
 	flags.Bool("disable-authorization", false, "Disable policy based authorization. Only use for bootstrapping or testing. DO NOT USE IN PRODUCTION.")
 
 	cmd.MarkFlagRequired("authn.audience")
 }
 
+func addReconcileFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.Duration("reconcile.interval", time.Hour, "Interval at which IAM users are reconciled with authn-server accounts. Set to 0 to disable")
+	flags.Bool("reconcile.adopt", false, "Create IAM users for authn-server accounts that are not yet managed by IAM during reconciliation")
+}
+
+func addPurgeFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.Duration("users.retention", 30*24*time.Hour, "Time soft-deleted users are kept before they are purged. Set to 0 to keep them until purged manually")
+	flags.Duration("users.purge-interval", time.Hour, "Interval at which soft-deleted users are purged once their retention period expired")
+}
+
+func addInviteFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.Duration("invite.ttl", invite.DefaultTTL, "Time after which invitations expire")
+	flags.String("invite.accept-url", "", "URL of the page invitees use to accept their invitation. The invite token is added as the token query parameter")
+	flags.String("invite.notify-file", "", "Append invite notifications including the secret token as JSON lines to a file. Invitations are disabled if not set")
+}
+
+// getInviteConfig returns the configuration for invitations and the path
+// of the file invite notifications are written to. The path is empty if
+// invitations are disabled.
+func getInviteConfig(cmd *cobra.Command) (invite.Config, string, error) {
+	f := cmd.Flags()
+
+	var (
+		ttl, _        = f.GetDuration("invite.ttl")
+		acceptURL, _  = f.GetString("invite.accept-url")
+		notifyFile, _ = f.GetString("invite.notify-file")
+	)
+
+	if acceptURL != "" {
+		if _, err := url.Parse(acceptURL); err != nil {
+			return invite.Config{}, "", fmt.Errorf("invalid --invite.accept-url: %w", err)
+		}
+	}
+
+	return invite.Config{
+		TTL:       ttl,
+		AcceptURL: acceptURL,
+	}, notifyFile, nil
+}
+
+func addRevocationFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.Duration("revocation.max-token-lifetime", authn.DefaultMaxTokenLifetime, "Maximum lifetime of access tokens issued by authn-server. Used to expire subject revocations")
+	flags.Duration("revocation.cleanup-interval", time.Hour, "Interval at which expired token revocations are removed. Set to 0 to disable")
+}
+
+func addProvisioningFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.Bool("jit.enabled", false, "Create IAM users from the claims of verified access tokens the first time they are seen")
+	flags.String("jit.username-claim", "username", "Token claim holding the username. Falls back to the authn-server account username")
+	flags.String("jit.email-claim", "email", "Token claim stored in the email attribute")
+	flags.StringSlice("jit.attr", nil, "Map token claims to user attributes using <attr>=<claim>")
+	flags.StringSlice("jit.group-rule", nil, "Add users to a group the first time they are seen if a token claim matches using <claim>=<value>=<group-urn>")
+	flags.Bool("jit.sync", false, "Update the username and attributes of existing users whenever they present a new token")
+}
+
+func addLDAPFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.String("ldap.listen", "", "Address to listen for LDAP requests. The read-only LDAP frontend is disabled if empty")
+	flags.String("ldap.base-dn", ldap.DefaultBaseDN, "Base DN of the LDAP directory tree")
+	flags.Bool("ldap.allow-anonymous", false, "Allow LDAP searches without a prior bind")
+	flags.Bool("ldap.tls", false, "Serve LDAP over TLS (LDAPS) using the certificate of --http.tls-cert")
+	flags.Duration("ldap.idle-timeout", ldap.DefaultIdleTimeout, "Time after which idle LDAP connections are closed")
+	flags.Int("ldap.max-connections", ldap.DefaultMaxConnections, "Maximum number of concurrent LDAP connections")
+}
+
+// getLDAPConfig returns the configuration for the LDAP frontend and
+// the address to listen on. The address is empty if the frontend is
+// disabled.
+func getLDAPConfig(cmd *cobra.Command) (ldap.Config, string) {
+	f := cmd.Flags()
+
+	var (
+		listen, _      = f.GetString("ldap.listen")
+		baseDN, _      = f.GetString("ldap.base-dn")
+		anonymous, _   = f.GetBool("ldap.allow-anonymous")
+		idleTimeout, _ = f.GetDuration("ldap.idle-timeout")
+		maxConns, _    = f.GetInt("ldap.max-connections")
+	)
+
+	return ldap.Config{
+		BaseDN:         baseDN,
+		AllowAnonymous: anonymous,
+		IdleTimeout:    idleTimeout,
+		MaxConnections: maxConns,
+	}, listen
+}
+
+// getProvisionConfig returns the configuration for just-in-time
+// provisioning and whether it is enabled.
+func getProvisionConfig(cmd *cobra.Command) (user.ProvisionConfig, bool, error) {
+	f := cmd.Flags()
+
+	var (
+		enabled, _       = f.GetBool("jit.enabled")
+		usernameClaim, _ = f.GetString("jit.username-claim")
+		emailClaim, _    = f.GetString("jit.email-claim")
+		attrs, _         = f.GetStringSlice("jit.attr")
+		rules, _         = f.GetStringSlice("jit.group-rule")
+		sync, _          = f.GetBool("jit.sync")
+	)
+
+	cfg := user.ProvisionConfig{
+		UsernameClaim: usernameClaim,
+		EmailClaim:    emailClaim,
+		Attributes:    make(map[string]string),
+		Sync:          sync,
+	}
+
+	for _, a := range attrs {
+		parts := strings.SplitN(a, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return cfg, false, fmt.Errorf("invalid attribute mapping %q", a)
+		}
+		cfg.Attributes[parts[0]] = parts[1]
+	}
+
+	for _, r := range rules {
+		rule, err := user.ParseGroupRule(r)
+		if err != nil {
+			return cfg, false, err
+		}
+		cfg.GroupRules = append(cfg.GroupRules, rule)
+	}
+
+	return cfg, enabled, nil
+}
+
 func getAuthnConfig(cmd *cobra.Command) (authn.Config, error) {
 	f := cmd.Flags()
 
 	var (
-		audience, _ = f.GetString("authn.audience")
-		server, _   = f.GetString("authn.server")
-		password, _ = f.GetString("authn.password")
-		user, _     = f.GetString("authn.user")
-		issuer, _   = f.GetString("authn.issuer")
+		audiences, _ = f.GetStringSlice("authn.audience")
+		server, _    = f.GetString("authn.server")
+		password, _  = f.GetString("authn.password")
+		user, _      = f.GetString("authn.user")
+		issuer, _    = f.GetString("authn.issuer")
+		origin, _    = f.GetString("authn.origin")
+		timeout, _   = f.GetDuration("authn.timeout")
+		retries, _   = f.GetInt("authn.retries")
+		refresh, _   = f.GetDuration("authn.jwks-refresh")
+		grace, _     = f.GetDuration("authn.jwks-grace")
 	)
 
 	if issuer == "" {
@@ -53,21 +263,27 @@ func getAuthnConfig(cmd *cobra.Command) (authn.Config, error) {
 		issuer = fmt.Sprintf("%s://%s", s.Scheme, s.Host)
 	}
 
-	if audience == "" {
+	if len(audiences) == 0 {
 		httpListen, _ := f.GetString("http.listen")
 		s, err := url.Parse(httpListen)
 		if err != nil {
 			return authn.Config{}, err
 		}
 
-		audience = s.Hostname()
+		audiences = []string{s.Hostname()}
 	}
 
 	return authn.Config{
-		Audiences:          jwt.Audience{audience},
+		Audiences:          jwt.Audience(audiences),
 		PrivateBaseAddress: server,
 		Password:           password,
 		Username:           user,
 		Issuer:             issuer,
+		Origin:             origin,
+		Timeout:            timeout,
+		MaxRetries:         retries,
+
+		KeySetRefreshInterval: refresh,
+		KeySetGracePeriod:     grace,
 	}, nil
 }
@@ -1,7 +1,11 @@
 package app
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,8 +19,14 @@ import (
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 	"github.com/tierklinik-dobersberg/identity-server/repos/bbolt"
 	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/attribute"
+	"github.com/tierklinik-dobersberg/identity-server/services/bulk"
 	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/invite"
+	"github.com/tierklinik-dobersberg/identity-server/services/ldap"
 	"github.com/tierklinik-dobersberg/identity-server/services/policy"
+	"github.com/tierklinik-dobersberg/identity-server/services/profile"
+	"github.com/tierklinik-dobersberg/identity-server/services/scim"
 	"github.com/tierklinik-dobersberg/identity-server/services/user"
 )
 
@@ -35,6 +45,12 @@ func NewIAMCommand() *cobra.Command {
 	addHTTPTransportFlags(cmd.Flags())
 	addAuthNFlags(cmd)
 	addRepoFlags(cmd)
+	addReconcileFlags(cmd)
+	addPurgeFlags(cmd)
+	addRevocationFlags(cmd)
+	addProvisioningFlags(cmd)
+	addInviteFlags(cmd)
+	addLDAPFlags(cmd)
 
 	return cmd
 }
@@ -58,10 +74,19 @@ func runMain(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	var members iam.MembershipRepository
+	{
+		if db == nil {
+			members = inmem.NewMembershipRepository()
+		} else {
+			members = db.MembershipRepo()
+		}
+	}
+
 	var users iam.UserRepository
 	{
 		if db == nil {
-			users = inmem.NewUserRepository()
+			users = inmem.NewUserRepositoryWithMemberships(members)
 		} else {
 			users = db.UserRepo()
 		}
@@ -76,24 +101,54 @@ func runMain(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	var members iam.MembershipRepository
+	var policies iam.PolicyRepository
 	{
 		if db == nil {
-			members = inmem.NewMembershipRepository()
+			policies = inmem.NewPolicyRepository()
 		} else {
-			members = db.MembershipRepo()
+			policies = db.PolicyRepo()
 		}
 	}
 
-	var policies iam.PolicyRepository
+	var attributes iam.AttributeRepository
 	{
 		if db == nil {
-			policies = inmem.NewPolicyRepository()
+			attributes = inmem.NewAttributeRepository()
 		} else {
-			policies = db.PolicyRepo()
+			attributes = db.AttributeRepo()
+		}
+	}
+
+	var revocations iam.RevocationRepository
+	{
+		if db == nil {
+			revocations = inmem.NewRevocationRepository()
+		} else {
+			revocations = db.RevocationRepo()
+		}
+	}
+
+	var invitations iam.InvitationRepository
+	{
+		if db == nil {
+			invitations = inmem.NewInvitationRepository()
+		} else {
+			invitations = db.InvitationRepo()
+		}
+	}
+
+	var keySets iam.KeySetRepository
+	{
+		if db == nil {
+			keySets = inmem.NewKeySetRepository()
+		} else {
+			keySets = db.KeySetRepo()
 		}
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// Create authn client service
 	var as authn.Service
 	var jwtTokenExtractor authn.SubjectExtractorFunc
@@ -102,7 +157,7 @@ func runMain(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
-		as, err = authn.NewService(cfg)
+		as, err = authn.NewService(cfg, keySets)
 		if err != nil {
 			return err
 		}
@@ -110,13 +165,59 @@ func runMain(cmd *cobra.Command, args []string) error {
 		jwtTokenExtractor = as.ExtractTokenSubject
 	}
 
+	// Revoked access tokens
+	var revocationList *authn.RevocationList
+	{
+		maxLifetime, _ := cmd.Flags().GetDuration("revocation.max-token-lifetime")
+		interval, _ := cmd.Flags().GetDuration("revocation.cleanup-interval")
+
+		revocationList, err = authn.NewRevocationList(ctx, revocations, maxLifetime)
+		if err != nil {
+			return err
+		}
+
+		jwtTokenExtractor = revocationList.Wrap(jwtTokenExtractor)
+
+		if interval > 0 {
+			go revocationList.RunCleanup(ctx, interval, log.With(logger, "component", "revocation"))
+		}
+	}
+
+	// User attribute schema
+	var attrs attribute.Service
+	{
+		attrs = attribute.NewService(attributes, users)
+		attrs = attribute.NewLoggingService(attrs, log.With(logger, "component", "attribute"))
+	}
+
 	// User management service
 	var us user.Service
 	{
-		us = user.NewService(users, as)
+		us = user.NewService(users, as, revocationList)
+		us = user.NewValidatingService(us, attrs)
 		us = user.NewLoggingService(log.With(logger, "component", "user"), us)
 	}
 
+	// Periodically reconcile users with authn-server
+	{
+		interval, _ := cmd.Flags().GetDuration("reconcile.interval")
+		adopt, _ := cmd.Flags().GetBool("reconcile.adopt")
+
+		if interval > 0 {
+			go user.RunReconciler(ctx, us, interval, user.ReconcileOptions{Adopt: adopt}, log.With(logger, "component", "reconciler"))
+		}
+	}
+
+	// Periodically purge soft-deleted users
+	{
+		retention, _ := cmd.Flags().GetDuration("users.retention")
+		interval, _ := cmd.Flags().GetDuration("users.purge-interval")
+
+		if retention > 0 && interval > 0 {
+			go user.RunPurger(ctx, us, interval, retention, log.With(logger, "component", "purger"))
+		}
+	}
+
 	//  Group management service
 	var gs group.Service
 	{
@@ -125,6 +226,19 @@ func runMain(cmd *cobra.Command, args []string) error {
 		gs = group.NewLoggingService(gs, groupLogger)
 	}
 
+	// Just-in-time provisioning of users
+	{
+		cfg, enabled, err := getProvisionConfig(cmd)
+		if err != nil {
+			return err
+		}
+
+		if enabled {
+			provisioner := user.NewProvisioner(us, gs, cfg)
+			jwtTokenExtractor = provisioner.Wrap(jwtTokenExtractor)
+		}
+	}
+
 	// Policy management service
 	var ps policy.Service
 	{
@@ -142,27 +256,157 @@ func runMain(cmd *cobra.Command, args []string) error {
 			var policyManager = enforcer.NewPolicyManager(policies)
 			authorizer = enforcer.NewLadonEnforcer(policyManager, nil)
 		}
+
+		// soft-deleted users must not be able to use tokens issued
+		// before they were deleted.
+		authorizer = user.NewActiveUserEnforcer(authorizer, users)
+
+		entries, _ := cmd.Flags().GetStringArray("authz.scope")
+		mapping, err := enforcer.ParseScopeMapping(entries)
+		if err != nil {
+			return err
+		}
+
+		// tokens are limited by their scopes even if policy based
+		// authorization is disabled.
+		authorizer = enforcer.NewScopedEnforcer(authorizer, mapping)
 	}
 
 	// Setup HTTP server handlers
 	mux := http.NewServeMux()
 	httpLogger := log.With(logger, "component", "http")
 	{
-		mux.Handle("/v1/users/", user.MakeHandler(us, jwtTokenExtractor, authorizer, httpLogger))
+		// the management APIs only expose attributes the caller may
+		// read. The profile API is limited to the caller itself.
+		managedUsers := user.NewAttrAccessService(us, authorizer)
+
+		userHandler := user.MakeHandler(managedUsers, jwtTokenExtractor, authorizer, httpLogger)
+		mux.Handle("/v1/users/", userHandler)
+		mux.Handle("/v1/tokens/", userHandler)
 		mux.Handle("/v1/groups/", group.MakeHandler(gs, jwtTokenExtractor, authorizer, httpLogger))
 		mux.Handle("/v1/policies/", policy.MakeHandler(ps, jwtTokenExtractor, authorizer, httpLogger))
+		mux.Handle("/v1/attributes/", attribute.MakeHandler(attrs, jwtTokenExtractor, authorizer, httpLogger))
+
+		// invite tokens are credentials so invitations are only
+		// enabled if a notifier to deliver them is configured.
+		var is invite.Service
+		{
+			cfg, notifyFile, err := getInviteConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			inviteLogger := log.With(logger, "component", "invite")
+
+			if notifyFile != "" {
+				// accepting an invitation is not restricted by the
+				// attribute access of the invitee.
+				is = invite.NewService(invitations, us, gs, attrs, invite.NewFileNotifier(notifyFile), cfg)
+				is = invite.NewLoggingService(is, inviteLogger)
+				is = invite.NewAttrAccessService(is, authorizer)
+
+				mux.Handle("/v1/invitations/", invite.MakeHandler(is, jwtTokenExtractor, authorizer, httpLogger))
+			} else {
+				level.Warn(inviteLogger).Log("msg", "invitations are disabled because no notifier is configured (see --invite.notify-file)")
+			}
+		}
+
+		var bs bulk.Service
+		bs = bulk.NewService(managedUsers, gs, attrs, is, authorizer)
+		bs = bulk.NewLoggingService(bs, log.With(logger, "component", "bulk"))
+		bulkHandler := bulk.MakeHandler(bs, jwtTokenExtractor, authorizer, httpLogger)
+		mux.Handle("/v1/users/import", bulkHandler)
+		mux.Handle("/v1/users/export", bulkHandler)
+
+		var prs profile.Service
+		prs = profile.NewService(us, members, authorizer, nil)
+		prs = profile.NewLoggingService(prs, log.With(logger, "component", "profile"))
+		profileHandler := profile.MakeHandler(prs, jwtTokenExtractor, authorizer, httpLogger)
+		mux.Handle("/v1/me", profileHandler)
+		mux.Handle("/v1/me/", profileHandler)
+		mux.Handle("/userinfo", profileHandler)
+
+		var ss scim.Service
+		ss = scim.NewService(managedUsers, gs, members, authorizer)
+		ss = scim.NewLoggingService(ss, log.With(logger, "component", "scim"))
+		mux.Handle(scim.BasePath+"/", scim.MakeHandler(ss, jwtTokenExtractor, httpLogger))
+	}
+
+	var handler http.Handler = mux
+	{
+		entries, _ := cmd.Flags().GetStringSlice("authn.cert-mapping")
+		paths, _ := cmd.Flags().GetStringSlice("http.client-cert-paths")
+
+		if len(entries) > 0 {
+			mapping, err := authn.ParseCertificateMapping(entries)
+			if err != nil {
+				return err
+			}
+
+			handler = authn.NewCertificateHandler(mapping, paths, handler)
+		}
+	}
+	handler = enforcer.NewImpersonationHandler(log.With(logger, "component", "audit"), handler)
+	http.Handle("/", handler)
+
+	tlsConfig, err := getTLSConfig(cmd)
+	if err != nil {
+		return err
 	}
-	http.Handle("/", mux)
 
 	httpAddr, _ := cmd.Flags().GetString("http.listen")
-	errs := make(chan error, 2)
+	errs := make(chan error, 3)
 	go func() {
+		srv := &http.Server{
+			Addr:      httpAddr,
+			TLSConfig: tlsConfig,
+		}
+
+		if tlsConfig != nil {
+			logger.Log("transport", "https", "address", httpAddr, "msg", "listening")
+			// certificates are already loaded in tlsConfig
+			errs <- srv.ListenAndServeTLS("", "")
+			return
+		}
+
 		logger.Log("transport", "http", "address", httpAddr, "msg", "listening")
-		errs <- http.ListenAndServe(httpAddr, nil)
+		errs <- srv.ListenAndServe()
 	}()
 
+	// Read-only LDAP frontend
+	{
+		cfg, addr := getLDAPConfig(cmd)
+		if addr != "" {
+			ldapLogger := log.With(logger, "component", "ldap")
+
+			srv, err := ldap.NewServer(cfg, users, groups, members, as, authorizer, ldapLogger)
+			if err != nil {
+				return err
+			}
+
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+
+			transport := "ldap"
+			if useTLS, _ := cmd.Flags().GetBool("ldap.tls"); useTLS {
+				if tlsConfig == nil {
+					return errors.New("--ldap.tls requires --http.tls-cert")
+				}
+				l = tls.NewListener(l, tlsConfig)
+				transport = "ldaps"
+			}
+
+			go func() {
+				logger.Log("transport", transport, "address", addr, "msg", "listening")
+				errs <- srv.Serve(l)
+			}()
+		}
+	}
+
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT)
 		errs <- fmt.Errorf("%s", <-c)
 	}()
@@ -5,12 +5,13 @@ go 1.13
 require (
 	github.com/asaskevich/govalidator v0.0.0-20200108200545-475eaeb16496 // indirect
 	github.com/ghodss/yaml v1.0.0
+	github.com/go-asn1-ber/asn1-ber v1.5.1
 	github.com/go-kit/kit v0.10.0
+	github.com/go-ldap/ldap/v3 v3.3.0
 	github.com/go-openapi/errors v0.19.3 // indirect
 	github.com/go-openapi/strfmt v0.19.4 // indirect
 	github.com/gorilla/mux v1.7.4
 	github.com/jedib0t/go-pretty v4.3.0+incompatible
-	github.com/keratin/authn-go v1.1.0
 	github.com/kr/pretty v0.2.0 // indirect
 	github.com/ory/ladon v1.1.0
 	github.com/pkg/errors v0.9.1 // indirect
@@ -19,7 +20,7 @@ require (
 	github.com/stretchr/testify v1.5.1
 	go.etcd.io/bbolt v1.3.3
 	go.mongodb.org/mongo-driver v1.2.1 // indirect
-	golang.org/x/crypto v0.0.0-20200221231518-2aa609cf4a9d
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
 	golang.org/x/net v0.0.0-20200114155413-6afb5195e5aa // indirect
 	golang.org/x/sys v0.0.0-20200223170610-d5e6a3e2c0ae // indirect
 	gopkg.in/square/go-jose.v2 v2.4.1
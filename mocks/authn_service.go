@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	"github.com/stretchr/testify/mock"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
 )
@@ -9,31 +11,48 @@ type AuthnService struct {
 	mock.Mock
 }
 
-func (a *AuthnService) ImportAccount(username, password string, locked bool) (int, error) {
+func (a *AuthnService) ImportAccount(_ context.Context, username, password string, locked bool) (int, error) {
 	args := a.Called(username, password, locked)
 	return args.Int(0), args.Error(1)
 }
 
-func (a *AuthnService) GetAccount(id int) (authn.Account, error) {
+func (a *AuthnService) GetAccount(_ context.Context, id int) (authn.Account, error) {
 	args := a.Called(id)
 	return args.Get(0).(authn.Account), args.Error(1)
 }
 
-func (a *AuthnService) LockAccount(id int) error {
+func (a *AuthnService) LockAccount(_ context.Context, id int) error {
 	return a.Called(id).Error(0)
 }
 
-func (a *AuthnService) UnlockAccount(id int) error {
+func (a *AuthnService) UnlockAccount(_ context.Context, id int) error {
 	return a.Called(id).Error(0)
 }
 
-func (a *AuthnService) ArchiveAccount(id int) error {
+func (a *AuthnService) ArchiveAccount(_ context.Context, id int) error {
 	return a.Called(id).Error(0)
 }
 
-func (a *AuthnService) ExtractTokenSubject(token string) (string, error) {
+func (a *AuthnService) UpdateUsername(_ context.Context, id int, username string) error {
+	return a.Called(id, username).Error(0)
+}
+
+func (a *AuthnService) SetPassword(_ context.Context, id int, password string) error {
+	return a.Called(id, password).Error(0)
+}
+
+func (a *AuthnService) ExpirePassword(_ context.Context, id int) error {
+	return a.Called(id).Error(0)
+}
+
+func (a *AuthnService) VerifyPassword(_ context.Context, username, password string) (int, error) {
+	args := a.Called(username, password)
+	return args.Int(0), args.Error(1)
+}
+
+func (a *AuthnService) ExtractTokenSubject(token string) (string, string, error) {
 	args := a.Called(token)
-	return args.String(0), args.Error(1)
+	return args.String(0), args.String(1), args.Error(2)
 }
 
 func NewAuthnService() *AuthnService {
@@ -0,0 +1,434 @@
+// Package authntest provides an in-process fake of authn-server that can
+// be used to test the authn.Service and authn.NewAuthenticator without
+// network access.
+//
+// Example:
+//
+//	srv := authntest.NewServer()
+//	defer srv.Close()
+//
+//	svc, err := authn.NewService(srv.Config("identity.example.com"), nil)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//
+//	id, _ := svc.ImportAccount(ctx, "admin", "password", false)
+//	token := srv.Token(id, "identity.example.com")
+package authntest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// Username is the HTTP basic auth username required for private
+	// endpoints.
+	Username = "hello"
+
+	// Password is the HTTP basic auth password required for private
+	// endpoints.
+	Password = "world"
+
+	keyID = "authntest"
+)
+
+// Server is a fake authn-server backed by httptest.Server. It implements
+// the private account endpoints and the login endpoint used by
+// authn.Service and serves a JWKS so tokens issued by Token can be
+// verified.
+type Server struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+
+	l        sync.Mutex
+	nextID   int
+	accounts map[int]*account
+}
+
+type account struct {
+	authn.Account
+	password        string
+	passwordExpired bool
+}
+
+// NewServer starts and returns a new fake authn-server. The caller
+// should call Close when finished to shut it down.
+func NewServer() *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("authntest: failed to generate signing key: %s", err))
+	}
+
+	s := &Server{
+		key:      key,
+		nextID:   1,
+		accounts: make(map[int]*account),
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/jwks", s.serveJWKS).Methods("GET")
+	r.HandleFunc("/session", s.login).Methods("POST")
+	r.HandleFunc("/accounts/import", s.requireAuth(s.importAccount)).Methods("POST")
+	r.HandleFunc("/accounts/{id}", s.requireAuth(s.getAccount)).Methods("GET")
+	r.HandleFunc("/accounts/{id}", s.requireAuth(s.archiveAccount)).Methods("DELETE")
+	r.HandleFunc("/accounts/{id}", s.requireAuth(s.updateAccount)).Methods("PATCH")
+	r.HandleFunc("/accounts/{id}/password", s.requireAuth(s.setPassword)).Methods("PATCH")
+	r.HandleFunc("/accounts/{id}/expire_password", s.requireAuth(s.expirePassword)).Methods("PATCH")
+	r.HandleFunc("/accounts/{id}/lock", s.requireAuth(s.lockAccount(true))).Methods("PATCH")
+	r.HandleFunc("/accounts/{id}/unlock", s.requireAuth(s.lockAccount(false))).Methods("PATCH")
+
+	s.Server = httptest.NewServer(r)
+
+	return s
+}
+
+// Config returns an authn.Config that points to s and accepts tokens for
+// the given audiences.
+func (s *Server) Config(audiences ...string) authn.Config {
+	return authn.Config{
+		Audiences:          jwt.Audience(audiences),
+		Issuer:             s.URL,
+		PrivateBaseAddress: s.URL,
+		Username:           Username,
+		Password:           Password,
+	}
+}
+
+// Token returns a signed JWT access token for accountID that is valid
+// for audience and expires in one hour.
+func (s *Server) Token(accountID int, audience ...string) string {
+	now := time.Now()
+
+	token, err := s.Sign(jwt.Claims{
+		Issuer:   s.URL,
+		Subject:  strconv.Itoa(accountID),
+		Audience: jwt.Audience(audience),
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	})
+	if err != nil {
+		panic(fmt.Sprintf("authntest: failed to sign token: %s", err))
+	}
+
+	return token
+}
+
+// Sign signs claims using the key published in the JWKS of s. Additional
+// private claims may be passed in extra and are merged into the token.
+func (s *Server) Sign(claims jwt.Claims, extra ...interface{}) (string, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: s.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	builder := jwt.Signed(signer).Claims(claims)
+	for _, e := range extra {
+		builder = builder.Claims(e)
+	}
+
+	return builder.CompactSerialize()
+}
+
+// Account returns the account stored under id.
+func (s *Server) Account(id int) (authn.Account, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	a, ok := s.accounts[id]
+	if !ok {
+		return authn.Account{}, false
+	}
+
+	return a.Account, true
+}
+
+// CheckPassword reports whether password is the current password of the
+// account stored under id.
+func (s *Server) CheckPassword(id int, password string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	a, ok := s.accounts[id]
+	return ok && a.password != "" && a.password == password
+}
+
+// PasswordExpired reports whether the password of the account stored
+// under id has been expired.
+func (s *Server) PasswordExpired(id int) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	a, ok := s.accounts[id]
+	return ok && a.passwordExpired
+}
+
+// AddAccount creates a new account directly on s, bypassing the
+// private API. It is useful to simulate accounts that have been
+// created outside of identity-server.
+func (s *Server) AddAccount(username, password string, locked bool) int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.addAccount(username, password, locked)
+}
+
+// addAccount requires s.l to be held.
+func (s *Server) addAccount(username, password string, locked bool) int {
+	id := s.nextID
+	s.nextID++
+
+	s.accounts[id] = &account{
+		Account: authn.Account{
+			ID:       id,
+			Username: username,
+			Locked:   locked,
+		},
+		password: password,
+	}
+
+	return id
+}
+
+func (s *Server) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{
+				Key:       &s.key.PublicKey,
+				KeyID:     keyID,
+				Algorithm: string(jose.RS256),
+				Use:       "sig",
+			},
+		},
+	})
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeFieldError(w, http.StatusBadRequest, "form", "INVALID")
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	password := r.PostForm.Get("password")
+
+	s.l.Lock()
+	var found *account
+	for _, a := range s.accounts {
+		if !a.Deleted && strings.EqualFold(a.Username, username) {
+			found = a
+			break
+		}
+	}
+
+	var field, message string
+	switch {
+	case found == nil || found.password == "" || found.password != password:
+		field, message = "credentials", "FAILED"
+	case found.Locked:
+		field, message = "account", "LOCKED"
+	case found.passwordExpired:
+		field, message = "credentials", "EXPIRED"
+	}
+	s.l.Unlock()
+
+	if message != "" {
+		writeFieldError(w, http.StatusUnprocessableEntity, field, message)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"result": map[string]interface{}{
+			"id_token": s.Token(found.ID, r.Header.Get("Origin")),
+		},
+	})
+}
+
+func (s *Server) importAccount(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeFieldError(w, http.StatusBadRequest, "form", "INVALID")
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	password := r.PostForm.Get("password")
+	locked, _ := strconv.ParseBool(r.PostForm.Get("locked"))
+
+	if username == "" {
+		writeFieldError(w, http.StatusUnprocessableEntity, "username", "MISSING")
+		return
+	}
+	if password == "" {
+		writeFieldError(w, http.StatusUnprocessableEntity, "password", "MISSING")
+		return
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.usernameTaken(username, 0) {
+		writeFieldError(w, http.StatusUnprocessableEntity, "username", "TAKEN")
+		return
+	}
+
+	id := s.addAccount(username, password, locked)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"result": map[string]interface{}{
+			"id": id,
+		},
+	})
+}
+
+func (s *Server) getAccount(w http.ResponseWriter, r *http.Request) {
+	s.withAccount(w, r, func(a *account) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"result": a.Account,
+		})
+	})
+}
+
+func (s *Server) archiveAccount(w http.ResponseWriter, r *http.Request) {
+	s.withAccount(w, r, func(a *account) {
+		a.Username = ""
+		a.password = ""
+		a.Locked = false
+		a.Deleted = true
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (s *Server) updateAccount(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeFieldError(w, http.StatusBadRequest, "form", "INVALID")
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	if username == "" {
+		writeFieldError(w, http.StatusUnprocessableEntity, "username", "MISSING")
+		return
+	}
+
+	s.withAccount(w, r, func(a *account) {
+		if s.usernameTaken(username, a.ID) {
+			writeFieldError(w, http.StatusUnprocessableEntity, "username", "TAKEN")
+			return
+		}
+
+		a.Username = username
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (s *Server) setPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeFieldError(w, http.StatusBadRequest, "form", "INVALID")
+		return
+	}
+
+	password := r.PostForm.Get("password")
+	if password == "" {
+		writeFieldError(w, http.StatusUnprocessableEntity, "password", "MISSING")
+		return
+	}
+
+	s.withAccount(w, r, func(a *account) {
+		a.password = password
+		a.passwordExpired = false
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (s *Server) expirePassword(w http.ResponseWriter, r *http.Request) {
+	s.withAccount(w, r, func(a *account) {
+		a.passwordExpired = true
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (s *Server) lockAccount(locked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.withAccount(w, r, func(a *account) {
+			a.Locked = locked
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+}
+
+// usernameTaken reports whether username is used by any account other
+// than except. It requires s.l to be held.
+func (s *Server) usernameTaken(username string, except int) bool {
+	for id, a := range s.accounts {
+		if id != except && strings.EqualFold(a.Username, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// withAccount calls fn with the account referenced in the request path
+// while holding s.l.
+func (s *Server) withAccount(w http.ResponseWriter, r *http.Request, fn func(a *account)) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeFieldError(w, http.StatusNotFound, "account", "NOT_FOUND")
+		return
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	a, ok := s.accounts[id]
+	if !ok {
+		writeFieldError(w, http.StatusNotFound, "account", "NOT_FOUND")
+		return
+	}
+
+	fn(a)
+}
+
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != Username || pass != Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func writeFieldError(w http.ResponseWriter, status int, field, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]string{
+			{
+				"field":   field,
+				"message": message,
+			},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
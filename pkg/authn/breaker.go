@@ -0,0 +1,107 @@
+package authn
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+// errCircuitOpen is returned (wrapped in a common.UnavailableError) when
+// requests to authn-server are rejected by the circuit breaker.
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// breaker is a simple circuit breaker. It opens after threshold
+// consecutive calls failed with common.UnavailableError and rejects all
+// calls for cooldown. Afterwards, a single trial call is let through and
+// decides whether the breaker closes again or stays open for another
+// cooldown period.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	l        sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// breakerCall identifies a call that has been allowed by a breaker.
+type breakerCall struct {
+	// trial is set for the single call let through by a half-open
+	// breaker.
+	trial bool
+}
+
+// allow reports whether a call may be performed. Callers that have been
+// allowed must report the result of the call using done or release.
+func (b *breaker) allow() (breakerCall, bool) {
+	if b == nil || b.threshold <= 0 {
+		return breakerCall{}, true
+	}
+
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.failures < b.threshold {
+		return breakerCall{}, true
+	}
+
+	if b.trial || time.Since(b.openedAt) < b.cooldown {
+		return breakerCall{}, false
+	}
+
+	// half-open, let a single trial request through
+	b.trial = true
+	return breakerCall{trial: true}, true
+}
+
+// done records the result of call. Only the trial call frees the
+// half-open slot, results of calls that were allowed before the
+// breaker opened are still counted though.
+func (b *breaker) done(call breakerCall, err error) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if call.trial {
+		b.trial = false
+	}
+
+	if !common.IsUnavailable(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// release gives up call without recording its result, e.g. because the
+// caller gave up. If call is the trial of a half-open breaker, the next
+// call is let through as the trial.
+func (b *breaker) release(call breakerCall) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if call.trial {
+		b.trial = false
+	}
+}
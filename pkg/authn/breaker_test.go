@@ -0,0 +1,102 @@
+package authn
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(2, time.Millisecond)
+	unavailable := common.NewUnavailableError(serviceName, errors.New("simulated"))
+
+	allowed := func() bool {
+		_, ok := b.allow()
+		return ok
+	}
+
+	for i := 0; i < 2; i++ {
+		call, ok := b.allow()
+		require.True(t, ok)
+		b.done(call, unavailable)
+	}
+	assert.False(t, allowed())
+
+	time.Sleep(2 * time.Millisecond)
+
+	// a released trial neither closes nor re-opens the breaker
+	trial, ok := b.allow()
+	require.True(t, ok)
+	assert.True(t, trial.trial)
+	assert.False(t, allowed())
+	b.release(trial)
+	assert.Equal(t, 2, b.failures)
+
+	trial, ok = b.allow()
+	require.True(t, ok)
+	b.done(trial, unavailable)
+	assert.False(t, allowed())
+
+	time.Sleep(2 * time.Millisecond)
+
+	trial, ok = b.allow()
+	require.True(t, ok)
+	b.done(trial, nil)
+	assert.True(t, allowed())
+	assert.True(t, allowed())
+}
+
+func TestBreaker_Concurrent(t *testing.T) {
+	b := newBreaker(2, time.Millisecond)
+	unavailable := common.NewUnavailableError(serviceName, errors.New("simulated"))
+
+	// slow has been allowed before the breaker opened
+	slow, ok := b.allow()
+	require.True(t, ok)
+
+	for i := 0; i < 2; i++ {
+		call, ok := b.allow()
+		require.True(t, ok)
+		b.done(call, unavailable)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	// only a single one of many concurrent calls is the trial
+	var (
+		wg     sync.WaitGroup
+		l      sync.Mutex
+		trials []breakerCall
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if call, ok := b.allow(); ok {
+				l.Lock()
+				trials = append(trials, call)
+				l.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Len(t, trials, 1)
+
+	// calls other than the trial do not free the half-open slot
+	b.done(slow, unavailable)
+	time.Sleep(2 * time.Millisecond)
+	_, ok = b.allow()
+	assert.False(t, ok)
+	b.release(slow)
+	_, ok = b.allow()
+	assert.False(t, ok)
+
+	b.done(trials[0], nil)
+	_, ok = b.allow()
+	assert.True(t, ok)
+}
@@ -0,0 +1,147 @@
+package authn
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// ContextKeyCertificateSubject is used by NewCertificateHandler to add
+// the IAM subject of a verified client certificate to the request
+// context.
+const ContextKeyCertificateSubject contextKey = "authn:certificate-subject"
+
+// CertificateMapping maps verified client certificates to IAM subjects
+// using either the common name of the certificate subject or one of the
+// URIs of the subject alternative name extension.
+type CertificateMapping struct {
+	commonNames map[string]string
+	uris        map[string]string
+}
+
+// ParseCertificateMapping parses a certificate mapping. Each entry has
+// the format <type>:<value>=<subject> where type is either "cn" to match
+// the subject common name or "uri" to match a SAN URI. subject must be a
+// valid IAM user URN. For example:
+//
+//	cn:billing-service=urn:iam::user/100
+//	uri:spiffe://example.com/billing=urn:iam::user/100
+func ParseCertificateMapping(entries []string) (*CertificateMapping, error) {
+	m := &CertificateMapping{
+		commonNames: make(map[string]string),
+		uris:        make(map[string]string),
+	}
+
+	for _, e := range entries {
+		idx := strings.LastIndex(e, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid certificate mapping %q: missing subject", e)
+		}
+
+		match, subject := e[:idx], e[idx+1:]
+		if !iam.UserURN(subject).IsValid() {
+			return nil, fmt.Errorf("invalid certificate mapping %q: invalid subject %q", e, subject)
+		}
+
+		parts := strings.SplitN(match, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid certificate mapping %q", e)
+		}
+
+		switch parts[0] {
+		case "cn":
+			m.commonNames[parts[1]] = subject
+		case "uri":
+			m.uris[parts[1]] = subject
+		default:
+			return nil, fmt.Errorf("invalid certificate mapping %q: unknown type %q", e, parts[0])
+		}
+	}
+
+	return m, nil
+}
+
+// Subject returns the IAM subject cert maps to. SAN URIs take precedence
+// over the common name.
+func (m *CertificateMapping) Subject(cert *x509.Certificate) (string, bool) {
+	if m == nil || cert == nil {
+		return "", false
+	}
+
+	for _, u := range cert.URIs {
+		if subject, ok := m.uris[u.String()]; ok {
+			return subject, true
+		}
+	}
+
+	if subject, ok := m.commonNames[cert.Subject.CommonName]; ok {
+		return subject, true
+	}
+
+	return "", false
+}
+
+// NewCertificateHandler returns a http.Handler that adds the IAM subject
+// of a verified client certificate to the request context before calling
+// next. If prefixes is not empty, only requests with a path that starts
+// with one of prefixes are considered. The subject is picked up by
+// NewAuthenticator and NewCertificateAuthenticator.
+func NewCertificateHandler(m *CertificateMapping, prefixes []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || !hasPrefix(r.URL.Path, prefixes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if subject, ok := m.Subject(r.TLS.VerifiedChains[0][0]); ok {
+			r = r.WithContext(context.WithValue(r.Context(), ContextKeyCertificateSubject, subject))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasPrefix(path string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CertificateSubject returns the IAM subject of the verified client
+// certificate added to ctx by NewCertificateHandler.
+func CertificateSubject(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(ContextKeyCertificateSubject).(string)
+	return subject, ok && subject != ""
+}
+
+// NewCertificateAuthenticator returns an endpoint.Middleware that only
+// accepts requests authenticated by a client certificate. It can be used
+// instead of NewAuthenticator for routes that must not accept access
+// tokens.
+func NewCertificateAuthenticator() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			subject, ok := CertificateSubject(ctx)
+			if !ok {
+				return nil, ErrMissingCertificate
+			}
+
+			ctx = enforcer.WithSubject(ctx, subject)
+			return next(ctx, request)
+		}
+	}
+}
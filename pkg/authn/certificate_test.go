@@ -0,0 +1,194 @@
+package authn_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+// testCA is a minimal certificate authority used to issue client
+// certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, commonName string, uris ...string) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, u := range uris {
+		parsed, err := url.Parse(u)
+		require.NoError(t, err)
+		tmpl.URIs = append(tmpl.URIs, parsed)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func TestParseCertificateMapping(t *testing.T) {
+	m, err := authn.ParseCertificateMapping([]string{
+		"cn:billing=urn:iam::user/100",
+		"uri:spiffe://example.com/a=b=urn:iam::user/101",
+	})
+	require.NoError(t, err)
+
+	u, _ := url.Parse("spiffe://example.com/a=b")
+
+	subject, ok := m.Subject(&x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, URIs: []*url.URL{u}})
+	assert.True(t, ok)
+	assert.Equal(t, "urn:iam::user/101", subject)
+
+	subject, ok = m.Subject(&x509.Certificate{Subject: pkix.Name{CommonName: "billing"}})
+	assert.True(t, ok)
+	assert.Equal(t, "urn:iam::user/100", subject)
+
+	_, ok = m.Subject(&x509.Certificate{Subject: pkix.Name{CommonName: "other"}})
+	assert.False(t, ok)
+
+	for _, invalid := range []string{
+		"cn:billing",
+		"cn:billing=100",
+		"dn:billing=urn:iam::user/100",
+		"cn:=urn:iam::user/100",
+	} {
+		_, err := authn.ParseCertificateMapping([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCertificateAuthentication(t *testing.T) {
+	ca := newTestCA(t)
+
+	mapping, err := authn.ParseCertificateMapping([]string{"cn:billing=urn:iam::user/100"})
+	require.NoError(t, err)
+
+	noTokens := func(string) (string, string, error) { return "", "", errors.New("invalid token") }
+	ep := authn.NewAuthenticator(noTokens)(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		subject, _ := enforcer.Subject(ctx)
+		return subject, nil
+	})
+
+	handler := kithttp.NewServer(
+		ep,
+		func(context.Context, *http.Request) (interface{}, error) { return nil, nil },
+		kithttp.EncodeJSONResponse,
+		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+	)
+
+	srv := httptest.NewUnstartedServer(authn.NewCertificateHandler(mapping, []string{"/v1/"}, handler))
+	srv.TLS = &tls.Config{
+		ClientCAs:  ca.pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	// use a new client for each request so connections with a
+	// different certificate are not reused.
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      roots,
+					Certificates: certs,
+				},
+			},
+		}
+	}
+
+	get := func(path string, certs ...tls.Certificate) int {
+		res, err := newClient(certs...).Get(srv.URL + path)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get("/v1/users", ca.issue(t, "billing")))
+	assert.Equal(t, http.StatusUnauthorized, get("/v1/users", ca.issue(t, "unknown")))
+	assert.Equal(t, http.StatusUnauthorized, get("/v1/users"))
+
+	// certificates are only accepted for /v1/
+	assert.Equal(t, http.StatusUnauthorized, get("/other", ca.issue(t, "billing")))
+
+	// certificates not issued by the client CA are rejected during
+	// the TLS handshake.
+	other := newTestCA(t)
+	_, err = newClient(other.issue(t, "billing")).Get(srv.URL + "/v1/users")
+	assert.Error(t, err)
+}
+
+func TestNewCertificateAuthenticator(t *testing.T) {
+	ep := authn.NewCertificateAuthenticator()(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		subject, _ := enforcer.Subject(ctx)
+		return subject, nil
+	})
+
+	_, err := ep(context.Background(), nil)
+	assert.Error(t, err)
+
+	ctx := context.WithValue(context.Background(), authn.ContextKeyCertificateSubject, "urn:iam::user/100")
+	subject, err := ep(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "urn:iam::user/100", subject)
+}
@@ -0,0 +1,170 @@
+package authn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// serviceName is used when reporting authn-server as unavailable.
+const serviceName = "authn-server"
+
+// privateClient talks to the private (admin) API of authn-server. Unlike
+// the client provided by keratin/authn-go it honors request contexts and
+// converts error responses to typed errors. A privateClient without
+// credentials is used for the public API.
+type privateClient struct {
+	cli      *http.Client
+	baseURL  *url.URL
+	username string
+	password string
+
+	// origin is sent as the Origin header, if set. authn-server
+	// requires it for the public API.
+	origin string
+}
+
+func newPrivateClient(base, username, password string) (*privateClient, error) {
+	// ensure that base ends with a '/' so ResolveReference() keeps
+	// any path prefix.
+	if !strings.HasSuffix(base, "/") {
+		base = base + "/"
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	return &privateClient{
+		cli:      &http.Client{},
+		baseURL:  u,
+		username: username,
+		password: password,
+	}, nil
+}
+
+// fieldError describes an error for a single request field as
+// returned by authn-server.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (f fieldError) String() string {
+	return fmt.Sprintf("%s: %s", f.Field, f.Message)
+}
+
+// do sends a request to the private authn-server API and decodes the
+// "result" field of the response into result, if not nil.
+func (c *privateClient) do(ctx context.Context, method, path string, form url.Values, result interface{}) error {
+	if result == nil {
+		return c.send(ctx, method, path, form, nil)
+	}
+
+	data := struct {
+		Result interface{} `json:"result"`
+	}{result}
+
+	return c.send(ctx, method, path, form, &data)
+}
+
+// send sends a request to authn-server and decodes the response
+// body into v, if not nil.
+func (c *privateClient) send(ctx context.Context, method, path string, form url.Values, v interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, c.baseURL.ResolveReference(&url.URL{Path: path}).String(), body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if c.origin != "" {
+		req.Header.Set("Origin", c.origin)
+	}
+
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	res, err := c.cli.Do(req)
+	if err != nil {
+		return common.NewUnavailableError(serviceName, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return decodeErrorResponse(res)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// keySet fetches the JSON Web Key Set used by authn-server to sign
+// access tokens.
+func (c *privateClient) keySet(ctx context.Context) (jose.JSONWebKeySet, error) {
+	var set jose.JSONWebKeySet
+	if err := c.send(ctx, "GET", "jwks", nil, &set); err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+
+	return set, nil
+}
+
+// decodeErrorResponse converts a non-2xx response from authn-server
+// into one of the typed errors from the common package.
+func decodeErrorResponse(res *http.Response) error {
+	var payload struct {
+		Errors []fieldError `json:"errors"`
+	}
+	// authn-server does not always send a body so we ignore any
+	// errors here.
+	_ = json.NewDecoder(res.Body).Decode(&payload)
+
+	msgs := make([]string, len(payload.Errors))
+	for i, f := range payload.Errors {
+		msgs[i] = f.String()
+	}
+	description := strings.Join(msgs, "; ")
+	if description == "" {
+		description = res.Status
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return common.NewNotFoundError("account")
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return common.NewUnavailableError(serviceName, fmt.Errorf("received %s", res.Status))
+	}
+
+	for _, f := range payload.Errors {
+		if f.Message == "TAKEN" {
+			return common.NewConflictError(f.Field)
+		}
+	}
+
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		// identity-server is not allowed to use the private API. There's
+		// nothing the user can do about it so report it as unavailable.
+		return common.NewUnavailableError(serviceName, fmt.Errorf("received %s", res.Status))
+	}
+
+	return common.NewInvalidArgumentError(description)
+}
@@ -1,13 +1,110 @@
 package authn
 
-import "gopkg.in/square/go-jose.v2/jwt"
+import (
+	"time"
 
-// Config is a copy of kerating/authn-go/authn.Config
-// to avoid direct dependecies to authn-go
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// DefaultTimeout is the default value for Config.Timeout.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultMaxRetries is the default value for Config.MaxRetries.
+	DefaultMaxRetries = 2
+
+	// DefaultRetryBackoff is the default value for Config.RetryBackoff.
+	DefaultRetryBackoff = 200 * time.Millisecond
+
+	// DefaultBreakerThreshold is the default value for Config.BreakerThreshold.
+	DefaultBreakerThreshold = 5
+
+	// DefaultBreakerCooldown is the default value for Config.BreakerCooldown.
+	DefaultBreakerCooldown = 30 * time.Second
+
+	// DefaultKeySetRefreshInterval is the default value for
+	// Config.KeySetRefreshInterval.
+	DefaultKeySetRefreshInterval = time.Hour
+
+	// DefaultKeySetGracePeriod is the default value for
+	// Config.KeySetGracePeriod.
+	DefaultKeySetGracePeriod = 24 * time.Hour
+
+	// DefaultSubjectCacheSize is the default value for
+	// Config.SubjectCacheSize.
+	DefaultSubjectCacheSize = 10000
+)
+
+// Config configures the connection to authn-server.
 type Config struct {
 	Audiences          jwt.Audience `json:"audience" yaml:"audience"`
 	Issuer             string       `json:"issuer" yaml:"issuer"`
 	PrivateBaseAddress string       `json:"server" yaml:"server"`
 	Username           string       `json:"username" yaml:"username"`
 	Password           string       `json:"password" yaml:"password"`
+
+	// Origin is sent as the Origin header when verifying passwords
+	// using the login endpoint of authn-server. It must be one of the
+	// APP_DOMAINS configured at authn-server.
+	Origin string `json:"origin" yaml:"origin"`
+
+	// Timeout is the deadline for a single call to authn-server.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+
+	// MaxRetries is the number of times idempotent calls are retried
+	// if authn-server is unavailable. Set to a negative value to disable
+	// retries.
+	MaxRetries int `json:"maxRetries" yaml:"maxRetries"`
+
+	// RetryBackoff is the delay before the first retry. It is doubled for
+	// each following retry.
+	RetryBackoff time.Duration `json:"retryBackoff" yaml:"retryBackoff"`
+
+	// BreakerThreshold is the number of consecutive failed calls after
+	// which all calls to authn-server are rejected for BreakerCooldown.
+	// Set to a negative value to disable the circuit breaker.
+	BreakerThreshold int `json:"breakerThreshold" yaml:"breakerThreshold"`
+
+	// BreakerCooldown is the time the circuit breaker stays open.
+	BreakerCooldown time.Duration `json:"breakerCooldown" yaml:"breakerCooldown"`
+
+	// KeySetRefreshInterval is the age after which the cached key set of
+	// the issuer is refreshed in the background.
+	KeySetRefreshInterval time.Duration `json:"keySetRefreshInterval" yaml:"keySetRefreshInterval"`
+
+	// KeySetGracePeriod is the time a cached key set is still used after
+	// KeySetRefreshInterval if it cannot be refreshed.
+	KeySetGracePeriod time.Duration `json:"keySetGracePeriod" yaml:"keySetGracePeriod"`
+
+	// SubjectCacheSize is the maximum number of verified tokens whose
+	// subject is memoized until the token expires. Set to a negative
+	// value to disable memoization.
+	SubjectCacheSize int `json:"subjectCacheSize" yaml:"subjectCacheSize"`
+}
+
+func (c *Config) setDefaults() {
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = DefaultMaxRetries
+	}
+	if c.RetryBackoff == 0 {
+		c.RetryBackoff = DefaultRetryBackoff
+	}
+	if c.BreakerThreshold == 0 {
+		c.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if c.BreakerCooldown == 0 {
+		c.BreakerCooldown = DefaultBreakerCooldown
+	}
+	if c.KeySetRefreshInterval == 0 {
+		c.KeySetRefreshInterval = DefaultKeySetRefreshInterval
+	}
+	if c.KeySetGracePeriod == 0 {
+		c.KeySetGracePeriod = DefaultKeySetGracePeriod
+	}
+	if c.SubjectCacheSize == 0 {
+		c.SubjectCacheSize = DefaultSubjectCacheSize
+	}
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/transport/http"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
@@ -18,42 +19,61 @@ type contextKey string
 // and validated JWT token to the request context.
 const ContextKeyJWTClaims contextKey = "authn:jwt-token"
 
+// ContextKeyAudience is used by NewAuthenticator to add the configured
+// audience the access token has been verified for to the request context.
+// It is also available to policies using the "audience" policy context
+// key.
+const ContextKeyAudience contextKey = "authn:audience"
+
 // SubjectExtractorFunc extracts and validates the JWT user subject from
-// the token.
-type SubjectExtractorFunc func(token string) (string, error)
+// the token. It also returns the configured audience the token has been
+// verified for. The audience is empty if it is unknown.
+type SubjectExtractorFunc func(token string) (subject, audience string, err error)
 
 // NewAuthenticator returns an endpoint.Middleware that extracts and
 // validates an AuthN JWT access token. The user URN is added to the
-// request context.
+// request context. Requests without an access token are accepted if
+// they have been authenticated using a client certificate (see
+// NewCertificateHandler).
 func NewAuthenticator(fn SubjectExtractorFunc) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (interface{}, error) {
-			val := ctx.Value(http.ContextKeyRequestAuthorization)
-			if val == nil {
-				return nil, errors.New("not authorized") // TODO(ppacher): return approriate error
+			bearer, _ := ctx.Value(http.ContextKeyRequestAuthorization).(string)
+			if bearer == "" {
+				if subject, ok := CertificateSubject(ctx); ok {
+					return next(enforcer.WithSubject(ctx, subject), request)
+				}
+
+				return nil, ErrMissingToken
 			}
-			bearer := val.(string)
 
-			if !strings.HasPrefix(bearer, "Bearer") {
-				return nil, errors.New("invalid authorization key")
+			if !strings.HasPrefix(bearer, "Bearer ") {
+				return nil, ErrMalformedAuthorization
 			}
-			idToken := strings.Replace(bearer, "Bearer ", "", 1)
+			idToken := strings.TrimSpace(strings.TrimPrefix(bearer, "Bearer "))
 
 			token, err := jwt.ParseSigned(idToken)
 			if err != nil {
-				return nil, err
+				return nil, newInvalidTokenError(err)
 			}
 
 			// We use UnsafeClaimsWithoutVerification here because the SubjectExtractorFunc is expected
 			// to verify the token. We cannot do any verification here because we just
 			// don't know enough about the token to parse.
 			var claims jwt.Claims
-			token.UnsafeClaimsWithoutVerification(&claims)
+			var scope scopeClaim
+			token.UnsafeClaimsWithoutVerification(&claims, &scope)
 
 			// fn should verify the token here ...
-			accountID, err := fn(idToken)
+			accountID, audience, err := fn(idToken)
 			if err != nil {
-				return nil, fmt.Errorf("issuer=%q: %w", claims.Issuer, err)
+				// failing to verify a token because authn-server cannot
+				// be reached is not the client's fault.
+				var pe *ProvisioningError
+				if common.IsUnavailable(err) || errors.As(err, &pe) {
+					return nil, err
+				}
+				return nil, newInvalidTokenError(fmt.Errorf("issuer=%q: %w", claims.Issuer, err))
 			}
 
 			// We add the whole JWT as well as an identity-server UserURN to
@@ -61,11 +81,67 @@ func NewAuthenticator(fn SubjectExtractorFunc) endpoint.Middleware {
 			ctx = context.WithValue(ctx, ContextKeyJWTClaims, claims)
 			ctx = enforcer.WithSubject(ctx, fmt.Sprintf("urn:iam::user/%s", accountID))
 
+			if audience != "" {
+				ctx = withAudience(ctx, audience)
+			}
+
+			if scopes, ok := scope.scopes(); ok {
+				ctx = enforcer.WithScopes(ctx, scopes)
+			}
+
 			return next(ctx, request)
 		}
 	}
 }
 
+// withAudience adds audience to the request and policy context.
+func withAudience(ctx context.Context, audience string) context.Context {
+	values := enforcer.Context{}
+	if existing, ok := enforcer.PolicyContext(ctx); ok {
+		for k, v := range existing {
+			values[k] = v
+		}
+	}
+	values["audience"] = audience
+
+	ctx = context.WithValue(ctx, ContextKeyAudience, audience)
+	return enforcer.WithPolicyContext(ctx, values)
+}
+
+// Audience returns the audience of the access token used to
+// authenticate the request.
+func Audience(ctx context.Context) (string, bool) {
+	audience, ok := ctx.Value(ContextKeyAudience).(string)
+	return audience, ok
+}
+
+// scopeClaim holds the OAuth2 "scope" claim of an access token. The
+// scope is usually a space separated string but a list of strings is
+// accepted as well.
+type scopeClaim struct {
+	Scope interface{} `json:"scope"`
+}
+
+// scopes returns the scopes granted to the token. The returned bool is
+// false if the token does not have a scope claim and is therefore not
+// limited by scopes.
+func (c scopeClaim) scopes() ([]string, bool) {
+	switch v := c.Scope.(type) {
+	case string:
+		return strings.Fields(v), true
+	case []interface{}:
+		scopes := make([]string, 0, len(v))
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+		return scopes, true
+	}
+
+	return nil, false
+}
+
 // LogFields returns authn related fields that might be useful in
 // log statements.
 func LogFields(ctx context.Context) []interface{} {
@@ -0,0 +1,176 @@
+package authn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Error codes defined by RFC 6750 for the WWW-Authenticate header.
+const (
+	// ErrorCodeInvalidRequest is used if the authorization header
+	// is malformed.
+	ErrorCodeInvalidRequest = "invalid_request"
+
+	// ErrorCodeInvalidToken is used if the access token is malformed,
+	// expired, revoked or has not been issued for IAM.
+	ErrorCodeInvalidToken = "invalid_token"
+)
+
+// Realm is the realm announced in the WWW-Authenticate header.
+const Realm = "iam"
+
+// AuthenticationError is returned by NewAuthenticator and
+// NewCertificateAuthenticator if a request cannot be authenticated.
+type AuthenticationError struct {
+	// Code is the RFC 6750 error code. It is empty if the request
+	// does not contain any credentials.
+	Code string
+
+	// Description is a human readable description of the error.
+	Description string
+
+	// Err is the underlying error, if any.
+	Err error
+}
+
+var (
+	// ErrMissingToken is returned if a request neither contains an
+	// access token nor a client certificate.
+	ErrMissingToken = &AuthenticationError{Description: "missing access token"}
+
+	// ErrMalformedAuthorization is returned if the Authorization header
+	// does not contain a bearer token.
+	ErrMalformedAuthorization = &AuthenticationError{Code: ErrorCodeInvalidRequest, Description: "malformed authorization header"}
+
+	// ErrMissingCertificate is returned by NewCertificateAuthenticator if
+	// the request is not authenticated by a known client certificate.
+	ErrMissingCertificate = &AuthenticationError{Description: "missing or unknown client certificate"}
+)
+
+// ErrInvalidCredentials is returned by Service.VerifyPassword if the
+// username or password is wrong or the account cannot be used to log in.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// newInvalidTokenError returns an AuthenticationError for a token that
+// failed verification because of err.
+func newInvalidTokenError(err error) *AuthenticationError {
+	var descr string
+	switch {
+	case errors.Is(err, jwt.ErrExpired):
+		descr = "token expired"
+	case errors.Is(err, jwt.ErrNotValidYet):
+		descr = "token not valid yet"
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		descr = "invalid audience"
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		descr = "invalid issuer"
+	case errors.Is(err, ErrTokenRevoked):
+		descr = "token revoked"
+	default:
+		descr = "invalid token"
+	}
+
+	return &AuthenticationError{
+		Code:        ErrorCodeInvalidToken,
+		Description: descr,
+		Err:         err,
+	}
+}
+
+func (ae *AuthenticationError) Error() string {
+	if ae.Err == nil {
+		return ae.Description
+	}
+	return fmt.Sprintf("%s: %s", ae.Description, ae.Err)
+}
+
+// Unwrap returns the underlying error.
+func (ae *AuthenticationError) Unwrap() error {
+	return ae.Err
+}
+
+// MarshalJSON implements the json.Marshaler interface and is
+// used by http.DefaultErrorEncoder. The underlying error is not
+// exposed to clients.
+func (ae *AuthenticationError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"error": ae.Description,
+	})
+}
+
+// StatusCode returns http.StatusUnauthorized and implements the
+// StatusCoder interface of go-kit's http transport.
+func (*AuthenticationError) StatusCode() int {
+	return http.StatusUnauthorized
+}
+
+// Headers returns the WWW-Authenticate challenge for the error and
+// implements the Headerer interface of go-kit's http transport.
+func (ae *AuthenticationError) Headers() http.Header {
+	params := []string{fmt.Sprintf("realm=%q", Realm)}
+	if ae.Code != "" {
+		params = append(params,
+			fmt.Sprintf("error=%q", ae.Code),
+			fmt.Sprintf("error_description=%q", ae.Description),
+		)
+	}
+
+	h := make(http.Header)
+	h.Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+	return h
+}
+
+// IsAuthenticationError reports whether err is or wraps an
+// AuthenticationError.
+func IsAuthenticationError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var ae *AuthenticationError
+	return errors.As(err, &ae)
+}
+
+// ProvisioningError is returned by a SubjectExtractorFunc if the token
+// is valid but the user it has been issued to cannot be provisioned.
+// NewAuthenticator returns it unchanged.
+type ProvisioningError struct {
+	// Reason is a human readable description of why the user cannot
+	// be provisioned.
+	Reason string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (pe *ProvisioningError) Error() string {
+	if pe.Err == nil {
+		return "cannot provision user: " + pe.Reason
+	}
+	return fmt.Sprintf("cannot provision user: %s: %s", pe.Reason, pe.Err)
+}
+
+// Unwrap returns the underlying error.
+func (pe *ProvisioningError) Unwrap() error {
+	return pe.Err
+}
+
+// MarshalJSON implements the json.Marshaler interface and is
+// used by http.DefaultErrorEncoder. The underlying error is not
+// exposed to clients.
+func (pe *ProvisioningError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"error": "cannot provision user: " + pe.Reason,
+	})
+}
+
+// StatusCode returns http.StatusForbidden and implements the
+// StatusCoder interface of go-kit's http transport.
+func (*ProvisioningError) StatusCode() int {
+	return http.StatusForbidden
+}
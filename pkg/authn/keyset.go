@@ -0,0 +1,177 @@
+package authn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// keyMissRefreshInterval limits how often the key set is refreshed
+// because a token references an unknown key.
+const keyMissRefreshInterval = time.Minute
+
+// errNoKeySet is returned (wrapped in a common.UnavailableError) if no
+// usable key set is available.
+var errNoKeySet = errors.New("no usable key set")
+
+// persistedKeySet is the format used to store the key set in a
+// iam.KeySetRepository.
+type persistedKeySet struct {
+	Keys      jose.JSONWebKeySet `json:"jwks"`
+	FetchedAt time.Time          `json:"fetchedAt"`
+}
+
+// keySet caches the JSON Web Key Set of the issuer. A cached key set
+// is refreshed in the background once it is older than refresh and
+// is still used for grace if refreshing fails. Afterwards, the key set
+// must be refreshed before any token can be verified.
+type keySet struct {
+	fetch   func(ctx context.Context) (jose.JSONWebKeySet, error)
+	repo    iam.KeySetRepository
+	refresh time.Duration
+	grace   time.Duration
+
+	// ul is held while updating the key set.
+	ul sync.Mutex
+
+	l           sync.RWMutex
+	keys        jose.JSONWebKeySet
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	refreshing  bool
+}
+
+func newKeySet(fetch func(ctx context.Context) (jose.JSONWebKeySet, error), repo iam.KeySetRepository, refresh, grace time.Duration) *keySet {
+	ks := &keySet{
+		fetch:   fetch,
+		repo:    repo,
+		refresh: refresh,
+		grace:   grace,
+	}
+
+	if repo != nil {
+		// The persisted key set is only a fallback until authn-server
+		// can be reached so there's nothing to do if we fail to load it.
+		if blob, err := repo.Load(context.Background()); err == nil {
+			var p persistedKeySet
+			if json.Unmarshal(blob, &p) == nil {
+				ks.keys = p.Keys
+				ks.fetchedAt = p.FetchedAt
+			}
+		}
+	}
+
+	return ks
+}
+
+// key returns the keys that match keyID.
+func (ks *keySet) key(ctx context.Context, keyID string) ([]jose.JSONWebKey, error) {
+	ks.l.RLock()
+	fetchedAt := ks.fetchedAt
+	lastAttempt := ks.lastAttempt
+	ks.l.RUnlock()
+
+	age := time.Since(fetchedAt)
+	switch {
+	case fetchedAt.IsZero() || age > ks.refresh+ks.grace:
+		if err := ks.update(ctx, fetchedAt); err != nil {
+			return nil, err
+		}
+	case age > ks.refresh:
+		ks.updateInBackground()
+	}
+
+	if keys := ks.lookup(keyID); len(keys) > 0 {
+		return keys, nil
+	}
+
+	// authn-server might have rotated its keys.
+	if time.Since(lastAttempt) > keyMissRefreshInterval {
+		if err := ks.update(ctx, fetchedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	return ks.lookup(keyID), nil
+}
+
+func (ks *keySet) lookup(keyID string) []jose.JSONWebKey {
+	ks.l.RLock()
+	defer ks.l.RUnlock()
+
+	if keyID == "" {
+		return ks.keys.Keys
+	}
+
+	return ks.keys.Key(keyID)
+}
+
+// update fetches the key set unless it has been updated since
+// observed.
+func (ks *keySet) update(ctx context.Context, observed time.Time) error {
+	ks.ul.Lock()
+	defer ks.ul.Unlock()
+
+	ks.l.Lock()
+	if ks.fetchedAt.After(observed) {
+		ks.l.Unlock()
+		return nil
+	}
+	ks.lastAttempt = time.Now()
+	ks.l.Unlock()
+
+	keys, err := ks.fetch(ctx)
+	if err != nil {
+		if common.IsUnavailable(err) {
+			return err
+		}
+		return common.NewUnavailableError(serviceName, err)
+	}
+	if len(keys.Keys) == 0 {
+		return common.NewUnavailableError(serviceName, errNoKeySet)
+	}
+
+	now := time.Now()
+
+	ks.l.Lock()
+	ks.keys = keys
+	ks.fetchedAt = now
+	ks.l.Unlock()
+
+	if ks.repo != nil {
+		// failing to persist the key set only matters if authn-server
+		// becomes unavailable after a restart so we don't fail here.
+		if blob, err := json.Marshal(persistedKeySet{Keys: keys, FetchedAt: now}); err == nil {
+			_ = ks.repo.Store(ctx, blob)
+		}
+	}
+
+	return nil
+}
+
+// updateInBackground starts updating the key set in a new goroutine
+// unless an update is already running.
+func (ks *keySet) updateInBackground() {
+	ks.l.Lock()
+	if ks.refreshing {
+		ks.l.Unlock()
+		return
+	}
+	ks.refreshing = true
+	observed := ks.fetchedAt
+	ks.l.Unlock()
+
+	go func() {
+		_ = ks.update(context.Background(), observed)
+
+		ks.l.Lock()
+		ks.refreshing = false
+		ks.l.Unlock()
+	}()
+}
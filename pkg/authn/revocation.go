@@ -0,0 +1,230 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// DefaultMaxTokenLifetime is the default lifetime assumed for access
+// tokens when revoking all tokens of a subject.
+const DefaultMaxTokenLifetime = 24 * time.Hour
+
+// ErrTokenRevoked is returned by extractors wrapped using
+// RevocationList.Wrap if the token has been revoked.
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// RevocationList keeps track of revoked access tokens. Revocations are
+// persisted in a iam.RevocationRepository and cached in memory so checking
+// a token does not hit the repository.
+type RevocationList struct {
+	repo        iam.RevocationRepository
+	maxLifetime time.Duration
+
+	l        sync.RWMutex
+	tokens   map[string]iam.Revocation
+	subjects map[string]iam.Revocation
+}
+
+// NewRevocationList returns a new revocation list backed by repo and
+// loads all revocations stored in repo. maxTokenLifetime is the maximum
+// lifetime of access tokens issued by authn-server and determines how
+// long subject revocations need to be kept. If zero, DefaultMaxTokenLifetime
+// is used.
+func NewRevocationList(ctx context.Context, repo iam.RevocationRepository, maxTokenLifetime time.Duration) (*RevocationList, error) {
+	if maxTokenLifetime == 0 {
+		maxTokenLifetime = DefaultMaxTokenLifetime
+	}
+
+	rl := &RevocationList{
+		repo:        repo,
+		maxLifetime: maxTokenLifetime,
+		tokens:      make(map[string]iam.Revocation),
+		subjects:    make(map[string]iam.Revocation),
+	}
+
+	revocations, err := repo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, r := range revocations {
+		if !r.IsExpired(now) {
+			rl.add(r)
+		}
+	}
+
+	return rl, nil
+}
+
+// add adds r to the cache. It requires rl.l to be held or rl not
+// yet to be shared.
+func (rl *RevocationList) add(r iam.Revocation) {
+	if r.TokenID != "" {
+		rl.tokens[r.TokenID] = r
+		return
+	}
+
+	rl.subjects[r.Subject] = r
+}
+
+// RevokeToken revokes the token with the given ID ("jti" claim). expires
+// should be set to the expiration time of the token. If expires is zero,
+// the token is assumed to expire after the maximum token lifetime.
+func (rl *RevocationList) RevokeToken(ctx context.Context, tokenID string, expires time.Time) error {
+	if tokenID == "" {
+		return errors.New("missing token ID")
+	}
+
+	if expires.IsZero() {
+		expires = time.Now().Add(rl.maxLifetime)
+	}
+
+	r := iam.Revocation{
+		TokenID: tokenID,
+		Expires: expires,
+	}
+
+	rl.l.Lock()
+	defer rl.l.Unlock()
+
+	if err := rl.repo.Store(ctx, r); err != nil {
+		return err
+	}
+
+	rl.add(r)
+	return nil
+}
+
+// RevokeSubject revokes all tokens that have been issued to subject
+// before notBefore.
+func (rl *RevocationList) RevokeSubject(ctx context.Context, subject string, notBefore time.Time) error {
+	if subject == "" {
+		return errors.New("missing subject")
+	}
+
+	// JWT timestamps have a resolution of one second.
+	notBefore = notBefore.Truncate(time.Second)
+
+	rl.l.Lock()
+	defer rl.l.Unlock()
+
+	if existing, ok := rl.subjects[subject]; ok && existing.NotBefore.After(notBefore) {
+		return nil
+	}
+
+	r := iam.Revocation{
+		Subject:   subject,
+		NotBefore: notBefore,
+		Expires:   notBefore.Add(rl.maxLifetime),
+	}
+
+	if err := rl.repo.Store(ctx, r); err != nil {
+		return err
+	}
+
+	rl.add(r)
+	return nil
+}
+
+// IsRevoked reports whether the token described by claims has been
+// revoked. The claims must have been verified already.
+func (rl *RevocationList) IsRevoked(claims jwt.Claims) bool {
+	rl.l.RLock()
+	defer rl.l.RUnlock()
+
+	if claims.ID != "" {
+		if _, ok := rl.tokens[claims.ID]; ok {
+			return true
+		}
+	}
+
+	r, ok := rl.subjects[claims.Subject]
+	if !ok {
+		return false
+	}
+
+	// tokens without an "iat" claim are treated as revoked as
+	// we cannot tell when they have been issued.
+	if claims.IssuedAt == nil {
+		return true
+	}
+
+	return !claims.IssuedAt.Time().After(r.NotBefore)
+}
+
+// Wrap returns a SubjectExtractorFunc that uses fn to verify a token
+// and returns ErrTokenRevoked if the token has been revoked.
+func (rl *RevocationList) Wrap(fn SubjectExtractorFunc) SubjectExtractorFunc {
+	return func(token string) (string, string, error) {
+		subject, audience, err := fn(token)
+		if err != nil {
+			return "", "", err
+		}
+
+		// fn verified the token already so it's safe to
+		// just read the claims.
+		parsed, err := jwt.ParseSigned(token)
+		if err != nil {
+			return "", "", err
+		}
+
+		var claims jwt.Claims
+		if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+			return "", "", err
+		}
+
+		if rl.IsRevoked(claims) {
+			return "", "", ErrTokenRevoked
+		}
+
+		return subject, audience, nil
+	}
+}
+
+// Cleanup removes all expired revocations from the cache and the
+// repository.
+func (rl *RevocationList) Cleanup(ctx context.Context) error {
+	now := time.Now()
+
+	rl.l.Lock()
+	defer rl.l.Unlock()
+
+	for key, r := range rl.tokens {
+		if r.IsExpired(now) {
+			delete(rl.tokens, key)
+		}
+	}
+
+	for key, r := range rl.subjects {
+		if r.IsExpired(now) {
+			delete(rl.subjects, key)
+		}
+	}
+
+	return rl.repo.DeleteExpired(ctx, now)
+}
+
+// RunCleanup calls Cleanup every interval until ctx is cancelled.
+func (rl *RevocationList) RunCleanup(ctx context.Context, interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := rl.Cleanup(ctx); err != nil {
+				level.Warn(logger).Log("msg", "failed to clean up expired revocations", "err", err)
+			}
+		}
+	}
+}
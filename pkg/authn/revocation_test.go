@@ -0,0 +1,98 @@
+package authn_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestRevocationList(t *testing.T) {
+	ctx := context.Background()
+	repo := inmem.NewRevocationRepository()
+	now := time.Now()
+
+	rl, err := authn.NewRevocationList(ctx, repo, time.Hour)
+	require.NoError(t, err)
+
+	claims := func(id, subject string, issuedAt time.Time) jwt.Claims {
+		return jwt.Claims{
+			ID:       id,
+			Subject:  subject,
+			IssuedAt: jwt.NewNumericDate(issuedAt),
+		}
+	}
+
+	assert.False(t, rl.IsRevoked(claims("1", "10", now)))
+
+	require.NoError(t, rl.RevokeToken(ctx, "1", now.Add(time.Hour)))
+	assert.True(t, rl.IsRevoked(claims("1", "10", now)))
+	assert.False(t, rl.IsRevoked(claims("2", "10", now)))
+
+	require.NoError(t, rl.RevokeSubject(ctx, "10", now))
+	assert.True(t, rl.IsRevoked(claims("2", "10", now.Add(-time.Minute))))
+	assert.True(t, rl.IsRevoked(claims("2", "10", now)))
+	assert.False(t, rl.IsRevoked(claims("2", "10", now.Add(time.Minute))))
+	assert.False(t, rl.IsRevoked(claims("2", "11", now.Add(-time.Minute))))
+
+	// an older not-before must not overwrite a newer one
+	require.NoError(t, rl.RevokeSubject(ctx, "10", now.Add(-time.Hour)))
+	assert.True(t, rl.IsRevoked(claims("2", "10", now)))
+
+	// revocations are loaded from the repository
+	rl, err = authn.NewRevocationList(ctx, repo, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, rl.IsRevoked(claims("1", "10", now.Add(time.Minute))))
+	assert.True(t, rl.IsRevoked(claims("2", "10", now)))
+}
+
+func TestRevocationList_Cleanup(t *testing.T) {
+	ctx := context.Background()
+	repo := inmem.NewRevocationRepository()
+	now := time.Now()
+
+	require.NoError(t, repo.Store(ctx, iam.Revocation{TokenID: "expired", Expires: now.Add(-time.Second)}))
+
+	rl, err := authn.NewRevocationList(ctx, repo, time.Hour)
+	require.NoError(t, err)
+
+	// expired revocations are not loaded at all
+	assert.False(t, rl.IsRevoked(jwt.Claims{ID: "expired"}))
+
+	require.NoError(t, rl.RevokeToken(ctx, "1", now.Add(-time.Second)))
+	require.NoError(t, rl.RevokeToken(ctx, "2", now.Add(time.Hour)))
+	require.NoError(t, rl.Cleanup(ctx))
+
+	assert.False(t, rl.IsRevoked(jwt.Claims{ID: "1"}))
+	assert.True(t, rl.IsRevoked(jwt.Claims{ID: "2"}))
+
+	revocations, err := repo.Get(ctx)
+	require.NoError(t, err)
+	assert.Len(t, revocations, 1)
+}
+
+func TestRevocationList_Wrap(t *testing.T) {
+	svc, srv := setupAuthnTestBed(t)
+	defer srv.Close()
+	ctx := context.Background()
+
+	rl, err := authn.NewRevocationList(ctx, inmem.NewRevocationRepository(), time.Hour)
+	require.NoError(t, err)
+
+	extract := rl.Wrap(svc.ExtractTokenSubject)
+	token := srv.Token(10, testAudience)
+
+	subject, _, err := extract(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "10", subject)
+
+	require.NoError(t, rl.RevokeSubject(ctx, "10", time.Now()))
+	_, _, err = extract(token)
+	assert.Equal(t, authn.ErrTokenRevoked, err)
+}
@@ -1,11 +1,16 @@
 package authn
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"strconv"
-	"strings"
+	"time"
 
-	"github.com/keratin/authn-go/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	jose "gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
@@ -17,94 +22,364 @@ type Account struct {
 	Deleted  bool   `json:"deleted"`
 }
 
-// Service provides access to authn-server
+// Service provides access to authn-server. All methods except
+// ExtractTokenSubject return common.NotFoundError if the account
+// does not exist, common.ConflictError if the request conflicts with
+// an existing account and common.UnavailableError if authn-server
+// cannot be reached.
 type Service interface {
 	// ImportAccount creates a new account at authn-server
-	ImportAccount(username, password string, locked bool) (int, error)
+	ImportAccount(ctx context.Context, username, password string, locked bool) (int, error)
 
-	// GetAccount returns an authn account
-	GetAccount(id int) (Account, error)
+	// GetAccount returns an authn account.
+	GetAccount(ctx context.Context, id int) (Account, error)
 
 	// LockAccount locks a user account
-	LockAccount(accountID int) error
+	LockAccount(ctx context.Context, accountID int) error
 
 	// UnlockAccount unlocks a user account
-	UnlockAccount(accountID int) error
+	UnlockAccount(ctx context.Context, accountID int) error
 
 	// ArchiveAccount archives a user account
-	ArchiveAccount(id int) error
+	ArchiveAccount(ctx context.Context, id int) error
+
+	// UpdateUsername changes the username of an account.
+	UpdateUsername(ctx context.Context, id int, username string) error
+
+	// SetPassword replaces the password of an account.
+	SetPassword(ctx context.Context, id int, password string) error
+
+	// ExpirePassword expires the password of an account. The user
+	// is required to choose a new password during the next login.
+	ExpirePassword(ctx context.Context, id int) error
+
+	// VerifyPassword verifies the credentials of an account by logging in
+	// at authn-server and returns the account ID. It returns an error
+	// wrapping ErrInvalidCredentials if the credentials are wrong, the
+	// account is locked or its password has expired.
+	VerifyPassword(ctx context.Context, username, password string) (int, error)
 
 	// ExtractTokenSubject verifies the JWT token and returns
-	// the subject it was issued to.
-	ExtractTokenSubject(token string) (string, error)
+	// the subject it was issued to. Tokens are verified using
+	// a cached copy of the issuer's key set so authn-server is
+	// only contacted if the key set needs to be refreshed. If
+	// Config.Audiences is set, the token must have been issued for
+	// at least one of them and the first one that matches is
+	// returned as the audience. Otherwise the audience is only
+	// returned if the token has exactly one.
+	ExtractTokenSubject(token string) (subject, audience string, err error)
 }
 
 type service struct {
-	cli       *authn.Client
+	private   *privateClient
+	public    *privateClient
+	breaker   *breaker
+	keys      *keySet
+	subjects  *subjectCache
+	cfg       Config
+	issuer    string
 	audiences jwt.Audience
 }
 
-// NewService returns a new authn-service
-func NewService(cfg Config) (Service, error) {
-	cli, err := authn.NewClient(authn.Config{
-		Issuer:         cfg.Issuer,
-		PrivateBaseURL: cfg.PrivateBaseAddress,
-		Audience:       string(cfg.Audiences[0]), // TODO(ppacher): this is ugly, make it better :)
-		Username:       cfg.Username,
-		Password:       cfg.Password,
-	})
+// NewService returns a new authn-service. If keys is not nil, the
+// key set used to verify access tokens is persisted in keys and used
+// if authn-server is unreachable after a restart.
+func NewService(cfg Config, keys iam.KeySetRepository) (Service, error) {
+	cfg.setDefaults()
+
+	if cfg.Issuer == "" {
+		return nil, errors.New("missing issuer")
+	}
+
+	// tokens are expected to contain the normalized issuer URL.
+	issuer, err := url.Parse(cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	base := cfg.PrivateBaseAddress
+	if base == "" {
+		base = cfg.Issuer
+	}
+
+	private, err := newPrivateClient(base, cfg.Username, cfg.Password)
 	if err != nil {
 		return nil, err
 	}
 
-	return &service{
-		cli: cli,
-	}, nil
+	public, err := newPrivateClient(cfg.Issuer, "", "")
+	if err != nil {
+		return nil, err
+	}
+	public.origin = cfg.Origin
+
+	s := &service{
+		private:  private,
+		public:   public,
+		breaker:  newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		subjects: newSubjectCache(cfg.SubjectCacheSize),
+		cfg:      cfg,
+		issuer:   issuer.String(),
+
+		// tokens must have been issued for at least one of
+		// the configured audiences.
+		audiences: cfg.Audiences,
+	}
+
+	s.keys = newKeySet(s.fetchKeySet, keys, cfg.KeySetRefreshInterval, cfg.KeySetGracePeriod)
+
+	return s, nil
+}
+
+// call invokes fn with a per-call deadline. If idempotent is true and fn
+// fails because authn-server is unavailable, the call is retried up to
+// cfg.MaxRetries times.
+func (s *service) call(ctx context.Context, idempotent bool, fn func(ctx context.Context) error) error {
+	attempts := 1
+	if idempotent && s.cfg.MaxRetries > 0 {
+		attempts += s.cfg.MaxRetries
+	}
+
+	var err error
+	backoff := s.cfg.RetryBackoff
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				return common.NewUnavailableError(serviceName, ctx.Err())
+			}
+		}
+
+		call, ok := s.breaker.allow()
+		if !ok {
+			return common.NewUnavailableError(serviceName, errCircuitOpen)
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+		err = fn(callCtx)
+		cancel()
+
+		// Don't blame authn-server if our caller gave up.
+		if ctx.Err() != nil {
+			s.breaker.release(call)
+			return common.NewUnavailableError(serviceName, ctx.Err())
+		}
+
+		s.breaker.done(call, err)
+		if !common.IsUnavailable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s *service) ImportAccount(ctx context.Context, username, password string, locked bool) (int, error) {
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+	form.Set("locked", strconv.FormatBool(locked))
+
+	var result struct {
+		ID int `json:"id"`
+	}
+
+	// importing an account is not idempotent as a retry
+	// might fail with a conflict if the first attempt
+	// actually succeeded.
+	err := s.call(ctx, false, func(ctx context.Context) error {
+		return s.private.do(ctx, "POST", "accounts/import", form, &result)
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return result.ID, nil
 }
 
-func (s *service) ImportAccount(username, password string, locked bool) (int, error) {
-	return s.cli.ImportAccount(username, password, locked)
+func (s *service) LockAccount(ctx context.Context, accountID int) error {
+	return s.call(ctx, true, func(ctx context.Context) error {
+		return s.private.do(ctx, "PATCH", "accounts/"+strconv.Itoa(accountID)+"/lock", nil, nil)
+	})
+}
+
+func (s *service) UnlockAccount(ctx context.Context, accountID int) error {
+	return s.call(ctx, true, func(ctx context.Context) error {
+		return s.private.do(ctx, "PATCH", "accounts/"+strconv.Itoa(accountID)+"/unlock", nil, nil)
+	})
 }
 
-func (s *service) LockAccount(accountID int) error {
-	return s.cli.LockAccount(strconv.Itoa(accountID))
+func (s *service) ArchiveAccount(ctx context.Context, id int) error {
+	return s.call(ctx, true, func(ctx context.Context) error {
+		return s.private.do(ctx, "DELETE", "accounts/"+strconv.Itoa(id), nil, nil)
+	})
 }
 
-func (s *service) UnlockAccount(accountID int) error {
-	return s.cli.UnlockAccount(strconv.Itoa(accountID))
+func (s *service) UpdateUsername(ctx context.Context, id int, username string) error {
+	form := url.Values{}
+	form.Set("username", username)
+
+	return s.call(ctx, true, func(ctx context.Context) error {
+		return s.private.do(ctx, "PATCH", "accounts/"+strconv.Itoa(id), form, nil)
+	})
 }
 
-func (s *service) ArchiveAccount(id int) error {
-	return s.cli.ArchiveAccount(strconv.Itoa(id))
+func (s *service) SetPassword(ctx context.Context, id int, password string) error {
+	form := url.Values{}
+	form.Set("password", password)
+
+	return s.call(ctx, true, func(ctx context.Context) error {
+		return s.private.do(ctx, "PATCH", "accounts/"+strconv.Itoa(id)+"/password", form, nil)
+	})
 }
 
-func (s *service) GetAccount(id int) (Account, error) {
-	ac, err := s.cli.GetAccount(strconv.Itoa(id))
+func (s *service) ExpirePassword(ctx context.Context, id int) error {
+	return s.call(ctx, true, func(ctx context.Context) error {
+		return s.private.do(ctx, "PATCH", "accounts/"+strconv.Itoa(id)+"/expire_password", nil, nil)
+	})
+}
+
+func (s *service) GetAccount(ctx context.Context, id int) (Account, error) {
+	var ac Account
+
+	err := s.call(ctx, true, func(ctx context.Context) error {
+		return s.private.do(ctx, "GET", "accounts/"+strconv.Itoa(id), nil, &ac)
+	})
 	if err != nil {
 		return Account{}, err
 	}
 
-	return Account{
-		ID:       ac.ID,
-		Username: ac.Username,
-		Locked:   ac.Locked,
-		Deleted:  ac.Deleted,
-	}, nil
+	return ac, nil
+}
+
+func (s *service) VerifyPassword(ctx context.Context, username, password string) (int, error) {
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+
+	var result struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err := s.call(ctx, true, func(ctx context.Context) error {
+		return s.public.do(ctx, "POST", "session", form, &result)
+	})
+	if common.IsInvalidArgument(err) || common.IsNotFound(err) {
+		return -1, fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	// The identity token has been received from authn-server
+	// directly so there's no need to verify its signature.
+	token, err := jwt.ParseSigned(result.IDToken)
+	if err != nil {
+		return -1, err
+	}
+
+	var claims jwt.Claims
+	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return -1, err
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return -1, fmt.Errorf("unexpected subject %q: %w", claims.Subject, err)
+	}
+
+	return id, nil
 }
 
-func (s *service) ExtractTokenSubject(token string) (string, error) {
+func (s *service) fetchKeySet(ctx context.Context) (jose.JSONWebKeySet, error) {
+	var set jose.JSONWebKeySet
+
+	err := s.call(ctx, true, func(ctx context.Context) error {
+		var err error
+		set, err = s.private.keySet(ctx)
+		return err
+	})
+
+	return set, err
+}
+
+func (s *service) ExtractTokenSubject(token string) (string, string, error) {
+	if subject, audience, ok := s.subjects.get(token); ok {
+		return subject, audience, nil
+	}
+
+	claims, audience, err := s.verify(context.Background(), token)
+	if err != nil {
+		return "", "", err
+	}
+
+	if claims.Expiry != nil {
+		s.subjects.put(token, claims.Subject, audience, claims.Expiry.Time())
+	}
+
+	return claims.Subject, audience, nil
+}
+
+// verify verifies the signature and claims of token and returns the
+// configured audience the token has been issued for (see
+// ExtractTokenSubject).
+func (s *service) verify(ctx context.Context, token string) (jwt.Claims, string, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return jwt.Claims{}, "", err
+	}
+
+	if len(parsed.Headers) != 1 {
+		return jwt.Claims{}, "", errors.New("multi-signature JWTs are not supported")
+	}
+
+	keys, err := s.keys.key(ctx, parsed.Headers[0].KeyID)
+	if err != nil {
+		return jwt.Claims{}, "", err
+	}
+	if len(keys) == 0 {
+		return jwt.Claims{}, "", errors.New("unknown signing key")
+	}
+
+	var claims jwt.Claims
+	for _, key := range keys {
+		if err = parsed.Claims(key, &claims); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return jwt.Claims{}, "", err
+	}
+
+	expected := jwt.Expected{
+		Issuer: s.issuer,
+		Time:   time.Now(),
+	}
+
 	if len(s.audiences) == 0 {
-		return s.cli.SubjectFromWithAudience(token, nil)
+		if err := claims.Validate(expected); err != nil {
+			return jwt.Claims{}, "", err
+		}
+
+		var audience string
+		if len(claims.Audience) == 1 {
+			audience = claims.Audience[0]
+		}
+		return claims, audience, nil
 	}
 
-	errors := make([]string, 0, len(s.audiences))
 	for _, audience := range s.audiences {
-		if subject, err := s.cli.SubjectFromWithAudience(token, jwt.Audience{audience}); err == nil {
-			return subject, nil
-		} else {
-			errors = append(errors, err.Error())
+		expected.Audience = jwt.Audience{audience}
+		err := claims.Validate(expected)
+		if err == nil {
+			return claims, audience, nil
+		}
+		if err != jwt.ErrInvalidAudience {
+			return jwt.Claims{}, "", err
 		}
 	}
 
-	return "", fmt.Errorf("invalid audience: %s", strings.Join(errors, ", "))
+	return jwt.Claims{}, "", fmt.Errorf("%w: %v", jwt.ErrInvalidAudience, []string(claims.Audience))
 }
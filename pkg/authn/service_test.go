@@ -0,0 +1,463 @@
+package authn_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn/authntest"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const testAudience = "identity.example.com"
+
+func setupAuthnTestBed(t *testing.T) (authn.Service, *authntest.Server) {
+	srv := authntest.NewServer()
+
+	svc, err := authn.NewService(srv.Config(testAudience), nil)
+	require.NoError(t, err)
+
+	return svc, srv
+}
+
+func TestService_Accounts(t *testing.T) {
+	svc, srv := setupAuthnTestBed(t)
+	defer srv.Close()
+	ctx := context.Background()
+
+	id, err := svc.ImportAccount(ctx, "admin", "password", false)
+	require.NoError(t, err)
+
+	account, err := svc.GetAccount(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, authn.Account{ID: id, Username: "admin"}, account)
+
+	_, err = svc.ImportAccount(ctx, "admin", "password", false)
+	assert.True(t, common.IsConflict(err))
+
+	assert.NoError(t, svc.LockAccount(ctx, id))
+	account, _ = srv.Account(id)
+	assert.True(t, account.Locked)
+
+	assert.NoError(t, svc.UnlockAccount(ctx, id))
+	account, _ = srv.Account(id)
+	assert.False(t, account.Locked)
+
+	assert.NoError(t, svc.ArchiveAccount(ctx, id))
+	account, err = svc.GetAccount(ctx, id)
+	assert.NoError(t, err)
+	assert.True(t, account.Deleted)
+
+	_, err = svc.GetAccount(ctx, 100)
+	assert.True(t, common.IsNotFound(err))
+}
+
+func TestService_VerifyPassword(t *testing.T) {
+	svc, srv := setupAuthnTestBed(t)
+	defer srv.Close()
+	ctx := context.Background()
+
+	id := srv.AddAccount("alice", "secret", false)
+
+	accountID, err := svc.VerifyPassword(ctx, "alice", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, id, accountID)
+
+	_, err = svc.VerifyPassword(ctx, "alice", "wrong")
+	assert.True(t, errors.Is(err, authn.ErrInvalidCredentials))
+
+	_, err = svc.VerifyPassword(ctx, "bob", "secret")
+	assert.True(t, errors.Is(err, authn.ErrInvalidCredentials))
+
+	require.NoError(t, svc.LockAccount(ctx, id))
+	_, err = svc.VerifyPassword(ctx, "alice", "secret")
+	assert.True(t, errors.Is(err, authn.ErrInvalidCredentials))
+}
+
+func TestService_Unavailable(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	svc, err := authn.NewService(authn.Config{
+		Audiences:        []string{testAudience},
+		Issuer:           srv.URL,
+		MaxRetries:       2,
+		RetryBackoff:     time.Millisecond,
+		BreakerThreshold: 4,
+		BreakerCooldown:  time.Hour,
+	}, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// idempotent calls are retried
+	_, err = svc.GetAccount(ctx, 1)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+
+	// imports are not
+	_, err = svc.ImportAccount(ctx, "admin", "password", false)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hits))
+
+	// the breaker is open now and rejects calls without
+	// contacting authn-server
+	err = svc.LockAccount(ctx, 1)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hits))
+}
+
+func TestService_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	svc, err := authn.NewService(authn.Config{
+		Audiences:  []string{testAudience},
+		Issuer:     srv.URL,
+		Timeout:    10 * time.Millisecond,
+		MaxRetries: -1,
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = svc.GetAccount(context.Background(), 1)
+	assert.True(t, common.IsUnavailable(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = svc.ArchiveAccount(ctx, 1)
+	assert.True(t, common.IsUnavailable(err))
+}
+
+func TestService_ExtractTokenSubject(t *testing.T) {
+	svc, srv := setupAuthnTestBed(t)
+	defer srv.Close()
+
+	subject, _, err := svc.ExtractTokenSubject(srv.Token(10, testAudience))
+	assert.NoError(t, err)
+	assert.Equal(t, "10", subject)
+
+	other := authntest.NewServer()
+	defer other.Close()
+
+	_, _, err = svc.ExtractTokenSubject(other.Token(10, testAudience))
+	assert.Error(t, err)
+}
+
+func TestService_OfflineVerification(t *testing.T) {
+	srv := authntest.NewServer()
+	keys := inmem.NewKeySetRepository()
+
+	svc, err := authn.NewService(srv.Config(testAudience), keys)
+	require.NoError(t, err)
+
+	first := srv.Token(10, testAudience)
+	subject, _, err := svc.ExtractTokenSubject(first)
+	require.NoError(t, err)
+	assert.Equal(t, "10", subject)
+
+	srv.Close()
+
+	// the subject of first is memoized and the key set is cached
+	// so both tokens can be verified without authn-server.
+	subject, _, err = svc.ExtractTokenSubject(first)
+	assert.NoError(t, err)
+	assert.Equal(t, "10", subject)
+
+	subject, _, err = svc.ExtractTokenSubject(srv.Token(11, testAudience))
+	assert.NoError(t, err)
+	assert.Equal(t, "11", subject)
+
+	// a new service uses the persisted key set
+	svc, err = authn.NewService(srv.Config(testAudience), keys)
+	require.NoError(t, err)
+
+	subject, _, err = svc.ExtractTokenSubject(srv.Token(12, testAudience))
+	assert.NoError(t, err)
+	assert.Equal(t, "12", subject)
+
+	// once the grace period is over the key set must be
+	// refreshed.
+	cfg := srv.Config(testAudience)
+	cfg.KeySetRefreshInterval = time.Nanosecond
+	cfg.KeySetGracePeriod = time.Nanosecond
+	cfg.MaxRetries = -1
+	svc, err = authn.NewService(cfg, keys)
+	require.NoError(t, err)
+
+	_, _, err = svc.ExtractTokenSubject(srv.Token(13, testAudience))
+	assert.True(t, common.IsUnavailable(err))
+}
+
+func TestService_ExtractTokenSubject_Invalid(t *testing.T) {
+	svc, srv := setupAuthnTestBed(t)
+	defer srv.Close()
+
+	now := time.Now()
+	expired, err := srv.Sign(jwt.Claims{
+		Issuer:   srv.URL,
+		Subject:  "10",
+		Audience: jwt.Audience{testAudience},
+		IssuedAt: jwt.NewNumericDate(now.Add(-2 * time.Hour)),
+		Expiry:   jwt.NewNumericDate(now.Add(-time.Hour)),
+	})
+	require.NoError(t, err)
+
+	_, _, err = svc.ExtractTokenSubject(expired)
+	assert.Error(t, err)
+
+	otherIssuer, err := srv.Sign(jwt.Claims{
+		Issuer:  "https://example.com",
+		Subject: "10",
+		Expiry:  jwt.NewNumericDate(now.Add(time.Hour)),
+	})
+	require.NoError(t, err)
+
+	_, _, err = svc.ExtractTokenSubject(otherIssuer)
+	assert.Error(t, err)
+
+	_, _, err = svc.ExtractTokenSubject("not-a-token")
+	assert.Error(t, err)
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	svc, srv := setupAuthnTestBed(t)
+	defer srv.Close()
+
+	var subject string
+	ep := authn.NewAuthenticator(svc.ExtractTokenSubject)(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		subject, _ = enforcer.Subject(ctx)
+		return nil, nil
+	})
+
+	ctx := context.WithValue(context.Background(), kithttp.ContextKeyRequestAuthorization, "Bearer "+srv.Token(10, testAudience))
+	_, err := ep(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "urn:iam::user/10", subject)
+
+	_, err = ep(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestNewAuthenticator_Scopes(t *testing.T) {
+	svc, srv := setupAuthnTestBed(t)
+	defer srv.Close()
+
+	var (
+		scopes []string
+		scoped bool
+	)
+	ep := authn.NewAuthenticator(svc.ExtractTokenSubject)(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		scopes, scoped = enforcer.Scopes(ctx)
+		return nil, nil
+	})
+
+	authenticate := func(extra ...interface{}) {
+		now := time.Now()
+		token, err := srv.Sign(jwt.Claims{
+			Issuer:   srv.URL,
+			Subject:  "10",
+			Audience: jwt.Audience{testAudience},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		}, extra...)
+		require.NoError(t, err)
+
+		ctx := context.WithValue(context.Background(), kithttp.ContextKeyRequestAuthorization, "Bearer "+token)
+		_, err = ep(ctx, nil)
+		require.NoError(t, err)
+	}
+
+	authenticate()
+	assert.False(t, scoped)
+
+	authenticate(map[string]interface{}{"scope": "groups:read iam:user:load"})
+	assert.True(t, scoped)
+	assert.Equal(t, []string{"groups:read", "iam:user:load"}, scopes)
+
+	authenticate(map[string]interface{}{"scope": []string{"groups:read"}})
+	assert.True(t, scoped)
+	assert.Equal(t, []string{"groups:read"}, scopes)
+
+	authenticate(map[string]interface{}{"scope": ""})
+	assert.True(t, scoped)
+	assert.Empty(t, scopes)
+}
+
+func TestNewAuthenticator_Errors(t *testing.T) {
+	svc, srv := setupAuthnTestBed(t)
+	defer srv.Close()
+
+	authenticate := func(fn authn.SubjectExtractorFunc, authorization string) error {
+		ep := authn.NewAuthenticator(fn)(func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		})
+
+		ctx := context.Background()
+		if authorization != "" {
+			ctx = context.WithValue(ctx, kithttp.ContextKeyRequestAuthorization, authorization)
+		}
+
+		_, err := ep(ctx, nil)
+		return err
+	}
+
+	assertAuthError := func(t *testing.T, err error, code, descr string) {
+		var ae *authn.AuthenticationError
+		require.True(t, errors.As(err, &ae), "expected an authentication error, got %v", err)
+		assert.Equal(t, code, ae.Code)
+		assert.Equal(t, descr, ae.Description)
+		assert.Equal(t, http.StatusUnauthorized, ae.StatusCode())
+	}
+
+	t.Run("Missing", func(t *testing.T) {
+		err := authenticate(svc.ExtractTokenSubject, "")
+		assertAuthError(t, err, "", "missing access token")
+		assert.Equal(t, `Bearer realm="iam"`, err.(*authn.AuthenticationError).Headers().Get("WWW-Authenticate"))
+	})
+
+	t.Run("MalformedHeader", func(t *testing.T) {
+		err := authenticate(svc.ExtractTokenSubject, "Basic Zm9vOmJhcg==")
+		assertAuthError(t, err, authn.ErrorCodeInvalidRequest, "malformed authorization header")
+	})
+
+	t.Run("MalformedToken", func(t *testing.T) {
+		err := authenticate(svc.ExtractTokenSubject, "Bearer not-a-token")
+		assertAuthError(t, err, authn.ErrorCodeInvalidToken, "invalid token")
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		now := time.Now()
+		expired, err := srv.Sign(jwt.Claims{
+			Issuer:   srv.URL,
+			Subject:  "10",
+			Audience: jwt.Audience{testAudience},
+			IssuedAt: jwt.NewNumericDate(now.Add(-2 * time.Hour)),
+			Expiry:   jwt.NewNumericDate(now.Add(-time.Hour)),
+		})
+		require.NoError(t, err)
+
+		err = authenticate(svc.ExtractTokenSubject, "Bearer "+expired)
+		assertAuthError(t, err, authn.ErrorCodeInvalidToken, "token expired")
+		assert.Equal(t,
+			`Bearer realm="iam", error="invalid_token", error_description="token expired"`,
+			err.(*authn.AuthenticationError).Headers().Get("WWW-Authenticate"),
+		)
+	})
+
+	t.Run("WrongAudience", func(t *testing.T) {
+		err := authenticate(svc.ExtractTokenSubject, "Bearer "+srv.Token(10, "other"))
+		assertAuthError(t, err, authn.ErrorCodeInvalidToken, "invalid audience")
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		fn := func(string) (string, string, error) { return "", "", common.NewUnavailableError("authn-server", nil) }
+		err := authenticate(fn, "Bearer "+srv.Token(10, testAudience))
+		assert.True(t, common.IsUnavailable(err))
+		assert.False(t, authn.IsAuthenticationError(err))
+	})
+}
+
+func TestService_Audiences(t *testing.T) {
+	srv := authntest.NewServer()
+	defer srv.Close()
+
+	svc, err := authn.NewService(srv.Config("api.example.com", "admin.example.com"), nil)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		aud     []string
+		matched string
+	}{
+		{[]string{"api.example.com"}, "api.example.com"},
+		{[]string{"admin.example.com"}, "admin.example.com"},
+		{[]string{"other.example.com", "admin.example.com"}, "admin.example.com"},
+		// the first configured audience wins
+		{[]string{"admin.example.com", "api.example.com"}, "api.example.com"},
+	} {
+		subject, audience, err := svc.ExtractTokenSubject(srv.Token(10, c.aud...))
+		assert.NoError(t, err, c.aud)
+		assert.Equal(t, "10", subject)
+		assert.Equal(t, c.matched, audience, c.aud)
+	}
+
+	for _, aud := range [][]string{
+		{"other.example.com"},
+		{},
+	} {
+		_, _, err := svc.ExtractTokenSubject(srv.Token(10, aud...))
+		assert.True(t, errors.Is(err, jwt.ErrInvalidAudience), "%v: %v", aud, err)
+	}
+
+	// other validation errors are not reported as audience errors
+	now := time.Now()
+	expired, err := srv.Sign(jwt.Claims{
+		Issuer:   srv.URL,
+		Subject:  "10",
+		Audience: jwt.Audience{"admin.example.com"},
+		IssuedAt: jwt.NewNumericDate(now.Add(-2 * time.Hour)),
+		Expiry:   jwt.NewNumericDate(now.Add(-time.Hour)),
+	})
+	require.NoError(t, err)
+
+	_, _, err = svc.ExtractTokenSubject(expired)
+	assert.True(t, errors.Is(err, jwt.ErrExpired), err)
+}
+
+func TestNewAuthenticator_Audience(t *testing.T) {
+	srv := authntest.NewServer()
+	defer srv.Close()
+
+	svc, err := authn.NewService(srv.Config("api.example.com", "admin.example.com"), nil)
+	require.NoError(t, err)
+
+	var (
+		audience      string
+		hasAudience   bool
+		policyContext enforcer.Context
+	)
+	ep := authn.NewAuthenticator(svc.ExtractTokenSubject)(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		audience, hasAudience = authn.Audience(ctx)
+		policyContext, _ = enforcer.PolicyContext(ctx)
+		return nil, nil
+	})
+
+	authenticate := func(aud ...string) {
+		ctx := context.WithValue(context.Background(), kithttp.ContextKeyRequestAuthorization, "Bearer "+srv.Token(10, aud...))
+		ctx = enforcer.WithPolicyContext(ctx, enforcer.Context{"remoteIP": "10.0.0.1"})
+
+		_, err := ep(ctx, nil)
+		require.NoError(t, err)
+	}
+
+	authenticate("admin.example.com")
+	assert.True(t, hasAudience)
+	assert.Equal(t, "admin.example.com", audience)
+	assert.Equal(t, enforcer.Context{"audience": "admin.example.com", "remoteIP": "10.0.0.1"}, policyContext)
+
+	// the matched audience is used for tokens with multiple audiences
+	authenticate("other.example.com", "admin.example.com")
+	assert.True(t, hasAudience)
+	assert.Equal(t, "admin.example.com", audience)
+	assert.Equal(t, enforcer.Context{"audience": "admin.example.com", "remoteIP": "10.0.0.1"}, policyContext)
+}
@@ -0,0 +1,85 @@
+package authn
+
+import (
+	"sync"
+	"time"
+)
+
+type cachedSubject struct {
+	subject  string
+	audience string
+	expires  time.Time
+}
+
+// subjectCache memoizes the subjects and audiences of verified tokens
+// until the tokens expire.
+type subjectCache struct {
+	size int
+
+	l       sync.Mutex
+	entries map[string]cachedSubject
+}
+
+func newSubjectCache(size int) *subjectCache {
+	return &subjectCache{
+		size:    size,
+		entries: make(map[string]cachedSubject),
+	}
+}
+
+// get returns the subject and audience of token if it has been verified
+// before and has not yet expired.
+func (c *subjectCache) get(token string) (string, string, bool) {
+	if c == nil || c.size <= 0 {
+		return "", "", false
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	e, ok := c.entries[token]
+	if !ok {
+		return "", "", false
+	}
+
+	if !time.Now().Before(e.expires) {
+		delete(c.entries, token)
+		return "", "", false
+	}
+
+	return e.subject, e.audience, true
+}
+
+// put adds the subject and audience of a verified token that expires at
+// expires.
+func (c *subjectCache) put(token, subject, audience string, expires time.Time) {
+	if c == nil || c.size <= 0 {
+		return
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if len(c.entries) >= c.size {
+		now := time.Now()
+		for key, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+
+		// still full, evict a random entry
+		for key := range c.entries {
+			if len(c.entries) < c.size {
+				break
+			}
+			delete(c.entries, key)
+		}
+	}
+
+	c.entries[token] = cachedSubject{
+		subject:  subject,
+		audience: audience,
+		expires:  expires,
+	}
+}
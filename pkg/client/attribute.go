@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// AttributeClient implements a HTTP client for the user attribute
+// schema endpoints.
+type AttributeClient struct {
+	*IdentityClient
+}
+
+// Schema returns all attribute definitions sorted by name.
+func (ac *AttributeClient) Schema(ctx context.Context) (iam.AttributeSchema, error) {
+	req, err := ac.newRequest(ctx, "GET", "/v1/attributes/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ac.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Attributes iam.AttributeSchema `json:"attributes"`
+	}
+
+	return response.Attributes, ac.parseResponse(res, &response)
+}
+
+// JSONSchema returns the attribute schema as a JSON Schema document.
+func (ac *AttributeClient) JSONSchema(ctx context.Context) (map[string]interface{}, error) {
+	req, err := ac.newRequest(ctx, "GET", "/v1/attributes/?format=json-schema", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ac.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var response map[string]interface{}
+
+	return response, ac.parseResponse(res, &response)
+}
+
+// Load returns the definition of the attribute name.
+func (ac *AttributeClient) Load(ctx context.Context, name string) (iam.AttributeDefinition, error) {
+	req, err := ac.newRequest(ctx, "GET", "/v1/attributes/"+name, nil)
+	if err != nil {
+		return iam.AttributeDefinition{}, err
+	}
+
+	res, err := ac.cli.Do(req)
+	if err != nil {
+		return iam.AttributeDefinition{}, err
+	}
+
+	var response iam.AttributeDefinition
+
+	return response, ac.parseResponse(res, &response)
+}
+
+// Define creates or replaces the definition of an attribute.
+func (ac *AttributeClient) Define(ctx context.Context, def iam.AttributeDefinition) error {
+	req, err := ac.newRequest(ctx, "PUT", "/v1/attributes/"+def.Name, def)
+	if err != nil {
+		return err
+	}
+
+	res, err := ac.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return ac.parseResponse(res, nil)
+}
+
+// Delete deletes the definition of the attribute name.
+func (ac *AttributeClient) Delete(ctx context.Context, name string) error {
+	req, err := ac.newRequest(ctx, "DELETE", "/v1/attributes/"+name, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := ac.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return ac.parseResponse(res, nil)
+}
@@ -3,21 +3,44 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"strings"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
 
 // Option is used to configure the IdentityClient
 type Option func(cli *IdentityClient)
 
+// ErrPreconditionFailed is returned by conditional updates if the
+// resource has been modified in the meantime. See IfMatch.
+var ErrPreconditionFailed = errors.New("412 Precondition Failed")
+
+// IfMatch returns a new context that makes all updates performed with it
+// conditional. Updates fail with ErrPreconditionFailed unless the user,
+// group or policy is still in the given version, i.e. it has not been
+// modified since it has been loaded.
+//
+//	u, err := cli.Users().LoadUser(ctx, urn)
+//	...
+//	err = cli.Users().UpdateAttrs(client.IfMatch(ctx, u.Version), urn, attrs)
+func IfMatch(ctx context.Context, version uint64) context.Context {
+	return iam.WithIfMatch(ctx, version)
+}
+
 // IdentityClient talks to the identity-server using it's
 // HTTP API.
 type IdentityClient struct {
-	cli   *http.Client
-	url   string
-	token TokenLoader
+	cli         *http.Client
+	url         string
+	token       TokenLoader
+	impersonate iam.UserURN
 }
 
 // NewIdentityClient returns a new IdentityClient that talks to the
@@ -47,12 +70,28 @@ func (cli *IdentityClient) newRequest(ctx context.Context, method string, endpoi
 
 	req = req.Clone(ctx)
 
-	token, err := cli.token.Load()
-	if err != nil {
-		return nil, err
+	// clients that authenticate using a TLS client certificate
+	// don't have a token loader.
+	if cli.token != nil {
+		token, err := cli.token.Load()
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+
+	if cli.impersonate != "" {
+		req.Header.Set(enforcer.ImpersonationHeader, string(cli.impersonate))
 	}
 
-	req.Header.Add("Authorization", "Bearer "+token)
+	if versions, ok := iam.IfMatch(ctx); ok {
+		tags := make([]string, len(versions))
+		for i, v := range versions {
+			tags[i] = common.ETag(v)
+		}
+		req.Header.Set("If-Match", strings.Join(tags, ", "))
+	}
 
 	if body != nil {
 		blob, err := json.Marshal(body)
@@ -67,6 +106,10 @@ func (cli *IdentityClient) newRequest(ctx context.Context, method string, endpoi
 }
 
 func (cli *IdentityClient) parseResponse(res *http.Response, target interface{}) error {
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return ErrPreconditionFailed
+	}
+
 	if res.StatusCode >= 300 {
 		return errors.New(res.Status)
 	}
@@ -96,6 +139,21 @@ func (cli *IdentityClient) Policies() *PolicyClient {
 	return &PolicyClient{cli}
 }
 
+// Profile returns a ProfileClient using this IdentityClient.
+func (cli *IdentityClient) Profile() *ProfileClient {
+	return &ProfileClient{cli}
+}
+
+// Attributes returns an AttributeClient using this IdentityClient.
+func (cli *IdentityClient) Attributes() *AttributeClient {
+	return &AttributeClient{cli}
+}
+
+// Invitations returns an InvitationClient using this IdentityClient.
+func (cli *IdentityClient) Invitations() *InvitationClient {
+	return &InvitationClient{cli}
+}
+
 // WithClient sets the http.Client that should be used.
 func WithClient(cli *http.Client) Option {
 	return func(c *IdentityClient) {
@@ -103,10 +161,43 @@ func WithClient(cli *http.Client) Option {
 	}
 }
 
+// WithTLSConfig configures the TLS client configuration used to talk to
+// identity-server. Use it to authenticate using a client certificate. It
+// must be passed after WithClient. The http.Client passed to WithClient
+// is not modified but copied and its transport cloned. Transports other
+// than *http.Transport are replaced.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *IdentityClient) {
+		var transport *http.Transport
+		switch t := c.cli.Transport.(type) {
+		case nil:
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		case *http.Transport:
+			transport = t.Clone()
+		default:
+			transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+		}
+		transport.TLSClientConfig = cfg
+
+		cli := *c.cli
+		cli.Transport = transport
+		c.cli = &cli
+	}
+}
+
 // WithTokenLoader configures the access token loader to
-// use.
+// use. If loader is nil, requests are sent without an access
+// token.
 func WithTokenLoader(loader TokenLoader) Option {
 	return func(c *IdentityClient) {
 		c.token = loader
 	}
 }
+
+// WithImpersonation sends all requests on behalf of the user identified
+// by urn. The authenticated subject must be allowed to impersonate urn.
+func WithImpersonation(urn iam.UserURN) Option {
+	return func(c *IdentityClient) {
+		c.impersonate = urn
+	}
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTLSConfig(t *testing.T) {
+	cfg := &tls.Config{ServerName: "iam.example.com"}
+
+	// the client passed to WithClient is not modified
+	transport := &http.Transport{MaxIdleConns: 7}
+	hc := &http.Client{Transport: transport, Timeout: time.Second}
+	c := NewIdentityClient("https://iam.example.com", WithClient(hc), WithTLSConfig(cfg))
+
+	assert.Equal(t, transport, hc.Transport)
+	assert.NotSame(t, cfg, transport.TLSClientConfig)
+	assert.NotSame(t, hc, c.cli)
+	assert.Equal(t, time.Second, c.cli.Timeout)
+
+	cloned, ok := c.cli.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.Equal(t, 7, cloned.MaxIdleConns)
+	assert.Same(t, cfg, cloned.TLSClientConfig)
+
+	// the default transport is cloned as well
+	c = NewIdentityClient("https://iam.example.com", WithTLSConfig(cfg))
+	cloned, ok = c.cli.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.NotSame(t, http.DefaultTransport, cloned)
+	assert.Same(t, cfg, cloned.TLSClientConfig)
+	assert.NotSame(t, cfg, http.DefaultTransport.(*http.Transport).TLSClientConfig)
+}
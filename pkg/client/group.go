@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"errors"
+	"net/url"
 
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
@@ -15,7 +16,18 @@ type GroupClient struct {
 
 // Get returns all groups managed by IAM.
 func (gc *GroupClient) Get(ctx context.Context) ([]iam.Group, error) {
-	req, err := gc.newRequest(ctx, "GET", "/v1/groups/", nil)
+	return gc.Query(ctx, iam.ListQuery{})
+}
+
+// Query returns all groups matching q in the order requested by q.
+func (gc *GroupClient) Query(ctx context.Context, q iam.ListQuery) ([]iam.Group, error) {
+	params := url.Values{}
+	q.MetadataFilter.Encode(params)
+	if q.SortBy != "" {
+		params.Set("sort", string(q.SortBy))
+	}
+
+	req, err := gc.newRequest(ctx, "GET", "/v1/groups/?"+params.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
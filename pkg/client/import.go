@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// ImportRowResult is the result of importing a single user.
+type ImportRowResult struct {
+	Row        int          `json:"row"`
+	Username   string       `json:"username"`
+	Status     string       `json:"status"`
+	ID         iam.UserURN  `json:"id,omitempty"`
+	Invitation string       `json:"invitation,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Fields     []FieldError `json:"fields,omitempty"`
+	RolledBack bool         `json:"rolledBack,omitempty"`
+}
+
+// FieldError describes why the value of a single field is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"error"`
+}
+
+// ImportReport holds the results of a bulk import.
+type ImportReport struct {
+	DryRun  bool              `json:"dryRun"`
+	Created int               `json:"created"`
+	Invited int               `json:"invited"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// ImportUsers creates users from r which holds a file in the given format
+// (csv, json or yaml). If dryRun is true the records are only validated.
+// The report contains the result of each record.
+func (uc *UserClient) ImportUsers(ctx context.Context, format string, r io.Reader, dryRun bool) (ImportReport, error) {
+	params := url.Values{
+		"format": {format},
+		"dryRun": {strconv.FormatBool(dryRun)},
+	}
+
+	req, err := uc.newRequest(ctx, "POST", "/v1/users/import?"+params.Encode(), nil)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	req.Body = ioutil.NopCloser(r)
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	var report ImportReport
+	if err := uc.parseResponse(res, &report); err != nil {
+		return ImportReport{}, err
+	}
+
+	return report, nil
+}
+
+// ExportUsers writes all users including their attributes and group
+// memberships to w using the given format (csv, json or yaml).
+func (uc *UserClient) ExportUsers(ctx context.Context, format string, w io.Writer) error {
+	req, err := uc.newRequest(ctx, "GET", "/v1/users/export?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return errors.New(res.Status)
+	}
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
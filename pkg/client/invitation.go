@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// InvitationClient implements a HTTP client for the invitation
+// endpoints.
+type InvitationClient struct {
+	*IdentityClient
+}
+
+// Invite invites a new user with the given username, attributes and
+// groups. The invite token is delivered to the invitee by
+// identity-server and not returned.
+func (ic *InvitationClient) Invite(ctx context.Context, username string, attrs map[string]interface{}, groups []iam.GroupURN) (iam.Invitation, error) {
+	body := struct {
+		Username   string                 `json:"username"`
+		Attributes map[string]interface{} `json:"attrs,omitempty"`
+		Groups     []iam.GroupURN         `json:"groups,omitempty"`
+	}{
+		Username:   username,
+		Attributes: attrs,
+		Groups:     groups,
+	}
+
+	req, err := ic.newRequest(ctx, "POST", "/v1/invitations/", body)
+	if err != nil {
+		return iam.Invitation{}, err
+	}
+
+	res, err := ic.cli.Do(req)
+	if err != nil {
+		return iam.Invitation{}, err
+	}
+
+	var inv iam.Invitation
+	if err := ic.parseResponse(res, &inv); err != nil {
+		return iam.Invitation{}, err
+	}
+
+	return inv, nil
+}
+
+// Invitations returns all invitations sorted by username. state is
+// either pending, expired or empty to return all invitations.
+func (ic *InvitationClient) Invitations(ctx context.Context, state string) ([]iam.Invitation, error) {
+	params := url.Values{}
+	if state != "" {
+		params.Set("state", state)
+	}
+
+	req, err := ic.newRequest(ctx, "GET", "/v1/invitations/?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ic.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Invitations []iam.Invitation `json:"invitations"`
+	}
+
+	if err := ic.parseResponse(res, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Invitations, nil
+}
+
+// Load returns the invitation id.
+func (ic *InvitationClient) Load(ctx context.Context, id string) (iam.Invitation, error) {
+	req, err := ic.newRequest(ctx, "GET", "/v1/invitations/"+url.PathEscape(id), nil)
+	if err != nil {
+		return iam.Invitation{}, err
+	}
+
+	res, err := ic.cli.Do(req)
+	if err != nil {
+		return iam.Invitation{}, err
+	}
+
+	var inv iam.Invitation
+	if err := ic.parseResponse(res, &inv); err != nil {
+		return iam.Invitation{}, err
+	}
+
+	return inv, nil
+}
+
+// Revoke revokes the invitation id.
+func (ic *InvitationClient) Revoke(ctx context.Context, id string) error {
+	req, err := ic.newRequest(ctx, "DELETE", "/v1/invitations/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := ic.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return ic.parseResponse(res, nil)
+}
+
+// Accept accepts the invitation identified by token and creates the
+// invited user with password. It does not require an access token.
+func (ic *InvitationClient) Accept(ctx context.Context, token, password string) (iam.UserURN, error) {
+	body := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{
+		Token:    token,
+		Password: password,
+	}
+
+	// invitees don't have an account yet.
+	anonymous := *ic.IdentityClient
+	anonymous.token = nil
+	anonymous.impersonate = ""
+
+	req, err := anonymous.newRequest(ctx, "POST", "/v1/invitations/accept", body)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := anonymous.cli.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		URN iam.UserURN `json:"urn"`
+	}
+	if err := anonymous.parseResponse(res, &response); err != nil {
+		return "", err
+	}
+
+	return response.URN, nil
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"errors"
+	"net/url"
 
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
@@ -104,7 +105,18 @@ func (pc *PolicyClient) Update(ctx context.Context, urn iam.PolicyURN, p iam.Pol
 
 // List returns a list of all available policies.
 func (pc *PolicyClient) List(ctx context.Context) ([]iam.Policy, error) {
-	req, err := pc.newRequest(ctx, "GET", "/v1/policies/", nil)
+	return pc.Query(ctx, iam.ListQuery{})
+}
+
+// Query returns all policies matching q in the order requested by q.
+func (pc *PolicyClient) Query(ctx context.Context, q iam.ListQuery) ([]iam.Policy, error) {
+	params := url.Values{}
+	q.MetadataFilter.Encode(params)
+	if q.SortBy != "" {
+		params.Set("sort", string(q.SortBy))
+	}
+
+	req, err := pc.newRequest(ctx, "GET", "/v1/policies/?"+params.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
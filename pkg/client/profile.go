@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// ProfileClient provides access to the profile of the authenticated
+// subject.
+type ProfileClient struct {
+	*IdentityClient
+}
+
+// Profile is the IAM user of the authenticated subject including its
+// group memberships and the actions it is allowed to perform.
+type Profile struct {
+	iam.User
+
+	Groups      []iam.GroupURN `json:"groups"`
+	Permissions []string       `json:"permissions"`
+}
+
+// UserInfo holds the OpenID Connect claims of the authenticated subject.
+type UserInfo struct {
+	Subject           string   `json:"sub"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// Me returns the profile of the authenticated subject.
+func (pc *ProfileClient) Me(ctx context.Context) (Profile, error) {
+	req, err := pc.newRequest(ctx, "GET", "/v1/me", nil)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	res, err := pc.cli.Do(req)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	var p Profile
+	if err := pc.parseResponse(res, &p); err != nil {
+		return Profile{}, err
+	}
+
+	return p, nil
+}
+
+// UserInfo returns the OpenID Connect claims of the authenticated
+// subject.
+func (pc *ProfileClient) UserInfo(ctx context.Context) (UserInfo, error) {
+	req, err := pc.newRequest(ctx, "GET", "/userinfo", nil)
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	res, err := pc.cli.Do(req)
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	var info UserInfo
+	if err := pc.parseResponse(res, &info); err != nil {
+		return UserInfo{}, err
+	}
+
+	return info, nil
+}
+
+// SetAttr updates an attribute of the authenticated subject. The
+// attribute must be user-editable.
+func (pc *ProfileClient) SetAttr(ctx context.Context, key string, value interface{}) error {
+	req, err := pc.newRequest(ctx, "PUT", "/v1/me/attrs/"+key, value)
+	if err != nil {
+		return err
+	}
+
+	res, err := pc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return pc.parseResponse(res, nil)
+}
+
+// DeleteAttr deletes an attribute of the authenticated subject. The
+// attribute must be user-editable.
+func (pc *ProfileClient) DeleteAttr(ctx context.Context, key string) error {
+	req, err := pc.newRequest(ctx, "DELETE", "/v1/me/attrs/"+key, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := pc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return pc.parseResponse(res, nil)
+}
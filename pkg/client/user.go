@@ -3,6 +3,9 @@ package client
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
 
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
@@ -67,7 +70,38 @@ func (uc *UserClient) LoadUser(ctx context.Context, urn iam.UserURN) (iam.User,
 	return u, nil
 }
 
-// DeleteUser deletes the user identified by URN.
+// LoadUserByUsername loads the user with the given username.
+func (uc *UserClient) LoadUserByUsername(ctx context.Context, username string) (iam.User, error) {
+	return uc.lookupUser(ctx, url.Values{"username": {username}})
+}
+
+// LoadUserByAttr loads the user whose unique attribute key is set to
+// value.
+func (uc *UserClient) LoadUserByAttr(ctx context.Context, key, value string) (iam.User, error) {
+	return uc.lookupUser(ctx, url.Values{"attr": {key}, "value": {value}})
+}
+
+func (uc *UserClient) lookupUser(ctx context.Context, params url.Values) (iam.User, error) {
+	req, err := uc.newRequest(ctx, "GET", "/v1/users/?"+params.Encode(), nil)
+	if err != nil {
+		return iam.User{}, err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return iam.User{}, err
+	}
+
+	var u iam.User
+	if err := uc.parseResponse(res, &u); err != nil {
+		return iam.User{}, err
+	}
+
+	return u, nil
+}
+
+// DeleteUser soft-deletes the user identified by URN. It can be
+// restored using RestoreUser until it is purged.
 func (uc *UserClient) DeleteUser(ctx context.Context, urn iam.UserURN) error {
 	id := urn.AccountID()
 	if id == "" {
@@ -87,6 +121,47 @@ func (uc *UserClient) DeleteUser(ctx context.Context, urn iam.UserURN) error {
 	return uc.parseResponse(res, nil)
 }
 
+// RestoreUser restores the soft-deleted user identified by URN.
+func (uc *UserClient) RestoreUser(ctx context.Context, urn iam.UserURN) error {
+	id := urn.AccountID()
+	if id == "" {
+		return errors.New("Invalid UserURN")
+	}
+
+	req, err := uc.newRequest(ctx, "POST", "/v1/users/"+id+"/restore", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return uc.parseResponse(res, nil)
+}
+
+// PurgeUser permanently deletes the user identified by URN and
+// archives its account on authn-server.
+func (uc *UserClient) PurgeUser(ctx context.Context, urn iam.UserURN) error {
+	id := urn.AccountID()
+	if id == "" {
+		return errors.New("Invalid UserURN")
+	}
+
+	req, err := uc.newRequest(ctx, "DELETE", "/v1/users/"+id+"?purge=true", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return uc.parseResponse(res, nil)
+}
+
 // LockUser locks or unlocks the user identified by URN.
 func (uc *UserClient) LockUser(ctx context.Context, urn iam.UserURN, locked bool) error {
 	id := urn.AccountID()
@@ -112,6 +187,118 @@ func (uc *UserClient) LockUser(ctx context.Context, urn iam.UserURN, locked bool
 	return uc.parseResponse(res, nil)
 }
 
+// RevokeTokens revokes all access tokens issued to the user identified
+// by URN.
+func (uc *UserClient) RevokeTokens(ctx context.Context, urn iam.UserURN) error {
+	id := urn.AccountID()
+	if id == "" {
+		return errors.New("Invalid UserURN")
+	}
+
+	req, err := uc.newRequest(ctx, "DELETE", "/v1/users/"+id+"/tokens", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return uc.parseResponse(res, nil)
+}
+
+// RevokeToken revokes a single access token by its ID ("jti" claim).
+func (uc *UserClient) RevokeToken(ctx context.Context, tokenID string) error {
+	if tokenID == "" {
+		return errors.New("missing token ID")
+	}
+
+	req, err := uc.newRequest(ctx, "DELETE", "/v1/tokens/"+url.PathEscape(tokenID), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return uc.parseResponse(res, nil)
+}
+
+// SetUsername changes the username of the user identified by URN.
+func (uc *UserClient) SetUsername(ctx context.Context, urn iam.UserURN, username string) error {
+	id := urn.AccountID()
+	if id == "" {
+		return errors.New("Invalid UserURN")
+	}
+
+	body := struct {
+		Username string `json:"username"`
+	}{
+		Username: username,
+	}
+
+	req, err := uc.newRequest(ctx, "PUT", "/v1/users/"+id+"/username", body)
+	if err != nil {
+		return err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return uc.parseResponse(res, nil)
+}
+
+// SetPassword sets a new password for the user identified by URN.
+func (uc *UserClient) SetPassword(ctx context.Context, urn iam.UserURN, password string) error {
+	id := urn.AccountID()
+	if id == "" {
+		return errors.New("Invalid UserURN")
+	}
+
+	body := struct {
+		Password string `json:"password"`
+	}{
+		Password: password,
+	}
+
+	req, err := uc.newRequest(ctx, "PUT", "/v1/users/"+id+"/password", body)
+	if err != nil {
+		return err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return uc.parseResponse(res, nil)
+}
+
+// ExpirePassword expires the password of the user identified by URN.
+func (uc *UserClient) ExpirePassword(ctx context.Context, urn iam.UserURN) error {
+	id := urn.AccountID()
+	if id == "" {
+		return errors.New("Invalid UserURN")
+	}
+
+	req, err := uc.newRequest(ctx, "DELETE", "/v1/users/"+id+"/password", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return uc.parseResponse(res, nil)
+}
+
 // Users returns a list of users stored and managed by IAM.
 func (uc *UserClient) Users(ctx context.Context) ([]iam.User, error) {
 	req, err := uc.newRequest(ctx, "GET", "/v1/users/", nil)
@@ -135,6 +322,117 @@ func (uc *UserClient) Users(ctx context.Context) ([]iam.User, error) {
 	return response.Users, nil
 }
 
+// QueryUsers returns a single page of users matching q. Use
+// IterateUsers to iterate over all pages.
+func (uc *UserClient) QueryUsers(ctx context.Context, q iam.UserQuery) (iam.UserPage, error) {
+	params := url.Values{}
+	if q.UsernamePrefix != "" {
+		params.Set("prefix", q.UsernamePrefix)
+	}
+	if q.Locked != nil {
+		params.Set("locked", strconv.FormatBool(*q.Locked))
+	}
+	if q.Deleted {
+		params.Set("deleted", "true")
+	}
+	if q.Group != "" {
+		params.Set("group", string(q.Group))
+	}
+	for key, value := range q.Attributes {
+		params.Set("attrs."+key, value)
+	}
+	q.MetadataFilter.Encode(params)
+	if q.SortBy != "" {
+		params.Set("sort", string(q.SortBy))
+	}
+	if q.Cursor != "" {
+		params.Set("cursor", q.Cursor)
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	req, err := uc.newRequest(ctx, "GET", "/v1/users/?"+params.Encode(), nil)
+	if err != nil {
+		return iam.UserPage{}, err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return iam.UserPage{}, err
+	}
+
+	var page iam.UserPage
+	if err := uc.parseResponse(res, &page); err != nil {
+		return iam.UserPage{}, err
+	}
+
+	return page, nil
+}
+
+// UserIterator iterates over all users matching a query fetching one
+// page at a time.
+//
+//	it := cli.Users().IterateUsers(ctx, iam.UserQuery{Limit: 100})
+//	for it.Next() {
+//		fmt.Println(it.User().Username)
+//	}
+//	if err := it.Err(); err != nil {
+//		// handle error
+//	}
+type UserIterator struct {
+	uc    *UserClient
+	ctx   context.Context
+	q     iam.UserQuery
+	users []iam.User
+	user  iam.User
+	done  bool
+	err   error
+}
+
+// IterateUsers returns an iterator over all users matching q. q.Limit
+// is used as the page size.
+func (uc *UserClient) IterateUsers(ctx context.Context, q iam.UserQuery) *UserIterator {
+	return &UserIterator{
+		uc:  uc,
+		ctx: ctx,
+		q:   q,
+	}
+}
+
+// Next advances the iterator to the next user. It returns false once
+// all users have been returned or an error occurred.
+func (it *UserIterator) Next() bool {
+	for len(it.users) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		page, err := it.uc.QueryUsers(it.ctx, it.q)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.users = page.Users
+		it.q.Cursor = page.NextCursor
+		it.done = page.NextCursor == ""
+	}
+
+	it.user, it.users = it.users[0], it.users[1:]
+	return true
+}
+
+// User returns the current user.
+func (it *UserIterator) User() iam.User {
+	return it.user
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
 // UpdateAttrs updates a users attributes.
 func (uc *UserClient) UpdateAttrs(ctx context.Context, urn iam.UserURN, attrs map[string]interface{}) error {
 	id := urn.AccountID()
@@ -194,3 +492,68 @@ func (uc *UserClient) DeleteAttr(ctx context.Context, urn iam.UserURN, key strin
 
 	return uc.parseResponse(res, nil)
 }
+
+// PatchAttrs applies patch to the attributes of a user. patch must be
+// an iam.MergePatch or an iam.JSONPatch.
+func (uc *UserClient) PatchAttrs(ctx context.Context, urn iam.UserURN, patch iam.AttrPatch) error {
+	id := urn.AccountID()
+	if id == "" {
+		return errors.New("Invalid UserURN")
+	}
+
+	var contentType string
+	switch patch.(type) {
+	case iam.MergePatch:
+		contentType = iam.MergePatchContentType
+	case iam.JSONPatch:
+		contentType = iam.JSONPatchContentType
+	default:
+		return errors.New("unsupported patch type")
+	}
+
+	req, err := uc.newRequest(ctx, "PATCH", "/v1/users/"+id+"/attrs/", patch)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return uc.parseResponse(res, nil)
+}
+
+// ReconcileReport describes the changes and inconsistencies detected
+// while reconciling IAM users with authn-server accounts.
+type ReconcileReport struct {
+	Updated   []iam.UserURN     `json:"updated,omitempty"`
+	Deleted   []iam.UserURN     `json:"deleted,omitempty"`
+	Orphaned  []iam.UserURN     `json:"orphaned,omitempty"`
+	Unmanaged []int             `json:"unmanaged,omitempty"`
+	Adopted   []iam.UserURN     `json:"adopted,omitempty"`
+	Failed    map[string]string `json:"failed,omitempty"`
+}
+
+// Reconcile synchronizes IAM users with authn-server accounts. If adopt
+// is true, IAM users are created for all authn-server accounts that are
+// not yet managed by IAM.
+func (uc *UserClient) Reconcile(ctx context.Context, adopt bool) (ReconcileReport, error) {
+	req, err := uc.newRequest(ctx, "POST", fmt.Sprintf("/v1/users/reconcile?adopt=%t", adopt), nil)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+
+	res, err := uc.cli.Do(req)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+
+	var report ReconcileReport
+	if err := uc.parseResponse(res, &report); err != nil {
+		return ReconcileReport{}, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"context"
+	"time"
+)
+
+type withoutCancelCtx struct {
+	parent context.Context
+}
+
+// WithoutCancel returns a context that keeps all values of parent, e.g.
+// the request subject, but is never cancelled and has no deadline. It
+// is used to roll back changes after the request context has been
+// cancelled.
+func WithoutCancel(parent context.Context) context.Context {
+	return withoutCancelCtx{parent}
+}
+
+func (withoutCancelCtx) Deadline() (deadline time.Time, ok bool) {
+	return
+}
+
+func (withoutCancelCtx) Done() <-chan struct{} {
+	return nil
+}
+
+func (withoutCancelCtx) Err() error {
+	return nil
+}
+
+func (c withoutCancelCtx) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,23 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKey struct{}
+
+func TestWithoutCancel(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.WithValue(context.Background(), testKey{}, "value"), time.Hour)
+	cancel()
+
+	ctx := WithoutCancel(parent)
+	assert.NoError(t, ctx.Err())
+	assert.Nil(t, ctx.Done())
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+	assert.Equal(t, "value", ctx.Value(testKey{}))
+}
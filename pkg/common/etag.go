@@ -0,0 +1,46 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ETag returns the HTTP entity tag for version.
+func ETag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// ParseETag parses an entity tag returned by ETag. Weak tags are not
+// supported.
+func ParseETag(tag string) (uint64, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	v, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return v, true
+}
+
+// ParseIfMatch parses the value of an If-Match header and returns the
+// versions it requires. The second return value is false if the header
+// does not restrict updates, i.e. if it is empty or "*". Entity tags
+// that are not returned by ETag are skipped as they never match.
+func ParseIfMatch(header string) ([]uint64, bool) {
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return nil, false
+	}
+
+	var versions []uint64
+	for _, tag := range strings.Split(header, ",") {
+		if v, ok := ParseETag(tag); ok {
+			versions = append(versions, v)
+		}
+	}
+
+	return versions, true
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETag(t *testing.T) {
+	assert.Equal(t, `"3"`, ETag(3))
+
+	v, ok := ParseETag(` "3" `)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), v)
+
+	for _, invalid := range []string{"", "3", `W/"3"`, `"three"`, `"`} {
+		_, ok := ParseETag(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestParseIfMatch(t *testing.T) {
+	cases := []struct {
+		header   string
+		versions []uint64
+		ok       bool
+	}{
+		{"", nil, false},
+		{" * ", nil, false},
+		{`"1"`, []uint64{1}, true},
+		{`"1", "2"`, []uint64{1, 2}, true},
+		{`W/"1", "2"`, []uint64{2}, true},
+		{`"one"`, nil, true},
+	}
+
+	for _, c := range cases {
+		versions, ok := ParseIfMatch(c.header)
+		assert.Equal(t, c.ok, ok, c.header)
+		assert.Equal(t, c.versions, versions, c.header)
+	}
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// PreconditionFailedError is returned when a conditional request
+// cannot be performed because the resource has been modified, i.e. its
+// version does not match the one required by the request.
+type PreconditionFailedError struct {
+	// ResourceKind is the kind of resource that has been modified.
+	ResourceKind string
+}
+
+func (pfe *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("%s has been modified", pfe.ResourceKind)
+}
+
+// MarshalJSON implements the json.Marshaller interface and is
+// used by http.DefaultErrorEncoder.
+func (pfe *PreconditionFailedError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"error": pfe.Error(),
+	})
+}
+
+// StatusCode returns http.StatusPreconditionFailed and implements the
+// StatusCoder interface of go-kit's http transport.
+func (*PreconditionFailedError) StatusCode() int {
+	return http.StatusPreconditionFailed
+}
+
+// IsPreconditionFailed reports whether err is a PreconditionFailedError
+// or not. IsPreconditionFailed returns false if err is nil.
+func IsPreconditionFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pfe *PreconditionFailedError
+	return errors.As(err, &pfe)
+}
+
+// NewPreconditionFailedError returns a new precondition-failed error
+// for resourceKind.
+func NewPreconditionFailedError(resourceKind string) error {
+	return &PreconditionFailedError{ResourceKind: resourceKind}
+}
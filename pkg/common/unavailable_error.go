@@ -0,0 +1,61 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// UnavailableError is returned when an operation failed because a
+// service it depends on is (temporarily) unavailable.
+type UnavailableError struct {
+	// Service is the name of the unavailable service.
+	Service string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (ue *UnavailableError) Error() string {
+	if ue.Err == nil {
+		return fmt.Sprintf("%s unavailable", ue.Service)
+	}
+	return fmt.Sprintf("%s unavailable: %s", ue.Service, ue.Err)
+}
+
+// Unwrap returns the underlying error.
+func (ue *UnavailableError) Unwrap() error {
+	return ue.Err
+}
+
+// MarshalJSON implements the json.Marshaler interface and is
+// used by http.DefaultErrorEncoder.
+func (ue *UnavailableError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"error": ue.Error(),
+	})
+}
+
+// StatusCode returns http.StatusServiceUnavailable and implements the
+// StatusCoder interface of go-kit's http transport.
+func (*UnavailableError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// IsUnavailable reports whether err is or wraps an UnavailableError.
+// IsUnavailable returns false if err is nil.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var ue *UnavailableError
+	return errors.As(err, &ue)
+}
+
+// NewUnavailableError returns a new unavailable error for service
+// caused by err.
+func NewUnavailableError(service string, err error) error {
+	return &UnavailableError{Service: service, Err: err}
+}
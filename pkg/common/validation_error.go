@@ -0,0 +1,71 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FieldError describes why the value of a single field is invalid.
+type FieldError struct {
+	// Field is the name of the invalid field.
+	Field string `json:"field"`
+
+	// Message describes why the value of Field is invalid.
+	Message string `json:"error"`
+}
+
+func (fe FieldError) String() string {
+	return fmt.Sprintf("%s: %s", fe.Field, fe.Message)
+}
+
+// ValidationError indicates that an operation failed because one or
+// more fields of the request are invalid. ValidationError is an invalid
+// argument error so IsInvalidArgument reports true for it.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (ve *ValidationError) Error() string {
+	msgs := make([]string, len(ve.Fields))
+	for i, fe := range ve.Fields {
+		msgs[i] = fe.String()
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether target is an InvalidArgumentError.
+func (*ValidationError) Is(target error) bool {
+	_, ok := target.(*InvalidArgumentError)
+	return ok
+}
+
+// MarshalJSON implements the json.Marshaler interface and is used
+// by http.DefaultErrorEncoder
+func (ve *ValidationError) MarshalJSON() ([]byte, error) {
+	fields := ve.Fields
+	if fields == nil {
+		fields = []FieldError{}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"error":  ve.Error(),
+		"fields": fields,
+	})
+}
+
+// StatusCode returns http.StatusBadRequest and implements the
+// StatusCoder interface of go-kit's http transport.
+func (*ValidationError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// NewValidationError returns a new validation error for fields. It
+// returns nil if fields is empty.
+func NewValidationError(fields []FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: fields}
+}
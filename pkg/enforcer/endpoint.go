@@ -2,6 +2,7 @@ package enforcer
 
 import (
 	"context"
+	"sync"
 
 	"github.com/go-kit/kit/endpoint"
 )
@@ -24,6 +25,10 @@ const (
 	// ContextKeyContext is used to store additional context values for an
 	// operation.
 	ContextKeyContext contextKey = "enforcer:context"
+
+	// ContextKeyRequestCache is used to store the cache of a request (see
+	// Once).
+	ContextKeyRequestCache contextKey = "enforcer:request-cache"
 )
 
 // WithSubject adds subject to the request context.
@@ -82,6 +87,40 @@ func PolicyContext(ctx context.Context) (Context, bool) {
 	return val.(Context), true
 }
 
+type requestCache struct {
+	mu      sync.Mutex
+	results map[interface{}]error
+}
+
+// WithRequestCache adds an empty request cache to ctx (see Once).
+func WithRequestCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ContextKeyRequestCache, &requestCache{
+		results: make(map[interface{}]error),
+	})
+}
+
+// Once calls fn only once per key for the lifetime of the request cache of
+// ctx and returns the remembered result on subsequent calls. Enforcers use it
+// to avoid loading the same data for every decision of a request. If ctx
+// does not have a request cache fn is called every time.
+func Once(ctx context.Context, key interface{}, fn func() error) error {
+	cache, ok := ctx.Value(ContextKeyRequestCache).(*requestCache)
+	if !ok {
+		return fn()
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if err, ok := cache.results[key]; ok {
+		return err
+	}
+
+	err := fn()
+	cache.results[key] = err
+	return err
+}
+
 // NewActionEndpoint adds the specified endpoint the each request context of
 // the wrapped endpoint.
 func NewActionEndpoint(action string) endpoint.Middleware {
@@ -111,9 +150,27 @@ func NewResourceEndpoint(resourcer func(ctx context.Context, request interface{}
 
 // NewEnforcedEndpoint returns an endpoint.Middleware that uses enforcer to ensure
 // the request subject is allowed to perform the given action on a resource.
+// If the request impersonates another user (see NewImpersonationHandler), the
+// subject must be allowed to perform ActionImpersonate on the impersonated user
+// and both, the subject and the impersonated user, must be allowed to perform
+// the action. The impersonated user becomes the subject of the request while
+// the original subject is available using Actor.
+// Requests with token scopes (see WithScopes) are limited to the actions
+// allowed by their scopes. Scopes are used as action patterns unless
+// enforcer has been created using NewScopedEnforcer with a ScopeMapping.
+// A request cache (see Once) is added to the request context unless it
+// already has one.
 func NewEnforcedEndpoint(enforcer Enforcer) endpoint.Middleware {
+	if _, ok := enforcer.(*ScopedEnforcer); !ok {
+		enforcer = NewScopedEnforcer(enforcer, nil)
+	}
+
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if _, ok := ctx.Value(ContextKeyRequestCache).(*requestCache); !ok {
+				ctx = WithRequestCache(ctx)
+			}
+
 			action, ok := Action(ctx)
 			if !ok {
 				return nil, &PermissionDeniedError{"No action defined"}
@@ -133,6 +190,12 @@ func NewEnforcedEndpoint(enforcer Enforcer) endpoint.Middleware {
 
 			context, _ := PolicyContext(ctx)
 
+			ctx, err := impersonate(ctx, enforcer, subject, action, resource, context)
+			if err != nil {
+				return nil, err
+			}
+			subject, _ = Subject(ctx)
+
 			if err := enforcer.Enforce(ctx, subject, action, resource, context); err != nil {
 				return nil, err
 			}
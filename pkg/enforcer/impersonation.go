@@ -0,0 +1,165 @@
+package enforcer
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// ActionImpersonate is the action a subject must be allowed to
+// perform on a user URN in order to impersonate that user.
+const ActionImpersonate = "iam:user:impersonate"
+
+// ImpersonationHeader is the HTTP header used to request impersonation
+// of the user URN set as its value.
+const ImpersonationHeader = "X-Impersonate-User"
+
+const (
+	// ContextKeyActor is used to store the real subject of an impersonated
+	// request in a request context. The impersonated user is stored using
+	// ContextKeySubject.
+	ContextKeyActor contextKey = "enforcer:actor"
+
+	contextKeyImpersonation contextKey = "enforcer:impersonation"
+)
+
+// impersonation tracks an impersonated request so it can be
+// logged once the request has been served.
+type impersonation struct {
+	target string
+
+	l        sync.Mutex
+	actor    string
+	action   string
+	resource string
+	err      error
+}
+
+func (imp *impersonation) record(actor, action, resource string, err error) {
+	imp.l.Lock()
+	defer imp.l.Unlock()
+
+	imp.actor = actor
+	imp.action = action
+	imp.resource = resource
+	imp.err = err
+}
+
+// WithImpersonation requests the impersonation of target for the
+// request context. The impersonation is authorized and applied by
+// NewEnforcedEndpoint.
+func WithImpersonation(ctx context.Context, target string) context.Context {
+	return context.WithValue(ctx, contextKeyImpersonation, &impersonation{target: target})
+}
+
+// WithActor adds the real subject of an impersonated request to the
+// request context.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, ContextKeyActor, actor)
+}
+
+// Actor returns the real subject of the request. For requests that
+// are not impersonated, the actor is the subject.
+func Actor(ctx context.Context) (string, bool) {
+	if actor, ok := ctx.Value(ContextKeyActor).(string); ok {
+		return actor, true
+	}
+
+	return Subject(ctx)
+}
+
+// IsImpersonated reports whether ctx belongs to an impersonated request.
+func IsImpersonated(ctx context.Context) bool {
+	_, ok := ctx.Value(ContextKeyActor).(string)
+	return ok
+}
+
+// impersonate authorizes the impersonation requested in ctx, if any,
+// and returns a context with the impersonated user as the subject.
+// Impersonated requests must be allowed for the impersonated user as
+// well as for the actor so impersonation cannot be used to gain
+// additional privileges.
+func impersonate(ctx context.Context, e Enforcer, actor, action, resource string, policyContext Context) (context.Context, error) {
+	imp, ok := ctx.Value(contextKeyImpersonation).(*impersonation)
+	if !ok {
+		return ctx, nil
+	}
+
+	err := func() error {
+		if !iam.UserURN(imp.target).IsValid() {
+			return &PermissionDeniedError{"Invalid impersonation target"}
+		}
+
+		if imp.target == actor {
+			return &PermissionDeniedError{"Cannot impersonate self"}
+		}
+
+		if err := e.Enforce(ctx, actor, ActionImpersonate, imp.target, policyContext); err != nil {
+			return err
+		}
+
+		return e.Enforce(ctx, actor, action, resource, policyContext)
+	}()
+
+	imp.record(actor, action, resource, err)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = WithActor(ctx, actor)
+	return WithSubject(ctx, imp.target), nil
+}
+
+// NewImpersonationHandler returns a http.Handler that adds the
+// impersonation requested using ImpersonationHeader to the request
+// context before calling next. Every impersonated request is logged
+// to logger including the real actor and the impersonated user.
+func NewImpersonationHandler(logger log.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get(ImpersonationHeader)
+		if target == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := WithImpersonation(r.Context(), target)
+		imp := ctx.Value(contextKeyImpersonation).(*impersonation)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		imp.l.Lock()
+		defer imp.l.Unlock()
+
+		l := level.Info(logger)
+		if imp.err != nil || imp.actor == "" {
+			l = level.Warn(logger)
+		}
+
+		l.Log(
+			"msg", "impersonated request",
+			"actor", imp.actor,
+			"subject", imp.target,
+			"action", imp.action,
+			"resource", imp.resource,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"err", imp.err,
+		)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,117 @@
+package enforcer_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+// staticEnforcer allows all subject/action/resource combinations
+// it contains.
+type staticEnforcer map[[3]string]bool
+
+func (s staticEnforcer) Enforce(_ context.Context, subject, action, resource string, _ enforcer.Context) error {
+	if s[[3]string{subject, action, resource}] {
+		return nil
+	}
+	return &enforcer.PermissionDeniedError{Reason: "not allowed"}
+}
+
+func TestImpersonation(t *testing.T) {
+	const (
+		support = "urn:iam::user/1"
+		alice   = "urn:iam::user/2"
+		admin   = "urn:iam::user/3"
+	)
+
+	authz := staticEnforcer{
+		{support, enforcer.ActionImpersonate, alice}: true,
+		{support, enforcer.ActionImpersonate, admin}: true,
+		{support, "iam:user:load", alice}:            true,
+		{alice, "iam:user:load", alice}:              true,
+		{admin, "iam:user:load", alice}:              true,
+		{admin, "iam:user:delete", alice}:            true,
+	}
+
+	var buf bytes.Buffer
+	logger := log.NewLogfmtLogger(&buf)
+
+	serve := func(action, target string) (string, string, int) {
+		var subject, actor string
+
+		ep := endpoint.Chain(
+			enforcer.NewActionEndpoint(action),
+			enforcer.NewResourceEndpoint(func(context.Context, interface{}) (string, error) { return alice, nil }),
+			enforcer.NewEnforcedEndpoint(authz),
+		)(func(ctx context.Context, _ interface{}) (interface{}, error) {
+			subject, _ = enforcer.Subject(ctx)
+			actor, _ = enforcer.Actor(ctx)
+			return nil, nil
+		})
+
+		handler := enforcer.NewImpersonationHandler(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := enforcer.WithSubject(r.Context(), support)
+			if _, err := ep(ctx, nil); err != nil {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}))
+
+		req := httptest.NewRequest("GET", "/v1/users/2", nil)
+		if target != "" {
+			req.Header.Set(enforcer.ImpersonationHeader, target)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return subject, actor, rec.Code
+	}
+
+	t.Run("NotImpersonated", func(t *testing.T) {
+		buf.Reset()
+		subject, actor, code := serve("iam:user:load", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, support, subject)
+		assert.Equal(t, support, actor)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("Impersonated", func(t *testing.T) {
+		buf.Reset()
+		subject, actor, code := serve("iam:user:load", alice)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, alice, subject)
+		assert.Equal(t, support, actor)
+		assert.Contains(t, buf.String(), "actor=urn:iam::user/1 subject=urn:iam::user/2")
+	})
+
+	t.Run("NotAllowed", func(t *testing.T) {
+		_, _, code := serve("iam:user:load", "urn:iam::user/4")
+		assert.Equal(t, http.StatusForbidden, code)
+	})
+
+	t.Run("HigherPrivileges", func(t *testing.T) {
+		// admin may delete alice but support must not be able to
+		// do so by impersonating admin.
+		buf.Reset()
+		_, _, code := serve("iam:user:delete", admin)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Contains(t, buf.String(), "level=warn")
+		assert.Contains(t, buf.String(), "status=403")
+	})
+
+	t.Run("InvalidTarget", func(t *testing.T) {
+		_, _, code := serve("iam:user:load", "alice")
+		assert.Equal(t, http.StatusForbidden, code)
+
+		_, _, code = serve("iam:user:load", support)
+		assert.Equal(t, http.StatusForbidden, code)
+	})
+}
@@ -0,0 +1,120 @@
+package enforcer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ContextKeyScopes is used to store the scopes granted to the access
+// token of a request in the request context.
+const ContextKeyScopes contextKey = "enforcer:scopes"
+
+// WithScopes adds the scopes granted to the access token of the request
+// to ctx. Requests with scopes are limited to the actions the scopes
+// allow. See NewScopedEnforcer.
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, ContextKeyScopes, scopes)
+}
+
+// Scopes returns the scopes associated with ctx. The returned bool is
+// false if the request is not limited by scopes.
+func Scopes(ctx context.Context) ([]string, bool) {
+	scopes, ok := ctx.Value(ContextKeyScopes).([]string)
+	return scopes, ok
+}
+
+// ScopeMapping maps scopes to the action patterns they allow. Patterns
+// use the syntax of path.Match, for example "iam:group:*". A scope that
+// is not part of the mapping but starts with the "iam:" prefix of all
+// IAM actions is used as an action pattern itself. Other unmapped
+// scopes, like the OpenID Connect scopes "openid", "profile" and
+// "email", are ignored (see Allows).
+type ScopeMapping map[string][]string
+
+// actionPrefix is the prefix of all IAM actions.
+const actionPrefix = "iam:"
+
+// ParseScopeMapping parses a scope mapping. Each entry has the format
+// <scope>=<pattern>. Multiple entries for the same scope are merged. For
+// example:
+//
+//	groups:read=iam:group:read
+//	groups:read=iam:user:list
+func ParseScopeMapping(entries []string) (ScopeMapping, error) {
+	m := make(ScopeMapping)
+
+	for _, e := range entries {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid scope mapping %q", e)
+		}
+
+		if _, err := path.Match(parts[1], ""); err != nil {
+			return nil, fmt.Errorf("invalid scope mapping %q: %w", e, err)
+		}
+
+		m[parts[0]] = append(m[parts[0]], parts[1])
+	}
+
+	return m, nil
+}
+
+// Allows reports whether any of scopes allows action. Scopes that are
+// neither mapped nor action patterns are ignored. If all scopes are
+// ignored, the token has not been issued for a particular set of IAM
+// actions (e.g. a plain OpenID Connect token) and is not limited by its
+// scopes. An empty list of scopes does not allow any action.
+func (m ScopeMapping) Allows(scopes []string, action string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+
+	limited := false
+	for _, scope := range scopes {
+		patterns, ok := m[scope]
+		if !ok {
+			if !strings.HasPrefix(scope, actionPrefix) {
+				continue
+			}
+			patterns = []string{scope}
+		}
+		limited = true
+
+		for _, p := range patterns {
+			if matched, _ := path.Match(p, action); matched {
+				return true
+			}
+		}
+	}
+
+	return !limited
+}
+
+// ScopedEnforcer limits the decisions of an Enforcer to the actions
+// allowed by the scopes of a request.
+type ScopedEnforcer struct {
+	enforcer Enforcer
+	mapping  ScopeMapping
+}
+
+// NewScopedEnforcer returns an Enforcer that denies all actions that are
+// not allowed by the scopes associated with the request context (see
+// WithScopes) and delegates all other decisions to e. Requests without
+// scopes are only subject to e.
+func NewScopedEnforcer(e Enforcer, mapping ScopeMapping) *ScopedEnforcer {
+	return &ScopedEnforcer{
+		enforcer: e,
+		mapping:  mapping,
+	}
+}
+
+// Enforce implements the Enforcer interface.
+func (e *ScopedEnforcer) Enforce(ctx context.Context, subject, action, resource string, context Context) error {
+	if scopes, ok := Scopes(ctx); ok && !e.mapping.Allows(scopes, action) {
+		return &PermissionDeniedError{fmt.Sprintf("Action %s not allowed by token scope", action)}
+	}
+
+	return e.enforcer.Enforce(ctx, subject, action, resource, context)
+}
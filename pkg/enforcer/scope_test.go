@@ -0,0 +1,90 @@
+package enforcer_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+func TestParseScopeMapping(t *testing.T) {
+	m, err := enforcer.ParseScopeMapping([]string{
+		"groups:read=iam:group:read",
+		"groups:read=iam:user:list",
+		"users=iam:user:*",
+	})
+	require.NoError(t, err)
+
+	assert.True(t, m.Allows([]string{"groups:read"}, "iam:group:read"))
+	assert.True(t, m.Allows([]string{"groups:read"}, "iam:user:list"))
+	assert.False(t, m.Allows([]string{"groups:read"}, "iam:group:delete"))
+	assert.True(t, m.Allows([]string{"openid", "users"}, "iam:user:delete"))
+	assert.False(t, m.Allows(nil, "iam:user:delete"))
+
+	// unmapped scopes are action patterns
+	assert.True(t, m.Allows([]string{"iam:policy:*"}, "iam:policy:list"))
+	assert.False(t, m.Allows([]string{"iam:policy:*"}, "iam:user:read"))
+
+	// other unmapped scopes are ignored so plain OpenID Connect tokens
+	// are only limited by policies.
+	assert.True(t, m.Allows([]string{"openid", "profile", "email"}, "iam:user:read"))
+	assert.False(t, m.Allows([]string{"openid", "groups:read"}, "iam:user:read"))
+
+	for _, invalid := range []string{"groups:read", "=iam:group:read", "groups:read=", "groups=[a-"} {
+		_, err := enforcer.ParseScopeMapping([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestNewEnforcedEndpoint_Scopes(t *testing.T) {
+	const subject = "urn:iam::user/1"
+
+	authz := staticEnforcer{
+		{subject, "iam:group:read", ""}:   true,
+		{subject, "iam:group:delete", ""}: true,
+	}
+
+	mapping, err := enforcer.ParseScopeMapping([]string{"groups:read=iam:group:read"})
+	require.NoError(t, err)
+
+	call := func(e enforcer.Enforcer, action string, scopes ...string) error {
+		ep := endpoint.Chain(
+			enforcer.NewActionEndpoint(action),
+			enforcer.NewEnforcedEndpoint(e),
+		)(func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		})
+
+		ctx := enforcer.WithSubject(context.Background(), subject)
+		if scopes != nil {
+			ctx = enforcer.WithScopes(ctx, scopes)
+		}
+
+		_, err := ep(ctx, nil)
+		return err
+	}
+
+	scoped := enforcer.NewScopedEnforcer(authz, mapping)
+
+	// without scopes, only policies apply
+	assert.NoError(t, call(scoped, "iam:group:delete"))
+	assert.Error(t, call(scoped, "iam:user:list"))
+
+	// with scopes, both policies and scopes must allow the action
+	assert.NoError(t, call(scoped, "iam:group:read", "groups:read"))
+	assert.Error(t, call(scoped, "iam:group:delete", "groups:read"))
+
+	// tokens with an empty scope are not allowed to do anything
+	assert.Error(t, call(scoped, "iam:group:read", []string{}...))
+
+	// scopes are honored for enforcers without a mapping as well
+	assert.NoError(t, call(authz, "iam:group:delete", "iam:group:*"))
+	assert.Error(t, call(authz, "iam:group:delete", "iam:group:read"))
+
+	// unmapped scopes that are not action patterns are ignored
+	assert.NoError(t, call(scoped, "iam:group:delete", "openid", "profile"))
+	assert.Error(t, call(scoped, "iam:group:delete", "openid", "groups:read"))
+}
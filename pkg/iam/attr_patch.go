@@ -0,0 +1,400 @@
+package iam
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+// Media types of attribute patches.
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// AttrPatch modifies the attributes of a user. Patches are applied to
+// the current attributes of a user while holding the user service lock.
+type AttrPatch interface {
+	// Apply applies the patch to attrs and returns the resulting
+	// attributes. attrs may be modified. Implementations return
+	// common.InvalidArgumentError if the patch cannot be applied.
+	Apply(attrs map[string]interface{}) (map[string]interface{}, error)
+}
+
+// AttrPatchFunc is a function that implements AttrPatch.
+type AttrPatchFunc func(attrs map[string]interface{}) (map[string]interface{}, error)
+
+// Apply implements AttrPatch.
+func (fn AttrPatchFunc) Apply(attrs map[string]interface{}) (map[string]interface{}, error) {
+	return fn(attrs)
+}
+
+// MergePatch is a JSON merge patch as defined by RFC 7396. Attributes
+// with a null value are removed, objects are merged recursively and
+// all other values replace the existing ones.
+type MergePatch map[string]interface{}
+
+// Apply implements AttrPatch.
+func (p MergePatch) Apply(attrs map[string]interface{}) (map[string]interface{}, error) {
+	// the patch may be applied more than once so its values must
+	// not become part of attrs.
+	return mergePatch(attrs, CopyAttrValue(map[string]interface{}(p))).(map[string]interface{}), nil
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok || t == nil {
+		t = make(map[string]interface{}, len(p))
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = mergePatch(t[key], value)
+		}
+	}
+
+	return t
+}
+
+// JSONPatchOperation is a single operation of a JSONPatch.
+type JSONPatchOperation struct {
+	// Op is one of add, remove, replace, move, copy or test.
+	Op string `json:"op"`
+
+	// Path is a JSON pointer (RFC 6901) to the target of the
+	// operation, e.g. /address/city. The first token is the name of
+	// the attribute.
+	Path string `json:"path"`
+
+	// From is the JSON pointer to the source of move and copy
+	// operations.
+	From string `json:"from,omitempty"`
+
+	// Value is the value for add, replace and test operations.
+	Value interface{} `json:"value"`
+}
+
+// JSONPatch is a JSON patch as defined by RFC 6902. Operations are
+// applied in order and the patch fails as a whole if one of them fails.
+type JSONPatch []JSONPatchOperation
+
+// Apply implements AttrPatch.
+func (p JSONPatch) Apply(attrs map[string]interface{}) (map[string]interface{}, error) {
+	var doc interface{} = attrs
+	if attrs == nil {
+		doc = map[string]interface{}{}
+	}
+
+	for i, op := range p {
+		var err error
+		doc, err = op.apply(doc)
+		if err != nil {
+			return nil, common.NewInvalidArgumentError(fmt.Sprintf("patch operation %d (%s %s): %s", i, op.Op, op.Path, err))
+		}
+	}
+
+	result, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, common.NewInvalidArgumentError("patch must result in an object")
+	}
+
+	return result, nil
+}
+
+// Reads returns the JSON pointers to the values read by op, i.e. the
+// path of test operations and the source of move and copy operations.
+func (op JSONPatchOperation) Reads() []string {
+	switch op.Op {
+	case "test":
+		return []string{op.Path}
+	case "move", "copy":
+		return []string{op.From}
+	default:
+		return nil
+	}
+}
+
+// PointerAttr returns the name of the attribute referenced by the JSON
+// pointer p. It returns an empty string if p references all attributes.
+func PointerAttr(p string) string {
+	tokens, err := parsePointer(p)
+	if err != nil || len(tokens) == 0 {
+		return ""
+	}
+	return tokens[0]
+}
+
+func (op JSONPatchOperation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		return pointerAdd(doc, path, CopyAttrValue(op.Value), false)
+
+	case "replace":
+		return pointerAdd(doc, path, CopyAttrValue(op.Value), true)
+
+	case "remove":
+		if len(path) == 0 {
+			return nil, fmt.Errorf("cannot remove all attributes")
+		}
+		doc, _, err = pointerRemove(doc, path)
+		return doc, err
+
+	case "test":
+		value, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(value, op.Value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if op.Op == "move" {
+			if len(from) < len(path) && isPrefix(from, path) {
+				return nil, fmt.Errorf("cannot move a value into itself")
+			}
+			doc, value, err = pointerRemove(doc, from)
+		} else {
+			value, err = pointerGet(doc, from)
+			value = CopyAttrValue(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return pointerAdd(doc, path, value, false)
+
+	default:
+		return nil, fmt.Errorf("unsupported operation")
+	}
+}
+
+// parsePointer splits a JSON pointer into its unescaped reference
+// tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid path %q", p)
+	}
+
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex parses the reference token t for an array of length n.
+// "-" refers to the element after the last one if allowEnd is true.
+func arrayIndex(t string, n int, allowEnd bool) (int, error) {
+	if t == "-" && allowEnd {
+		return n, nil
+	}
+
+	i, err := strconv.Atoi(t)
+	if err != nil || i < 0 || (t != "0" && strings.HasPrefix(t, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", t)
+	}
+
+	max := n - 1
+	if allowEnd {
+		max = n
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+
+	return i, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch n := doc.(type) {
+		case map[string]interface{}:
+			value, ok := n[t]
+			if !ok {
+				return nil, fmt.Errorf("%q not found", t)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(t, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = n[i]
+		default:
+			return nil, fmt.Errorf("%q not found", t)
+		}
+	}
+
+	return doc, nil
+}
+
+// pointerAdd adds value at path and returns the modified doc. If replace
+// is true the target must exist and array elements are replaced instead
+// of inserted.
+func pointerAdd(doc interface{}, path []string, value interface{}, replace bool) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	t, rest := path[0], path[1:]
+
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		child, ok := n[t]
+		if len(rest) == 0 {
+			if replace && !ok {
+				return nil, fmt.Errorf("%q not found", t)
+			}
+			n[t] = value
+			return n, nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("%q not found", t)
+		}
+
+		child, err := pointerAdd(child, rest, value, replace)
+		if err != nil {
+			return nil, err
+		}
+		n[t] = child
+		return n, nil
+
+	case []interface{}:
+		i, err := arrayIndex(t, len(n), len(rest) == 0 && !replace)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rest) > 0 || replace {
+			child, err := pointerAdd(n[i], rest, value, replace)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = child
+			return n, nil
+		}
+
+		n = append(n, nil)
+		copy(n[i+1:], n[i:])
+		n[i] = value
+		return n, nil
+
+	default:
+		return nil, fmt.Errorf("%q not found", t)
+	}
+}
+
+// pointerRemove removes the value at path and returns the modified doc
+// and the removed value.
+func pointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	t, rest := path[0], path[1:]
+
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		child, ok := n[t]
+		if !ok {
+			return nil, nil, fmt.Errorf("%q not found", t)
+		}
+		if len(rest) == 0 {
+			delete(n, t)
+			return n, child, nil
+		}
+
+		child, removed, err := pointerRemove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[t] = child
+		return n, removed, nil
+
+	case []interface{}:
+		i, err := arrayIndex(t, len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+
+		child, removed, err := pointerRemove(n[i], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[i] = child
+		return n, removed, nil
+
+	default:
+		return nil, nil, fmt.Errorf("%q not found", t)
+	}
+}
+
+// CopyAttrs returns a deep copy of attrs so it can be modified without
+// affecting attrs.
+func CopyAttrs(attrs map[string]interface{}) map[string]interface{} {
+	if attrs == nil {
+		return nil
+	}
+	return CopyAttrValue(attrs).(map[string]interface{})
+}
+
+// CopyAttrValue returns a deep copy of the attribute value v. Objects
+// and arrays are copied recursively.
+func CopyAttrValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = CopyAttrValue(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = CopyAttrValue(value)
+		}
+		return l
+	default:
+		return v
+	}
+}
@@ -0,0 +1,100 @@
+package iam
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+func decodeAttrs(t *testing.T, s string) map[string]interface{} {
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(s), &m))
+	return m
+}
+
+func TestMergePatch(t *testing.T) {
+	attrs := decodeAttrs(t, `{"job": "vet", "room": 12, "address": {"city": "Vienna", "zip": "1010"}}`)
+
+	var patch MergePatch
+	require.NoError(t, json.Unmarshal([]byte(`{"room": null, "phone": "123", "address": {"zip": null, "street": "Ring"}}`), &patch))
+
+	result, err := patch.Apply(attrs)
+	require.NoError(t, err)
+	assert.Equal(t, decodeAttrs(t, `{"job": "vet", "phone": "123", "address": {"city": "Vienna", "street": "Ring"}}`), result)
+
+	// nulls inside new objects are dropped
+	result, err = MergePatch{"tags": map[string]interface{}{"a": nil, "b": true}}.Apply(nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"tags": map[string]interface{}{"b": true}}, result)
+}
+
+func TestJSONPatch(t *testing.T) {
+	cases := []struct {
+		name  string
+		patch string
+		want  string
+		err   bool
+	}{
+		{"add", `[{"op": "add", "path": "/phone", "value": "123"}]`, `{"job": "vet", "phone": "123", "address": {"city": "Vienna"}, "tags": ["a", "b"]}`, false},
+		{"add nested", `[{"op": "add", "path": "/address/zip", "value": "1010"}]`, `{"job": "vet", "address": {"city": "Vienna", "zip": "1010"}, "tags": ["a", "b"]}`, false},
+		{"insert into array", `[{"op": "add", "path": "/tags/1", "value": "x"}, {"op": "add", "path": "/tags/-", "value": "z"}]`, `{"job": "vet", "address": {"city": "Vienna"}, "tags": ["a", "x", "b", "z"]}`, false},
+		{"replace", `[{"op": "replace", "path": "/tags/0", "value": "c"}]`, `{"job": "vet", "address": {"city": "Vienna"}, "tags": ["c", "b"]}`, false},
+		{"remove", `[{"op": "remove", "path": "/address/city"}, {"op": "remove", "path": "/tags/0"}]`, `{"job": "vet", "address": {}, "tags": ["b"]}`, false},
+		{"move", `[{"op": "move", "from": "/address/city", "path": "/city"}]`, `{"job": "vet", "city": "Vienna", "address": {}, "tags": ["a", "b"]}`, false},
+		{"copy", `[{"op": "copy", "from": "/address", "path": "/home"}, {"op": "add", "path": "/home/zip", "value": "1010"}]`, `{"job": "vet", "address": {"city": "Vienna"}, "home": {"city": "Vienna", "zip": "1010"}, "tags": ["a", "b"]}`, false},
+		{"test", `[{"op": "test", "path": "/address/city", "value": "Vienna"}, {"op": "replace", "path": "/job", "value": "nurse"}]`, `{"job": "nurse", "address": {"city": "Vienna"}, "tags": ["a", "b"]}`, false},
+		{"escaped", `[{"op": "add", "path": "/a~1b~0c", "value": 1}]`, `{"job": "vet", "a/b~c": 1, "address": {"city": "Vienna"}, "tags": ["a", "b"]}`, false},
+		{"replace all", `[{"op": "replace", "path": "", "value": {"job": "nurse"}}]`, `{"job": "nurse"}`, false},
+		{"test failed", `[{"op": "replace", "path": "/job", "value": "nurse"}, {"op": "test", "path": "/job", "value": "vet"}]`, "", true},
+		{"replace missing", `[{"op": "replace", "path": "/phone", "value": "123"}]`, "", true},
+		{"remove missing", `[{"op": "remove", "path": "/address/zip"}]`, "", true},
+		{"missing parent", `[{"op": "add", "path": "/home/city", "value": "Graz"}]`, "", true},
+		{"index out of range", `[{"op": "add", "path": "/tags/3", "value": "c"}]`, "", true},
+		{"invalid index", `[{"op": "replace", "path": "/tags/01", "value": "c"}]`, "", true},
+		{"invalid path", `[{"op": "add", "path": "phone", "value": "123"}]`, "", true},
+		{"move into child", `[{"op": "move", "from": "/address", "path": "/address/old"}]`, "", true},
+		{"remove all", `[{"op": "remove", "path": ""}]`, "", true},
+		{"no object", `[{"op": "replace", "path": "", "value": "vet"}]`, "", true},
+		{"unsupported", `[{"op": "merge", "path": "/job", "value": "vet"}]`, "", true},
+	}
+
+	for _, c := range cases {
+		var patch JSONPatch
+		require.NoError(t, json.Unmarshal([]byte(c.patch), &patch), c.name)
+
+		attrs := decodeAttrs(t, `{"job": "vet", "address": {"city": "Vienna"}, "tags": ["a", "b"]}`)
+		result, err := patch.Apply(attrs)
+		if c.err {
+			assert.True(t, common.IsInvalidArgument(err), c.name)
+			continue
+		}
+
+		require.NoError(t, err, c.name)
+		assert.Equal(t, decodeAttrs(t, c.want), result, c.name)
+	}
+}
+
+func TestJSONPatch_Reapply(t *testing.T) {
+	// values of the patch must not be shared with the result so the
+	// patch can be applied again.
+	patch := JSONPatch{
+		{Op: "add", Path: "/address", Value: map[string]interface{}{}},
+		{Op: "add", Path: "/address/city", Value: "Vienna"},
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := patch.Apply(nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"address": map[string]interface{}{"city": "Vienna"}}, result)
+	}
+	assert.Equal(t, map[string]interface{}{}, patch[0].Value)
+}
+
+func TestPointerAttr(t *testing.T) {
+	assert.Equal(t, "address", PointerAttr("/address/city"))
+	assert.Equal(t, "a/b", PointerAttr("/a~1b"))
+	assert.Equal(t, "", PointerAttr(""))
+}
@@ -0,0 +1,417 @@
+package iam
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+// AttributeType is the type of values an attribute accepts.
+type AttributeType string
+
+// Supported attribute types. Values are checked using their JSON
+// representation.
+const (
+	AttrTypeString  AttributeType = "string"
+	AttrTypeNumber  AttributeType = "number"
+	AttrTypeInteger AttributeType = "integer"
+	AttrTypeBoolean AttributeType = "boolean"
+	AttrTypeArray   AttributeType = "array"
+	AttrTypeObject  AttributeType = "object"
+)
+
+// AttributeFormat further restricts values of string attributes or
+// the elements of array attributes.
+type AttributeFormat string
+
+// Supported attribute formats.
+const (
+	AttrFormatEmail    AttributeFormat = "email"
+	AttrFormatPhone    AttributeFormat = "phone"
+	AttrFormatURI      AttributeFormat = "uri"
+	AttrFormatDate     AttributeFormat = "date"
+	AttrFormatDateTime AttributeFormat = "date-time"
+)
+
+var (
+	attrNameRegexp  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]{0,63}$`)
+	phoneRegexp     = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	errValueMissing = errors.New("is required")
+)
+
+// AttributeDefinition describes a single user attribute.
+// swagger:model AttributeDefinition
+type AttributeDefinition struct {
+	// Name is the attribute key in User.Attributes.
+	Name string `json:"name"`
+
+	// Type is the type of values the attribute accepts.
+	Type AttributeType `json:"type"`
+
+	// Format restricts string values (or the elements of arrays) to a
+	// well-known format.
+	Format AttributeFormat `json:"format,omitempty"`
+
+	// Required rejects users that don't have the attribute set.
+	Required bool `json:"required,omitempty"`
+
+	// Unique rejects values that are already used by another user.
+	// Users can be looked up by unique attributes. Only supported for
+	// string, number and integer attributes.
+	Unique bool `json:"unique,omitempty"`
+
+	// Default is used if the attribute is not set when a user is
+	// created or its attributes are replaced.
+	Default interface{} `json:"default,omitempty"`
+
+	// Enum limits values (or the elements of arrays) to the given
+	// list.
+	Enum []interface{} `json:"enum,omitempty"`
+
+	// Description is a human readable description of the attribute
+	// that may be displayed in forms.
+	Description string `json:"description,omitempty"`
+}
+
+// Validate returns an error if d is not a valid attribute definition.
+func (d AttributeDefinition) Validate() error {
+	var fields []common.FieldError
+	add := func(field, format string, args ...interface{}) {
+		fields = append(fields, common.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !attrNameRegexp.MatchString(d.Name) {
+		add("name", "must start with a letter and only contain letters, digits, '_', '.' and '-'")
+	}
+
+	switch d.Type {
+	case AttrTypeString, AttrTypeNumber, AttrTypeInteger, AttrTypeBoolean, AttrTypeArray, AttrTypeObject:
+	default:
+		add("type", "unsupported type %q", d.Type)
+	}
+
+	switch d.Format {
+	case "":
+	case AttrFormatEmail, AttrFormatPhone, AttrFormatURI, AttrFormatDate, AttrFormatDateTime:
+		if d.Type != AttrTypeString && d.Type != AttrTypeArray {
+			add("format", "only supported for string and array attributes")
+		}
+	default:
+		add("format", "unsupported format %q", d.Format)
+	}
+
+	if d.Unique && d.Type != AttrTypeString && d.Type != AttrTypeNumber && d.Type != AttrTypeInteger {
+		add("unique", "not supported for %s attributes", d.Type)
+	}
+
+	if len(d.Enum) > 0 && (d.Type == AttrTypeBoolean || d.Type == AttrTypeObject) {
+		add("enum", "not supported for %s attributes", d.Type)
+	}
+
+	// enum values and the default must be valid values themselves
+	// but are only checked if the rest of the definition is fine.
+	if len(fields) == 0 {
+		elem := d
+		elem.Enum = nil
+		if d.Type == AttrTypeArray {
+			elem.Type = ""
+		}
+		for i, v := range d.Enum {
+			if err := elem.Check(v); err != nil {
+				add(fmt.Sprintf("enum[%d]", i), "%s", err)
+			}
+		}
+
+		if d.Default != nil {
+			if err := d.Check(d.Default); err != nil {
+				add("default", "%s", err)
+			}
+		}
+	}
+
+	return common.NewValidationError(fields)
+}
+
+// Check returns an error if value is not a valid value for d.
+func (d AttributeDefinition) Check(value interface{}) error {
+	if value == nil {
+		return errValueMissing
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch d.Type {
+	case AttrTypeString:
+		if v.Kind() != reflect.String {
+			return fmt.Errorf("must be a string")
+		}
+	case AttrTypeNumber:
+		if _, ok := toFloat(v); !ok {
+			return fmt.Errorf("must be a number")
+		}
+	case AttrTypeInteger:
+		if f, ok := toFloat(v); !ok || f != math.Trunc(f) {
+			return fmt.Errorf("must be an integer")
+		}
+	case AttrTypeBoolean:
+		if v.Kind() != reflect.Bool {
+			return fmt.Errorf("must be a boolean")
+		}
+	case AttrTypeObject:
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("must be an object")
+		}
+		return nil
+	case AttrTypeArray:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return fmt.Errorf("must be an array")
+		}
+
+		for i := 0; i < v.Len(); i++ {
+			if err := d.checkElem(v.Index(i).Interface()); err != nil {
+				return fmt.Errorf("element %d %s", i, err)
+			}
+		}
+		return nil
+	}
+
+	return d.checkElem(value)
+}
+
+// checkElem checks the format and enum constraints of value which is
+// either the attribute value itself or an element of an array.
+func (d AttributeDefinition) checkElem(value interface{}) error {
+	if value == nil {
+		return fmt.Errorf("must not be null")
+	}
+
+	if d.Format != "" {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		if err := checkFormat(d.Format, s); err != nil {
+			return err
+		}
+	}
+
+	if len(d.Enum) > 0 {
+		for _, e := range d.Enum {
+			if valuesEqual(e, value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %v", d.Enum)
+	}
+
+	return nil
+}
+
+func checkFormat(format AttributeFormat, s string) error {
+	var ok bool
+	switch format {
+	case AttrFormatEmail:
+		addr, err := mail.ParseAddress(s)
+		ok = err == nil && addr.Address == s
+	case AttrFormatPhone:
+		ok = phoneRegexp.MatchString(s)
+	case AttrFormatURI:
+		u, err := url.Parse(s)
+		ok = err == nil && u.IsAbs()
+	case AttrFormatDate:
+		_, err := time.Parse("2006-01-02", s)
+		ok = err == nil
+	case AttrFormatDateTime:
+		_, err := time.Parse(time.RFC3339, s)
+		ok = err == nil
+	default:
+		ok = true
+	}
+
+	if !ok {
+		return fmt.Errorf("must be a valid %s", format)
+	}
+	return nil
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// valuesEqual compares a and b treating all numeric types as equal if
+// they hold the same value. This is required because values decoded
+// from JSON are always float64.
+func valuesEqual(a, b interface{}) bool {
+	fa, okA := toFloat(reflect.ValueOf(a))
+	fb, okB := toFloat(reflect.ValueOf(b))
+	if okA || okB {
+		return okA && okB && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// AttributeSchema is the set of attribute definitions users are
+// validated against.
+type AttributeSchema []AttributeDefinition
+
+// Lookup returns the definition of the attribute name.
+func (s AttributeSchema) Lookup(name string) (AttributeDefinition, bool) {
+	for _, d := range s {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return AttributeDefinition{}, false
+}
+
+// Unique returns the names of all unique attributes.
+func (s AttributeSchema) Unique() []string {
+	var names []string
+	for _, d := range s {
+		if d.Unique {
+			names = append(names, d.Name)
+		}
+	}
+	return names
+}
+
+// Apply validates the complete set of attributes of a user and returns
+// a copy of attrs with defaults applied to unset attributes. Attributes
+// not defined in s are rejected. An empty schema accepts all
+// attributes. Apply returns a common.ValidationError listing all
+// invalid attributes.
+func (s AttributeSchema) Apply(attrs map[string]interface{}) (map[string]interface{}, error) {
+	if len(s) == 0 {
+		return attrs, nil
+	}
+
+	result := make(map[string]interface{}, len(attrs))
+	for key, value := range attrs {
+		result[key] = value
+	}
+
+	var fields []common.FieldError
+	for _, d := range s {
+		value, ok := result[d.Name]
+		if !ok && d.Default != nil {
+			value = d.Default
+			result[d.Name] = value
+			ok = true
+		}
+
+		if !ok {
+			if d.Required {
+				fields = append(fields, common.FieldError{Field: d.Name, Message: errValueMissing.Error()})
+			}
+			continue
+		}
+
+		if err := d.Check(value); err != nil {
+			fields = append(fields, common.FieldError{Field: d.Name, Message: err.Error()})
+		}
+	}
+
+	for key := range result {
+		if _, ok := s.Lookup(key); !ok {
+			fields = append(fields, common.FieldError{Field: key, Message: "is not defined"})
+		}
+	}
+
+	if len(fields) > 0 {
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+		return nil, common.NewValidationError(fields)
+	}
+
+	return result, nil
+}
+
+// Check validates a single attribute value. A nil value validates
+// that the attribute may be deleted which is only denied for required
+// attributes. An empty schema accepts all attributes.
+func (s AttributeSchema) Check(key string, value interface{}) error {
+	if len(s) == 0 {
+		return nil
+	}
+
+	d, ok := s.Lookup(key)
+
+	// attributes that are not defined (anymore) may always be deleted.
+	if value == nil {
+		if ok && d.Required {
+			return common.NewValidationError([]common.FieldError{{Field: key, Message: errValueMissing.Error()}})
+		}
+		return nil
+	}
+
+	if !ok {
+		return common.NewValidationError([]common.FieldError{{Field: key, Message: "is not defined"}})
+	}
+
+	if err := d.Check(value); err != nil {
+		return common.NewValidationError([]common.FieldError{{Field: key, Message: err.Error()}})
+	}
+
+	return nil
+}
+
+// JSONSchema returns s as a JSON Schema (draft 7) object describing
+// User.Attributes. UIs can use it to render and validate forms.
+func (s AttributeSchema) JSONSchema() map[string]interface{} {
+	properties := make(map[string]interface{}, len(s))
+	required := []string{}
+
+	for _, d := range s {
+		prop := map[string]interface{}{
+			"type": d.Type,
+		}
+
+		target := prop
+		if d.Type == AttrTypeArray && (d.Format != "" || len(d.Enum) > 0) {
+			target = map[string]interface{}{}
+			prop["items"] = target
+		}
+
+		if d.Format != "" {
+			target["format"] = d.Format
+		}
+		if len(d.Enum) > 0 {
+			target["enum"] = d.Enum
+		}
+		if d.Default != nil {
+			prop["default"] = d.Default
+		}
+		if d.Description != "" {
+			prop["description"] = d.Description
+		}
+
+		properties[d.Name] = prop
+
+		if d.Required {
+			required = append(required, d.Name)
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": len(s) == 0,
+	}
+}
@@ -54,4 +54,6 @@ type Group struct {
 	ID      GroupURN `json:"id"`
 	Name    string   `json:"name"`
 	Comment string   `json:"comment,omitempty"`
+
+	Metadata
 }
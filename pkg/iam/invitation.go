@@ -0,0 +1,38 @@
+package iam
+
+import "time"
+
+// Invitation is a pending user that has been invited by an
+// administrator. The user is created with Username, Attributes and
+// Groups once the invitee accepts the invitation by choosing a
+// password. Invitations are identified by a hash of the secret invite
+// token so the token itself is never stored.
+// swagger:model Invitation
+type Invitation struct {
+	// ID identifies the invitation.
+	ID string `json:"id"`
+
+	// Username is the username of the invited user.
+	Username string `json:"username"`
+
+	// Attributes holds the attributes of the invited user.
+	Attributes map[string]interface{} `json:"attrs,omitempty"`
+
+	// Groups holds the groups the invited user is added to.
+	Groups []GroupURN `json:"groups,omitempty"`
+
+	// CreatedAt is the time the invitation has been created.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// CreatedBy is the subject that created the invitation.
+	CreatedBy string `json:"createdBy,omitempty"`
+
+	// ExpiresAt is the time after which the invitation cannot be
+	// accepted anymore.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// IsExpired returns true if inv expired before t.
+func (inv Invitation) IsExpired(t time.Time) bool {
+	return inv.ExpiresAt.Before(t)
+}
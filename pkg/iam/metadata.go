@@ -0,0 +1,210 @@
+package iam
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Metadata records when and by whom a user, group or policy has been
+// created and last updated. Actors are the subjects of the requests
+// that caused the change (see enforcer.Subject). They are empty for
+// changes made by IAM itself, e.g. during reconciliation.
+type Metadata struct {
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+
+	// Version is incremented by the repositories each time the
+	// resource is stored. See CheckVersion.
+	Version uint64 `json:"version"`
+}
+
+// Touch records an update by actor at t. The creation time and actor
+// are recorded as well if m has not been created yet.
+func (m *Metadata) Touch(actor string, t time.Time) {
+	t = t.UTC()
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = t
+		m.CreatedBy = actor
+	}
+	m.UpdatedAt = t
+	m.UpdatedBy = actor
+}
+
+// MetadataFilter selects users, groups and policies by their Metadata.
+// Zero values do not restrict the selection.
+type MetadataFilter struct {
+	// CreatedBy only selects resources created by the actor.
+	CreatedBy string
+
+	// UpdatedBy only selects resources last updated by the actor.
+	UpdatedBy string
+
+	// CreatedAfter and CreatedBefore only select resources created
+	// within the range. Both bounds are exclusive.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// UpdatedAfter and UpdatedBefore only select resources last
+	// updated within the range. Both bounds are exclusive.
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+}
+
+// Matches reports whether m matches all filters of f.
+func (f MetadataFilter) Matches(m Metadata) bool {
+	switch {
+	case f.CreatedBy != "" && m.CreatedBy != f.CreatedBy:
+		return false
+	case f.UpdatedBy != "" && m.UpdatedBy != f.UpdatedBy:
+		return false
+	case !f.CreatedAfter.IsZero() && !m.CreatedAt.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !m.CreatedAt.Before(f.CreatedBefore):
+		return false
+	case !f.UpdatedAfter.IsZero() && !m.UpdatedAt.After(f.UpdatedAfter):
+		return false
+	case !f.UpdatedBefore.IsZero() && !m.UpdatedAt.Before(f.UpdatedBefore):
+		return false
+	}
+
+	return true
+}
+
+// metadataFilterParams maps query parameters to the time filters of a
+// MetadataFilter.
+var metadataFilterParams = []struct {
+	name  string
+	field func(f *MetadataFilter) *time.Time
+}{
+	{"createdAfter", func(f *MetadataFilter) *time.Time { return &f.CreatedAfter }},
+	{"createdBefore", func(f *MetadataFilter) *time.Time { return &f.CreatedBefore }},
+	{"updatedAfter", func(f *MetadataFilter) *time.Time { return &f.UpdatedAfter }},
+	{"updatedBefore", func(f *MetadataFilter) *time.Time { return &f.UpdatedBefore }},
+}
+
+// ParseMetadataFilter parses the query parameters createdBy, updatedBy,
+// createdAfter, createdBefore, updatedAfter and updatedBefore. Times use
+// RFC 3339.
+func ParseMetadataFilter(q url.Values) (MetadataFilter, error) {
+	f := MetadataFilter{
+		CreatedBy: q.Get("createdBy"),
+		UpdatedBy: q.Get("updatedBy"),
+	}
+
+	for _, p := range metadataFilterParams {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, fmt.Errorf("invalid value for %s", p.name)
+		}
+		*p.field(&f) = t
+	}
+
+	return f, nil
+}
+
+// Encode adds the query parameters parsed by ParseMetadataFilter to q.
+func (f MetadataFilter) Encode(q url.Values) {
+	if f.CreatedBy != "" {
+		q.Set("createdBy", f.CreatedBy)
+	}
+	if f.UpdatedBy != "" {
+		q.Set("updatedBy", f.UpdatedBy)
+	}
+
+	for _, p := range metadataFilterParams {
+		if t := *p.field(&f); !t.IsZero() {
+			q.Set(p.name, t.Format(time.RFC3339Nano))
+		}
+	}
+}
+
+// TimeSortKey returns the sort key of a resource for SortByCreatedAt
+// and SortByUpdatedAt. The ID breaks ties between resources sharing the
+// same time. Resources without a time sort first.
+func TimeSortKey(t time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	if !t.IsZero() {
+		binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	}
+	return append(key, id...)
+}
+
+// ListQuery filters and sorts groups and policies.
+type ListQuery struct {
+	MetadataFilter
+
+	// SortBy defines the order of the results. It is either
+	// SortByName (the default), SortByCreatedAt or SortByUpdatedAt.
+	SortBy SortField
+}
+
+// Validate returns an error if q contains an unknown sort field.
+func (q ListQuery) Validate() error {
+	switch q.SortBy {
+	case "", SortByName, SortByCreatedAt, SortByUpdatedAt:
+		return nil
+	default:
+		return fmt.Errorf("unsupported sort field %q", q.SortBy)
+	}
+}
+
+// less reports whether the resource a sorts before b. Names break ties
+// and are used if q is sorted by name.
+func (q ListQuery) less(a, b Metadata, nameA, nameB string) bool {
+	var ta, tb time.Time
+	switch q.SortBy {
+	case SortByCreatedAt:
+		ta, tb = a.CreatedAt, b.CreatedAt
+	case SortByUpdatedAt:
+		ta, tb = a.UpdatedAt, b.UpdatedAt
+	}
+
+	if !ta.Equal(tb) {
+		return ta.Before(tb)
+	}
+	return strings.Compare(nameA, nameB) < 0
+}
+
+// Groups returns the groups matching q in the order requested by q.
+func (q ListQuery) Groups(groups []Group) []Group {
+	result := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		if q.Matches(g.Metadata) {
+			result = append(result, g)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return q.less(result[i].Metadata, result[j].Metadata, result[i].Name, result[j].Name)
+	})
+
+	return result
+}
+
+// Policies returns the policies matching q in the order requested by
+// q. Policies are sorted by ID if q is sorted by name.
+func (q ListQuery) Policies(policies []Policy) []Policy {
+	result := make([]Policy, 0, len(policies))
+	for _, p := range policies {
+		if q.Matches(p.Metadata) {
+			result = append(result, p)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return q.less(result[i].Metadata, result[j].Metadata, result[i].ID, result[j].ID)
+	})
+
+	return result
+}
@@ -1,6 +1,7 @@
 package iam
 
 import (
+	"encoding/json"
 	"strings"
 
 	"github.com/ory/ladon"
@@ -56,4 +57,16 @@ func (urn PolicyURN) PolicyName() string {
 // Policy wraps ladon.DefaultPolicy
 type Policy struct {
 	ladon.DefaultPolicy
+	Metadata
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It is required because
+// ladon.DefaultPolicy implements json.Unmarshaler as well and would
+// otherwise ignore the metadata.
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	if err := p.DefaultPolicy.UnmarshalJSON(data); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &p.Metadata)
 }
@@ -2,11 +2,15 @@ package iam
 
 import (
 	"context"
+	"time"
 )
 
 // UserRepository provides persistent storage for user account information.
 type UserRepository interface {
 	// Store stores a user, overwriting and existing one if necassary.
+	// Usernames are unique. Implementations return common.ConflictError
+	// if the username is already used by another user or if the stored
+	// user has a different version (see NextVersion).
 	Store(ctx context.Context, user User) error
 
 	// Delete deletes the user with the given urn. Implementations
@@ -19,15 +23,26 @@ type UserRepository interface {
 	// Load returns a read model of the user identified by urn.
 	Load(ctx context.Context, urn UserURN) (User, error)
 
+	// LoadByUsername returns the read model of the user with the given
+	// username. If no such user exists common.NotFoundError should be
+	// returned.
+	LoadByUsername(ctx context.Context, username string) (User, error)
+
 	// Get returns a list of read models of all users.
 	Get(ctx context.Context) ([]User, error)
+
+	// Query returns a page of users matching q sorted by q.SortBy.
+	// Implementations return common.InvalidArgumentError if q is
+	// invalid (see UserQuery.Validate).
+	Query(ctx context.Context, q UserQuery) (UserPage, error)
 }
 
 // GroupRepository provides persistent storage for group information.
 type GroupRepository interface {
 	// Store stores a group, overwritting and existing one if necassary.
 	// Implementations should ignore the group.Member field as it is taken
-	// care of by the membership repository
+	// care of by the membership repository. Like UserRepository.Store,
+	// implementations maintain the version of the group.
 	Store(ctx context.Context, group Group) error
 
 	// Delete deletes an existing account group. Implementations tracking
@@ -63,7 +78,8 @@ type MembershipRepository interface {
 // PolicyRepository persists access and permission policies.
 type PolicyRepository interface {
 	// Store stores a policy an overwrites an existing one
-	// if necassary.
+	// if necassary. Like UserRepository.Store, implementations
+	// maintain the version of the policy.
 	Store(ctx context.Context, policy Policy) error
 
 	// Delete deletes an existing policy. If the given policy
@@ -76,3 +92,64 @@ type PolicyRepository interface {
 	// Get returns a list of all policies stored.
 	Get(ctx context.Context) ([]Policy, error)
 }
+
+// AttributeRepository persists the user attribute schema.
+type AttributeRepository interface {
+	// Store stores an attribute definition and replaces an existing
+	// one with the same name.
+	Store(ctx context.Context, def AttributeDefinition) error
+
+	// Delete deletes the definition of the attribute name. If it does
+	// not exist common.NotFoundError should be returned.
+	Delete(ctx context.Context, name string) error
+
+	// Load loads the definition of the attribute name. If it does not
+	// exist common.NotFoundError should be returned.
+	Load(ctx context.Context, name string) (AttributeDefinition, error)
+
+	// Get returns all attribute definitions sorted by name.
+	Get(ctx context.Context) (AttributeSchema, error)
+}
+
+// InvitationRepository persists pending invitations.
+type InvitationRepository interface {
+	// Store stores an invitation and replaces an existing one with the
+	// same ID.
+	Store(ctx context.Context, inv Invitation) error
+
+	// Delete deletes the invitation id. If it does not exist
+	// common.NotFoundError should be returned.
+	Delete(ctx context.Context, id string) error
+
+	// Load loads the invitation id. If it does not exist
+	// common.NotFoundError should be returned.
+	Load(ctx context.Context, id string) (Invitation, error)
+
+	// Get returns all invitations sorted by username.
+	Get(ctx context.Context) ([]Invitation, error)
+}
+
+// RevocationRepository persists revoked access tokens.
+type RevocationRepository interface {
+	// Store stores a revocation. An existing revocation for the same
+	// token ID or subject is overwritten.
+	Store(ctx context.Context, revocation Revocation) error
+
+	// Get returns all revocations stored.
+	Get(ctx context.Context) ([]Revocation, error)
+
+	// DeleteExpired deletes all revocations that expired before t.
+	DeleteExpired(ctx context.Context, t time.Time) error
+}
+
+// KeySetRepository persists the last known JSON Web Key Set of the
+// authn-server issuer so access tokens can still be verified while
+// authn-server is unreachable.
+type KeySetRepository interface {
+	// Store stores the encoded key set and replaces any existing one.
+	Store(ctx context.Context, blob []byte) error
+
+	// Load returns the encoded key set. If no key set has been stored
+	// yet common.NotFoundError should be returned.
+	Load(ctx context.Context) ([]byte, error)
+}
@@ -0,0 +1,27 @@
+package iam
+
+import "time"
+
+// Revocation marks JWT access tokens as revoked. A revocation either
+// applies to a single token identified by TokenID (the "jti" claim) or
+// to all tokens issued to Subject before NotBefore.
+type Revocation struct {
+	// TokenID is the "jti" claim of the revoked token.
+	TokenID string `json:"jti,omitempty"`
+
+	// Subject is the "sub" claim of all revoked tokens.
+	Subject string `json:"sub,omitempty"`
+
+	// NotBefore is only used together with Subject. All tokens issued
+	// before NotBefore are considered revoked.
+	NotBefore time.Time `json:"nbf,omitempty"`
+
+	// Expires is the time after which all tokens affected by the
+	// revocation have expired and the revocation can be forgotten.
+	Expires time.Time `json:"exp"`
+}
+
+// IsExpired returns true if r expired before t.
+func (r Revocation) IsExpired(t time.Time) bool {
+	return r.Expires.Before(t)
+}
@@ -1,6 +1,9 @@
 package iam
 
-import "strings"
+import (
+	"strings"
+	"time"
+)
 
 // UserURN uniquely identifies a paticular user/account
 type UserURN string
@@ -56,4 +59,11 @@ type User struct {
 	ID         UserURN                `json:"id"`
 	Locked     *bool                  `json:"locked,omitempty"`
 	Attributes map[string]interface{} `json:"attrs,omitempty"`
+
+	Metadata
+
+	// DeletedAt is set if the user has been soft-deleted. Soft-deleted
+	// users keep their attributes and memberships until they are
+	// restored or purged.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
@@ -0,0 +1,210 @@
+package iam
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SortField is the field users, groups or policies are sorted by when
+// listed.
+type SortField string
+
+// UserSortField is the field users are sorted by when listed using
+// UserRepository.Query.
+type UserSortField = SortField
+
+// Supported sort fields. SortByName is only supported for groups and
+// policies, SortByUsername and SortByAccountID only for users.
+const (
+	SortByUsername  SortField = "username"
+	SortByAccountID SortField = "accountID"
+	SortByName      SortField = "name"
+	SortByCreatedAt SortField = "createdAt"
+	SortByUpdatedAt SortField = "updatedAt"
+)
+
+// ErrInvalidCursor is returned if a cursor passed in UserQuery cannot
+// be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UserQuery selects a page of users. All filters must match for a user
+// to be included.
+type UserQuery struct {
+	// UsernamePrefix only selects users whose username starts with
+	// the prefix.
+	UsernamePrefix string
+
+	// Locked only selects locked or unlocked users if set.
+	Locked *bool
+
+	// Group only selects members of the group if set.
+	Group GroupURN
+
+	// Deleted only selects soft-deleted users. By default soft-deleted
+	// users are excluded.
+	Deleted bool
+
+	// MetadataFilter selects users by their creation and update
+	// metadata.
+	MetadataFilter
+
+	// Attributes only selects users that have all the attributes set to
+	// the given values. Values are compared using their string
+	// representation. Attributes holding a list match if any of its
+	// elements matches.
+	Attributes map[string]string
+
+	// SortBy defines the order of users. Defaults to SortByUsername.
+	SortBy UserSortField
+
+	// Cursor continues a previous query. It must be the NextCursor of
+	// the previous UserPage and is only valid for the same SortBy.
+	Cursor string
+
+	// Limit is the maximum number of users returned. Zero means no
+	// limit.
+	Limit int
+}
+
+// UserPage is a single page of users returned by UserRepository.Query.
+type UserPage struct {
+	// Users holds the users of the page.
+	Users []User `json:"users"`
+
+	// NextCursor continues the query with the next page. It is empty
+	// if there are no more users.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Validate returns an error if q contains an unknown sort field or an
+// invalid cursor.
+func (q UserQuery) Validate() error {
+	switch q.SortBy {
+	case "", SortByUsername, SortByAccountID, SortByCreatedAt, SortByUpdatedAt:
+	default:
+		return fmt.Errorf("unsupported sort field %q", q.SortBy)
+	}
+
+	if q.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+
+	_, err := q.After()
+	return err
+}
+
+// After returns the sort key decoded from q.Cursor. Users with a sort
+// key less than or equal to the returned key belong to previous pages.
+func (q UserQuery) After() ([]byte, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	switch q.SortBy {
+	case SortByAccountID:
+		if len(key) != 8 {
+			return nil, ErrInvalidCursor
+		}
+	case SortByCreatedAt, SortByUpdatedAt:
+		if len(key) <= 8 {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	return key, nil
+}
+
+// SortKey returns the key used to order u. Keys compare bytewise.
+func (q UserQuery) SortKey(u User) []byte {
+	switch q.SortBy {
+	case SortByAccountID:
+		return AccountIDSortKey(u.AccountID)
+	case SortByCreatedAt:
+		return TimeSortKey(u.CreatedAt, string(u.ID))
+	case SortByUpdatedAt:
+		return TimeSortKey(u.UpdatedAt, string(u.ID))
+	default:
+		return UsernameSortKey(u.Username, u.ID)
+	}
+}
+
+// CursorFor returns the cursor that continues q after u.
+func (q UserQuery) CursorFor(u User) string {
+	return base64.RawURLEncoding.EncodeToString(q.SortKey(u))
+}
+
+// UsernameSortKey returns the sort key of a user for SortByUsername.
+// The URN breaks ties between users sharing the same username.
+func UsernameSortKey(username string, urn UserURN) []byte {
+	return []byte(username + "\x00" + string(urn))
+}
+
+// AccountIDSortKey returns the sort key of a user for SortByAccountID.
+func AccountIDSortKey(accountID int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(accountID))
+	return key
+}
+
+// Matches reports whether u matches the username, lock state, deleted
+// state, metadata and attribute filters of q. The group filter must be checked by
+// the caller.
+func (q UserQuery) Matches(u User) bool {
+	if !strings.HasPrefix(u.Username, q.UsernamePrefix) {
+		return false
+	}
+
+	if (u.DeletedAt != nil) != q.Deleted {
+		return false
+	}
+
+	if !q.MetadataFilter.Matches(u.Metadata) {
+		return false
+	}
+
+	if q.Locked != nil {
+		locked := u.Locked != nil && *u.Locked
+		if locked != *q.Locked {
+			return false
+		}
+	}
+
+	for key, want := range q.Attributes {
+		if !attrMatches(u.Attributes[key], want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func attrMatches(v interface{}, want string) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case []interface{}:
+		for _, elem := range val {
+			if attrMatches(elem, want) {
+				return true
+			}
+		}
+		return false
+	case []string:
+		for _, elem := range val {
+			if elem == want {
+				return true
+			}
+		}
+		return false
+	default:
+		return fmt.Sprint(val) == want
+	}
+}
@@ -0,0 +1,56 @@
+package iam
+
+import (
+	"context"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+type ifMatchKey struct{}
+
+// WithIfMatch returns a new context that makes updates conditional.
+// Services only modify a resource if its current version is one of
+// versions. Without versions no update is permitted.
+func WithIfMatch(ctx context.Context, versions ...uint64) context.Context {
+	if versions == nil {
+		versions = []uint64{}
+	}
+	return context.WithValue(ctx, ifMatchKey{}, versions)
+}
+
+// IfMatch returns the versions required by ctx. The second return value
+// reports whether updates are conditional at all.
+func IfMatch(ctx context.Context) ([]uint64, bool) {
+	versions, ok := ctx.Value(ifMatchKey{}).([]uint64)
+	return versions, ok
+}
+
+// CheckVersion returns common.PreconditionFailedError if ctx makes
+// updates conditional and the version of m is not one of the required
+// versions. kind is used as the resource kind of the error.
+func CheckVersion(ctx context.Context, kind string, m Metadata) error {
+	versions, ok := IfMatch(ctx)
+	if !ok {
+		return nil
+	}
+
+	for _, v := range versions {
+		if v == m.Version {
+			return nil
+		}
+	}
+
+	return common.NewPreconditionFailedError(kind)
+}
+
+// NextVersion returns the version of a resource with metadata m after
+// it has been stored by a repository that currently holds the resource
+// in the given version. Repositories return common.ConflictError if the
+// resource has been modified since it was loaded.
+func NextVersion(current uint64, m Metadata) (uint64, error) {
+	if m.Version != current {
+		return 0, common.NewConflictError("version")
+	}
+
+	return current + 1, nil
+}
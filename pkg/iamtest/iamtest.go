@@ -0,0 +1,182 @@
+// Package iamtest provides helpers shared by the integration tests of
+// IAM services: a fake authn-server with a matching authn.Service,
+// simple enforcers, a static token loader and a JSON HTTP client.
+package iamtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn/authntest"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+// Audience is the audience of all tokens issued by Authn.
+const Audience = "identity.example.com"
+
+// Authn is a fake authn-server together with an authn.Service that
+// talks to it and verifies the tokens it issues for Audience.
+type Authn struct {
+	*authntest.Server
+
+	// Service talks to Server.
+	Service authn.Service
+
+	deferred []func()
+}
+
+// NewAuthn starts a new fake authn-server. Callers must call Close once
+// they are done.
+func NewAuthn(t *testing.T) *Authn {
+	srv := authntest.NewServer()
+
+	as, err := authn.NewService(srv.Config(Audience), nil)
+	if err != nil {
+		srv.Close()
+		require.NoError(t, err)
+	}
+
+	return &Authn{
+		Server:  srv,
+		Service: as,
+	}
+}
+
+// Serve starts a HTTP test server for h, usually the handler of a
+// service that verifies tokens using Service. The server is closed
+// together with a.
+func (a *Authn) Serve(h http.Handler) *httptest.Server {
+	srv := httptest.NewServer(h)
+	a.Defer(srv.Close)
+	return srv
+}
+
+// Defer registers fn to be called by Close, e.g. to stop a server that
+// has been started for a test. Functions are called in reverse order.
+func (a *Authn) Defer(fn func()) {
+	a.deferred = append(a.deferred, fn)
+}
+
+// Close calls all functions registered using Defer and closes the
+// authn-server.
+func (a *Authn) Close() {
+	for i := len(a.deferred) - 1; i >= 0; i-- {
+		a.deferred[i]()
+	}
+	a.Server.Close()
+}
+
+// AccountToken creates a new account and returns its ID together with
+// an access token issued to it.
+func (a *Authn) AccountToken(username string) (int, string) {
+	id := a.AddAccount(username, "password", false)
+	return id, a.Token(id, Audience)
+}
+
+// StaticToken is a token loader (see client.TokenLoader) that always
+// returns the same access token.
+type StaticToken string
+
+// Load implements client.TokenLoader.
+func (t StaticToken) Load() (string, error) { return string(t), nil }
+
+// DenyActions is an enforcer.Enforcer that denies all actions it
+// contains and allows all others.
+type DenyActions map[string]bool
+
+// Enforce implements enforcer.Enforcer.
+func (d DenyActions) Enforce(_ context.Context, _, action, _ string, _ enforcer.Context) error {
+	if d[action] {
+		return &enforcer.PermissionDeniedError{Reason: "denied"}
+	}
+	return nil
+}
+
+// AllowActions is an enforcer.Enforcer that only allows the actions it
+// contains. Resources are limited to the listed ones if the list is not
+// empty.
+type AllowActions map[string][]string
+
+// Enforce implements enforcer.Enforcer.
+func (a AllowActions) Enforce(_ context.Context, _, action, resource string, _ enforcer.Context) error {
+	resources, ok := a[action]
+	if !ok {
+		return &enforcer.PermissionDeniedError{Reason: "denied"}
+	}
+	if len(resources) == 0 {
+		return nil
+	}
+	for _, r := range resources {
+		if r == resource {
+			return nil
+		}
+	}
+	return &enforcer.PermissionDeniedError{Reason: "denied"}
+}
+
+// Client sends JSON requests to a HTTP test server.
+type Client struct {
+	// URL is the base URL all paths are relative to.
+	URL string
+
+	// Token is sent as the bearer token if not empty.
+	Token string
+
+	// ContentType is sent as the content type of request bodies.
+	// Defaults to application/json.
+	ContentType string
+
+	// Header holds additional headers sent with each request.
+	Header http.Header
+}
+
+// Do sends a request with body encoded as JSON. Unless result is nil,
+// the response body is decoded into result. Bodies of error responses
+// are decoded on a best-effort basis as they may have a different
+// shape. The returned response has already been read and closed.
+func (c Client) Do(t *testing.T, method, path string, body, result interface{}) *http.Response {
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+
+	req, err := http.NewRequest(method, c.URL+path, &buf)
+	require.NoError(t, err)
+
+	contentType := c.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	blob, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	if result != nil && len(blob) > 0 {
+		err := json.Unmarshal(blob, result)
+		if res.StatusCode < 300 {
+			require.NoError(t, err)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,102 @@
+package bbolt
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"go.etcd.io/bbolt"
+)
+
+var errAttributeNotFound = common.NewNotFoundError("attribute")
+
+type attributeRepo struct {
+	*Database
+}
+
+func (db *attributeRepo) Store(ctx context.Context, def iam.AttributeDefinition) error {
+	blob, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(attributeBucketKey)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(def.Name), blob)
+	})
+}
+
+func (db *attributeRepo) Delete(ctx context.Context, name string) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(attributeBucketKey)
+		if b == nil {
+			return errAttributeNotFound
+		}
+
+		if b.Get([]byte(name)) == nil {
+			return errAttributeNotFound
+		}
+
+		return b.Delete([]byte(name))
+	})
+}
+
+func (db *attributeRepo) Load(ctx context.Context, name string) (iam.AttributeDefinition, error) {
+	var blob []byte
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(attributeBucketKey)
+		if b == nil {
+			return errAttributeNotFound
+		}
+
+		blob = b.Get([]byte(name))
+		if blob == nil {
+			return errAttributeNotFound
+		}
+
+		// blob is only valid during the transaction
+		blob = append([]byte(nil), blob...)
+		return nil
+	})
+	if err != nil {
+		return iam.AttributeDefinition{}, err
+	}
+
+	var def iam.AttributeDefinition
+	if err := json.Unmarshal(blob, &def); err != nil {
+		return iam.AttributeDefinition{}, err
+	}
+
+	return def, nil
+}
+
+func (db *attributeRepo) Get(ctx context.Context) (iam.AttributeSchema, error) {
+	var schema iam.AttributeSchema
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(attributeBucketKey)
+		if b == nil {
+			return nil
+		}
+
+		// keys are sorted by name already.
+		return b.ForEach(func(_, value []byte) error {
+			var def iam.AttributeDefinition
+			if err := json.Unmarshal(value, &def); err != nil {
+				return err
+			}
+
+			schema = append(schema, def)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
@@ -0,0 +1,44 @@
+package bbolt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+func Test_AttributeRepo(t *testing.T) {
+	f, cleanup := getTempDb()
+	defer cleanup()
+
+	db, err := Open(f)
+	require.NoError(t, err)
+	repo := db.AttributeRepo()
+	ctx := context.Background()
+
+	schema, err := repo.Get(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, schema)
+
+	phone := iam.AttributeDefinition{Name: "phone", Type: iam.AttrTypeString, Format: iam.AttrFormatPhone}
+	email := iam.AttributeDefinition{Name: "email", Type: iam.AttrTypeString, Format: iam.AttrFormatEmail, Required: true}
+	require.NoError(t, repo.Store(ctx, phone))
+	require.NoError(t, repo.Store(ctx, email))
+
+	def, err := repo.Load(ctx, "phone")
+	require.NoError(t, err)
+	assert.Equal(t, phone, def)
+
+	schema, err = repo.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, iam.AttributeSchema{email, phone}, schema)
+
+	require.NoError(t, repo.Delete(ctx, "phone"))
+	assert.True(t, common.IsNotFound(repo.Delete(ctx, "phone")))
+
+	_, err = repo.Load(ctx, "phone")
+	assert.True(t, common.IsNotFound(err))
+}
@@ -1,6 +1,8 @@
 package bbolt
 
 import (
+	"encoding/json"
+
 	"github.com/go-kit/kit/log"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 	"go.etcd.io/bbolt"
@@ -8,10 +10,20 @@ import (
 
 var (
 	userBucketKey            = []byte("iam-v1-users")
+	userByNameBucketKey      = []byte("iam-v1-users-by-name")
+	userByAccountBucketKey   = []byte("iam-v1-users-by-account")
+	userByCreatedBucketKey   = []byte("iam-v1-users-by-created")
+	userByUpdatedBucketKey   = []byte("iam-v1-users-by-updated")
+	usernameBucketKey        = []byte("iam-v1-usernames")
 	groupBucketKey           = []byte("iam-v1-groups")
 	membershipGroupBucketKey = []byte("iam-v1-memberships-group")
 	membershipUserBucketKey  = []byte("iam-v1-memberships-user")
 	policyBucketKey          = []byte("iam-v1-policy")
+	attributeBucketKey       = []byte("iam-v1-attributes")
+	invitationBucketKey      = []byte("iam-v1-invitations")
+	revokedTokenBucketKey    = []byte("iam-v1-revoked-tokens")
+	revokedSubjectBucketKey  = []byte("iam-v1-revoked-subjects")
+	authnBucketKey           = []byte("iam-v1-authn")
 )
 
 // Database provides persistence for users, groups and policies
@@ -42,6 +54,26 @@ func (db *Database) PolicyRepo() iam.PolicyRepository {
 	return &policyRepo{db}
 }
 
+// AttributeRepo returns a iam.AttributeRepository backed by db.
+func (db *Database) AttributeRepo() iam.AttributeRepository {
+	return &attributeRepo{db}
+}
+
+// InvitationRepo returns a iam.InvitationRepository backed by db.
+func (db *Database) InvitationRepo() iam.InvitationRepository {
+	return &invitationRepo{db}
+}
+
+// RevocationRepo returns a iam.RevocationRepository backed by db.
+func (db *Database) RevocationRepo() iam.RevocationRepository {
+	return &revocationRepo{db}
+}
+
+// KeySetRepo returns a iam.KeySetRepository backed by db.
+func (db *Database) KeySetRepo() iam.KeySetRepository {
+	return &keySetRepo{db}
+}
+
 // Open opes the database file at path and returns
 // a new Database instance
 func Open(path string) (*Database, error) {
@@ -60,3 +92,17 @@ func OpenWithLogger(path string, l log.Logger) (*Database, error) {
 		l:  l,
 	}, nil
 }
+
+// nextVersion returns the version of a resource with metadata m after
+// replacing blob, the currently stored resource (if any). See
+// iam.NextVersion.
+func nextVersion(blob []byte, m iam.Metadata) (uint64, error) {
+	var stored iam.Metadata
+	if blob != nil {
+		if err := json.Unmarshal(blob, &stored); err != nil {
+			return 0, err
+		}
+	}
+
+	return iam.NextVersion(stored.Version, m)
+}
@@ -16,17 +16,22 @@ type groupRepo struct {
 }
 
 func (db *groupRepo) Store(ctx context.Context, group iam.Group) error {
-	blob, err := json.Marshal(group)
-	if err != nil {
-		return err
-	}
-
 	return db.db.Update(func(tx *bbolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists(groupBucketKey)
 		if err != nil {
 			return err
 		}
 
+		group.Version, err = nextVersion(bucket.Get([]byte(group.ID)), group.Metadata)
+		if err != nil {
+			return err
+		}
+
+		blob, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+
 		return bucket.Put([]byte(group.ID), blob)
 	})
 }
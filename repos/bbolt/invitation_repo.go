@@ -0,0 +1,105 @@
+package bbolt
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"go.etcd.io/bbolt"
+)
+
+var errInvitationNotFound = common.NewNotFoundError("invitation")
+
+type invitationRepo struct {
+	*Database
+}
+
+func (db *invitationRepo) Store(ctx context.Context, inv iam.Invitation) error {
+	blob, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(invitationBucketKey)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(inv.ID), blob)
+	})
+}
+
+func (db *invitationRepo) Delete(ctx context.Context, id string) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(invitationBucketKey)
+		if b == nil {
+			return errInvitationNotFound
+		}
+
+		if b.Get([]byte(id)) == nil {
+			return errInvitationNotFound
+		}
+
+		return b.Delete([]byte(id))
+	})
+}
+
+func (db *invitationRepo) Load(ctx context.Context, id string) (iam.Invitation, error) {
+	var blob []byte
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(invitationBucketKey)
+		if b == nil {
+			return errInvitationNotFound
+		}
+
+		blob = b.Get([]byte(id))
+		if blob == nil {
+			return errInvitationNotFound
+		}
+
+		// blob is only valid during the transaction
+		blob = append([]byte(nil), blob...)
+		return nil
+	})
+	if err != nil {
+		return iam.Invitation{}, err
+	}
+
+	var inv iam.Invitation
+	if err := json.Unmarshal(blob, &inv); err != nil {
+		return iam.Invitation{}, err
+	}
+
+	return inv, nil
+}
+
+func (db *invitationRepo) Get(ctx context.Context) ([]iam.Invitation, error) {
+	var invitations []iam.Invitation
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(invitationBucketKey)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(_, value []byte) error {
+			var inv iam.Invitation
+			if err := json.Unmarshal(value, &inv); err != nil {
+				return err
+			}
+
+			invitations = append(invitations, inv)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// keys are sorted by ID, not by username.
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].Username < invitations[j].Username })
+
+	return invitations, nil
+}
@@ -0,0 +1,54 @@
+package bbolt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+func Test_InvitationRepo(t *testing.T) {
+	f, cleanup := getTempDb()
+	defer cleanup()
+
+	db, err := Open(f)
+	require.NoError(t, err)
+	repo := db.InvitationRepo()
+	ctx := context.Background()
+
+	invitations, err := repo.Get(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, invitations)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	bob := iam.Invitation{
+		ID:         "a",
+		Username:   "bob",
+		Attributes: map[string]interface{}{"email": "bob@example.com"},
+		Groups:     []iam.GroupURN{"urn:iam::group/vets"},
+		CreatedAt:  now,
+		CreatedBy:  "1",
+		ExpiresAt:  now.Add(time.Hour),
+	}
+	alice := iam.Invitation{ID: "b", Username: "alice", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, repo.Store(ctx, bob))
+	require.NoError(t, repo.Store(ctx, alice))
+
+	inv, err := repo.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, bob, inv)
+
+	invitations, err = repo.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []iam.Invitation{alice, bob}, invitations)
+
+	require.NoError(t, repo.Delete(ctx, "a"))
+	assert.True(t, common.IsNotFound(repo.Delete(ctx, "a")))
+
+	_, err = repo.Load(ctx, "a")
+	assert.True(t, common.IsNotFound(err))
+}
@@ -0,0 +1,56 @@
+package bbolt
+
+import (
+	"context"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"go.etcd.io/bbolt"
+)
+
+var _ iam.KeySetRepository = &keySetRepo{}
+var errKeySetNotFound = common.NewNotFoundError("key set")
+
+var keySetKey = []byte("jwks")
+
+type keySetRepo struct {
+	*Database
+}
+
+// Store implements iam.KeySetRepository
+func (db *keySetRepo) Store(ctx context.Context, blob []byte) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(authnBucketKey)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(keySetKey, blob)
+	})
+}
+
+// Load implements iam.KeySetRepository
+func (db *keySetRepo) Load(ctx context.Context) ([]byte, error) {
+	var blob []byte
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(authnBucketKey)
+		if bucket == nil {
+			return errKeySetNotFound
+		}
+
+		// the value is only valid during the transaction
+		if value := bucket.Get(keySetKey); value != nil {
+			blob = append([]byte(nil), value...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if blob == nil {
+		return nil, errKeySetNotFound
+	}
+
+	return blob, nil
+}
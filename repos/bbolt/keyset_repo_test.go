@@ -0,0 +1,29 @@
+package bbolt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+func Test_KeySetRepo(t *testing.T) {
+	f, cleanup := getTempDb()
+	defer cleanup()
+
+	db, err := Open(f)
+	require.NoError(t, err)
+	repo := db.KeySetRepo()
+	ctx := context.Background()
+
+	_, err = repo.Load(ctx)
+	assert.True(t, common.IsNotFound(err))
+
+	require.NoError(t, repo.Store(ctx, []byte(`{"jwks":{"keys":[]}}`)))
+
+	blob, err := repo.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, `{"jwks":{"keys":[]}}`, string(blob))
+}
@@ -16,17 +16,22 @@ type policyRepo struct {
 }
 
 func (db *policyRepo) Store(ctx context.Context, policy iam.Policy) error {
-	blob, err := json.Marshal(policy)
-	if err != nil {
-		return err
-	}
-
 	return db.db.Update(func(tx *bbolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(policyBucketKey)
 		if err != nil {
 			return err
 		}
 
+		policy.Version, err = nextVersion(b.Get([]byte(policy.ID)), policy.Metadata)
+		if err != nil {
+			return err
+		}
+
+		blob, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+
 		return b.Put([]byte(policy.ID), blob)
 	})
 }
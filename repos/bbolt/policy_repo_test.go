@@ -0,0 +1,56 @@
+package bbolt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ory/ladon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+func TestPolicy_StoreLoad(t *testing.T) {
+	f, cleanup := getTempDb()
+	defer cleanup()
+
+	db, err := Open(f)
+	require.NoError(t, err)
+	repo := db.PolicyRepo()
+	ctx := context.Background()
+
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy := iam.Policy{
+		DefaultPolicy: ladon.DefaultPolicy{
+			ID:         "urn:iam::policy/admins",
+			Subjects:   []string{"urn:iam::group/admins"},
+			Effect:     ladon.AllowAccess,
+			Resources:  []string{"<.*>"},
+			Actions:    []string{"<.*>"},
+			Conditions: ladon.Conditions{},
+		},
+		Metadata: iam.Metadata{
+			CreatedAt: created,
+			CreatedBy: "urn:iam::user/1",
+			UpdatedAt: created.Add(time.Hour),
+			UpdatedBy: "urn:iam::user/2",
+		},
+	}
+	require.NoError(t, repo.Store(ctx, policy))
+	policy.Version = 1
+
+	// the metadata must survive ladon's JSON decoding
+	loaded, err := repo.Load(ctx, "urn:iam::policy/admins")
+	require.NoError(t, err)
+	assert.Equal(t, policy, loaded)
+
+	policies, err := repo.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []iam.Policy{policy}, policies)
+
+	// stores of outdated versions are rejected
+	require.NoError(t, repo.Store(ctx, loaded))
+	assert.True(t, common.IsConflict(repo.Store(ctx, loaded)))
+}
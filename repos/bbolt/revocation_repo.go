@@ -0,0 +1,111 @@
+package bbolt
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"go.etcd.io/bbolt"
+)
+
+var _ iam.RevocationRepository = &revocationRepo{}
+
+type revocationRepo struct {
+	*Database
+}
+
+// Store implements iam.RevocationRepository
+func (db *revocationRepo) Store(ctx context.Context, revocation iam.Revocation) error {
+	bucketKey, key := revokedTokenBucketKey, revocation.TokenID
+	if key == "" {
+		bucketKey, key = revokedSubjectBucketKey, revocation.Subject
+	}
+	if key == "" {
+		return common.NewInvalidArgumentError("revocation requires a token ID or subject")
+	}
+
+	blob, err := json.Marshal(revocation)
+	if err != nil {
+		return err
+	}
+
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketKey)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(key), blob)
+	})
+}
+
+// Get implements iam.RevocationRepository
+func (db *revocationRepo) Get(ctx context.Context) ([]iam.Revocation, error) {
+	var revocations []iam.Revocation
+
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		for _, key := range [][]byte{revokedTokenBucketKey, revokedSubjectBucketKey} {
+			bucket := tx.Bucket(key)
+			if bucket == nil {
+				continue
+			}
+
+			err := bucket.ForEach(func(_, blob []byte) error {
+				var r iam.Revocation
+				if err := json.Unmarshal(blob, &r); err != nil {
+					return err
+				}
+
+				revocations = append(revocations, r)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return revocations, err
+}
+
+// DeleteExpired implements iam.RevocationRepository
+func (db *revocationRepo) DeleteExpired(ctx context.Context, t time.Time) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		for _, key := range [][]byte{revokedTokenBucketKey, revokedSubjectBucketKey} {
+			bucket := tx.Bucket(key)
+			if bucket == nil {
+				continue
+			}
+
+			// collect keys first as the bucket must not be modified
+			// while iterating using ForEach.
+			var expired [][]byte
+			err := bucket.ForEach(func(k, blob []byte) error {
+				var r iam.Revocation
+				if err := json.Unmarshal(blob, &r); err != nil {
+					return err
+				}
+
+				if r.IsExpired(t) {
+					expired = append(expired, k)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, k := range expired {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,39 @@
+package bbolt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+func Test_RevocationRepo(t *testing.T) {
+	f, cleanup := getTempDb()
+	defer cleanup()
+
+	db, err := Open(f)
+	require.NoError(t, err)
+	repo := db.RevocationRepo()
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	token := iam.Revocation{TokenID: "jti-1", Expires: now.Add(time.Hour)}
+	subject := iam.Revocation{Subject: "10", NotBefore: now, Expires: now.Add(-time.Second)}
+
+	assert.Error(t, repo.Store(ctx, iam.Revocation{}))
+	require.NoError(t, repo.Store(ctx, token))
+	require.NoError(t, repo.Store(ctx, subject))
+
+	revocations, err := repo.Get(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []iam.Revocation{token, subject}, revocations)
+
+	require.NoError(t, repo.DeleteExpired(ctx, now))
+
+	revocations, err = repo.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []iam.Revocation{token}, revocations)
+}
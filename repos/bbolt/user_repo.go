@@ -1,6 +1,7 @@
 package bbolt
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 
@@ -10,7 +11,10 @@ import (
 )
 
 var _ iam.UserRepository = &userRepo{}
-var errUserNotFound = common.NewNotFoundError("user")
+var (
+	errUserNotFound     = common.NewNotFoundError("user")
+	errUsernameConflict = common.NewConflictError("username")
+)
 
 type userRepo struct {
 	*Database
@@ -18,17 +22,35 @@ type userRepo struct {
 
 // Store impelements iam.UserRepository
 func (db *userRepo) Store(ctx context.Context, user iam.User) error {
-	blob, err := json.Marshal(user)
-	if err != nil {
-		return err
-	}
-
 	return db.db.Update(func(tx *bbolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists(userBucketKey)
 		if err != nil {
 			return err
 		}
 
+		if err := ensureUserIndexes(tx); err != nil {
+			return err
+		}
+
+		old := bucket.Get([]byte(user.ID))
+		user.Version, err = nextVersion(old, user.Metadata)
+		if err != nil {
+			return err
+		}
+
+		blob, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		if err := unindexUser(tx, old); err != nil {
+			return err
+		}
+
+		if err := indexUser(tx, user); err != nil {
+			return err
+		}
+
 		return bucket.Put([]byte(user.ID), blob)
 	})
 }
@@ -41,6 +63,14 @@ func (db *userRepo) Delete(ctx context.Context, urn iam.UserURN) error {
 			return nil
 		}
 
+		if err := ensureUserIndexes(tx); err != nil {
+			return err
+		}
+
+		if err := unindexUser(tx, bucket.Get([]byte(urn))); err != nil {
+			return err
+		}
+
 		return bucket.Delete([]byte(urn))
 	})
 }
@@ -70,6 +100,40 @@ func (db *userRepo) Load(ctx context.Context, urn iam.UserURN) (user iam.User, e
 	return
 }
 
+// LoadByUsername implements iam.UserRepository
+func (db *userRepo) LoadByUsername(ctx context.Context, username string) (user iam.User, err error) {
+	if err := db.ensureIndexes(); err != nil {
+		return user, err
+	}
+
+	var blob []byte
+	err = db.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(userBucketKey)
+		if bucket == nil {
+			return errUserNotFound
+		}
+
+		urn := tx.Bucket(usernameBucketKey).Get([]byte(username))
+		if urn == nil {
+			return errUserNotFound
+		}
+
+		blob = bucket.Get(urn)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	if blob == nil {
+		err = errUserNotFound
+	} else {
+		err = json.Unmarshal(blob, &user)
+	}
+
+	return
+}
+
 // Get implements iam.UserRepository
 func (db *userRepo) Get(ctx context.Context) (users []iam.User, err error) {
 	var blobs [][]byte
@@ -104,3 +168,226 @@ func (db *userRepo) Get(ctx context.Context) (users []iam.User, err error) {
 
 	return
 }
+
+// Query implements iam.UserRepository. Users are iterated in the order
+// of the username or account ID index starting at the cursor so only
+// the requested page and the users skipped by filters are loaded.
+func (db *userRepo) Query(ctx context.Context, q iam.UserQuery) (page iam.UserPage, err error) {
+	if err := q.Validate(); err != nil {
+		return page, common.NewInvalidArgumentError(err.Error())
+	}
+	after, _ := q.After()
+
+	if err := db.ensureIndexes(); err != nil {
+		return page, err
+	}
+
+	page.Users = []iam.User{}
+	err = db.db.View(func(tx *bbolt.Tx) error {
+		users := tx.Bucket(userBucketKey)
+		if users == nil {
+			return nil
+		}
+
+		var members map[iam.UserURN]bool
+		if q.Group != "" {
+			members = make(map[iam.UserURN]bool)
+
+			if b := tx.Bucket(membershipGroupBucketKey); b != nil && b.Get([]byte(q.Group)) != nil {
+				var list userList
+				if err := json.Unmarshal(b.Get([]byte(q.Group)), &list); err != nil {
+					return err
+				}
+				for _, urn := range list {
+					members[urn] = true
+				}
+			}
+		}
+
+		var (
+			index  *bbolt.Bucket
+			prefix []byte
+		)
+		switch q.SortBy {
+		case iam.SortByAccountID:
+			index = tx.Bucket(userByAccountBucketKey)
+		case iam.SortByCreatedAt:
+			index = tx.Bucket(userByCreatedBucketKey)
+		case iam.SortByUpdatedAt:
+			index = tx.Bucket(userByUpdatedBucketKey)
+		default:
+			index = tx.Bucket(userByNameBucketKey)
+			prefix = []byte(q.UsernamePrefix)
+		}
+
+		cursor := index.Cursor()
+		key, value := cursor.Seek(prefix)
+		if after != nil && bytes.Compare(after, prefix) >= 0 {
+			key, value = cursor.Seek(after)
+			if bytes.Equal(key, after) {
+				key, value = cursor.Next()
+			}
+		}
+
+		for ; key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			urn := iam.UserURN(value)
+			if members != nil && !members[urn] {
+				continue
+			}
+
+			var u iam.User
+			if err := json.Unmarshal(users.Get(value), &u); err != nil {
+				return err
+			}
+
+			if !q.Matches(u) {
+				continue
+			}
+
+			if q.Limit > 0 && len(page.Users) == q.Limit {
+				page.NextCursor = q.CursorFor(page.Users[q.Limit-1])
+				return nil
+			}
+
+			page.Users = append(page.Users, u)
+		}
+
+		return nil
+	})
+
+	return page, err
+}
+
+// ensureIndexes migrates databases created before the user indexes
+// existed.
+func (db *userRepo) ensureIndexes() error {
+	var indexed bool
+	db.db.View(func(tx *bbolt.Tx) error {
+		indexed = hasUserIndexes(tx)
+		return nil
+	})
+	if indexed {
+		return nil
+	}
+
+	return db.db.Update(ensureUserIndexes)
+}
+
+// userIndexKeys holds the keys of all user index buckets.
+var userIndexKeys = [][]byte{
+	userByNameBucketKey,
+	userByAccountBucketKey,
+	userByCreatedBucketKey,
+	userByUpdatedBucketKey,
+	usernameBucketKey,
+}
+
+func hasUserIndexes(tx *bbolt.Tx) bool {
+	for _, key := range userIndexKeys {
+		if tx.Bucket(key) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureUserIndexes creates the username and sort indexes from all
+// stored users unless they exist already. Indexes added later are
+// created the same way.
+func ensureUserIndexes(tx *bbolt.Tx) error {
+	if hasUserIndexes(tx) {
+		return nil
+	}
+
+	for _, key := range userIndexKeys {
+		if _, err := tx.CreateBucketIfNotExists(key); err != nil {
+			return err
+		}
+	}
+
+	users := tx.Bucket(userBucketKey)
+	if users == nil {
+		return nil
+	}
+
+	return users.ForEach(func(_, blob []byte) error {
+		var u iam.User
+		if err := json.Unmarshal(blob, &u); err != nil {
+			return err
+		}
+
+		err := indexUser(tx, u)
+		if common.IsConflict(err) {
+			// duplicate usernames stored before usernames were
+			// unique keep the first user in the username index.
+			return indexUserSortKeys(tx, u)
+		}
+		return err
+	})
+}
+
+// indexUser adds u to all user indexes. It returns a conflict error if
+// the username is used by another user.
+func indexUser(tx *bbolt.Tx, u iam.User) error {
+	names := tx.Bucket(usernameBucketKey)
+	if owner := names.Get([]byte(u.Username)); owner != nil && string(owner) != string(u.ID) {
+		return errUsernameConflict
+	}
+
+	if err := names.Put([]byte(u.Username), []byte(u.ID)); err != nil {
+		return err
+	}
+
+	return indexUserSortKeys(tx, u)
+}
+
+func indexUserSortKeys(tx *bbolt.Tx, u iam.User) error {
+	for key, sortKey := range userSortKeys(u) {
+		if err := tx.Bucket([]byte(key)).Put(sortKey, []byte(u.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userSortKeys returns the key of u in each sort index by index bucket
+// key.
+func userSortKeys(u iam.User) map[string][]byte {
+	return map[string][]byte{
+		string(userByNameBucketKey):    iam.UsernameSortKey(u.Username, u.ID),
+		string(userByAccountBucketKey): iam.AccountIDSortKey(u.AccountID),
+		string(userByCreatedBucketKey): iam.TimeSortKey(u.CreatedAt, string(u.ID)),
+		string(userByUpdatedBucketKey): iam.TimeSortKey(u.UpdatedAt, string(u.ID)),
+	}
+}
+
+// unindexUser removes the index entries of the stored user blob, if
+// any.
+func unindexUser(tx *bbolt.Tx, blob []byte) error {
+	if blob == nil {
+		return nil
+	}
+
+	var u iam.User
+	if err := json.Unmarshal(blob, &u); err != nil {
+		return err
+	}
+
+	names := tx.Bucket(usernameBucketKey)
+	if owner := names.Get([]byte(u.Username)); string(owner) == string(u.ID) {
+		if err := names.Delete([]byte(u.Username)); err != nil {
+			return err
+		}
+	}
+
+	for key, sortKey := range userSortKeys(u) {
+		if err := tx.Bucket([]byte(key)).Delete(sortKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
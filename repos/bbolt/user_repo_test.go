@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 	"go.etcd.io/bbolt"
 )
@@ -37,6 +39,7 @@ func Test_Store(t *testing.T) {
 
 	err = db.Store(context.Background(), user)
 	assert.NoError(t, err)
+	user.Version = 1
 
 	db.db.View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(userBucketKey)
@@ -59,9 +62,18 @@ func Test_Store(t *testing.T) {
 		Attributes: map[string]interface{}{
 			"job": "developer",
 		},
+		Metadata: iam.Metadata{Version: 1},
 	}
 	err = db.Store(context.Background(), user)
 	assert.NoError(t, err)
+	user.Version = 2
+
+	// but not if the user has been modified in the meantime
+	stale := user
+	stale.Version = 1
+	stale.Username = "admin3"
+	err = db.Store(context.Background(), stale)
+	assert.True(t, common.IsConflict(err))
 
 	db.db.View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(userBucketKey)
@@ -112,3 +124,169 @@ func Test_Get(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []iam.User{testExistingUser}, list)
 }
+
+func Test_Query(t *testing.T) {
+	// testExistingUser is stored without indexes like in databases
+	// created by previous versions.
+	db, cleanup := getTempUserRepoWithData(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	locked := true
+	for _, u := range []iam.User{
+		{AccountID: 2, Username: "bob", ID: "urn:iam::user/2", Attributes: map[string]interface{}{"department": "surgery"}},
+		{AccountID: 3, Username: "alice", ID: "urn:iam::user/3", Locked: &locked, Attributes: map[string]interface{}{"department": []interface{}{"surgery", "lab"}}},
+		{AccountID: 4, Username: "carol", ID: "urn:iam::user/4"},
+		{AccountID: 5, Username: "al", ID: "urn:iam::user/5"},
+	} {
+		require.NoError(t, db.Store(ctx, u))
+	}
+
+	// renames must update the index
+	require.NoError(t, db.Store(ctx, iam.User{AccountID: 4, Username: "dave", ID: "urn:iam::user/4", Metadata: iam.Metadata{Version: 1}}))
+	require.NoError(t, db.Delete(ctx, "urn:iam::user/5"))
+
+	usernames := func(page iam.UserPage) []string {
+		var names []string
+		for _, u := range page.Users {
+			names = append(names, u.Username)
+		}
+		return names
+	}
+
+	page, err := db.Query(ctx, iam.UserQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin", "alice", "bob", "dave"}, usernames(page))
+	assert.Empty(t, page.NextCursor)
+
+	page, err = db.Query(ctx, iam.UserQuery{SortBy: iam.SortByAccountID, Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob", "alice", "dave"}, usernames(page))
+	require.NotEmpty(t, page.NextCursor)
+
+	page, err = db.Query(ctx, iam.UserQuery{SortBy: iam.SortByAccountID, Limit: 3, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, usernames(page))
+	assert.Empty(t, page.NextCursor)
+
+	page, err = db.Query(ctx, iam.UserQuery{UsernamePrefix: "a", Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, usernames(page))
+
+	page, err = db.Query(ctx, iam.UserQuery{UsernamePrefix: "a", Limit: 1, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, usernames(page))
+	assert.Empty(t, page.NextCursor)
+
+	page, err = db.Query(ctx, iam.UserQuery{Attributes: map[string]string{"department": "surgery"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, usernames(page))
+
+	unlocked := false
+	page, err = db.Query(ctx, iam.UserQuery{Locked: &unlocked, Attributes: map[string]string{"department": "surgery"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, usernames(page))
+
+	members := &memberRepo{db.Database}
+	require.NoError(t, members.AddMember(ctx, "urn:iam::user/4", "urn:iam::group/vets"))
+	require.NoError(t, members.AddMember(ctx, "urn:iam::user/10", "urn:iam::group/vets"))
+
+	page, err = db.Query(ctx, iam.UserQuery{Group: "urn:iam::group/vets"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin", "dave"}, usernames(page))
+
+	page, err = db.Query(ctx, iam.UserQuery{Group: "urn:iam::group/nurses"})
+	require.NoError(t, err)
+	assert.Empty(t, page.Users)
+
+	_, err = db.Query(ctx, iam.UserQuery{SortBy: iam.SortByAccountID, Cursor: "invalid"})
+	assert.True(t, common.IsInvalidArgument(err))
+}
+
+func Test_QueryMetadata(t *testing.T) {
+	db, cleanup := getTempUserRepoWithData(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return t0.Add(time.Duration(hours) * time.Hour) }
+
+	for _, u := range []iam.User{
+		{AccountID: 2, Username: "bob", ID: "urn:iam::user/2", Metadata: iam.Metadata{CreatedAt: at(1), CreatedBy: "admin", UpdatedAt: at(5), UpdatedBy: "admin"}},
+		{AccountID: 3, Username: "alice", ID: "urn:iam::user/3", Metadata: iam.Metadata{CreatedAt: at(2), CreatedBy: "bob", UpdatedAt: at(3), UpdatedBy: "bob"}},
+		{AccountID: 4, Username: "carol", ID: "urn:iam::user/4", Metadata: iam.Metadata{CreatedAt: at(3), CreatedBy: "admin", UpdatedAt: at(4), UpdatedBy: "bob"}},
+	} {
+		require.NoError(t, db.Store(ctx, u))
+	}
+
+	// updates must move the user in the index
+	carol, err := db.Load(ctx, "urn:iam::user/4")
+	require.NoError(t, err)
+	carol.Touch("alice", at(6))
+	require.NoError(t, db.Store(ctx, carol))
+
+	usernames := func(page iam.UserPage) []string {
+		var names []string
+		for _, u := range page.Users {
+			names = append(names, u.Username)
+		}
+		return names
+	}
+
+	// admin has been stored without metadata and sorts first
+	page, err := db.Query(ctx, iam.UserQuery{SortBy: iam.SortByCreatedAt})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin", "bob", "alice", "carol"}, usernames(page))
+
+	page, err = db.Query(ctx, iam.UserQuery{SortBy: iam.SortByUpdatedAt, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin", "alice"}, usernames(page))
+
+	page, err = db.Query(ctx, iam.UserQuery{SortBy: iam.SortByUpdatedAt, Limit: 2, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob", "carol"}, usernames(page))
+	assert.Empty(t, page.NextCursor)
+
+	page, err = db.Query(ctx, iam.UserQuery{MetadataFilter: iam.MetadataFilter{CreatedBy: "admin", UpdatedAfter: at(5)}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"carol"}, usernames(page))
+
+	page, err = db.Query(ctx, iam.UserQuery{MetadataFilter: iam.MetadataFilter{CreatedAfter: at(0), CreatedBefore: at(3)}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, usernames(page))
+}
+
+func Test_LoadByUsername(t *testing.T) {
+	// testExistingUser is stored without indexes like in databases
+	// created by previous versions.
+	db, cleanup := getTempUserRepoWithData(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	u, err := db.LoadByUsername(ctx, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, testExistingUser, u)
+
+	_, err = db.LoadByUsername(ctx, "alice")
+	assert.True(t, common.IsNotFound(err))
+
+	require.NoError(t, db.Store(ctx, iam.User{AccountID: 2, Username: "alice", ID: "urn:iam::user/2"}))
+
+	err = db.Store(ctx, iam.User{AccountID: 3, Username: "alice", ID: "urn:iam::user/3"})
+	assert.True(t, common.IsConflict(err))
+	_, err = db.Load(ctx, "urn:iam::user/3")
+	assert.True(t, common.IsNotFound(err))
+
+	// renaming releases the old username
+	require.NoError(t, db.Store(ctx, iam.User{AccountID: 2, Username: "alice.smith", ID: "urn:iam::user/2", Metadata: iam.Metadata{Version: 1}}))
+	_, err = db.LoadByUsername(ctx, "alice")
+	assert.True(t, common.IsNotFound(err))
+
+	u, err = db.LoadByUsername(ctx, "alice.smith")
+	require.NoError(t, err)
+	assert.Equal(t, iam.UserURN("urn:iam::user/2"), u.ID)
+
+	require.NoError(t, db.Delete(ctx, "urn:iam::user/2"))
+	_, err = db.LoadByUsername(ctx, "alice.smith")
+	assert.True(t, common.IsNotFound(err))
+}
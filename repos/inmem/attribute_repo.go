@@ -0,0 +1,69 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+type attributeRepo struct {
+	l sync.RWMutex
+	m map[string]iam.AttributeDefinition
+}
+
+func (r *attributeRepo) Store(ctx context.Context, def iam.AttributeDefinition) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.m[def.Name] = def
+	return nil
+}
+
+func (r *attributeRepo) Delete(ctx context.Context, name string) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if _, ok := r.m[name]; !ok {
+		return common.NewNotFoundError("attribute")
+	}
+
+	delete(r.m, name)
+
+	return nil
+}
+
+func (r *attributeRepo) Load(ctx context.Context, name string) (iam.AttributeDefinition, error) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	def, ok := r.m[name]
+	if !ok {
+		return iam.AttributeDefinition{}, common.NewNotFoundError("attribute")
+	}
+
+	return def, nil
+}
+
+func (r *attributeRepo) Get(ctx context.Context) (iam.AttributeSchema, error) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	schema := make(iam.AttributeSchema, 0, len(r.m))
+	for _, def := range r.m {
+		schema = append(schema, def)
+	}
+
+	sort.Slice(schema, func(i, j int) bool { return schema[i].Name < schema[j].Name })
+
+	return schema, nil
+}
+
+// NewAttributeRepository returns a new in-memory attribute repository.
+func NewAttributeRepository() iam.AttributeRepository {
+	return &attributeRepo{
+		m: make(map[string]iam.AttributeDefinition),
+	}
+}
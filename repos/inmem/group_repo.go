@@ -24,6 +24,12 @@ func (repo *groupRepo) Store(ctx context.Context, group iam.Group) error {
 	repo.rw.Lock()
 	defer repo.rw.Unlock()
 
+	version, err := iam.NextVersion(repo.groups[group.ID].Version, group.Metadata)
+	if err != nil {
+		return err
+	}
+
+	group.Version = version
 	repo.groups[group.ID] = group
 
 	return ctx.Err()
@@ -0,0 +1,69 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+type invitationRepo struct {
+	l sync.RWMutex
+	m map[string]iam.Invitation
+}
+
+func (r *invitationRepo) Store(ctx context.Context, inv iam.Invitation) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.m[inv.ID] = inv
+	return nil
+}
+
+func (r *invitationRepo) Delete(ctx context.Context, id string) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if _, ok := r.m[id]; !ok {
+		return common.NewNotFoundError("invitation")
+	}
+
+	delete(r.m, id)
+
+	return nil
+}
+
+func (r *invitationRepo) Load(ctx context.Context, id string) (iam.Invitation, error) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	inv, ok := r.m[id]
+	if !ok {
+		return iam.Invitation{}, common.NewNotFoundError("invitation")
+	}
+
+	return inv, nil
+}
+
+func (r *invitationRepo) Get(ctx context.Context) ([]iam.Invitation, error) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	invitations := make([]iam.Invitation, 0, len(r.m))
+	for _, inv := range r.m {
+		invitations = append(invitations, inv)
+	}
+
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].Username < invitations[j].Username })
+
+	return invitations, nil
+}
+
+// NewInvitationRepository returns a new in-memory invitation repository.
+func NewInvitationRepository() iam.InvitationRepository {
+	return &invitationRepo{
+		m: make(map[string]iam.Invitation),
+	}
+}
@@ -0,0 +1,39 @@
+package inmem
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+type keySetRepo struct {
+	rw   sync.RWMutex
+	blob []byte
+}
+
+// NewKeySetRepository creates a new in-memory key set repository
+func NewKeySetRepository() iam.KeySetRepository {
+	return &keySetRepo{}
+}
+
+func (r *keySetRepo) Store(ctx context.Context, blob []byte) error {
+	r.rw.Lock()
+	defer r.rw.Unlock()
+
+	r.blob = append([]byte(nil), blob...)
+
+	return ctx.Err()
+}
+
+func (r *keySetRepo) Load(ctx context.Context) ([]byte, error) {
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+
+	if r.blob == nil {
+		return nil, common.NewNotFoundError("key set")
+	}
+
+	return append([]byte(nil), r.blob...), ctx.Err()
+}
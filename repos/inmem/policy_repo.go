@@ -17,7 +17,14 @@ func (r *policyRepo) Store(ctx context.Context, policy iam.Policy) error {
 	r.l.Lock()
 	defer r.l.Unlock()
 
-	r.m[iam.PolicyURN(policy.ID)] = policy
+	urn := iam.PolicyURN(policy.ID)
+	version, err := iam.NextVersion(r.m[urn].Version, policy.Metadata)
+	if err != nil {
+		return err
+	}
+
+	policy.Version = version
+	r.m[urn] = policy
 	return nil
 }
 
@@ -0,0 +1,74 @@
+package inmem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+type revocationRepo struct {
+	rw       sync.RWMutex
+	tokens   map[string]iam.Revocation
+	subjects map[string]iam.Revocation
+}
+
+// NewRevocationRepository creates a new in-memory revocation repository
+func NewRevocationRepository() iam.RevocationRepository {
+	return &revocationRepo{
+		tokens:   make(map[string]iam.Revocation),
+		subjects: make(map[string]iam.Revocation),
+	}
+}
+
+func (r *revocationRepo) Store(ctx context.Context, revocation iam.Revocation) error {
+	r.rw.Lock()
+	defer r.rw.Unlock()
+
+	switch {
+	case revocation.TokenID != "":
+		r.tokens[revocation.TokenID] = revocation
+	case revocation.Subject != "":
+		r.subjects[revocation.Subject] = revocation
+	default:
+		return common.NewInvalidArgumentError("revocation requires a token ID or subject")
+	}
+
+	return ctx.Err()
+}
+
+func (r *revocationRepo) Get(ctx context.Context) ([]iam.Revocation, error) {
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+
+	revocations := make([]iam.Revocation, 0, len(r.tokens)+len(r.subjects))
+	for _, rev := range r.tokens {
+		revocations = append(revocations, rev)
+	}
+	for _, rev := range r.subjects {
+		revocations = append(revocations, rev)
+	}
+
+	return revocations, ctx.Err()
+}
+
+func (r *revocationRepo) DeleteExpired(ctx context.Context, t time.Time) error {
+	r.rw.Lock()
+	defer r.rw.Unlock()
+
+	for key, rev := range r.tokens {
+		if rev.IsExpired(t) {
+			delete(r.tokens, key)
+		}
+	}
+
+	for key, rev := range r.subjects {
+		if rev.IsExpired(t) {
+			delete(r.subjects, key)
+		}
+	}
+
+	return ctx.Err()
+}
@@ -1,7 +1,9 @@
 package inmem
 
 import (
+	"bytes"
 	"context"
+	"sort"
 	"sync"
 
 	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
@@ -11,15 +13,33 @@ import (
 var errUserNotFound = common.NewNotFoundError("user")
 
 type userRepository struct {
-	l     sync.RWMutex
-	users map[iam.UserURN]iam.User
+	l         sync.RWMutex
+	users     map[iam.UserURN]iam.User
+	usernames map[string]iam.UserURN
+	members   iam.MembershipRepository
 }
 
 func (r *userRepository) Store(ctx context.Context, user iam.User) error {
 	r.l.Lock()
 	defer r.l.Unlock()
 
+	version, err := iam.NextVersion(r.users[user.ID].Version, user.Metadata)
+	if err != nil {
+		return err
+	}
+
+	if owner, ok := r.usernames[user.Username]; ok && owner != user.ID {
+		return common.NewConflictError("username")
+	}
+
+	if old, ok := r.users[user.ID]; ok {
+		delete(r.usernames, old.Username)
+	}
+
+	user.Version = version
+
 	r.users[user.ID] = user
+	r.usernames[user.Username] = user.ID
 
 	return ctx.Err()
 }
@@ -28,11 +48,13 @@ func (r *userRepository) Delete(ctx context.Context, urn iam.UserURN) error {
 	r.l.Lock()
 	defer r.l.Unlock()
 
-	if _, ok := r.users[urn]; !ok {
+	u, ok := r.users[urn]
+	if !ok {
 		return errUserNotFound
 	}
 
 	delete(r.users, urn)
+	delete(r.usernames, u.Username)
 
 	return ctx.Err()
 }
@@ -48,6 +70,17 @@ func (r *userRepository) Load(ctx context.Context, urn iam.UserURN) (iam.User, e
 	return iam.User{}, errUserNotFound
 }
 
+func (r *userRepository) LoadByUsername(ctx context.Context, username string) (iam.User, error) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	if urn, ok := r.usernames[username]; ok {
+		return r.users[urn], nil
+	}
+
+	return iam.User{}, errUserNotFound
+}
+
 func (r *userRepository) Get(ctx context.Context) ([]iam.User, error) {
 	r.l.RLock()
 	defer r.l.RUnlock()
@@ -60,9 +93,81 @@ func (r *userRepository) Get(ctx context.Context) ([]iam.User, error) {
 	return users, ctx.Err()
 }
 
-// NewUserRepository returns a new in-memory user repository
+func (r *userRepository) Query(ctx context.Context, q iam.UserQuery) (iam.UserPage, error) {
+	if err := q.Validate(); err != nil {
+		return iam.UserPage{}, common.NewInvalidArgumentError(err.Error())
+	}
+	after, _ := q.After()
+
+	var members map[iam.UserURN]bool
+	if q.Group != "" {
+		if r.members == nil {
+			return iam.UserPage{}, common.NewInvalidArgumentError("group filter not supported")
+		}
+
+		urns, err := r.members.Members(ctx, q.Group)
+		if err != nil && !common.IsNotFound(err) {
+			return iam.UserPage{}, err
+		}
+
+		members = make(map[iam.UserURN]bool, len(urns))
+		for _, urn := range urns {
+			members[urn] = true
+		}
+	}
+
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	var (
+		users []iam.User
+		keys  = make(map[iam.UserURN][]byte)
+	)
+	for _, u := range r.users {
+		if members != nil && !members[u.ID] {
+			continue
+		}
+
+		key := q.SortKey(u)
+		if after != nil && bytes.Compare(key, after) <= 0 {
+			continue
+		}
+
+		if q.Matches(u) {
+			users = append(users, u)
+			keys[u.ID] = key
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return bytes.Compare(keys[users[i].ID], keys[users[j].ID]) < 0
+	})
+
+	page := iam.UserPage{Users: users}
+	if q.Limit > 0 && len(users) > q.Limit {
+		page.Users = users[:q.Limit]
+		page.NextCursor = q.CursorFor(page.Users[q.Limit-1])
+	}
+	if page.Users == nil {
+		page.Users = []iam.User{}
+	}
+
+	return page, ctx.Err()
+}
+
+// NewUserRepository returns a new in-memory user repository. Queries
+// using a group filter are not supported. See
+// NewUserRepositoryWithMemberships.
 func NewUserRepository() iam.UserRepository {
+	return NewUserRepositoryWithMemberships(nil)
+}
+
+// NewUserRepositoryWithMemberships returns a new in-memory user
+// repository that uses members to filter users by group.
+func NewUserRepositoryWithMemberships(members iam.MembershipRepository) iam.UserRepository {
 	return &userRepository{
-		users: make(map[iam.UserURN]iam.User),
+		users:     make(map[iam.UserURN]iam.User),
+		usernames: make(map[string]iam.UserURN),
+		members:   members,
 	}
 }
@@ -0,0 +1,88 @@
+package attribute
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+type schemaRequest struct {
+	JSONSchema bool
+}
+
+// The user attribute schema.
+// swagger:model attributeSchemaResponse
+type schemaResponse struct {
+	// Attributes holds all attribute definitions sorted by name.
+	Attributes iam.AttributeSchema `json:"attributes"`
+}
+
+func makeSchemaEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(schemaRequest)
+		schema, err := s.Schema(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.JSONSchema {
+			return schema.JSONSchema(), nil
+		}
+
+		if schema == nil {
+			schema = iam.AttributeSchema{}
+		}
+		return schemaResponse{schema}, nil
+	}
+}
+
+type loadAttributeRequest struct {
+	Name string
+}
+
+func makeLoadAttributeEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(loadAttributeRequest)
+		return s.Load(ctx, req.Name)
+	}
+}
+
+type defineAttributeRequest struct {
+	Definition iam.AttributeDefinition
+}
+
+type defineAttributeResponse struct{}
+
+func (defineAttributeResponse) StatusCode() int { return http.StatusNoContent }
+
+func makeDefineAttributeEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(defineAttributeRequest)
+		if err := s.Define(ctx, req.Definition); err != nil {
+			return nil, err
+		}
+
+		return defineAttributeResponse{}, nil
+	}
+}
+
+type deleteAttributeRequest struct {
+	Name string
+}
+
+type deleteAttributeResponse struct{}
+
+func (deleteAttributeResponse) StatusCode() int { return http.StatusNoContent }
+
+func makeDeleteAttributeEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deleteAttributeRequest)
+		if err := s.Delete(ctx, req.Name); err != nil {
+			return nil, err
+		}
+
+		return deleteAttributeResponse{}, nil
+	}
+}
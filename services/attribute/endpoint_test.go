@@ -0,0 +1,139 @@
+package attribute
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+var bg = context.Background()
+
+func Test_SchemaEndpoint(t *testing.T) {
+	s := &serviceMock{}
+	ep := makeSchemaEndpoint(s)
+
+	schema := iam.AttributeSchema{{Name: "email", Type: iam.AttrTypeString, Required: true}}
+	s.On("Schema").Return(schema, nil)
+
+	res, err := ep(bg, schemaRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, schemaResponse{Attributes: schema}, res)
+
+	res, err = ep(bg, schemaRequest{JSONSchema: true})
+	assert.NoError(t, err)
+	assert.Equal(t, schema.JSONSchema(), res)
+
+	// an empty schema is encoded as an empty list
+	s = &serviceMock{}
+	s.On("Schema").Return(iam.AttributeSchema(nil), nil)
+	res, err = makeSchemaEndpoint(s)(bg, schemaRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, schemaResponse{Attributes: iam.AttributeSchema{}}, res)
+
+	s = &serviceMock{}
+	s.On("Schema").Return(iam.AttributeSchema(nil), errors.New("simulated"))
+	res, err = makeSchemaEndpoint(s)(bg, schemaRequest{})
+	assert.Error(t, err)
+	assert.Nil(t, res)
+}
+
+func Test_LoadAttributeEndpoint(t *testing.T) {
+	s := &serviceMock{}
+	ep := makeLoadAttributeEndpoint(s)
+
+	def := iam.AttributeDefinition{Name: "email", Type: iam.AttrTypeString}
+	s.On("Load", "email").Once().Return(def, nil)
+	s.On("Load", "phone").Once().Return(iam.AttributeDefinition{}, common.NewNotFoundError("attribute"))
+
+	res, err := ep(bg, loadAttributeRequest{Name: "email"})
+	assert.NoError(t, err)
+	assert.Equal(t, def, res)
+
+	_, err = ep(bg, loadAttributeRequest{Name: "phone"})
+	assert.True(t, common.IsNotFound(err))
+
+	s.AssertExpectations(t)
+}
+
+func Test_DefineAttributeEndpoint(t *testing.T) {
+	s := &serviceMock{}
+	ep := makeDefineAttributeEndpoint(s)
+
+	def := iam.AttributeDefinition{Name: "email", Type: iam.AttrTypeString}
+	s.On("Define", def).Once().Return(nil)
+
+	res, err := ep(bg, defineAttributeRequest{Definition: def})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.(kithttp.StatusCoder).StatusCode())
+
+	invalid := iam.AttributeDefinition{Name: "room"}
+	s.On("Define", invalid).Once().Return(common.NewInvalidArgumentError("missing type"))
+	res, err = ep(bg, defineAttributeRequest{Definition: invalid})
+	assert.True(t, common.IsInvalidArgument(err))
+	assert.Nil(t, res)
+
+	s.AssertExpectations(t)
+}
+
+func Test_DeleteAttributeEndpoint(t *testing.T) {
+	s := &serviceMock{}
+	ep := makeDeleteAttributeEndpoint(s)
+
+	s.On("Delete", "email").Once().Return(nil)
+	s.On("Delete", "phone").Once().Return(common.NewNotFoundError("attribute"))
+
+	res, err := ep(bg, deleteAttributeRequest{Name: "email"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.(kithttp.StatusCoder).StatusCode())
+
+	res, err = ep(bg, deleteAttributeRequest{Name: "phone"})
+	assert.True(t, common.IsNotFound(err))
+	assert.Nil(t, res)
+
+	s.AssertExpectations(t)
+}
+
+type serviceMock struct {
+	mock.Mock
+}
+
+func (s *serviceMock) Define(_ context.Context, def iam.AttributeDefinition) error {
+	return s.Called(def).Error(0)
+}
+
+func (s *serviceMock) Delete(_ context.Context, name string) error {
+	return s.Called(name).Error(0)
+}
+
+func (s *serviceMock) Load(_ context.Context, name string) (iam.AttributeDefinition, error) {
+	args := s.Called(name)
+	return args.Get(0).(iam.AttributeDefinition), args.Error(1)
+}
+
+func (s *serviceMock) Schema(_ context.Context) (iam.AttributeSchema, error) {
+	args := s.Called()
+	return args.Get(0).(iam.AttributeSchema), args.Error(1)
+}
+
+func (s *serviceMock) ValidateAttrs(_ context.Context, attrs map[string]interface{}) (map[string]interface{}, error) {
+	args := s.Called(attrs)
+	res, _ := args.Get(0).(map[string]interface{})
+	return res, args.Error(1)
+}
+
+func (s *serviceMock) ValidateAttr(_ context.Context, key string, value interface{}) error {
+	return s.Called(key, value).Error(0)
+}
+
+func (s *serviceMock) UniqueAttrs(_ context.Context) ([]string, error) {
+	args := s.Called()
+	res, _ := args.Get(0).([]string)
+	return res, args.Error(1)
+}
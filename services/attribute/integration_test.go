@@ -0,0 +1,166 @@
+package attribute_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iamtest"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/attribute"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+type testbed struct {
+	*iamtest.Authn
+	attrs  attribute.Service
+	users  user.Service
+	client iamtest.Client
+}
+
+func newTestbed(t *testing.T) *testbed {
+	a := iamtest.NewAuthn(t)
+
+	users := inmem.NewUserRepository()
+	attrs := attribute.NewService(inmem.NewAttributeRepository(), users)
+	us := user.NewValidatingService(user.NewService(users, a.Service, nil), attrs)
+
+	authz := enforcer.NewNoOpEnforcer()
+	srv := a.Serve(attribute.MakeHandler(attrs, a.Service.ExtractTokenSubject, authz, log.NewNopLogger()))
+
+	_, token := a.AccountToken("admin")
+
+	return &testbed{
+		Authn:  a,
+		attrs:  attrs,
+		users:  us,
+		client: iamtest.Client{URL: srv.URL, Token: token},
+	}
+}
+
+func TestIntegration_ManageSchema(t *testing.T) {
+	tb := newTestbed(t)
+	defer tb.Close()
+
+	email := iam.AttributeDefinition{
+		Type:        iam.AttrTypeString,
+		Format:      iam.AttrFormatEmail,
+		Required:    true,
+		Description: "Primary email address",
+	}
+	assert.Equal(t, http.StatusNoContent, tb.client.Do(t, "PUT", "/v1/attributes/email", email, nil).StatusCode)
+
+	role := iam.AttributeDefinition{
+		Type:    iam.AttrTypeArray,
+		Enum:    []interface{}{"vet", "nurse"},
+		Default: []interface{}{"nurse"},
+	}
+	assert.Equal(t, http.StatusNoContent, tb.client.Do(t, "PUT", "/v1/attributes/roles", role, nil).StatusCode)
+
+	var def iam.AttributeDefinition
+	require.Equal(t, http.StatusOK, tb.client.Do(t, "GET", "/v1/attributes/email", nil, &def).StatusCode)
+	email.Name = "email"
+	assert.Equal(t, email, def)
+
+	var schema struct {
+		Attributes iam.AttributeSchema `json:"attributes"`
+	}
+	require.Equal(t, http.StatusOK, tb.client.Do(t, "GET", "/v1/attributes/", nil, &schema).StatusCode)
+	require.Len(t, schema.Attributes, 2)
+	assert.Equal(t, "email", schema.Attributes[0].Name)
+	assert.Equal(t, "roles", schema.Attributes[1].Name)
+
+	var jsonSchema map[string]interface{}
+	require.Equal(t, http.StatusOK, tb.client.Do(t, "GET", "/v1/attributes/?format=json-schema", nil, &jsonSchema).StatusCode)
+	assert.Equal(t, "object", jsonSchema["type"])
+	assert.Equal(t, []interface{}{"email"}, jsonSchema["required"])
+	assert.Equal(t, map[string]interface{}{
+		"type":    "array",
+		"items":   map[string]interface{}{"enum": []interface{}{"vet", "nurse"}},
+		"default": []interface{}{"nurse"},
+	}, jsonSchema["properties"].(map[string]interface{})["roles"])
+
+	var errBody struct {
+		Fields []common.FieldError `json:"fields"`
+	}
+	invalid := iam.AttributeDefinition{Type: "date", Default: 1}
+	require.Equal(t, http.StatusBadRequest, tb.client.Do(t, "PUT", "/v1/attributes/birthday", invalid, &errBody).StatusCode)
+	assert.Equal(t, []common.FieldError{{Field: "type", Message: `unsupported type "date"`}}, errBody.Fields)
+
+	invalid = iam.AttributeDefinition{Type: iam.AttrTypeString, Format: iam.AttrFormatDate, Default: "yesterday"}
+	require.Equal(t, http.StatusBadRequest, tb.client.Do(t, "PUT", "/v1/attributes/birthday", invalid, &errBody).StatusCode)
+	assert.Equal(t, []common.FieldError{{Field: "default", Message: "must be a valid date"}}, errBody.Fields)
+
+	assert.Equal(t, http.StatusBadRequest, tb.client.Do(t, "PUT", "/v1/attributes/phone", iam.AttributeDefinition{Name: "mobile", Type: iam.AttrTypeString}, nil).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, tb.client.Do(t, "GET", "/v1/attributes/?format=xml", nil, nil).StatusCode)
+
+	assert.Equal(t, http.StatusNoContent, tb.client.Do(t, "DELETE", "/v1/attributes/roles", nil, nil).StatusCode)
+	assert.Equal(t, http.StatusNotFound, tb.client.Do(t, "DELETE", "/v1/attributes/roles", nil, nil).StatusCode)
+	assert.Equal(t, http.StatusNotFound, tb.client.Do(t, "GET", "/v1/attributes/roles", nil, nil).StatusCode)
+}
+
+func TestIntegration_ValidateUsers(t *testing.T) {
+	tb := newTestbed(t)
+	defer tb.Close()
+	ctx := context.Background()
+
+	// without a schema all attributes are accepted
+	_, err := tb.users.CreateUser(ctx, "legacy", "secret", map[string]interface{}{"phoneNumber": "123"})
+	require.NoError(t, err)
+
+	require.NoError(t, tb.attrs.Define(ctx, iam.AttributeDefinition{Name: "email", Type: iam.AttrTypeString, Format: iam.AttrFormatEmail, Required: true}))
+	require.NoError(t, tb.attrs.Define(ctx, iam.AttributeDefinition{Name: "phone", Type: iam.AttrTypeString, Format: iam.AttrFormatPhone}))
+	require.NoError(t, tb.attrs.Define(ctx, iam.AttributeDefinition{Name: "room", Type: iam.AttrTypeInteger, Default: 1}))
+
+	_, err = tb.users.CreateUser(ctx, "alice", "secret", map[string]interface{}{
+		"phoneNumber": "123",
+		"room":        1.5,
+	})
+	var verr *common.ValidationError
+	require.True(t, errors.As(err, &verr), "%v", err)
+	assert.True(t, common.IsInvalidArgument(err))
+	assert.Equal(t, []common.FieldError{
+		{Field: "email", Message: "is required"},
+		{Field: "phoneNumber", Message: "is not defined"},
+		{Field: "room", Message: "must be an integer"},
+	}, verr.Fields)
+
+	urn, err := tb.users.CreateUser(ctx, "alice", "secret", map[string]interface{}{"email": "alice@example.com"})
+	require.NoError(t, err)
+
+	u, err := tb.users.LoadUser(ctx, urn)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"email": "alice@example.com", "room": 1}, u.Attributes)
+
+	assert.True(t, common.IsInvalidArgument(tb.users.SetAttr(ctx, urn, "phone", "0664 123")))
+	assert.NoError(t, tb.users.SetAttr(ctx, urn, "phone", "+43664123"))
+	assert.True(t, common.IsInvalidArgument(tb.users.SetAttr(ctx, urn, "phoneNumber", "+43664123")))
+
+	assert.True(t, common.IsInvalidArgument(tb.users.DeleteAttr(ctx, urn, "email")))
+	assert.NoError(t, tb.users.DeleteAttr(ctx, urn, "phone"))
+
+	assert.True(t, common.IsInvalidArgument(tb.users.UpdateAttrs(ctx, urn, map[string]interface{}{"email": "not-an-email"})))
+	assert.NoError(t, tb.users.UpdateAttrs(ctx, urn, map[string]interface{}{"email": "alice@example.org", "room": float64(4)}))
+
+	u, err = tb.users.LoadUser(ctx, urn)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"email": "alice@example.org", "room": float64(4)}, u.Attributes)
+
+	// attributes cannot be made unique while users share a value
+	bob, err := tb.users.CreateUser(ctx, "bob", "secret", map[string]interface{}{"email": "bob@example.com", "room": 4})
+	require.NoError(t, err)
+
+	unique := iam.AttributeDefinition{Type: iam.AttrTypeInteger, Unique: true}
+	assert.Equal(t, http.StatusConflict, tb.client.Do(t, "PUT", "/v1/attributes/room", unique, nil).StatusCode)
+
+	require.NoError(t, tb.users.SetAttr(ctx, bob, "room", 5))
+	assert.Equal(t, http.StatusNoContent, tb.client.Do(t, "PUT", "/v1/attributes/room", unique, nil).StatusCode)
+	assert.True(t, common.IsConflict(tb.users.SetAttr(ctx, bob, "room", 4)))
+}
@@ -0,0 +1,72 @@
+package attribute
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+type loggingService struct {
+	l log.Logger
+	Service
+}
+
+// NewLoggingService returns a service that logs method calls of Service.
+// Validation calls are not logged as they are logged by the user service.
+func NewLoggingService(s Service, logger log.Logger) Service {
+	return &loggingService{
+		l:       logger,
+		Service: s,
+	}
+}
+
+func (s *loggingService) Define(ctx context.Context, def iam.AttributeDefinition) (err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "define_attribute",
+			"name", def.Name,
+			"type", def.Type,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Define(ctx, def)
+}
+
+func (s *loggingService) Delete(ctx context.Context, name string) (err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "delete_attribute",
+			"name", name,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Delete(ctx, name)
+}
+
+func (s *loggingService) Load(ctx context.Context, name string) (def iam.AttributeDefinition, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "load_attribute",
+			"name", name,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Load(ctx, name)
+}
+
+func (s *loggingService) Schema(ctx context.Context) (schema iam.AttributeSchema, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "attribute_schema",
+			"attributes", len(schema),
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Schema(ctx)
+}
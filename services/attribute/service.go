@@ -0,0 +1,169 @@
+// Package attribute manages the schema of user attributes. Each
+// attribute is described by an iam.AttributeDefinition and user
+// attributes are validated against the schema when users are created
+// or their attributes are modified (see user.NewValidatingService).
+// The schema is published as JSON Schema so UIs can render forms for
+// user attributes.
+package attribute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/mutex"
+)
+
+// Service manages the user attribute schema.
+type Service interface {
+	// Define creates a new attribute definition or replaces an existing
+	// one. Existing users are not validated against the new definition
+	// except that an attribute cannot be made unique while several
+	// users share a value, in which case common.ConflictError is
+	// returned.
+	Define(ctx context.Context, def iam.AttributeDefinition) error
+
+	// Delete deletes an attribute definition. Users that have the
+	// attribute set keep it but cannot set it again.
+	Delete(ctx context.Context, name string) error
+
+	// Load returns the definition of the attribute name.
+	Load(ctx context.Context, name string) (iam.AttributeDefinition, error)
+
+	// Schema returns all attribute definitions sorted by name.
+	Schema(ctx context.Context) (iam.AttributeSchema, error)
+
+	// ValidateAttrs validates the complete set of attributes of a user
+	// and returns attrs with defaults applied. It implements
+	// user.AttributeValidator.
+	ValidateAttrs(ctx context.Context, attrs map[string]interface{}) (map[string]interface{}, error)
+
+	// ValidateAttr validates a single attribute. A nil value validates
+	// that the attribute may be deleted. It implements
+	// user.AttributeValidator.
+	ValidateAttr(ctx context.Context, key string, value interface{}) error
+
+	// UniqueAttrs returns the names of all unique attributes. It
+	// implements user.AttributeValidator.
+	UniqueAttrs(ctx context.Context) ([]string, error)
+}
+
+type service struct {
+	m     *mutex.Mutex
+	repo  iam.AttributeRepository
+	users iam.UserRepository
+}
+
+// NewService returns a new attribute schema service. users is used to
+// check that unique attributes are not shared by existing users. It may
+// be nil.
+func NewService(repo iam.AttributeRepository, users iam.UserRepository) Service {
+	return &service{
+		m:     mutex.New(),
+		repo:  repo,
+		users: users,
+	}
+}
+
+func (s *service) Define(ctx context.Context, def iam.AttributeDefinition) error {
+	if err := def.Validate(); err != nil {
+		return err
+	}
+
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	if def.Unique {
+		if err := s.checkDuplicates(ctx, def.Name); err != nil {
+			return err
+		}
+	}
+
+	return s.repo.Store(ctx, def)
+}
+
+// checkDuplicates returns common.ConflictError if users that have not
+// been deleted share a value of the attribute name.
+func (s *service) checkDuplicates(ctx context.Context, name string) error {
+	if s.users == nil {
+		return nil
+	}
+
+	users, err := s.users.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(users))
+	for _, u := range users {
+		value, ok := u.Attributes[name]
+		if !ok || value == nil || u.DeletedAt != nil {
+			continue
+		}
+
+		str := fmt.Sprint(value)
+		if seen[str] {
+			return common.NewConflictError(name)
+		}
+		seen[str] = true
+	}
+
+	return nil
+}
+
+func (s *service) Delete(ctx context.Context, name string) error {
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	return s.repo.Delete(ctx, name)
+}
+
+func (s *service) Load(ctx context.Context, name string) (iam.AttributeDefinition, error) {
+	if !s.m.TryLock(ctx) {
+		return iam.AttributeDefinition{}, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	return s.repo.Load(ctx, name)
+}
+
+func (s *service) Schema(ctx context.Context) (iam.AttributeSchema, error) {
+	if !s.m.TryLock(ctx) {
+		return nil, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	return s.repo.Get(ctx)
+}
+
+func (s *service) ValidateAttrs(ctx context.Context, attrs map[string]interface{}) (map[string]interface{}, error) {
+	schema, err := s.Schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return schema.Apply(attrs)
+}
+
+func (s *service) ValidateAttr(ctx context.Context, key string, value interface{}) error {
+	schema, err := s.Schema(ctx)
+	if err != nil {
+		return err
+	}
+
+	return schema.Check(key, value)
+}
+
+func (s *service) UniqueAttrs(ctx context.Context) ([]string, error) {
+	schema, err := s.Schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return schema.Unique(), nil
+}
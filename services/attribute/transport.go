@@ -0,0 +1,213 @@
+package attribute
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+const (
+	// ActionReadAttributes allows a subject to read the user attribute
+	// schema.
+	ActionReadAttributes = "iam:attribute:read"
+
+	// ActionWriteAttribute allows a subject to create and update
+	// attribute definitions.
+	ActionWriteAttribute = "iam:attribute:write"
+
+	// ActionDeleteAttribute allows a subject to delete attribute
+	// definitions.
+	ActionDeleteAttribute = "iam:attribute:delete"
+)
+
+// MakeHandler returns a http.Handler for the attribute schema service.
+func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer.Enforcer, logger log.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+	}
+
+	makeEndpoint := func(action string, factory func(Service) endpoint.Endpoint) endpoint.Endpoint {
+		return endpoint.Chain(
+			authn.NewAuthenticator(extractor),
+			enforcer.NewActionEndpoint(action),
+			enforcer.NewEnforcedEndpoint(authz),
+		)(factory(s))
+	}
+
+	schemaHandler := kithttp.NewServer(
+		makeEndpoint(ActionReadAttributes, makeSchemaEndpoint),
+		decodeSchemaRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	loadAttributeHandler := kithttp.NewServer(
+		makeEndpoint(ActionReadAttributes, makeLoadAttributeEndpoint),
+		decodeLoadAttributeRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	defineAttributeHandler := kithttp.NewServer(
+		makeEndpoint(ActionWriteAttribute, makeDefineAttributeEndpoint),
+		decodeDefineAttributeRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	deleteAttributeHandler := kithttp.NewServer(
+		makeEndpoint(ActionDeleteAttribute, makeDeleteAttributeEndpoint),
+		decodeDeleteAttributeRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	r := mux.NewRouter()
+
+	// swagger:route GET /v1/attributes/ attributes getAttributeSchema
+	//
+	// Returns the user attribute schema. If the format query parameter
+	// is set to json-schema the schema is returned as a JSON Schema
+	// describing user attributes that can be used to render forms.
+	//
+	//	Produces:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Parameters:
+	//	+	in: query
+	//		name: format
+	//		description: Set to json-schema to receive a JSON Schema document.
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		200: attributeSchemaResponse
+	r.Handle("/v1/attributes/", schemaHandler).Methods("GET")
+
+	// swagger:route GET /v1/attributes/{name} attributes getAttribute
+	//
+	// Returns the definition of a user attribute.
+	//
+	//	Produces:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		200: AttributeDefinition
+	r.Handle("/v1/attributes/{name}", loadAttributeHandler).Methods("GET")
+
+	// swagger:route PUT /v1/attributes/{name} attributes defineAttribute
+	//
+	// Creates or replaces the definition of a user attribute. Invalid
+	// definitions are rejected with a list of field errors.
+	//
+	//	Consumes:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Parameters:
+	//	+	in: body
+	//		type: AttributeDefinition
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		204: description: The attribute definition has been stored.
+	r.Handle("/v1/attributes/{name}", defineAttributeHandler).Methods("PUT")
+
+	// swagger:route DELETE /v1/attributes/{name} attributes deleteAttribute
+	//
+	// Deletes the definition of a user attribute.
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		204: description: The attribute definition has been deleted.
+	r.Handle("/v1/attributes/{name}", deleteAttributeHandler).Methods("DELETE")
+
+	return r
+}
+
+func decodeSchemaRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req schemaRequest
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+	case "json-schema":
+		req.JSONSchema = true
+	default:
+		return nil, common.NewInvalidArgumentError("unsupported format " + format)
+	}
+
+	return req, nil
+}
+
+func decodeLoadAttributeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return loadAttributeRequest{Name: mux.Vars(r)["name"]}, nil
+}
+
+func decodeDefineAttributeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req defineAttributeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req.Definition); err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	name := mux.Vars(r)["name"]
+	if req.Definition.Name != "" && req.Definition.Name != name {
+		return nil, common.NewInvalidArgumentError("attribute name does not match the URL")
+	}
+	req.Definition.Name = name
+
+	return req, nil
+}
+
+func decodeDeleteAttributeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return deleteAttributeRequest{Name: mux.Vars(r)["name"]}, nil
+}
+
+// encodeError encodes err as a JSON object and maps typed errors (e.g.
+// from pkg/common, authn and enforcer) to their HTTP status code.
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var h kithttp.Headerer
+	if errors.As(err, &h) {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
+	var body interface{} = map[string]interface{}{
+		"error": err.Error(),
+	}
+
+	var sc kithttp.StatusCoder
+	if errors.As(err, &sc) {
+		w.WriteHeader(sc.StatusCode())
+		if m, ok := sc.(json.Marshaler); ok {
+			body = m
+		}
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(body)
+}
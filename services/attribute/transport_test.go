@@ -0,0 +1,92 @@
+package attribute
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+func Test_decodeSchemaRequest(t *testing.T) {
+	req, err := decodeSchemaRequest(bg, httptest.NewRequest("GET", "/v1/attributes/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, schemaRequest{}, req)
+
+	req, err = decodeSchemaRequest(bg, httptest.NewRequest("GET", "/v1/attributes/?format=json-schema", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, schemaRequest{JSONSchema: true}, req)
+
+	_, err = decodeSchemaRequest(bg, httptest.NewRequest("GET", "/v1/attributes/?format=xml", nil))
+	assert.True(t, common.IsInvalidArgument(err))
+}
+
+func Test_decodeDefineAttributeRequest(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/v1/attributes/email", strings.NewReader(`{"type": "string", "format": "email"}`))
+	r = mux.SetURLVars(r, map[string]string{"name": "email"})
+
+	req, err := decodeDefineAttributeRequest(bg, r)
+	assert.NoError(t, err)
+	assert.Equal(t, defineAttributeRequest{
+		Definition: iam.AttributeDefinition{
+			Name:   "email",
+			Type:   iam.AttrTypeString,
+			Format: iam.AttrFormatEmail,
+		},
+	}, req)
+
+	// the name must match the URL
+	r = httptest.NewRequest("PUT", "/v1/attributes/email", strings.NewReader(`{"name": "phone", "type": "string"}`))
+	r = mux.SetURLVars(r, map[string]string{"name": "email"})
+	_, err = decodeDefineAttributeRequest(bg, r)
+	assert.True(t, common.IsInvalidArgument(err))
+
+	r = httptest.NewRequest("PUT", "/v1/attributes/email", strings.NewReader(`invalid-json`))
+	r = mux.SetURLVars(r, map[string]string{"name": "email"})
+	_, err = decodeDefineAttributeRequest(bg, r)
+	assert.True(t, common.IsInvalidArgument(err))
+}
+
+func Test_decodeNameRequests(t *testing.T) {
+	r := mux.SetURLVars(httptest.NewRequest("GET", "/v1/attributes/email", nil), map[string]string{"name": "email"})
+
+	req, err := decodeLoadAttributeRequest(bg, r)
+	assert.NoError(t, err)
+	assert.Equal(t, loadAttributeRequest{Name: "email"}, req)
+
+	req, err = decodeDeleteAttributeRequest(bg, r)
+	assert.NoError(t, err)
+	assert.Equal(t, deleteAttributeRequest{Name: "email"}, req)
+}
+
+func Test_MakeHandler(t *testing.T) {
+	extractor := func(string) (string, string, error) { return "", "", nil }
+	r := MakeHandler(&serviceMock{}, extractor, enforcer.NewNoOpEnforcer(), log.NewNopLogger())
+	assert.NotNil(t, r)
+}
+
+func Test_encodeError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{common.NewNotFoundError("attribute"), http.StatusNotFound},
+		{common.NewInvalidArgumentError("invalid"), http.StatusBadRequest},
+		{&enforcer.PermissionDeniedError{Reason: "denied"}, http.StatusForbidden},
+		{errors.New("simulated"), http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		encodeError(bg, c.err, w)
+		assert.Equal(t, c.status, w.Code, c.err.Error())
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,40 @@
+package bulk
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+type importRequest struct {
+	Records []Record
+	DryRun  bool
+}
+
+func makeImportEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(importRequest)
+		return s.Import(ctx, req.Records, ImportOptions{DryRun: req.DryRun})
+	}
+}
+
+type exportRequest struct {
+	Format Format
+}
+
+type exportResponse struct {
+	Format  Format
+	Records []Record
+}
+
+func makeExportEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(exportRequest)
+		records, err := s.Export(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return exportResponse{Format: req.Format, Records: records}, nil
+	}
+}
@@ -0,0 +1,295 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+// Format is the file format of user imports and exports.
+type Format string
+
+// Supported formats.
+const (
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+// Reserved CSV columns. All other columns hold user attributes.
+const (
+	columnUsername = "username"
+	columnPassword = "password"
+	columnInvite   = "invite"
+	columnGroups   = "groups"
+)
+
+// groupSeparator separates the groups of a user in CSV files.
+const groupSeparator = ";"
+
+// ParseFormat parses the name of a format ("csv", "json", "yaml" or
+// "yml") or its media type (e.g. "text/csv; charset=utf-8").
+func ParseFormat(s string) (Format, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.Index(s, ";"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+
+	switch s {
+	case "csv", "text/csv":
+		return FormatCSV, nil
+	case "json", "application/json":
+		return FormatJSON, nil
+	case "yaml", "yml", "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return FormatYAML, nil
+	}
+
+	return "", common.NewInvalidArgumentError(fmt.Sprintf("unsupported format %q", s))
+}
+
+// ContentType returns the media type of f.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatYAML:
+		return "application/yaml"
+	default:
+		return "application/json; charset=utf-8"
+	}
+}
+
+// Decode reads all records from r.
+//
+// JSON and YAML documents contain a list of records. CSV files must
+// start with a header row. The columns username, password, invite and
+// groups are reserved, all other columns are attribute keys. Groups are
+// separated by semicolons. Empty attribute cells leave the attribute
+// unset and cells starting with '[', '{' or '"' are decoded as JSON.
+// All other cells are strings.
+func Decode(f Format, r io.Reader) ([]Record, error) {
+	switch f {
+	case FormatCSV:
+		return decodeCSV(r)
+	case FormatJSON, FormatYAML:
+		blob, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if f == FormatYAML {
+			blob, err = yaml.YAMLToJSON(blob)
+			if err != nil {
+				return nil, common.NewInvalidArgumentError(err.Error())
+			}
+		}
+
+		var records []Record
+		if err := json.Unmarshal(blob, &records); err != nil {
+			return nil, common.NewInvalidArgumentError(err.Error())
+		}
+		return records, nil
+	}
+
+	return nil, common.NewInvalidArgumentError(fmt.Sprintf("unsupported format %q", f))
+}
+
+// Encode writes records to w. CSV files contain a column for each
+// attribute key used by any of the records. See Decode for more
+// information.
+func Encode(f Format, w io.Writer, records []Record) error {
+	if records == nil {
+		records = []Record{}
+	}
+
+	switch f {
+	case FormatCSV:
+		return encodeCSV(w, records)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatYAML:
+		blob, err := yaml.Marshal(records)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(blob)
+		return err
+	}
+
+	return common.NewInvalidArgumentError(fmt.Sprintf("unsupported format %q", f))
+}
+
+func decodeCSV(r io.Reader) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	hasUsername := false
+	for i, col := range header {
+		header[i] = strings.TrimSpace(col)
+		if header[i] == columnUsername {
+			hasUsername = true
+		}
+	}
+	if !hasUsername {
+		return nil, common.NewInvalidArgumentError("missing username column")
+	}
+
+	var records []Record
+	for n := 1; ; n++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, common.NewInvalidArgumentError(err.Error())
+		}
+
+		var rec Record
+		for i, cell := range row {
+			switch header[i] {
+			case columnUsername:
+				rec.Username = cell
+			case columnPassword:
+				rec.Password = cell
+			case columnInvite:
+				if cell == "" {
+					continue
+				}
+				rec.Invite, err = strconv.ParseBool(cell)
+				if err != nil {
+					return nil, common.NewInvalidArgumentError(fmt.Sprintf("row %d: invalid value for invite: %q", n, cell))
+				}
+			case columnGroups:
+				for _, grp := range strings.Split(cell, groupSeparator) {
+					if grp = strings.TrimSpace(grp); grp != "" {
+						rec.Groups = append(rec.Groups, grp)
+					}
+				}
+			default:
+				if cell == "" {
+					continue
+				}
+
+				var value interface{} = cell
+				if strings.ContainsAny(cell[:1], `[{"`) {
+					if err := json.Unmarshal([]byte(cell), &value); err != nil {
+						return nil, common.NewInvalidArgumentError(fmt.Sprintf("row %d: invalid value for %s: %s", n, header[i], err))
+					}
+				}
+
+				if rec.Attributes == nil {
+					rec.Attributes = make(map[string]interface{})
+				}
+				rec.Attributes[header[i]] = value
+			}
+		}
+
+		records = append(records, rec)
+	}
+}
+
+func encodeCSV(w io.Writer, records []Record) error {
+	keySet := make(map[string]bool)
+	hasPassword := false
+	hasInvite := false
+	for _, rec := range records {
+		for key := range rec.Attributes {
+			keySet[key] = true
+		}
+		hasPassword = hasPassword || rec.Password != ""
+		hasInvite = hasInvite || rec.Invite
+	}
+
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	header := []string{columnUsername}
+	if hasPassword {
+		header = append(header, columnPassword)
+	}
+	if hasInvite {
+		header = append(header, columnInvite)
+	}
+	header = append(header, columnGroups)
+	header = append(header, keys...)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		row := make([]string, 0, len(header))
+		row = append(row, rec.Username)
+		if hasPassword {
+			row = append(row, rec.Password)
+		}
+		if hasInvite {
+			invite := ""
+			if rec.Invite {
+				invite = "true"
+			}
+			row = append(row, invite)
+		}
+		row = append(row, strings.Join(rec.Groups, groupSeparator))
+
+		for _, key := range keys {
+			cell, err := encodeCell(rec.Attributes[key])
+			if err != nil {
+				return fmt.Errorf("%s: %s: %w", rec.Username, key, err)
+			}
+			row = append(row, cell)
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// encodeCell encodes an attribute value so decodeCSV returns an equal
+// value. Strings are written as they are unless they are empty or
+// would be decoded as JSON.
+func encodeCell(value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	if s, ok := value.(string); ok && s != "" && !strings.ContainsAny(s[:1], `[{"`) {
+		return s, nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,96 @@
+package bulk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+func TestParseFormat(t *testing.T) {
+	for input, expected := range map[string]Format{
+		"csv":                     FormatCSV,
+		"text/csv; charset=utf-8": FormatCSV,
+		"JSON":                    FormatJSON,
+		"application/json":        FormatJSON,
+		"yml":                     FormatYAML,
+		"application/x-yaml":      FormatYAML,
+	} {
+		f, err := ParseFormat(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, f, input)
+	}
+
+	_, err := ParseFormat("xml")
+	assert.True(t, common.IsInvalidArgument(err))
+}
+
+func TestDecodeCSV(t *testing.T) {
+	input := `username,password,invite,groups,email,tags,room
+alice,secret,,vets; admins,alice@example.com,"[""a"",""b""]",
+bob,,true,,bob@example.com,,12
+`
+
+	records, err := Decode(FormatCSV, strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, []Record{
+		{
+			Username: "alice",
+			Password: "secret",
+			Groups:   []string{"vets", "admins"},
+			Attributes: map[string]interface{}{
+				"email": "alice@example.com",
+				"tags":  []interface{}{"a", "b"},
+			},
+		},
+		{
+			Username: "bob",
+			Invite:   true,
+			Attributes: map[string]interface{}{
+				"email": "bob@example.com",
+				"room":  "12",
+			},
+		},
+	}, records)
+
+	_, err = Decode(FormatCSV, strings.NewReader("name\nalice\n"))
+	assert.True(t, common.IsInvalidArgument(err))
+
+	_, err = Decode(FormatCSV, strings.NewReader("username,invite\nalice,maybe\n"))
+	assert.True(t, common.IsInvalidArgument(err))
+
+	_, err = Decode(FormatCSV, strings.NewReader("username,tags\nalice,[invalid\n"))
+	assert.True(t, common.IsInvalidArgument(err))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	records := []Record{
+		{
+			Username: "alice",
+			Groups:   []string{"admins", "vets"},
+			Attributes: map[string]interface{}{
+				"email":   "alice@example.com",
+				"tags":    []interface{}{"a", "b"},
+				"note":    "[not a list]",
+				"empty":   "",
+				"address": map[string]interface{}{"city": "Vienna"},
+			},
+		},
+		{
+			Username: "bob",
+			Invite:   true,
+		},
+	}
+
+	for _, f := range []Format{FormatCSV, FormatJSON, FormatYAML} {
+		var buf bytes.Buffer
+		require.NoError(t, Encode(f, &buf, records), f)
+
+		decoded, err := Decode(f, &buf)
+		require.NoError(t, err, f)
+		assert.Equal(t, records, decoded, f)
+	}
+}
@@ -0,0 +1,173 @@
+package bulk_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/client"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iamtest"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/attribute"
+	"github.com/tierklinik-dobersberg/identity-server/services/bulk"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/invite"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+// failingGroups fails to add members to the group broken.
+type failingGroups struct {
+	group.Service
+	broken iam.GroupURN
+}
+
+func (g failingGroups) AddMember(ctx context.Context, grp iam.GroupURN, member iam.UserURN) error {
+	if grp == g.broken {
+		return errors.New("simulated")
+	}
+	return g.Service.AddMember(ctx, grp, member)
+}
+
+func TestIntegration_ImportExport(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	ctx := context.Background()
+
+	attrs := attribute.NewService(inmem.NewAttributeRepository(), nil)
+	require.NoError(t, attrs.Define(ctx, iam.AttributeDefinition{Name: "email", Type: iam.AttrTypeString, Format: iam.AttrFormatEmail, Unique: true}))
+	require.NoError(t, attrs.Define(ctx, iam.AttributeDefinition{Name: "room", Type: iam.AttrTypeInteger}))
+
+	us := user.NewValidatingService(user.NewService(inmem.NewUserRepository(), as, nil), attrs)
+	gs := group.NewService(us, inmem.NewGroupRepository(), inmem.NewMembershipRepository(), log.NewNopLogger())
+	vets, err := gs.Create(ctx, "vets", "")
+	require.NoError(t, err)
+	broken, err := gs.Create(ctx, "broken", "")
+	require.NoError(t, err)
+	_, err = gs.Create(ctx, "board", "")
+	require.NoError(t, err)
+
+	// the admin may not add users to the board group
+	authz := iamtest.AllowActions{
+		group.ActionGroupWrite: {string(vets), string(broken)},
+	}
+
+	var notifications []invite.Notification
+	is := invite.NewService(inmem.NewInvitationRepository(), us, gs, attrs, invite.NotifierFunc(func(_ context.Context, n invite.Notification) error {
+		notifications = append(notifications, n)
+		return nil
+	}), invite.Config{})
+
+	s := bulk.NewService(us, failingGroups{Service: gs, broken: broken}, attrs, is, authz)
+	srv := httptest.NewServer(bulk.MakeHandler(s, as.ExtractTokenSubject, enforcer.NewNoOpEnforcer(), log.NewNopLogger()))
+	defer srv.Close()
+
+	adminID := authnServer.AddAccount("admin", "password", false)
+	cli := client.NewIdentityClient(srv.URL,
+		client.WithTokenLoader(iamtest.StaticToken(authnServer.Token(adminID, iamtest.Audience))),
+	).Users()
+
+	input := `username,password,invite,groups,email,room
+alice,secret,,vets,alice@example.com,12
+bob,,true,vets,bob@example.com,
+carol,secret,,,alice@example.com,
+dave,secret,,,dave@example.com,twelve
+erin,secret,,unknown,erin@example.com,
+frank,secret,,vets;broken,frank@example.com,
+alice,secret,,,alice2@example.com,
+grace,secret,,board,grace@example.com,
+`
+
+	statuses := func(report client.ImportReport) []string {
+		var result []string
+		for _, row := range report.Rows {
+			result = append(result, row.Username+"="+row.Status)
+		}
+		return result
+	}
+
+	report, err := cli.ImportUsers(ctx, "csv", strings.NewReader(input), true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 5, report.Failed)
+	assert.Equal(t, []string{"alice=valid", "bob=valid", "carol=failed", "dave=failed", "erin=failed", "frank=valid", "alice=failed", "grace=failed"}, statuses(report))
+	assert.Equal(t, []client.FieldError{{Field: "room", Message: "must be an integer"}}, report.Rows[3].Fields)
+
+	// nothing has been created during the dry-run
+	users, err := us.Users(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+	assert.Empty(t, notifications)
+
+	report, err = cli.ImportUsers(ctx, "csv", strings.NewReader(input), false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Invited)
+	assert.Equal(t, []string{"alice=created", "bob=invited", "carol=failed", "dave=failed", "erin=failed", "frank=failed", "alice=failed", "grace=failed"}, statuses(report))
+
+	alice, err := us.LoadUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"email": "alice@example.com", "room": float64(12)}, alice.Attributes)
+	members, err := gs.GetMembers(ctx, vets)
+	require.NoError(t, err)
+	assert.Equal(t, []iam.UserURN{alice.ID}, members)
+
+	// invited users are created once they accept the invitation
+	bob := report.Rows[1]
+	require.Len(t, notifications, 1)
+	assert.Equal(t, bob.Invitation, notifications[0].Invitation.ID)
+	assert.Empty(t, bob.ID)
+	_, err = us.LoadUserByUsername(ctx, "bob")
+	assert.True(t, common.IsNotFound(err))
+
+	bobURN, err := is.Accept(ctx, notifications[0].Token, "bob-secret")
+	require.NoError(t, err)
+	bobUser, err := us.LoadUser(ctx, bobURN)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"email": "bob@example.com"}, bobUser.Attributes)
+	assert.True(t, authnServer.CheckPassword(bobUser.AccountID, "bob-secret"))
+	assert.False(t, authnServer.PasswordExpired(bobUser.AccountID))
+
+	// frank has been rolled back because he could not be added to
+	// all groups.
+	frank := report.Rows[5]
+	assert.True(t, frank.RolledBack)
+	_, err = us.LoadUser(ctx, frank.ID)
+	assert.Error(t, err)
+	frankID, err := strconv.Atoi(frank.ID.AccountID())
+	require.NoError(t, err)
+	account, ok := authnServer.Account(frankID)
+	require.True(t, ok)
+	assert.True(t, account.Deleted)
+	members, err = gs.GetMembers(ctx, vets)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []iam.UserURN{alice.ID, bobURN}, members)
+
+	var buf bytes.Buffer
+	require.NoError(t, cli.ExportUsers(ctx, "csv", &buf))
+	assert.Equal(t, "username,groups,email,room\nalice,vets,alice@example.com,12\nbob,vets,bob@example.com,\n", buf.String())
+
+	// exports can be imported again
+	records, err := bulk.Decode(bulk.FormatCSV, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", records[0].Username)
+	assert.Equal(t, []string{"vets"}, records[0].Groups)
+
+	// records cannot be invited if invitations are disabled
+	disabled := bulk.NewService(us, gs, attrs, nil, authz)
+	result, err := disabled.Import(ctx, []bulk.Record{{Username: "heidi", Invite: true}}, bulk.ImportOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, "invitations are disabled", result.Rows[0].Error)
+}
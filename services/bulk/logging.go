@@ -0,0 +1,49 @@
+package bulk
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type loggingService struct {
+	l log.Logger
+	Service
+}
+
+// NewLoggingService returns a service that logs method calls of Service
+func NewLoggingService(s Service, logger log.Logger) Service {
+	return &loggingService{
+		l:       logger,
+		Service: s,
+	}
+}
+
+func (s *loggingService) Import(ctx context.Context, records []Record, opts ImportOptions) (report ImportReport, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "import",
+			"records", len(records),
+			"dryRun", opts.DryRun,
+			"created", report.Created,
+			"invited", report.Invited,
+			"failed", report.Failed,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Import(ctx, records, opts)
+}
+
+func (s *loggingService) Export(ctx context.Context) (records []Record, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "export",
+			"records", len(records),
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Export(ctx)
+}
@@ -0,0 +1,422 @@
+// Package bulk imports and exports users including their attributes and
+// group memberships. It is used to onboard many users at once, e.g. when
+// a new site is set up, and supports CSV, JSON and YAML files.
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/services/attribute"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/invite"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+// Record describes a single user of an import or export.
+// swagger:model importRecord
+type Record struct {
+	// Username is the username of the user.
+	Username string `json:"username"`
+
+	// Password is the initial password of the user. It is never
+	// exported.
+	Password string `json:"password,omitempty"`
+
+	// Invite invites the user instead of creating it right away (see
+	// package invite). The user is created once the invitee accepts
+	// the invitation and chooses a password. Either Password or Invite
+	// must be set.
+	Invite bool `json:"invite,omitempty"`
+
+	// Attributes holds the user attributes.
+	Attributes map[string]interface{} `json:"attrs,omitempty"`
+
+	// Groups holds the names or URNs of the groups the user is a
+	// member of.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ImportOptions configures an import.
+type ImportOptions struct {
+	// DryRun validates all records without creating any users.
+	DryRun bool
+}
+
+// RowStatus is the result of importing a single record.
+type RowStatus string
+
+// Possible row states.
+const (
+	// RowCreated is reported for records whose user has been created.
+	RowCreated RowStatus = "created"
+
+	// RowInvited is reported for records whose user has been invited.
+	RowInvited RowStatus = "invited"
+
+	// RowValid is reported for valid records during a dry-run.
+	RowValid RowStatus = "valid"
+
+	// RowFailed is reported for invalid records and records whose user
+	// could not be created.
+	RowFailed RowStatus = "failed"
+)
+
+// RowResult is the result of importing a single record.
+// swagger:model importRowResult
+type RowResult struct {
+	// Row is the 1-based position of the record in the import. The
+	// header of CSV files is not counted.
+	Row int `json:"row"`
+
+	// Username is the username of the record.
+	Username string `json:"username"`
+
+	// Status is either created, invited, valid or failed.
+	Status RowStatus `json:"status"`
+
+	// ID is the URN of the created user.
+	ID iam.UserURN `json:"id,omitempty"`
+
+	// Invitation is the ID of the invitation of invited users.
+	Invitation string `json:"invitation,omitempty"`
+
+	// Error describes why the record failed.
+	Error string `json:"error,omitempty"`
+
+	// Fields lists invalid attributes of the record.
+	Fields []common.FieldError `json:"fields,omitempty"`
+
+	// RolledBack is set if the user had been created but was deleted
+	// again (and its authn-server account archived) because a later
+	// step failed.
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// ImportReport holds the results of an import.
+// swagger:model importReport
+type ImportReport struct {
+	// DryRun is set if no users have been created.
+	DryRun bool `json:"dryRun"`
+
+	// Created is the number of users created.
+	Created int `json:"created"`
+
+	// Invited is the number of users invited.
+	Invited int `json:"invited"`
+
+	// Failed is the number of records that failed.
+	Failed int `json:"failed"`
+
+	// Rows holds the result of each record in the order of the
+	// import.
+	Rows []RowResult `json:"rows"`
+}
+
+// Service imports and exports users.
+type Service interface {
+	// Import validates all records and creates or invites users for
+	// all valid records. Records are independent of each other: if a record
+	// fails, all changes made for it are rolled back but users created
+	// for other records are kept. Import only returns an error if the
+	// import could not be started at all. Check the report for the
+	// result of each record.
+	Import(ctx context.Context, records []Record, opts ImportOptions) (ImportReport, error)
+
+	// Export returns a record for each user sorted by username.
+	Export(ctx context.Context) ([]Record, error)
+}
+
+type service struct {
+	users   user.Service
+	groups  group.Service
+	attrs   attribute.Service
+	invites invite.Service
+	authz   enforcer.Enforcer
+}
+
+// NewService returns a new import and export service that manages users
+// and memberships using users and groups. attrs is used to validate
+// attributes during dry-runs and to convert string values of CSV files
+// to the types defined in the attribute schema. It may be nil. Records
+// with Invite set are invited using invites. If invites is nil, such
+// records are rejected. Adding users to groups requires
+// group.ActionGroupWrite on each group which is checked using authz, also
+// during dry-runs.
+func NewService(users user.Service, groups group.Service, attrs attribute.Service, invites invite.Service, authz enforcer.Enforcer) Service {
+	return &service{
+		users:   users,
+		groups:  groups,
+		attrs:   attrs,
+		invites: invites,
+		authz:   authz,
+	}
+}
+
+func (s *service) Import(ctx context.Context, records []Record, opts ImportOptions) (ImportReport, error) {
+	report := ImportReport{
+		DryRun: opts.DryRun,
+		Rows:   make([]RowResult, len(records)),
+	}
+
+	var schema iam.AttributeSchema
+	if s.attrs != nil {
+		var err error
+		if schema, err = s.attrs.Schema(ctx); err != nil {
+			return report, err
+		}
+	}
+
+	groups, err := s.groups.Get(ctx)
+	if err != nil {
+		return report, err
+	}
+	knownGroups := make(map[iam.GroupURN]bool, len(groups))
+	for _, grp := range groups {
+		knownGroups[grp.ID] = true
+	}
+
+	// usernames and values of unique attributes must not be used
+	// twice within the same import.
+	seen := make(map[string]int)
+	claim := func(what, value string, row int) error {
+		key := what + "=" + value
+		if other, ok := seen[key]; ok {
+			return common.NewInvalidArgumentError(fmt.Sprintf("%s %q is already used in row %d", what, value, other))
+		}
+		seen[key] = row
+		return nil
+	}
+
+	for i, rec := range records {
+		res := &report.Rows[i]
+		res.Row = i + 1
+		res.Username = rec.Username
+
+		err := s.importRecord(ctx, &rec, res, schema, knownGroups, claim, opts.DryRun)
+		if err != nil {
+			res.Status = RowFailed
+			res.Error = err.Error()
+			var ve *common.ValidationError
+			if errors.As(err, &ve) {
+				res.Fields = ve.Fields
+			}
+			report.Failed++
+			continue
+		}
+
+		switch {
+		case opts.DryRun:
+			res.Status = RowValid
+		case rec.Invite:
+			res.Status = RowInvited
+			report.Invited++
+		default:
+			res.Status = RowCreated
+			report.Created++
+		}
+	}
+
+	return report, nil
+}
+
+// importRecord validates rec and creates the user unless dryRun is set.
+func (s *service) importRecord(ctx context.Context, rec *Record, res *RowResult, schema iam.AttributeSchema, knownGroups map[iam.GroupURN]bool, claim func(what, value string, row int) error, dryRun bool) error {
+	if rec.Username == "" {
+		return common.NewInvalidArgumentError("username is required")
+	}
+	if err := claim("username", rec.Username, res.Row); err != nil {
+		return err
+	}
+
+	switch {
+	case rec.Password == "" && !rec.Invite:
+		return common.NewInvalidArgumentError("either password or invite is required")
+	case rec.Password != "" && rec.Invite:
+		return common.NewInvalidArgumentError("password and invite are mutually exclusive")
+	case rec.Invite && s.invites == nil:
+		return common.NewInvalidArgumentError("invitations are disabled")
+	}
+
+	groups := make([]iam.GroupURN, len(rec.Groups))
+	for i, name := range rec.Groups {
+		grp := iam.GroupURN(name)
+		if !grp.IsValid() {
+			grp = iam.GroupURN("urn:iam::group/" + name)
+		}
+		if !knownGroups[grp] {
+			return common.NewInvalidArgumentError(fmt.Sprintf("group %q does not exist", name))
+		}
+		if err := user.EnforceAttrAction(ctx, s.authz, group.ActionGroupWrite, string(grp)); err != nil {
+			return err
+		}
+		groups[i] = grp
+	}
+
+	if _, err := s.users.LoadUserByUsername(ctx, rec.Username); err == nil {
+		return common.NewConflictError("username")
+	} else if !common.IsNotFound(err) {
+		return err
+	}
+
+	attrs := coerceAttrs(schema, rec.Attributes)
+	if s.attrs != nil {
+		var err error
+		if attrs, err = s.attrs.ValidateAttrs(ctx, attrs); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range schema {
+		value, ok := attrs[d.Name]
+		if !d.Unique || !ok {
+			continue
+		}
+		str := fmt.Sprint(value)
+
+		if err := claim(d.Name, str, res.Row); err != nil {
+			return err
+		}
+
+		if _, err := s.users.LoadUserByAttr(ctx, d.Name, str); err == nil {
+			return common.NewConflictError(d.Name)
+		} else if !common.IsNotFound(err) {
+			return err
+		}
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	if rec.Invite {
+		inv, err := s.invites.Invite(ctx, rec.Username, attrs, groups)
+		if err != nil {
+			return err
+		}
+		res.Invitation = inv.ID
+		return nil
+	}
+
+	urn, err := s.users.CreateUser(ctx, rec.Username, rec.Password, attrs)
+	if err != nil {
+		return err
+	}
+	res.ID = urn
+
+	if err := s.addMemberships(ctx, urn, groups); err != nil {
+		// ctx might already be cancelled so make sure we still
+		// get the chance to roll back.
+		if rerr := s.users.PurgeUser(common.WithoutCancel(ctx), urn); rerr != nil {
+			return fmt.Errorf("%s (rollback failed: %s)", err, rerr)
+		}
+		res.RolledBack = true
+		return err
+	}
+
+	return nil
+}
+
+// addMemberships adds the user to groups. Memberships added before a
+// failure are removed again.
+func (s *service) addMemberships(ctx context.Context, urn iam.UserURN, groups []iam.GroupURN) (err error) {
+	var added []iam.GroupURN
+	defer func() {
+		if err != nil {
+			for _, grp := range added {
+				s.groups.DeleteMember(common.WithoutCancel(ctx), grp, urn)
+			}
+		}
+	}()
+
+	for _, grp := range groups {
+		if err := s.groups.AddMember(ctx, grp, urn); err != nil {
+			return err
+		}
+		added = append(added, grp)
+	}
+
+	return nil
+}
+
+func (s *service) Export(ctx context.Context) ([]Record, error) {
+	users, err := s.users.Users(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := s.groups.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	memberships := make(map[iam.UserURN][]string)
+	for _, grp := range groups {
+		members, err := s.groups.GetMembers(ctx, grp.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, urn := range members {
+			memberships[urn] = append(memberships[urn], grp.Name)
+		}
+	}
+
+	records := make([]Record, len(users))
+	for i, u := range users {
+		groups := memberships[u.ID]
+		sort.Strings(groups)
+
+		records[i] = Record{
+			Username:   u.Username,
+			Attributes: u.Attributes,
+			Groups:     groups,
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Username < records[j].Username })
+
+	return records, nil
+}
+
+// coerceAttrs converts string values of number, integer and boolean
+// attributes so CSV files don't need to carry type information. Values
+// that cannot be converted are kept and rejected by validation.
+func coerceAttrs(schema iam.AttributeSchema, attrs map[string]interface{}) map[string]interface{} {
+	if len(schema) == 0 || len(attrs) == 0 {
+		return attrs
+	}
+
+	result := make(map[string]interface{}, len(attrs))
+	for key, value := range attrs {
+		result[key] = value
+
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		d, ok := schema.Lookup(key)
+		if !ok {
+			continue
+		}
+
+		switch d.Type {
+		case iam.AttrTypeNumber, iam.AttrTypeInteger:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				result[key] = f
+			}
+		case iam.AttrTypeBoolean:
+			if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+				result[key] = b
+			}
+		}
+	}
+
+	return result
+}
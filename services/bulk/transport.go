@@ -0,0 +1,217 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+const (
+	// ActionImportUsers allows a subject to create users and add them to
+	// groups using bulk imports. Attribute write actions are still
+	// enforced by the user service and group.ActionGroupWrite is
+	// required for each group users are added to.
+	ActionImportUsers = "iam:user:import"
+
+	// ActionExportUsers allows a subject to export all users including
+	// their group memberships.
+	ActionExportUsers = "iam:user:export"
+)
+
+// MaxImportSize is the maximum size of an import request body in bytes.
+const MaxImportSize = 10 << 20
+
+// MakeHandler returns a http.Handler serving /v1/users/import and
+// /v1/users/export. Both paths must be routed to the handler before
+// the user management API.
+func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer.Enforcer, logger log.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+	}
+
+	makeEndpoint := func(action string, factory func(Service) endpoint.Endpoint) endpoint.Endpoint {
+		return endpoint.Chain(
+			authn.NewAuthenticator(extractor),
+			enforcer.NewActionEndpoint(action),
+			enforcer.NewEnforcedEndpoint(authz),
+		)(factory(s))
+	}
+
+	importHandler := kithttp.NewServer(
+		makeEndpoint(ActionImportUsers, makeImportEndpoint),
+		decodeImportRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	exportHandler := kithttp.NewServer(
+		makeEndpoint(ActionExportUsers, makeExportEndpoint),
+		decodeExportRequest,
+		encodeExportResponse,
+		opts...,
+	)
+
+	r := mux.NewRouter()
+
+	// swagger:route POST /v1/users/import users importUsers
+	//
+	// Creates users from a CSV, JSON or YAML file. The format is taken
+	// from the format query parameter or the Content-Type header. Each
+	// record is imported on its own and the result of each record is
+	// reported. Changes made for failed records, including the
+	// authn-server account, are rolled back.
+	//
+	//	Consumes:
+	//	- text/csv
+	//	- application/json
+	//	- application/yaml
+	//
+	//	Produces:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Parameters:
+	//	+	in: query
+	//		name: format
+	//		description: One of csv, json or yaml.
+	//	+	in: query
+	//		name: dryRun
+	//		type: boolean
+	//		description: Only validate the records.
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		200: importReport
+	r.Handle("/v1/users/import", importHandler).Methods("POST")
+
+	// swagger:route GET /v1/users/export users exportUsers
+	//
+	// Exports all users including their attributes and group
+	// memberships in a format accepted by importUsers.
+	//
+	//	Produces:
+	//	- application/json
+	//	- text/csv
+	//	- application/yaml
+	//
+	//	Schemes: http, https
+	//
+	//	Parameters:
+	//	+	in: query
+	//		name: format
+	//		description: One of csv, json (default) or yaml.
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		200: []importRecord
+	r.Handle("/v1/users/export", exportHandler).Methods("GET")
+
+	return r
+}
+
+func decodeImportRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var (
+		req importRequest
+		q   = r.URL.Query()
+	)
+
+	format := q.Get("format")
+	if format == "" {
+		format = r.Header.Get("Content-Type")
+	}
+	f, err := ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := q.Get("dryRun"); v != "" {
+		req.DryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, common.NewInvalidArgumentError("invalid value for dryRun")
+		}
+	}
+
+	req.Records, err = Decode(f, http.MaxBytesReader(nil, r.Body, MaxImportSize))
+	if err != nil {
+		if common.IsInvalidArgument(err) {
+			return nil, err
+		}
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	return req, nil
+}
+
+func decodeExportRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := exportRequest{Format: FormatJSON}
+
+	if v := r.URL.Query().Get("format"); v != "" {
+		f, err := ParseFormat(v)
+		if err != nil {
+			return nil, err
+		}
+		req.Format = f
+	}
+
+	return req, nil
+}
+
+func encodeExportResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	res := response.(exportResponse)
+
+	var buf bytes.Buffer
+	if err := Encode(res.Format, &buf, res.Records); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", res.Format.ContentType())
+	w.WriteHeader(http.StatusOK)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// encodeError encodes err as a JSON object and maps typed errors (e.g.
+// from pkg/common, authn and enforcer) to their HTTP status code.
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var h kithttp.Headerer
+	if errors.As(err, &h) {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
+	var body interface{} = map[string]interface{}{
+		"error": err.Error(),
+	}
+
+	var sc kithttp.StatusCoder
+	if errors.As(err, &sc) {
+		w.WriteHeader(sc.StatusCode())
+		if m, ok := sc.(json.Marshaler); ok {
+			body = m
+		}
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(body)
+}
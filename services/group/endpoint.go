@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
 
@@ -64,6 +65,14 @@ type loadGroupResponse struct {
 	iam.Group
 }
 
+// Headers implements the go-kit http.Headerer interface and
+// returns the ETag of the group.
+func (r loadGroupResponse) Headers() http.Header {
+	h := make(http.Header)
+	h.Set("ETag", common.ETag(r.Version))
+	return h
+}
+
 func makeLoadGroupEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(loadGroupRequest)
@@ -99,7 +108,9 @@ func makeGetGroupMembersEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
-type getGroupsRequest struct{}
+type getGroupsRequest struct {
+	Query iam.ListQuery
+}
 
 // A list of all groups stored and managed by IAM.
 // swagger:model groupList
@@ -110,12 +121,12 @@ type getGroupsResponse struct {
 
 func makeGetGroupsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		_ = request.(getGroupsRequest)
+		req := request.(getGroupsRequest)
 		grps, err := s.Get(ctx)
 		if err != nil {
 			return nil, err
 		}
-		return getGroupsResponse{Groups: grps}, nil
+		return getGroupsResponse{Groups: req.Query.Groups(grps)}, nil
 	}
 }
 
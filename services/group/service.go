@@ -3,9 +3,11 @@ package group
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/mutex"
 	"github.com/tierklinik-dobersberg/identity-server/services/user"
@@ -22,6 +24,9 @@ type Service interface {
 	Create(ctx context.Context, groupName string, groupComment string) (iam.GroupURN, error)
 
 	// Delete an existing account group and cancel the membership of all users.
+	// Like UpdateComment, Delete returns common.PreconditionFailedError if
+	// the context requires a version other than the current one (see
+	// iam.WithIfMatch).
 	Delete(ctx context.Context, urn iam.GroupURN) error
 
 	// Load loads an existing account from the repository optionally including
@@ -94,6 +99,7 @@ func (s *service) Create(ctx context.Context, groupName string, groupComment str
 		Name:    groupName,
 		Comment: groupComment,
 	}
+	touch(ctx, &grp.Metadata)
 
 	if err := s.groups.Store(ctx, grp); err != nil {
 		return "", err
@@ -112,6 +118,15 @@ func (s *service) Delete(ctx context.Context, urn iam.GroupURN) error {
 	}
 	defer s.l.Unlock()
 
+	grp, err := s.groups.Load(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	if err := iam.CheckVersion(ctx, "group", grp.Metadata); err != nil {
+		return err
+	}
+
 	members, err := s.members.Members(ctx, urn)
 	if err != nil {
 		return err
@@ -165,7 +180,12 @@ func (s *service) UpdateComment(ctx context.Context, urn iam.GroupURN, comment s
 		return err
 	}
 
+	if err := iam.CheckVersion(ctx, "group", grp.Metadata); err != nil {
+		return err
+	}
+
 	grp.Comment = comment
+	touch(ctx, &grp.Metadata)
 
 	return s.groups.Store(ctx, grp)
 }
@@ -248,3 +268,10 @@ func (s *service) userDeleted(user iam.UserURN) {
 		}
 	}
 }
+
+// touch records the actor of ctx as the last actor of m. Changes made
+// while impersonating a user are attributed to the impersonator.
+func touch(ctx context.Context, m *iam.Metadata) {
+	actor, _ := enforcer.Actor(ctx)
+	m.Touch(actor, time.Now())
+}
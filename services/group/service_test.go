@@ -4,17 +4,19 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/tierklinik-dobersberg/identity-server/mocks"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 	"github.com/tierklinik-dobersberg/identity-server/services/user"
 )
 
-var testCtx = context.Background()
+var testCtx = enforcer.WithSubject(context.Background(), "urn:iam::user/1")
 
 func TestService_Get(t *testing.T) {
 	s := setupTestBed()
@@ -55,7 +57,7 @@ func TestService_Create(t *testing.T) {
 			Comment: "some-comment",
 		}
 
-		s.groups.On("Store", expectedGroup).Once().Return(errors.New("simulated error"))
+		s.groups.On("Store", touched(expectedGroup)).Once().Return(errors.New("simulated error"))
 
 		urn, err := s.Create(testCtx, "devs", "some-comment")
 		assert.Equal(t, iam.GroupURN(""), urn)
@@ -72,7 +74,7 @@ func TestService_Create(t *testing.T) {
 			Comment: "some-comment",
 		}
 
-		s.groups.On("Store", expectedGroup).Once().Return(nil)
+		s.groups.On("Store", touched(expectedGroup)).Once().Return(nil)
 
 		urn, err := s.Create(testCtx, "devs", "some-comment")
 		assert.Equal(t, iam.GroupURN("urn:iam::group/devs"), urn)
@@ -89,10 +91,21 @@ func TestService_Delete(t *testing.T) {
 		assert.Equal(t, ErrInvalidParameter, s.Delete(testCtx, ""))
 	})
 
+	t.Run("Version mismatch", func(t *testing.T) {
+		t.Parallel()
+		s := setupTestBed()
+
+		s.groups.On("Load", iam.GroupURN("urn:iam::group/devs")).Once().Return(iam.Group{ID: "urn:iam::group/devs", Metadata: iam.Metadata{Version: 2}}, nil)
+		err := s.Delete(iam.WithIfMatch(testCtx, 1), "urn:iam::group/devs")
+		assert.True(t, common.IsPreconditionFailed(err))
+		s.AssertExpectations(t)
+	})
+
 	t.Run("Members() failed", func(t *testing.T) {
 		t.Parallel()
 		s := setupTestBed()
 
+		s.groups.On("Load", iam.GroupURN("urn:iam::group/devs")).Once().Return(iam.Group{ID: "urn:iam::group/devs"}, nil)
 		s.members.On("Members", iam.GroupURN("urn:iam::group/devs")).Once().Return([]iam.UserURN(nil), errors.New("simulated"))
 		err := s.Delete(testCtx, "urn:iam::group/devs")
 		assert.Error(t, err)
@@ -106,6 +119,7 @@ func TestService_Delete(t *testing.T) {
 			t.Parallel()
 			s := setupTestBed()
 
+			s.groups.On("Load", iam.GroupURN("urn:iam::group/devs")).Once().Return(iam.Group{ID: "urn:iam::group/devs"}, nil)
 			s.members.On("Members", iam.GroupURN("urn:iam::group/devs")).Once().Return([]iam.UserURN{
 				"urn:iam::user/10",
 				"urn:iam::user/22",
@@ -124,6 +138,7 @@ func TestService_Delete(t *testing.T) {
 			t.Parallel()
 			s := setupTestBed()
 
+			s.groups.On("Load", iam.GroupURN("urn:iam::group/devs")).Once().Return(iam.Group{ID: "urn:iam::group/devs"}, nil)
 			s.members.On("Members", iam.GroupURN("urn:iam::group/devs")).Once().Return([]iam.UserURN{
 				"urn:iam::user/10",
 				"urn:iam::user/22",
@@ -202,6 +217,24 @@ func TestService_UpdateComment(t *testing.T) {
 		s.AssertExpectations(t)
 	})
 
+	t.Run("Version mismatch", func(t *testing.T) {
+		t.Parallel()
+		s := setupTestBed()
+
+		existingGroup := iam.Group{
+			ID:       "urn:iam::group/devs",
+			Name:     "devs",
+			Comment:  "old comment",
+			Metadata: iam.Metadata{Version: 2},
+		}
+
+		s.groups.On("Load", iam.GroupURN("urn:iam::group/devs")).Once().Return(existingGroup, nil)
+
+		err := s.UpdateComment(iam.WithIfMatch(testCtx, 1), "urn:iam::group/devs", "new comment")
+		assert.True(t, common.IsPreconditionFailed(err))
+		s.AssertExpectations(t)
+	})
+
 	t.Run("Store fails", func(t *testing.T) {
 		t.Parallel()
 		s := setupTestBed()
@@ -215,7 +248,7 @@ func TestService_UpdateComment(t *testing.T) {
 		expectedGroup.Comment = "new comment"
 
 		s.groups.On("Load", iam.GroupURN("urn:iam::group/devs")).Once().Return(existingGroup, nil)
-		s.groups.On("Store", expectedGroup).Once().Return(errors.New("simulated"))
+		s.groups.On("Store", touched(expectedGroup)).Once().Return(errors.New("simulated"))
 
 		err := s.UpdateComment(testCtx, "urn:iam::group/devs", "new comment")
 		assert.Error(t, err)
@@ -235,7 +268,7 @@ func TestService_UpdateComment(t *testing.T) {
 		expectedGroup.Comment = "new comment"
 
 		s.groups.On("Load", iam.GroupURN("urn:iam::group/devs")).Once().Return(existingGroup, nil)
-		s.groups.On("Store", expectedGroup).Once().Return(nil)
+		s.groups.On("Store", touched(expectedGroup)).Once().Return(nil)
 
 		err := s.UpdateComment(testCtx, "urn:iam::group/devs", "new comment")
 		assert.NoError(t, err)
@@ -376,6 +409,21 @@ type testBed struct {
 	users    *userServiceMock
 }
 
+// touched matches g after the service recorded an update by the
+// subject of testCtx. The update time is ignored.
+func touched(g iam.Group) interface{} {
+	return mock.MatchedBy(func(got iam.Group) bool {
+		if got.UpdatedAt.IsZero() || got.UpdatedBy != "urn:iam::user/1" {
+			return false
+		}
+		if g.CreatedAt.IsZero() {
+			g.CreatedAt, g.CreatedBy = got.CreatedAt, got.CreatedBy
+		}
+		g.UpdatedAt, g.UpdatedBy = got.UpdatedAt, got.UpdatedBy
+		return g == got
+	})
+}
+
 func setupTestBed() *testBed {
 	var fn user.OnDeleteFunc
 	us := &userServiceMock{}
@@ -417,19 +465,68 @@ func (s *userServiceMock) LoadUser(_ context.Context, urn iam.UserURN) (iam.User
 	return args.Get(0).(iam.User), args.Error(1)
 }
 
+func (s *userServiceMock) LoadUserByUsername(_ context.Context, username string) (iam.User, error) {
+	args := s.Called(username)
+	return args.Get(0).(iam.User), args.Error(1)
+}
+
+func (s *userServiceMock) LoadUserByAttr(_ context.Context, key, value string) (iam.User, error) {
+	args := s.Called(key, value)
+	return args.Get(0).(iam.User), args.Error(1)
+}
+
 func (s *userServiceMock) DeleteUser(_ context.Context, urn iam.UserURN) error {
 	return s.Called(urn).Error(0)
 }
 
+func (s *userServiceMock) RestoreUser(_ context.Context, urn iam.UserURN) error {
+	return s.Called(urn).Error(0)
+}
+
+func (s *userServiceMock) PurgeUser(_ context.Context, urn iam.UserURN) error {
+	return s.Called(urn).Error(0)
+}
+
+func (s *userServiceMock) PurgeDeletedUsers(_ context.Context, t time.Time) ([]iam.UserURN, error) {
+	args := s.Called(t)
+	res, _ := args.Get(0).([]iam.UserURN)
+	return res, args.Error(1)
+}
+
 func (s *userServiceMock) LockUser(_ context.Context, urn iam.UserURN, locked bool) error {
 	return s.Called(urn, locked).Error(0)
 }
 
+func (s *userServiceMock) RevokeTokens(_ context.Context, urn iam.UserURN) error {
+	return s.Called(urn).Error(0)
+}
+
+func (s *userServiceMock) RevokeToken(_ context.Context, tokenID string) error {
+	return s.Called(tokenID).Error(0)
+}
+
+func (s *userServiceMock) SetUsername(_ context.Context, urn iam.UserURN, username string) error {
+	return s.Called(urn, username).Error(0)
+}
+
+func (s *userServiceMock) SetPassword(_ context.Context, urn iam.UserURN, password string) error {
+	return s.Called(urn, password).Error(0)
+}
+
+func (s *userServiceMock) ExpirePassword(_ context.Context, urn iam.UserURN) error {
+	return s.Called(urn).Error(0)
+}
+
 func (s *userServiceMock) Users(_ context.Context) ([]iam.User, error) {
 	args := s.Called()
 	return args.Get(0).([]iam.User), args.Error(1)
 }
 
+func (s *userServiceMock) QueryUsers(_ context.Context, q iam.UserQuery) (iam.UserPage, error) {
+	args := s.Called(q)
+	return args.Get(0).(iam.UserPage), args.Error(1)
+}
+
 func (s *userServiceMock) UpdateAttrs(_ context.Context, id iam.UserURN, attrs map[string]interface{}) error {
 	return s.Called(id, attrs).Error(0)
 }
@@ -442,6 +539,20 @@ func (s *userServiceMock) DeleteAttr(_ context.Context, urn iam.UserURN, key str
 	return s.Called(urn, key).Error(0)
 }
 
+func (s *userServiceMock) PatchAttrs(_ context.Context, urn iam.UserURN, patch iam.AttrPatch) error {
+	return s.Called(urn, patch).Error(0)
+}
+
+func (s *userServiceMock) Reconcile(_ context.Context, opts user.ReconcileOptions) (user.ReconcileReport, error) {
+	args := s.Called(opts)
+	return args.Get(0).(user.ReconcileReport), args.Error(1)
+}
+
+func (s *userServiceMock) ProvisionUser(_ context.Context, accountID int, username string, attrs map[string]interface{}, sync bool) (iam.User, bool, error) {
+	args := s.Called(accountID, username, attrs, sync)
+	return args.Get(0).(iam.User), args.Bool(1), args.Error(2)
+}
+
 func (s *userServiceMock) OnDelete(_ context.Context, fn user.OnDeleteFunc) {
 	s.Called(fn)
 }
@@ -3,8 +3,10 @@ package group
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
@@ -29,8 +31,9 @@ const (
 func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer.Enforcer, logger log.Logger) http.Handler {
 	opts := []kithttp.ServerOption{
 		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
-		kithttp.ServerErrorEncoder(kithttp.DefaultErrorEncoder),
+		kithttp.ServerErrorEncoder(encodeError),
 		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+		kithttp.ServerBefore(ifMatchToContext),
 	}
 
 	makeEndpoint := func(action string, factory func(Service) endpoint.Endpoint) endpoint.Endpoint {
@@ -101,7 +104,11 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 
 	// swagger:route GET /v1/groups/ groups listGroups
 	//
-	// List all groups stored and managed by IAM.
+	// List all groups stored and managed by IAM. Groups are sorted by
+	// name, createdAt or updatedAt (sort) and can be filtered by the
+	// creating and last updating subject (createdBy, updatedBy) and by
+	// time (createdAfter, createdBefore, updatedAfter, updatedBefore;
+	// RFC 3339).
 	//
 	//	Produces:
 	//	- application/json
@@ -138,6 +145,8 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//
 	// Get a specific group.
 	//
+	// The ETag header holds the version of the group. See If-Match.
+	//
 	// 	Produces:
 	//	- application/json
 	//
@@ -168,10 +177,14 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//		description: The ID of the group.
 	//	+	in: body
 	//		type: updateGroupCommentRequest
+	//	+	in: header
+	//		name: If-Match
+	//		description: Only apply the change if the ETag of the group matches.
 	//
 	//	Responses:
 	//		default: body:genericError
 	//		201: description: Group updated successfully.
+	//		412: description: The group has been modified.
 	r.Handle("/v1/groups/{id}", updateCommentHandler).Methods("PUT", "PATCH")
 
 	// swagger:route DELETE /v1/groups/{id} groups deleteGroup
@@ -187,10 +200,14 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//	+	in: path
 	//		name: id
 	//		description: The ID of the group to delete.
+	//	+	in: header
+	//		name: If-Match
+	//		description: Only apply the change if the ETag of the group matches.
 	//
 	//	Responses:
 	//		default: body:genericError
 	//		201: description: Group deleted successfully.
+	//		412: description: The group has been modified.
 	r.Handle("/v1/groups/{id}", deleteGroupHandler).Methods("DELETE")
 
 	// swagger:route GET /v1/groups/{id}/members/ groups getGroupMembers
@@ -260,7 +277,22 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 }
 
 func decodeGetGroupsRequest(ctx context.Context, req *http.Request) (interface{}, error) {
-	return getGroupsRequest{}, nil
+	values := req.URL.Query()
+
+	f, err := iam.ParseMetadataFilter(values)
+	if err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	q := iam.ListQuery{
+		MetadataFilter: f,
+		SortBy:         iam.SortField(values.Get("sort")),
+	}
+	if err := q.Validate(); err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	return getGroupsRequest{Query: q}, nil
 }
 
 func decodeCreateGroupRequest(ctx context.Context, req *http.Request) (interface{}, error) {
@@ -359,3 +391,50 @@ func getUserURN(r *http.Request, key string) (iam.UserURN, error) {
 	urn := iam.UserURN(fmt.Sprintf("urn:iam::user/%s", id))
 	return urn, nil
 }
+
+// ifMatchToContext makes updates conditional if r has an If-Match
+// header (see iam.WithIfMatch).
+func ifMatchToContext(ctx context.Context, r *http.Request) context.Context {
+	if versions, ok := common.ParseIfMatch(r.Header.Get("If-Match")); ok {
+		return iam.WithIfMatch(ctx, versions...)
+	}
+	return ctx
+}
+
+// encodeError encodes err as a JSON object and maps typed errors (e.g.
+// from pkg/common, authn and enforcer) to their HTTP status code.
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var h kithttp.Headerer
+	if errors.As(err, &h) {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
+	var body interface{} = map[string]interface{}{
+		"error": err.Error(),
+	}
+
+	var sc kithttp.StatusCoder
+	switch {
+	case err == os.ErrNotExist:
+		w.WriteHeader(http.StatusNotFound)
+		body = map[string]interface{}{"error": "resource not found"}
+	case err == os.ErrExist:
+		w.WriteHeader(http.StatusConflict)
+		body = map[string]interface{}{"error": "resource exists"}
+	case errors.As(err, &sc):
+		w.WriteHeader(sc.StatusCode())
+		if m, ok := sc.(json.Marshaler); ok {
+			body = m
+		}
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(body)
+}
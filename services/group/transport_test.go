@@ -4,11 +4,14 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
 
 func Test_decodeGetGroupsRequest(t *testing.T) {
@@ -16,6 +19,27 @@ func Test_decodeGetGroupsRequest(t *testing.T) {
 	res, err := decodeGetGroupsRequest(testCtx, r)
 	assert.NoError(t, err)
 	assert.Equal(t, getGroupsRequest{}, res)
+
+	r = httptest.NewRequest("GET", "/v1/groups/?sort=createdAt&createdBy=urn:iam::user/1&updatedAfter=2020-01-02T15:04:05Z", nil)
+	res, err = decodeGetGroupsRequest(testCtx, r)
+	assert.NoError(t, err)
+	assert.Equal(t, getGroupsRequest{
+		Query: iam.ListQuery{
+			MetadataFilter: iam.MetadataFilter{
+				CreatedBy:    "urn:iam::user/1",
+				UpdatedAfter: time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC),
+			},
+			SortBy: iam.SortByCreatedAt,
+		},
+	}, res)
+
+	r = httptest.NewRequest("GET", "/v1/groups/?sort=unknown", nil)
+	_, err = decodeGetGroupsRequest(testCtx, r)
+	assert.True(t, common.IsInvalidArgument(err))
+
+	r = httptest.NewRequest("GET", "/v1/groups/?createdAfter=yesterday", nil)
+	_, err = decodeGetGroupsRequest(testCtx, r)
+	assert.True(t, common.IsInvalidArgument(err))
 }
 
 func Test_decodeDecodeCreateGroupRequest(t *testing.T) {
@@ -132,7 +156,7 @@ func Test_decodeDeleteMemberRequest(t *testing.T) {
 
 func Test_MakeHandler(t *testing.T) {
 	s := &mockService{}
-	extractor := func(string) (string, error) { return "", nil }
+	extractor := func(string) (string, string, error) { return "", "", nil }
 	_ = MakeHandler(s, extractor, enforcer.NewNoOpEnforcer(), log.NewNopLogger())
 }
 
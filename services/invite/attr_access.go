@@ -0,0 +1,91 @@
+package invite
+
+import (
+	"context"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+type attrAccessService struct {
+	authz enforcer.Enforcer
+	Service
+}
+
+// NewAttrAccessService returns a service that enforces the per-attribute
+// actions of user.NewAttrAccessService for invitations. Creating an
+// invitation requires write access to all of its attributes and
+// group.ActionGroupWrite on all of its groups as the invitee becomes a
+// member once the invitation is accepted. Attributes the subject is not
+// allowed to read are removed from returned invitations. The resource of all attribute actions is empty
+// as invited users do not exist yet. Calls without a subject are not
+// restricted.
+func NewAttrAccessService(s Service, authz enforcer.Enforcer) Service {
+	if _, ok := authz.(*enforcer.ScopedEnforcer); !ok {
+		authz = enforcer.NewScopedEnforcer(authz, nil)
+	}
+
+	return &attrAccessService{
+		authz:   authz,
+		Service: s,
+	}
+}
+
+// filter removes all attributes from inv the subject is not allowed to
+// read.
+func (s *attrAccessService) filter(ctx context.Context, inv iam.Invitation) iam.Invitation {
+	if len(inv.Attributes) == 0 {
+		return inv
+	}
+
+	attrs := make(map[string]interface{}, len(inv.Attributes))
+	for key, value := range inv.Attributes {
+		if user.EnforceAttrAction(ctx, s.authz, user.ActionReadAttr(key), "") == nil {
+			attrs[key] = value
+		}
+	}
+	inv.Attributes = attrs
+
+	return inv
+}
+
+func (s *attrAccessService) Invite(ctx context.Context, username string, attrs map[string]interface{}, groups []iam.GroupURN) (iam.Invitation, error) {
+	for key := range attrs {
+		if err := user.EnforceAttrAction(ctx, s.authz, user.ActionWriteAttr(key), ""); err != nil {
+			return iam.Invitation{}, err
+		}
+	}
+	for _, grp := range groups {
+		if err := user.EnforceAttrAction(ctx, s.authz, group.ActionGroupWrite, string(grp)); err != nil {
+			return iam.Invitation{}, err
+		}
+	}
+
+	inv, err := s.Service.Invite(ctx, username, attrs, groups)
+	if err != nil {
+		return inv, err
+	}
+	return s.filter(ctx, inv), nil
+}
+
+func (s *attrAccessService) Invitations(ctx context.Context, state State) ([]iam.Invitation, error) {
+	invitations, err := s.Service.Invitations(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, inv := range invitations {
+		invitations[i] = s.filter(ctx, inv)
+	}
+	return invitations, nil
+}
+
+func (s *attrAccessService) Load(ctx context.Context, id string) (iam.Invitation, error) {
+	inv, err := s.Service.Load(ctx, id)
+	if err != nil {
+		return inv, err
+	}
+	return s.filter(ctx, inv), nil
+}
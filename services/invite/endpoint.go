@@ -0,0 +1,131 @@
+package invite
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// Invites a new user.
+// swagger:model inviteRequest
+type inviteRequest struct {
+	// Username is the username of the invited user.
+	// Required: true
+	Username string `json:"username"`
+
+	// Attributes holds the attributes of the invited user.
+	Attributes map[string]interface{} `json:"attrs,omitempty"`
+
+	// Groups holds the URNs of the groups the invited user is added
+	// to.
+	Groups []iam.GroupURN `json:"groups,omitempty"`
+}
+
+type inviteResponse struct {
+	iam.Invitation
+}
+
+func (inviteResponse) StatusCode() int { return http.StatusCreated }
+
+func makeInviteEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(inviteRequest)
+		inv, err := s.Invite(ctx, req.Username, req.Attributes, req.Groups)
+		if err != nil {
+			return nil, err
+		}
+
+		return inviteResponse{inv}, nil
+	}
+}
+
+type listInvitationsRequest struct {
+	State State
+}
+
+// A list of invitations.
+// swagger:model invitationList
+type listInvitationsResponse struct {
+	// Invitations holds all invitations sorted by username.
+	Invitations []iam.Invitation `json:"invitations"`
+}
+
+func makeListInvitationsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listInvitationsRequest)
+		invitations, err := s.Invitations(ctx, req.State)
+		if err != nil {
+			return nil, err
+		}
+
+		if invitations == nil {
+			invitations = []iam.Invitation{}
+		}
+		return listInvitationsResponse{invitations}, nil
+	}
+}
+
+type loadInvitationRequest struct {
+	ID string
+}
+
+func makeLoadInvitationEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(loadInvitationRequest)
+		return s.Load(ctx, req.ID)
+	}
+}
+
+type revokeInvitationRequest struct {
+	ID string
+}
+
+type revokeInvitationResponse struct{}
+
+func (revokeInvitationResponse) StatusCode() int { return http.StatusNoContent }
+
+func makeRevokeInvitationEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(revokeInvitationRequest)
+		if err := s.Revoke(ctx, req.ID); err != nil {
+			return nil, err
+		}
+
+		return revokeInvitationResponse{}, nil
+	}
+}
+
+// Accepts an invitation.
+// swagger:model acceptInvitationRequest
+type acceptInvitationRequest struct {
+	// Token is the invite token sent to the invitee.
+	// Required: true
+	Token string `json:"token"`
+
+	// Password is the password of the new user.
+	// Required: true
+	Password string `json:"password"`
+}
+
+// Response used in a successful call to acceptInvitation
+// swagger:model acceptInvitationResponse
+type acceptInvitationResponse struct {
+	// URN is the URN of the new user.
+	URN iam.UserURN `json:"urn"`
+}
+
+func (acceptInvitationResponse) StatusCode() int { return http.StatusCreated }
+
+func makeAcceptInvitationEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(acceptInvitationRequest)
+		urn, err := s.Accept(ctx, req.Token, req.Password)
+		if err != nil {
+			return nil, err
+		}
+
+		return acceptInvitationResponse{URN: urn}, nil
+	}
+}
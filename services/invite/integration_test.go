@@ -0,0 +1,244 @@
+package invite_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/client"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iamtest"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/attribute"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/invite"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+// notifications records all notifications sent.
+type notifications []invite.Notification
+
+func (n *notifications) Notify(_ context.Context, notification invite.Notification) error {
+	*n = append(*n, notification)
+	return nil
+}
+
+// denyGroup denies writing group and the actions of DenyActions.
+type denyGroup struct {
+	iamtest.DenyActions
+	group iam.GroupURN
+}
+
+func (d denyGroup) Enforce(ctx context.Context, subject, action, resource string, policyContext enforcer.Context) error {
+	if action == group.ActionGroupWrite && resource == string(d.group) {
+		return &enforcer.PermissionDeniedError{Reason: "denied"}
+	}
+	return d.DenyActions.Enforce(ctx, subject, action, resource, policyContext)
+}
+
+func TestIntegration_Invitations(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	ctx := context.Background()
+
+	attrs := attribute.NewService(inmem.NewAttributeRepository(), nil)
+	require.NoError(t, attrs.Define(ctx, iam.AttributeDefinition{Name: "email", Type: iam.AttrTypeString, Format: iam.AttrFormatEmail, Unique: true}))
+	require.NoError(t, attrs.Define(ctx, iam.AttributeDefinition{Name: "salary", Type: iam.AttrTypeString}))
+
+	us := user.NewValidatingService(user.NewService(inmem.NewUserRepository(), as, nil), attrs)
+	gs := group.NewService(us, inmem.NewGroupRepository(), inmem.NewMembershipRepository(), log.NewNopLogger())
+	vets, err := gs.Create(ctx, "vets", "")
+	require.NoError(t, err)
+	board, err := gs.Create(ctx, "board", "")
+	require.NoError(t, err)
+
+	authz := denyGroup{
+		group: board,
+		DenyActions: iamtest.DenyActions{
+			user.ActionReadAttr("salary"):  true,
+			user.ActionWriteAttr("salary"): true,
+		},
+	}
+
+	repo := inmem.NewInvitationRepository()
+	var sent notifications
+	s := invite.NewService(repo, us, gs, attrs, &sent, invite.Config{AcceptURL: "https://example.com/accept?lang=de"})
+
+	srv := httptest.NewServer(invite.MakeHandler(invite.NewAttrAccessService(s, authz), as.ExtractTokenSubject, authz, log.NewNopLogger()))
+	defer srv.Close()
+
+	adminID := authnServer.AddAccount("admin", "password", false)
+	admin := fmt.Sprintf("urn:iam::user/%d", adminID)
+	cli := client.NewIdentityClient(srv.URL,
+		client.WithTokenLoader(iamtest.StaticToken(authnServer.Token(adminID, iamtest.Audience))),
+	).Invitations()
+
+	_, err = us.CreateUser(ctx, "alice", "secret", map[string]interface{}{"email": "alice@example.com"})
+	require.NoError(t, err)
+
+	start := time.Now()
+	inv, err := cli.Invite(ctx, "bob", map[string]interface{}{"email": "bob@example.com"}, []iam.GroupURN{vets})
+	require.NoError(t, err)
+	assert.Equal(t, "bob", inv.Username)
+	assert.Equal(t, admin, inv.CreatedBy)
+	assert.True(t, inv.ExpiresAt.After(start.Add(invite.DefaultTTL-time.Minute)))
+
+	// the token is only sent to the invitee
+	require.Len(t, sent, 1)
+	token := sent[0].Token
+	assert.Equal(t, inv.ID, sent[0].Invitation.ID)
+	assert.NotContains(t, inv.ID, token)
+	assert.Equal(t, "https://example.com/accept?lang=de&token="+token, sent[0].URL)
+
+	loaded, err := cli.Load(ctx, inv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, inv.ID, loaded.ID)
+	assert.Equal(t, map[string]interface{}{"email": "bob@example.com"}, loaded.Attributes)
+
+	// usernames and unique attributes must not be used by users or
+	// pending invitations.
+	cases := []struct {
+		username string
+		attrs    map[string]interface{}
+		groups   []iam.GroupURN
+	}{
+		{"bob", nil, nil},
+		{"alice", nil, nil},
+		{"carol", map[string]interface{}{"email": "alice@example.com"}, nil},
+		{"carol", map[string]interface{}{"email": "bob@example.com"}, nil},
+		{"carol", map[string]interface{}{"email": "not-an-email"}, nil},
+		{"carol", map[string]interface{}{"salary": "A3"}, nil},
+		{"carol", nil, []iam.GroupURN{"urn:iam::group/unknown"}},
+		{"carol", nil, []iam.GroupURN{vets, board}},
+		{"", nil, nil},
+	}
+	for i, c := range cases {
+		_, err := cli.Invite(ctx, c.username, c.attrs, c.groups)
+		assert.Error(t, err, "case %d", i)
+	}
+	assert.Len(t, sent, 1)
+
+	// invitations are not created if the invitee cannot be notified
+	failing := invite.NewService(repo, us, gs, attrs, invite.NotifierFunc(func(context.Context, invite.Notification) error {
+		return errors.New("simulated")
+	}), invite.Config{})
+	_, err = failing.Invite(ctx, "carol", nil, nil)
+	assert.Error(t, err)
+
+	// invitations of the expiring service expire right away
+	expiring := invite.NewService(repo, us, gs, attrs, &sent, invite.Config{TTL: time.Nanosecond})
+	_, err = expiring.Invite(ctx, "carol", nil, nil)
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+	time.Sleep(time.Millisecond)
+
+	pending, err := cli.Invitations(ctx, "pending")
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "bob", pending[0].Username)
+
+	expired, err := cli.Invitations(ctx, "expired")
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, "carol", expired[0].Username)
+
+	all, err := cli.Invitations(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	_, err = cli.Invitations(ctx, "accepted")
+	assert.Error(t, err)
+
+	// expired invitations cannot be accepted but are replaced by new
+	// ones.
+	_, err = s.Accept(ctx, sent[1].Token, "secret")
+	assert.Equal(t, invite.ErrExpired, err)
+
+	carol, err := cli.Invite(ctx, "carol", map[string]interface{}{"email": "carol@example.com"}, nil)
+	require.NoError(t, err)
+	require.Len(t, sent, 3)
+	_, err = cli.Load(ctx, expired[0].ID)
+	assert.Error(t, err)
+
+	require.NoError(t, cli.Revoke(ctx, carol.ID))
+	assert.Error(t, cli.Revoke(ctx, carol.ID))
+	_, err = s.Accept(ctx, sent[2].Token, "secret")
+	assert.True(t, common.IsNotFound(err))
+
+	// invitees accept their invitation without an access token
+	anonymous := client.NewIdentityClient(srv.URL, client.WithTokenLoader(nil)).Invitations()
+	_, err = anonymous.Invitations(ctx, "")
+	assert.Error(t, err)
+	_, err = anonymous.Accept(ctx, "wrong-token", "bob-secret")
+	assert.Error(t, err)
+
+	bob, err := anonymous.Accept(ctx, token, "bob-secret")
+	require.NoError(t, err)
+
+	u, err := us.LoadUser(ctx, bob)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", u.Username)
+	assert.Equal(t, map[string]interface{}{"email": "bob@example.com"}, u.Attributes)
+	assert.Equal(t, admin, u.CreatedBy)
+	assert.True(t, authnServer.CheckPassword(u.AccountID, "bob-secret"))
+
+	members, err := gs.GetMembers(ctx, vets)
+	require.NoError(t, err)
+	assert.Equal(t, []iam.UserURN{bob}, members)
+
+	// invite tokens can only be used once
+	_, err = anonymous.Accept(ctx, token, "bob-secret")
+	assert.Error(t, err)
+	all, err = cli.Invitations(ctx, "")
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestFileNotifier(t *testing.T) {
+	f, err := ioutil.TempFile("", "invitations-*.jsonl")
+	require.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	notifier := invite.NewFileNotifier(f.Name())
+	ctx := context.Background()
+
+	require.NoError(t, notifier.Notify(ctx, invite.Notification{Invitation: iam.Invitation{ID: "1", Username: "alice"}, Token: "a"}))
+	require.NoError(t, notifier.Notify(ctx, invite.Notification{Invitation: iam.Invitation{ID: "2", Username: "bob"}, Token: "b"}))
+
+	blob, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(blob)), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"token":"a"`)
+	assert.Contains(t, lines[1], `"username":"bob"`)
+}
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	notifier := invite.NewLogNotifier(log.NewLogfmtLogger(&buf))
+
+	require.NoError(t, notifier.Notify(context.Background(), invite.Notification{
+		Invitation: iam.Invitation{ID: "1", Username: "alice"},
+		Token:      "secret-token",
+		URL:        "https://example.com/accept?token=secret-token",
+	}))
+
+	assert.Contains(t, buf.String(), "username=alice")
+	assert.NotContains(t, buf.String(), "secret-token")
+}
@@ -0,0 +1,86 @@
+package invite
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+type loggingService struct {
+	l log.Logger
+	Service
+}
+
+// NewLoggingService returns a service that logs method calls of Service.
+// Invite tokens and passwords are never logged.
+func NewLoggingService(s Service, logger log.Logger) Service {
+	return &loggingService{
+		l:       logger,
+		Service: s,
+	}
+}
+
+func (s *loggingService) Invite(ctx context.Context, username string, attrs map[string]interface{}, groups []iam.GroupURN) (inv iam.Invitation, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "invite",
+			"username", username,
+			"id", inv.ID,
+			"groups", len(groups),
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Invite(ctx, username, attrs, groups)
+}
+
+func (s *loggingService) Invitations(ctx context.Context, state State) (invitations []iam.Invitation, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "invitations",
+			"state", state,
+			"invitations", len(invitations),
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Invitations(ctx, state)
+}
+
+func (s *loggingService) Load(ctx context.Context, id string) (inv iam.Invitation, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "load_invitation",
+			"id", id,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Load(ctx, id)
+}
+
+func (s *loggingService) Revoke(ctx context.Context, id string) (err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "revoke_invitation",
+			"id", id,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Revoke(ctx, id)
+}
+
+func (s *loggingService) Accept(ctx context.Context, token, password string) (urn iam.UserURN, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "accept_invitation",
+			"urn", urn,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Accept(ctx, token, password)
+}
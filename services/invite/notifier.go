@@ -0,0 +1,90 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// Notification is sent to the invitee of a new invitation.
+type Notification struct {
+	// Invitation is the new invitation. The address of the invitee is
+	// usually one of its attributes.
+	Invitation iam.Invitation `json:"invitation"`
+
+	// Token is the secret invite token required to accept the
+	// invitation.
+	Token string `json:"token"`
+
+	// URL is the URL of the page the invitee uses to accept the
+	// invitation including the token. It is empty if Config.AcceptURL
+	// is not set.
+	URL string `json:"url,omitempty"`
+}
+
+// Notifier delivers invite tokens to invitees, e.g. by email. If Notify
+// returns an error the invitation is not created.
+type Notifier interface {
+	// Notify delivers n to the invitee.
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NotifierFunc is a function that implements Notifier.
+type NotifierFunc func(ctx context.Context, n Notification) error
+
+// Notify implements Notifier.
+func (fn NotifierFunc) Notify(ctx context.Context, n Notification) error {
+	return fn(ctx, n)
+}
+
+// NewLogNotifier returns a Notifier that logs notifications to logger.
+// The invite token and URL are secret and never logged so invitees are
+// not actually notified. It is meant for testing and development only.
+func NewLogNotifier(logger log.Logger) Notifier {
+	return NotifierFunc(func(_ context.Context, n Notification) error {
+		return logger.Log(
+			"msg", "invitation created",
+			"id", n.Invitation.ID,
+			"username", n.Invitation.Username,
+			"expires", n.Invitation.ExpiresAt,
+		)
+	})
+}
+
+type fileNotifier struct {
+	l    sync.Mutex
+	path string
+}
+
+// NewFileNotifier returns a Notifier that appends notifications
+// including the invite token as JSON lines to the file at path. It is
+// meant for testing and development only.
+func NewFileNotifier(path string) Notifier {
+	return &fileNotifier{path: path}
+}
+
+func (f *fileNotifier) Notify(_ context.Context, n Notification) error {
+	blob, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(blob, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
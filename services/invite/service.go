@@ -0,0 +1,398 @@
+// Package invite invites users to identity-server. Instead of choosing
+// an initial password for a new user, an administrator creates an
+// invitation holding the username, attributes and groups of a pending
+// user. The invitee receives a secret, single-use token through a
+// Notifier and accepts the invitation by choosing a password. Only then
+// the authn-server account and the IAM user are created.
+package invite
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/mutex"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+// DefaultTTL is the default time after which invitations expire.
+const DefaultTTL = 72 * time.Hour
+
+// ErrInvalidArgument is returned when an invalid argument is passed to
+// a Service method.
+var ErrInvalidArgument = common.NewInvalidArgumentError("invalid argument")
+
+// ErrExpired is returned when an expired invitation is accepted.
+var ErrExpired = common.NewInvalidArgumentError("invitation has expired")
+
+// State selects invitations by whether they can still be accepted.
+type State string
+
+// Possible invitation states.
+const (
+	// StatePending selects invitations that have not expired yet.
+	StatePending State = "pending"
+
+	// StateExpired selects invitations that have expired.
+	StateExpired State = "expired"
+)
+
+// Config configures the invitation service.
+type Config struct {
+	// TTL is the time after which invitations expire. Defaults to
+	// DefaultTTL.
+	TTL time.Duration
+
+	// AcceptURL is the URL of the page invitees use to accept their
+	// invitation. If set, the invite token is added as the token query
+	// parameter and passed to the Notifier as Notification.URL.
+	AcceptURL string
+}
+
+// Service manages invitations.
+type Service interface {
+	// Invite creates an invitation for a user with the given username,
+	// attributes and groups and sends the invite token to the invitee
+	// using the Notifier. The username and the values of unique
+	// attributes must neither be used by an existing user nor by
+	// another pending invitation. An expired invitation for the same
+	// username is replaced.
+	Invite(ctx context.Context, username string, attrs map[string]interface{}, groups []iam.GroupURN) (iam.Invitation, error)
+
+	// Invitations returns all invitations in state sorted by username.
+	// If state is empty, all invitations are returned.
+	Invitations(ctx context.Context, state State) ([]iam.Invitation, error)
+
+	// Load returns the invitation id.
+	Load(ctx context.Context, id string) (iam.Invitation, error)
+
+	// Revoke deletes the invitation id so it cannot be accepted
+	// anymore.
+	Revoke(ctx context.Context, id string) error
+
+	// Accept creates the user of the invitation identified by token
+	// with the given password and deletes the invitation. It returns
+	// common.NotFoundError if there is no invitation for token and
+	// ErrExpired if it has expired. Groups that have been deleted in
+	// the meantime are skipped. The user is created on behalf of the
+	// subject that created the invitation.
+	Accept(ctx context.Context, token, password string) (iam.UserURN, error)
+}
+
+type service struct {
+	m        *mutex.Mutex
+	repo     iam.InvitationRepository
+	users    user.Service
+	groups   group.Service
+	attrs    user.AttributeValidator
+	notifier Notifier
+	cfg      Config
+}
+
+// NewService returns a new invitation service that creates users and
+// memberships using users and groups. attrs is used to validate the
+// attributes of new invitations and to find unique attributes. It may
+// be nil. Invite tokens are delivered using notifier.
+func NewService(repo iam.InvitationRepository, users user.Service, groups group.Service, attrs user.AttributeValidator, notifier Notifier, cfg Config) Service {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+
+	return &service{
+		m:        mutex.New(),
+		repo:     repo,
+		users:    users,
+		groups:   groups,
+		attrs:    attrs,
+		notifier: notifier,
+		cfg:      cfg,
+	}
+}
+
+func (s *service) Invite(ctx context.Context, username string, attrs map[string]interface{}, groups []iam.GroupURN) (iam.Invitation, error) {
+	if username == "" {
+		return iam.Invitation{}, ErrInvalidArgument
+	}
+
+	for _, grp := range groups {
+		if _, err := s.groups.Load(ctx, grp); err != nil {
+			if common.IsNotFound(err) {
+				return iam.Invitation{}, common.NewInvalidArgumentError(fmt.Sprintf("group %q does not exist", grp))
+			}
+			return iam.Invitation{}, err
+		}
+	}
+
+	var unique []string
+	if s.attrs != nil {
+		var err error
+		if attrs, err = s.attrs.ValidateAttrs(ctx, attrs); err != nil {
+			return iam.Invitation{}, err
+		}
+		if unique, err = s.attrs.UniqueAttrs(ctx); err != nil {
+			return iam.Invitation{}, err
+		}
+	}
+
+	if !s.m.TryLock(ctx) {
+		return iam.Invitation{}, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	if _, err := s.users.LoadUserByUsername(ctx, username); err == nil {
+		return iam.Invitation{}, common.NewConflictError("username")
+	} else if !common.IsNotFound(err) {
+		return iam.Invitation{}, err
+	}
+
+	invitations, err := s.repo.Get(ctx)
+	if err != nil {
+		return iam.Invitation{}, err
+	}
+
+	now := time.Now()
+
+	var replaced string
+	for _, other := range invitations {
+		if other.Username != username {
+			continue
+		}
+		if !other.IsExpired(now) {
+			return iam.Invitation{}, common.NewConflictError("username")
+		}
+		replaced = other.ID
+	}
+
+	for _, key := range unique {
+		value, ok := attrs[key]
+		if !ok {
+			continue
+		}
+		str := fmt.Sprint(value)
+
+		if _, err := s.users.LoadUserByAttr(ctx, key, str); err == nil {
+			return iam.Invitation{}, common.NewConflictError(key)
+		} else if !common.IsNotFound(err) {
+			return iam.Invitation{}, err
+		}
+
+		for _, other := range invitations {
+			if other.IsExpired(now) {
+				continue
+			}
+			if v, ok := other.Attributes[key]; ok && fmt.Sprint(v) == str {
+				return iam.Invitation{}, common.NewConflictError(key)
+			}
+		}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return iam.Invitation{}, err
+	}
+
+	// invitations created while impersonating a user are attributed to
+	// the impersonator.
+	actor, _ := enforcer.Actor(ctx)
+	inv := iam.Invitation{
+		ID:         invitationID(token),
+		Username:   username,
+		Attributes: attrs,
+		Groups:     groups,
+		CreatedAt:  now.UTC(),
+		CreatedBy:  actor,
+		ExpiresAt:  now.Add(s.cfg.TTL).UTC(),
+	}
+
+	if err := s.repo.Store(ctx, inv); err != nil {
+		return iam.Invitation{}, err
+	}
+
+	if err := s.notifier.Notify(ctx, Notification{
+		Invitation: inv,
+		Token:      token,
+		URL:        s.acceptURL(token),
+	}); err != nil {
+		// the invitee cannot accept the invitation without
+		// the token.
+		s.repo.Delete(common.WithoutCancel(ctx), inv.ID)
+		return iam.Invitation{}, err
+	}
+
+	if replaced != "" {
+		if err := s.repo.Delete(ctx, replaced); err != nil && !common.IsNotFound(err) {
+			return iam.Invitation{}, err
+		}
+	}
+
+	return inv, nil
+}
+
+// acceptURL returns the URL invitees use to accept their invitation or
+// an empty string if none is configured.
+func (s *service) acceptURL(token string) string {
+	if s.cfg.AcceptURL == "" {
+		return ""
+	}
+
+	u, err := url.Parse(s.cfg.AcceptURL)
+	if err != nil {
+		return ""
+	}
+
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func (s *service) Invitations(ctx context.Context, state State) ([]iam.Invitation, error) {
+	switch state {
+	case "", StatePending, StateExpired:
+	default:
+		return nil, common.NewInvalidArgumentError(fmt.Sprintf("unsupported state %q", state))
+	}
+
+	if !s.m.TryLock(ctx) {
+		return nil, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	all, err := s.repo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if state == "" {
+		return all, nil
+	}
+
+	now := time.Now()
+	invitations := all[:0]
+	for _, inv := range all {
+		if inv.IsExpired(now) == (state == StateExpired) {
+			invitations = append(invitations, inv)
+		}
+	}
+
+	return invitations, nil
+}
+
+func (s *service) Load(ctx context.Context, id string) (iam.Invitation, error) {
+	if id == "" {
+		return iam.Invitation{}, ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return iam.Invitation{}, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	return s.repo.Load(ctx, id)
+}
+
+func (s *service) Revoke(ctx context.Context, id string) error {
+	if id == "" {
+		return ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	return s.repo.Delete(ctx, id)
+}
+
+func (s *service) Accept(ctx context.Context, token, password string) (iam.UserURN, error) {
+	if token == "" || password == "" {
+		return "", ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return "", ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	inv, err := s.repo.Load(ctx, invitationID(token))
+	if err != nil {
+		return "", err
+	}
+
+	if inv.IsExpired(time.Now()) {
+		return "", ErrExpired
+	}
+
+	if inv.CreatedBy != "" {
+		ctx = enforcer.WithSubject(ctx, inv.CreatedBy)
+	}
+
+	urn, err := s.users.CreateUser(ctx, inv.Username, password, inv.Attributes)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.finishUser(ctx, urn, inv); err != nil {
+		// ctx might already be cancelled so make sure we still
+		// get the chance to roll back.
+		if rerr := s.users.PurgeUser(common.WithoutCancel(ctx), urn); rerr != nil {
+			return "", fmt.Errorf("%s (rollback failed: %s)", err, rerr)
+		}
+		return "", err
+	}
+
+	return urn, nil
+}
+
+// finishUser adds the user to the groups of inv and deletes inv so it
+// cannot be accepted again. Memberships added before a failure are
+// removed again.
+func (s *service) finishUser(ctx context.Context, urn iam.UserURN, inv iam.Invitation) (err error) {
+	var added []iam.GroupURN
+	defer func() {
+		if err != nil {
+			for _, grp := range added {
+				s.groups.DeleteMember(common.WithoutCancel(ctx), grp, urn)
+			}
+		}
+	}()
+
+	for _, grp := range inv.Groups {
+		if err := s.groups.AddMember(ctx, grp, urn); err != nil {
+			if common.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		added = append(added, grp)
+	}
+
+	return s.repo.Delete(ctx, inv.ID)
+}
+
+// newToken returns a new random invite token.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// invitationID returns the ID of the invitation for token. Only the
+// hash of a token is stored so invitations cannot be accepted by
+// someone who can read the repository.
+func invitationID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}
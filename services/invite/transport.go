@@ -0,0 +1,241 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+const (
+	// ActionInviteUser allows a subject to invite users. Attribute
+	// write actions are enforced as well (see NewAttrAccessService).
+	ActionInviteUser = "iam:user:invite"
+
+	// ActionReadInvitations allows a subject to list and read
+	// invitations.
+	ActionReadInvitations = "iam:user:read-invitations"
+
+	// ActionRevokeInvitation allows a subject to revoke invitations.
+	ActionRevokeInvitation = "iam:user:revoke-invitation"
+)
+
+// MakeHandler returns a http.Handler serving /v1/invitations/. All
+// endpoints except the one to accept an invitation require
+// authentication.
+func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer.Enforcer, logger log.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+	}
+
+	makeEndpoint := func(action string, factory func(Service) endpoint.Endpoint) endpoint.Endpoint {
+		return endpoint.Chain(
+			authn.NewAuthenticator(extractor),
+			enforcer.NewActionEndpoint(action),
+			enforcer.NewEnforcedEndpoint(authz),
+		)(factory(s))
+	}
+
+	inviteHandler := kithttp.NewServer(
+		makeEndpoint(ActionInviteUser, makeInviteEndpoint),
+		decodeInviteRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	listInvitationsHandler := kithttp.NewServer(
+		makeEndpoint(ActionReadInvitations, makeListInvitationsEndpoint),
+		decodeListInvitationsRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	loadInvitationHandler := kithttp.NewServer(
+		makeEndpoint(ActionReadInvitations, makeLoadInvitationEndpoint),
+		decodeLoadInvitationRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	revokeInvitationHandler := kithttp.NewServer(
+		makeEndpoint(ActionRevokeInvitation, makeRevokeInvitationEndpoint),
+		decodeRevokeInvitationRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	// invitees don't have an account yet so the invite token is the
+	// only credential required.
+	acceptInvitationHandler := kithttp.NewServer(
+		makeAcceptInvitationEndpoint(s),
+		decodeAcceptInvitationRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	r := mux.NewRouter()
+
+	// swagger:route POST /v1/invitations/ invitations inviteUser
+	//
+	// Invites a new user. The invite token is delivered to the invitee
+	// and never returned to the caller. The user is created once the
+	// invitee accepts the invitation.
+	//
+	//	Produces:
+	//	- application/json
+	//
+	//	Consumes:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Parameters:
+	//	+	in: body
+	//		type: inviteRequest
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		201: Invitation
+	r.Handle("/v1/invitations/", inviteHandler).Methods("POST")
+
+	// swagger:route GET /v1/invitations/ invitations listInvitations
+	//
+	// Returns all invitations sorted by username.
+	//
+	//	Produces:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Parameters:
+	//	+	in: query
+	//		name: state
+	//		description: Only return pending or expired invitations.
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		200: invitationList
+	r.Handle("/v1/invitations/", listInvitationsHandler).Methods("GET")
+
+	// swagger:route POST /v1/invitations/accept invitations acceptInvitation
+	//
+	// Accepts an invitation and creates the invited user with the
+	// given password. The invite token cannot be used again. This
+	// endpoint does not require authentication.
+	//
+	//	Produces:
+	//	- application/json
+	//
+	//	Consumes:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Parameters:
+	//	+	in: body
+	//		type: acceptInvitationRequest
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		201: acceptInvitationResponse
+	r.Handle("/v1/invitations/accept", acceptInvitationHandler).Methods("POST")
+
+	// swagger:route GET /v1/invitations/{id} invitations getInvitation
+	//
+	// Returns a single invitation.
+	//
+	//	Produces:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		200: Invitation
+	r.Handle("/v1/invitations/{id}", loadInvitationHandler).Methods("GET")
+
+	// swagger:route DELETE /v1/invitations/{id} invitations revokeInvitation
+	//
+	// Revokes a pending or expired invitation.
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		204: description: The invitation has been revoked.
+	r.Handle("/v1/invitations/{id}", revokeInvitationHandler).Methods("DELETE")
+
+	return r
+}
+
+func decodeInviteRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req inviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	return req, nil
+}
+
+func decodeListInvitationsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return listInvitationsRequest{State: State(r.URL.Query().Get("state"))}, nil
+}
+
+func decodeLoadInvitationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return loadInvitationRequest{ID: mux.Vars(r)["id"]}, nil
+}
+
+func decodeRevokeInvitationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return revokeInvitationRequest{ID: mux.Vars(r)["id"]}, nil
+}
+
+func decodeAcceptInvitationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req acceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	return req, nil
+}
+
+// encodeError encodes err as a JSON object and maps typed errors (e.g.
+// from pkg/common, authn and enforcer) to their HTTP status code.
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var h kithttp.Headerer
+	if errors.As(err, &h) {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
+	var body interface{} = map[string]interface{}{
+		"error": err.Error(),
+	}
+
+	var sc kithttp.StatusCoder
+	if errors.As(err, &sc) {
+		w.WriteHeader(sc.StatusCode())
+		if m, ok := sc.(json.Marshaler); ok {
+			body = m
+		}
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(body)
+}
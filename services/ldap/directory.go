@@ -0,0 +1,304 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// Organizational units below the base DN that hold users and groups.
+const (
+	UsersOU  = "users"
+	GroupsOU = "groups"
+)
+
+// entry is a single entry of the directory tree.
+type entry struct {
+	dn    string
+	rdns  []string
+	attrs []attribute
+}
+
+type attribute struct {
+	name   string
+	values []string
+}
+
+// get returns the values of the attribute name. Attribute names are
+// case insensitive.
+func (e *entry) get(name string) ([]string, bool) {
+	for _, a := range e.attrs {
+		if strings.EqualFold(a.name, name) {
+			return a.values, true
+		}
+	}
+	return nil, false
+}
+
+func (e *entry) add(name string, values ...string) {
+	if len(values) == 0 {
+		return
+	}
+	e.attrs = append(e.attrs, attribute{name: name, values: values})
+}
+
+// normalizeDN parses dn and returns its RDNs in a normalized, lower
+// case form starting with the leftmost RDN.
+func normalizeDN(dn string) ([]string, error) {
+	if strings.TrimSpace(dn) == "" {
+		return nil, nil
+	}
+
+	parsed, err := goldap.ParseDN(dn)
+	if err != nil {
+		return nil, err
+	}
+
+	rdns := make([]string, len(parsed.RDNs))
+	for i, rdn := range parsed.RDNs {
+		parts := make([]string, len(rdn.Attributes))
+		for j, a := range rdn.Attributes {
+			parts[j] = strings.ToLower(a.Type) + "=" + strings.ToLower(a.Value)
+		}
+		sort.Strings(parts)
+		rdns[i] = strings.Join(parts, "+")
+	}
+
+	return rdns, nil
+}
+
+// hasSuffix reports whether rdns ends with suffix.
+func hasSuffix(rdns, suffix []string) bool {
+	if len(rdns) < len(suffix) {
+		return false
+	}
+
+	offset := len(rdns) - len(suffix)
+	for i := range suffix {
+		if rdns[offset+i] != suffix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalDN(a, b []string) bool {
+	return len(a) == len(b) && hasSuffix(a, b)
+}
+
+// escapeRDN escapes value for use in a relative distinguished name as
+// defined in RFC 4514.
+func escapeRDN(value string) string {
+	var b strings.Builder
+
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			(i == 0 && (r == ' ' || r == '#')),
+			(i == len(value)-1 && r == ' '):
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func (s *Server) userDN(username string) string {
+	return fmt.Sprintf("uid=%s,ou=%s,%s", escapeRDN(username), UsersOU, s.cfg.BaseDN)
+}
+
+func (s *Server) groupDN(name string) string {
+	return fmt.Sprintf("cn=%s,ou=%s,%s", escapeRDN(name), GroupsOU, s.cfg.BaseDN)
+}
+
+func (s *Server) newEntry(dn string) *entry {
+	rdns, err := normalizeDN(dn)
+	if err != nil {
+		// all DNs are built using escapeRDN so this is a bug.
+		panic(fmt.Sprintf("invalid DN %q: %s", dn, err))
+	}
+	return &entry{dn: dn, rdns: rdns}
+}
+
+// load builds the directory tree from the repositories. Users and
+// groups are only included if withUsers or withGroups is set. User
+// attributes are only included if readAttr returns true.
+func (s *Server) load(ctx context.Context, withUsers, withGroups bool, readAttr func(iam.User, string) bool) ([]*entry, error) {
+	root := s.newEntry(s.cfg.BaseDN)
+	root.add("objectClass", "top", baseObjectClass(s.base))
+	for _, a := range s.base.RDNs[0].Attributes {
+		root.add(a.Type, a.Value)
+	}
+
+	usersOU := s.newEntry(fmt.Sprintf("ou=%s,%s", UsersOU, s.cfg.BaseDN))
+	usersOU.add("objectClass", "top", "organizationalUnit")
+	usersOU.add("ou", UsersOU)
+
+	groupsOU := s.newEntry(fmt.Sprintf("ou=%s,%s", GroupsOU, s.cfg.BaseDN))
+	groupsOU.add("objectClass", "top", "organizationalUnit")
+	groupsOU.add("ou", GroupsOU)
+
+	entries := []*entry{root, usersOU, groupsOU}
+
+	if !withUsers && !withGroups {
+		return entries, nil
+	}
+
+	all, err := s.users.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// soft-deleted users are hidden, including from group members.
+	users := make([]iam.User, 0, len(all))
+	for _, u := range all {
+		if u.DeletedAt == nil {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].AccountID < users[j].AccountID })
+
+	usernames := make(map[iam.UserURN]string, len(users))
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+	}
+
+	if withUsers {
+		for _, u := range users {
+			groups, err := s.members.Memberships(ctx, u.ID)
+			if err != nil && !common.IsNotFound(err) {
+				return nil, err
+			}
+
+			entries = append(entries, s.userEntry(u, groups, readAttr))
+		}
+	}
+
+	if withGroups {
+		groups, err := s.groups.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+		for _, g := range groups {
+			members, err := s.members.Members(ctx, g.ID)
+			if err != nil && !common.IsNotFound(err) {
+				return nil, err
+			}
+
+			e := s.newEntry(s.groupDN(g.Name))
+			e.add("objectClass", "top", "groupOfNames")
+			e.add("cn", g.Name)
+			if g.Comment != "" {
+				e.add("description", g.Comment)
+			}
+
+			var dns, uids []string
+			for _, m := range members {
+				if username, ok := usernames[m]; ok {
+					dns = append(dns, s.userDN(username))
+					uids = append(uids, username)
+				}
+			}
+			sort.Strings(dns)
+			sort.Strings(uids)
+			e.add("member", dns...)
+			e.add("memberUid", uids...)
+
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *Server) userEntry(u iam.User, groups []iam.GroupURN, readAttr func(iam.User, string) bool) *entry {
+	e := s.newEntry(s.userDN(u.Username))
+	e.add("objectClass", "top", "person", "organizationalPerson", "inetOrgPerson")
+	e.add("uid", u.Username)
+	e.add("cn", u.Username)
+	e.add("sn", u.Username)
+	e.add("uidNumber", strconv.Itoa(u.AccountID))
+
+	if email, ok := u.Attributes["email"].(string); ok && email != "" && readAttr(u, "email") {
+		e.add("mail", email)
+	}
+
+	var names []string
+	for key := range u.Attributes {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	for _, key := range names {
+		// attributes that collide with the ones above or cannot be
+		// used as LDAP attribute descriptions are not exposed.
+		if _, ok := e.get(key); ok || key == "email" || !isAttrDescription(key) || !readAttr(u, key) {
+			continue
+		}
+		e.add(key, attrValues(u.Attributes[key])...)
+	}
+
+	var dns []string
+	for _, grp := range groups {
+		dns = append(dns, s.groupDN(grp.GroupName()))
+	}
+	sort.Strings(dns)
+	e.add("memberOf", dns...)
+
+	return e
+}
+
+// baseObjectClass returns the structural object class of the base
+// entry.
+func baseObjectClass(base *goldap.DN) string {
+	switch strings.ToLower(base.RDNs[0].Attributes[0].Type) {
+	case "o":
+		return "organization"
+	case "ou":
+		return "organizationalUnit"
+	default:
+		return "domain"
+	}
+}
+
+func isAttrDescription(s string) bool {
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// attrValues converts the value of a user attribute to LDAP attribute
+// values. Objects cannot be represented and are skipped.
+func attrValues(v interface{}) []string {
+	switch val := v.(type) {
+	case nil, map[string]interface{}:
+		return nil
+	case string:
+		return []string{val}
+	case []string:
+		return val
+	case []interface{}:
+		var values []string
+		for _, elem := range val {
+			values = append(values, attrValues(elem)...)
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(val)}
+	}
+}
@@ -0,0 +1,176 @@
+package ldap
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+var errInvalidFilter = errors.New("invalid filter")
+
+// dnAttributes are compared as distinguished names.
+var dnAttributes = map[string]bool{
+	"member":   true,
+	"memberof": true,
+}
+
+// packetString returns the string value of p.
+func packetString(p *ber.Packet) string {
+	if s, ok := p.Value.(string); ok {
+		return s
+	}
+	if p.Data != nil {
+		return p.Data.String()
+	}
+	return ""
+}
+
+// match evaluates the search filter f (RFC 4511 section 4.5.1.7) on e.
+// Extensible matches are not supported and never match.
+func match(f *ber.Packet, e *entry) (bool, error) {
+	if f.ClassType != ber.ClassContext {
+		return false, errInvalidFilter
+	}
+
+	switch f.Tag {
+	case goldap.FilterAnd:
+		for _, child := range f.Children {
+			ok, err := match(child, e)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case goldap.FilterOr:
+		for _, child := range f.Children {
+			ok, err := match(child, e)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case goldap.FilterNot:
+		if len(f.Children) != 1 {
+			return false, errInvalidFilter
+		}
+		ok, err := match(f.Children[0], e)
+		return !ok, err
+
+	case goldap.FilterPresent:
+		name := packetString(f)
+		if strings.EqualFold(name, "objectClass") {
+			return true, nil
+		}
+		_, ok := e.get(name)
+		return ok, nil
+
+	case goldap.FilterEqualityMatch, goldap.FilterApproxMatch,
+		goldap.FilterGreaterOrEqual, goldap.FilterLessOrEqual:
+		if len(f.Children) != 2 {
+			return false, errInvalidFilter
+		}
+
+		name := packetString(f.Children[0])
+		want := packetString(f.Children[1])
+		values, _ := e.get(name)
+
+		for _, v := range values {
+			if compareValues(name, v, want, uint64(f.Tag)) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case goldap.FilterSubstrings:
+		if len(f.Children) != 2 {
+			return false, errInvalidFilter
+		}
+
+		values, _ := e.get(packetString(f.Children[0]))
+		for _, v := range values {
+			if matchSubstrings(strings.ToLower(v), f.Children[1].Children) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case goldap.FilterExtensibleMatch:
+		return false, nil
+	}
+
+	return false, errInvalidFilter
+}
+
+// compareValues compares the attribute value v with want. Values are
+// compared case insensitive, integers numerically and DNs by their
+// normalized form.
+func compareValues(attr, v, want string, op uint64) bool {
+	if dnAttributes[strings.ToLower(attr)] {
+		a, err1 := normalizeDN(v)
+		b, err2 := normalizeDN(want)
+		return err1 == nil && err2 == nil && equalDN(a, b) &&
+			(op == goldap.FilterEqualityMatch || op == goldap.FilterApproxMatch)
+	}
+
+	cmp := strings.Compare(strings.ToLower(v), strings.ToLower(want))
+	if a, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if b, err := strconv.ParseInt(want, 10, 64); err == nil {
+			switch {
+			case a < b:
+				cmp = -1
+			case a > b:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+
+	switch op {
+	case goldap.FilterGreaterOrEqual:
+		return cmp >= 0
+	case goldap.FilterLessOrEqual:
+		return cmp <= 0
+	default:
+		return cmp == 0
+	}
+}
+
+func matchSubstrings(v string, subs []*ber.Packet) bool {
+	for i, sub := range subs {
+		s := strings.ToLower(packetString(sub))
+
+		switch sub.Tag {
+		case goldap.FilterSubstringsInitial:
+			if i != 0 || !strings.HasPrefix(v, s) {
+				return false
+			}
+			v = v[len(s):]
+
+		case goldap.FilterSubstringsAny:
+			idx := strings.Index(v, s)
+			if idx < 0 {
+				return false
+			}
+			v = v[idx+len(s):]
+
+		case goldap.FilterSubstringsFinal:
+			if i != len(subs)-1 || !strings.HasSuffix(v, s) {
+				return false
+			}
+
+		default:
+			return false
+		}
+	}
+
+	return true
+}
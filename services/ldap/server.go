@@ -0,0 +1,572 @@
+// Package ldap provides a read-only LDAP (RFC 4511) frontend for
+// legacy devices and applications that can only authenticate users and
+// look up groups using LDAP. Users and groups are exposed below the
+// configured base DN:
+//
+//	dc=iam
+//	├── ou=users
+//	│   └── uid=alice,ou=users,dc=iam
+//	└── ou=groups
+//	    └── cn=vets,ou=groups,dc=iam
+//
+// Users have the object class inetOrgPerson and list the groups they
+// belong to in memberOf. Groups have the object class groupOfNames and
+// list their members in member and memberUid.
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+// DefaultBaseDN is the default value for Config.BaseDN.
+const DefaultBaseDN = "dc=iam"
+
+// DefaultIdleTimeout is the default value for Config.IdleTimeout.
+const DefaultIdleTimeout = 5 * time.Minute
+
+// DefaultMaxMessageSize is the default value for Config.MaxMessageSize.
+const DefaultMaxMessageSize = 1 << 20
+
+// DefaultMaxConnections is the default value for Config.MaxConnections.
+const DefaultMaxConnections = 100
+
+// PasswordVerifier verifies the password of a user and returns the
+// account ID. It must return an error wrapping authn.ErrInvalidCredentials
+// if the credentials are invalid. authn.Service implements
+// PasswordVerifier using the login endpoint of authn-server.
+type PasswordVerifier interface {
+	VerifyPassword(ctx context.Context, username, password string) (int, error)
+}
+
+// Config configures the LDAP frontend.
+type Config struct {
+	// BaseDN is the DN of the root entry of the directory tree.
+	BaseDN string
+
+	// AllowAnonymous allows searches without a prior bind. Anonymous
+	// searches are not subject to policies.
+	AllowAnonymous bool
+
+	// IdleTimeout is the time after which idle connections are closed.
+	IdleTimeout time.Duration
+
+	// MaxMessageSize is the maximum size of a request in bytes.
+	// Connections sending larger requests are closed.
+	MaxMessageSize int64
+
+	// MaxConnections is the maximum number of concurrent connections.
+	// Further connections are closed right away.
+	MaxConnections int
+}
+
+// Server serves the users and groups of IAM using LDAP. Bound users
+// only see users and groups if they are allowed to perform
+// user.ActionListUsers and group.ActionGroupRead respectively.
+type Server struct {
+	cfg      Config
+	base     *goldap.DN
+	baseRDNs []string
+	users    iam.UserRepository
+	groups   iam.GroupRepository
+	members  iam.MembershipRepository
+	verifier PasswordVerifier
+	authz    enforcer.Enforcer
+	log      log.Logger
+
+	l         sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+}
+
+// NewServer returns a new LDAP server. Bind requests are verified
+// using verifier and searches of bound users are authorized using
+// authz.
+//
+// The BER decoder allocates the buffer for a value before reading it,
+// so NewServer lowers the global ber.MaxPacketLengthBytes to
+// cfg.MaxMessageSize. Otherwise unauthenticated clients could make the
+// server allocate up to 2 GiB per connection.
+func NewServer(cfg Config, users iam.UserRepository, groups iam.GroupRepository, members iam.MembershipRepository, verifier PasswordVerifier, authz enforcer.Enforcer, logger log.Logger) (*Server, error) {
+	if cfg.BaseDN == "" {
+		cfg.BaseDN = DefaultBaseDN
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if cfg.MaxConnections <= 0 {
+		cfg.MaxConnections = DefaultMaxConnections
+	}
+
+	if ber.MaxPacketLengthBytes <= 0 || ber.MaxPacketLengthBytes > cfg.MaxMessageSize {
+		ber.MaxPacketLengthBytes = cfg.MaxMessageSize
+	}
+
+	base, err := goldap.ParseDN(cfg.BaseDN)
+	if err != nil || len(base.RDNs) == 0 {
+		return nil, fmt.Errorf("invalid base DN %q", cfg.BaseDN)
+	}
+
+	baseRDNs, _ := normalizeDN(cfg.BaseDN)
+
+	return &Server{
+		cfg:       cfg,
+		base:      base,
+		baseRDNs:  baseRDNs,
+		users:     users,
+		groups:    groups,
+		members:   members,
+		verifier:  verifier,
+		authz:     authz,
+		log:       logger,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// ListenAndServe listens on the TCP address addr and serves LDAP
+// requests.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.l.Lock()
+	if s.closed {
+		s.l.Unlock()
+		l.Close()
+		return errors.New("server closed")
+	}
+	s.listeners[l] = struct{}{}
+	s.l.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.l.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.l.Unlock()
+
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.l.Lock()
+		if len(s.conns) >= s.cfg.MaxConnections {
+			s.l.Unlock()
+			level.Warn(s.log).Log("msg", "rejected connection because the connection limit has been reached", "remote", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.l.Unlock()
+
+		go func() {
+			defer func() {
+				s.l.Lock()
+				delete(s.conns, conn)
+				s.l.Unlock()
+				conn.Close()
+			}()
+
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Close closes all listeners and connections.
+func (s *Server) Close() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+
+	return nil
+}
+
+// session holds the state of a single connection.
+type session struct {
+	conn    net.Conn
+	subject string
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sess := &session{conn: conn}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.cfg.IdleTimeout))
+
+		// nested values are limited by ber.MaxPacketLengthBytes but
+		// there may be arbitrarily many of them.
+		packet, err := ber.ReadPacket(io.LimitReader(conn, s.cfg.MaxMessageSize))
+		if err != nil {
+			return
+		}
+
+		if len(packet.Children) < 2 {
+			return
+		}
+
+		msgID, ok := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		if !ok || op.ClassType != ber.ClassApplication {
+			return
+		}
+
+		var resp []*ber.Packet
+		switch op.Tag {
+		case goldap.ApplicationBindRequest:
+			resp = []*ber.Packet{s.bind(ctx, sess, msgID, op)}
+		case goldap.ApplicationUnbindRequest:
+			return
+		case goldap.ApplicationSearchRequest:
+			resp = s.search(ctx, sess, msgID, op)
+		case goldap.ApplicationAbandonRequest:
+			// all operations complete synchronously.
+			continue
+		case goldap.ApplicationModifyRequest,
+			goldap.ApplicationAddRequest,
+			goldap.ApplicationDelRequest,
+			goldap.ApplicationModifyDNRequest,
+			goldap.ApplicationCompareRequest:
+			// responses use the tag following the request.
+			resp = []*ber.Packet{result(msgID, op.Tag+1, goldap.LDAPResultUnwillingToPerform, "", "directory is read-only")}
+		case goldap.ApplicationExtendedRequest:
+			resp = []*ber.Packet{result(msgID, goldap.ApplicationExtendedResponse, goldap.LDAPResultProtocolError, "", "unsupported extended operation")}
+		default:
+			return
+		}
+
+		for _, p := range resp {
+			if _, err := conn.Write(p.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// result returns an LDAPResult message.
+func result(msgID int64, tag ber.Tag, code uint16, matchedDN, msg string) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, "Message ID"))
+
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	r.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, matchedDN, "matchedDN"))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, msg, "diagnosticMessage"))
+	p.AppendChild(r)
+
+	return p
+}
+
+func (s *Server) bind(ctx context.Context, sess *session, msgID int64, op *ber.Packet) *ber.Packet {
+	reply := func(code uint16, msg string) *ber.Packet {
+		return result(msgID, goldap.ApplicationBindResponse, code, "", msg)
+	}
+
+	if len(op.Children) != 3 {
+		return reply(goldap.LDAPResultProtocolError, "invalid bind request")
+	}
+
+	// a bind resets the authentication state even if it fails.
+	sess.subject = ""
+
+	if version, _ := op.Children[0].Value.(int64); version != 3 {
+		return reply(goldap.LDAPResultProtocolError, "only LDAPv3 is supported")
+	}
+
+	name := packetString(op.Children[1])
+	auth := op.Children[2]
+	if auth.ClassType != ber.ClassContext || auth.Tag != 0 {
+		return reply(goldap.LDAPResultAuthMethodNotSupported, "only simple authentication is supported")
+	}
+	password := packetString(auth)
+
+	switch {
+	case name == "" && password == "":
+		// anonymous bind
+		return reply(goldap.LDAPResultSuccess, "")
+	case password == "":
+		// RFC 4513 section 5.1.2
+		return reply(goldap.LDAPResultUnwillingToPerform, "unauthenticated bind not allowed")
+	}
+
+	username, ok := s.usernameFromDN(name)
+	if !ok {
+		return reply(goldap.LDAPResultInvalidCredentials, "")
+	}
+
+	subject, err := s.verify(ctx, username, password)
+	if err != nil {
+		level.Warn(s.log).Log("msg", "bind failed", "dn", name, "remote", sess.conn.RemoteAddr(), "err", err)
+
+		switch {
+		case errors.Is(err, authn.ErrInvalidCredentials), common.IsNotFound(err):
+			return reply(goldap.LDAPResultInvalidCredentials, "")
+		case common.IsUnavailable(err):
+			return reply(goldap.LDAPResultUnavailable, "authentication backend unavailable")
+		default:
+			return reply(goldap.LDAPResultOther, "")
+		}
+	}
+
+	level.Info(s.log).Log("msg", "bind succeeded", "dn", name, "subject", subject, "remote", sess.conn.RemoteAddr())
+
+	sess.subject = subject
+	return reply(goldap.LDAPResultSuccess, "")
+}
+
+// usernameFromDN returns the username of a bind DN. The DN may either
+// be the DN of a user entry or a plain username.
+func (s *Server) usernameFromDN(name string) (string, bool) {
+	if !strings.Contains(name, "=") {
+		return name, true
+	}
+
+	parsed, err := goldap.ParseDN(name)
+	if err != nil {
+		return "", false
+	}
+
+	rdns, _ := normalizeDN(name)
+	usersOU := append([]string{"ou=" + UsersOU}, s.baseRDNs...)
+	if len(rdns) != len(usersOU)+1 || !hasSuffix(rdns, usersOU) {
+		return "", false
+	}
+
+	attrs := parsed.RDNs[0].Attributes
+	if len(attrs) != 1 || (!strings.EqualFold(attrs[0].Type, "uid") && !strings.EqualFold(attrs[0].Type, "cn")) {
+		return "", false
+	}
+
+	return attrs[0].Value, true
+}
+
+// verify verifies the password of username and returns the URN of the
+// IAM user.
+func (s *Server) verify(ctx context.Context, username, password string) (string, error) {
+	id, err := s.verifier.VerifyPassword(ctx, username, password)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := s.users.Load(ctx, iam.UserURN(fmt.Sprintf("urn:iam::user/%d", id)))
+	if err != nil {
+		return "", err
+	}
+
+	if u.Locked != nil && *u.Locked {
+		return "", fmt.Errorf("%w: user is locked", authn.ErrInvalidCredentials)
+	}
+
+	if u.DeletedAt != nil {
+		return "", fmt.Errorf("%w: user is deleted", authn.ErrInvalidCredentials)
+	}
+
+	return string(u.ID), nil
+}
+
+// allowed reports whether the subject of sess may perform action.
+func (s *Server) allowed(ctx context.Context, sess *session, action string) bool {
+	return s.allowedOn(ctx, sess, action, "")
+}
+
+// allowedOn reports whether the subject of sess may perform action on
+// resource.
+func (s *Server) allowedOn(ctx context.Context, sess *session, action, resource string) bool {
+	if sess.subject == "" {
+		return s.cfg.AllowAnonymous
+	}
+	if s.authz == nil {
+		return true
+	}
+	return s.authz.Enforce(ctx, sess.subject, action, resource, nil) == nil
+}
+
+const (
+	scopeBaseObject   = 0
+	scopeSingleLevel  = 1
+	scopeWholeSubtree = 2
+)
+
+func (s *Server) search(ctx context.Context, sess *session, msgID int64, op *ber.Packet) []*ber.Packet {
+	done := func(code uint16, msg string) *ber.Packet {
+		return result(msgID, goldap.ApplicationSearchResultDone, code, "", msg)
+	}
+
+	if len(op.Children) != 8 {
+		return []*ber.Packet{done(goldap.LDAPResultProtocolError, "invalid search request")}
+	}
+
+	var (
+		baseDN       = packetString(op.Children[0])
+		scope, _     = op.Children[1].Value.(int64)
+		sizeLimit, _ = op.Children[3].Value.(int64)
+		typesOnly, _ = op.Children[5].Value.(bool)
+		filter       = op.Children[6]
+		attrs        []string
+	)
+	for _, a := range op.Children[7].Children {
+		attrs = append(attrs, packetString(a))
+	}
+
+	// The root DSE is always readable so clients can discover
+	// the naming context.
+	if baseDN == "" && scope == scopeBaseObject {
+		root := &entry{}
+		root.add("objectClass", "top")
+		root.add("namingContexts", s.cfg.BaseDN)
+		root.add("supportedLDAPVersion", "3")
+
+		var resp []*ber.Packet
+		if ok, _ := match(filter, root); ok {
+			resp = append(resp, encodeEntry(msgID, root, attrs, typesOnly))
+		}
+		return append(resp, done(goldap.LDAPResultSuccess, ""))
+	}
+
+	if sess.subject == "" && !s.cfg.AllowAnonymous {
+		return []*ber.Packet{done(goldap.LDAPResultInsufficientAccessRights, "bind required")}
+	}
+
+	base, err := normalizeDN(baseDN)
+	if err != nil {
+		return []*ber.Packet{done(goldap.LDAPResultInvalidDNSyntax, err.Error())}
+	}
+
+	entries, err := s.load(ctx,
+		s.allowed(ctx, sess, user.ActionListUsers),
+		s.allowed(ctx, sess, group.ActionGroupRead),
+		func(u iam.User, key string) bool {
+			return s.allowedOn(ctx, sess, user.ActionReadAttr(key), string(u.ID))
+		},
+	)
+	if err != nil {
+		level.Error(s.log).Log("msg", "failed to load directory", "err", err)
+		return []*ber.Packet{done(goldap.LDAPResultOther, "failed to load directory")}
+	}
+
+	found := false
+	for _, e := range entries {
+		if equalDN(e.rdns, base) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return []*ber.Packet{result(msgID, goldap.ApplicationSearchResultDone, goldap.LDAPResultNoSuchObject, s.cfg.BaseDN, "")}
+	}
+
+	var resp []*ber.Packet
+	for _, e := range entries {
+		var inScope bool
+		switch scope {
+		case scopeBaseObject:
+			inScope = equalDN(e.rdns, base)
+		case scopeSingleLevel:
+			inScope = len(e.rdns) == len(base)+1 && hasSuffix(e.rdns, base)
+		default:
+			inScope = hasSuffix(e.rdns, base)
+		}
+		if !inScope {
+			continue
+		}
+
+		ok, err := match(filter, e)
+		if err != nil {
+			return []*ber.Packet{done(goldap.LDAPResultProtocolError, err.Error())}
+		}
+		if !ok {
+			continue
+		}
+
+		if sizeLimit > 0 && int64(len(resp)) >= sizeLimit {
+			return append(resp, done(goldap.LDAPResultSizeLimitExceeded, ""))
+		}
+
+		resp = append(resp, encodeEntry(msgID, e, attrs, typesOnly))
+	}
+
+	return append(resp, done(goldap.LDAPResultSuccess, ""))
+}
+
+// encodeEntry returns a SearchResultEntry message for e that contains
+// the requested attributes.
+func encodeEntry(msgID int64, e *entry, attrs []string, typesOnly bool) *ber.Packet {
+	all := len(attrs) == 0
+	selected := make(map[string]bool)
+	for _, a := range attrs {
+		if a == "*" {
+			all = true
+		}
+		selected[strings.ToLower(a)] = true
+	}
+
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, "Message ID"))
+
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "objectName"))
+
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for _, a := range e.attrs {
+		if !all && !selected[strings.ToLower(a.name)] {
+			continue
+		}
+
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a.name, "type"))
+
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		if !typesOnly {
+			for _, v := range a.values {
+				values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+			}
+		}
+		attr.AppendChild(values)
+
+		list.AppendChild(attr)
+	}
+	r.AppendChild(list)
+	p.AppendChild(r)
+
+	return p
+}
@@ -0,0 +1,260 @@
+package ldap_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iamtest"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/ldap"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+const baseDN = "dc=example,dc=com"
+
+type testbed struct {
+	*iamtest.Authn
+	addr string
+}
+
+func newTestbed(t *testing.T, cfg ldap.Config, authz enforcer.Enforcer) *testbed {
+	ctx := context.Background()
+	authnServer := iamtest.NewAuthn(t)
+	as := authnServer.Service
+
+	users := inmem.NewUserRepository()
+	groups := inmem.NewGroupRepository()
+	members := inmem.NewMembershipRepository()
+	us := user.NewService(users, as, nil)
+	gs := group.NewService(us, groups, members, log.NewNopLogger())
+
+	alice, err := us.CreateUser(ctx, "alice", "secret", map[string]interface{}{"email": "alice@example.com"})
+	require.NoError(t, err)
+	bob, err := us.CreateUser(ctx, "bob", "secret", nil)
+	require.NoError(t, err)
+	_, err = us.CreateUser(ctx, "carol", "secret", nil)
+	require.NoError(t, err)
+
+	vets, err := gs.Create(ctx, "vets", "Veterinarians")
+	require.NoError(t, err)
+	require.NoError(t, gs.AddMember(ctx, vets, alice))
+	require.NoError(t, gs.AddMember(ctx, vets, bob))
+
+	nurses, err := gs.Create(ctx, "nurses", "")
+	require.NoError(t, err)
+	require.NoError(t, gs.AddMember(ctx, nurses, bob))
+
+	require.NoError(t, us.LockUser(ctx, bob, true))
+
+	// soft-deleted users are hidden from the directory
+	dave, err := us.CreateUser(ctx, "dave", "secret", nil)
+	require.NoError(t, err)
+	require.NoError(t, gs.AddMember(ctx, vets, dave))
+	require.NoError(t, us.DeleteUser(ctx, dave))
+
+	cfg.BaseDN = baseDN
+	srv, err := ldap.NewServer(cfg, users, groups, members, as, authz, log.NewNopLogger())
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+	authnServer.Defer(func() { srv.Close() })
+
+	return &testbed{
+		Authn: authnServer,
+		addr:  l.Addr().String(),
+	}
+}
+
+func (tb *testbed) dial(t *testing.T) *goldap.Conn {
+	conn, err := goldap.DialURL("ldap://" + tb.addr)
+	require.NoError(t, err)
+	return conn
+}
+
+func search(t *testing.T, conn *goldap.Conn, base, filter string, attrs ...string) []*goldap.Entry {
+	res, err := conn.Search(goldap.NewSearchRequest(base, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, 0, false, filter, attrs, nil))
+	require.NoError(t, err)
+	return res.Entries
+}
+
+func dns(entries []*goldap.Entry) []string {
+	var result []string
+	for _, e := range entries {
+		result = append(result, e.DN)
+	}
+	return result
+}
+
+func TestServer_Bind(t *testing.T) {
+	tb := newTestbed(t, ldap.Config{}, enforcer.NewNoOpEnforcer())
+	defer tb.Close()
+
+	conn := tb.dial(t)
+	defer conn.Close()
+
+	assert.NoError(t, conn.Bind("uid=alice,ou=users,"+baseDN, "secret"))
+	assert.NoError(t, conn.Bind("alice", "secret"))
+
+	err := conn.Bind("uid=alice,ou=users,"+baseDN, "wrong")
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials), "%v", err)
+
+	err = conn.Bind("uid=unknown,ou=users,"+baseDN, "secret")
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials), "%v", err)
+
+	err = conn.Bind("uid=alice,ou=groups,"+baseDN, "secret")
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials), "%v", err)
+
+	// locked in IAM
+	err = conn.Bind("bob", "secret")
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials), "%v", err)
+
+	err = conn.Bind("dave", "secret")
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials), "%v", err)
+
+	err = conn.UnauthenticatedBind("alice")
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultUnwillingToPerform), "%v", err)
+}
+
+func TestServer_Search(t *testing.T) {
+	tb := newTestbed(t, ldap.Config{}, enforcer.NewNoOpEnforcer())
+	defer tb.Close()
+
+	conn := tb.dial(t)
+	defer conn.Close()
+
+	// anonymous searches are denied by default
+	_, err := conn.Search(goldap.NewSearchRequest(baseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, 0, false, "(uid=alice)", nil, nil))
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultInsufficientAccessRights), "%v", err)
+
+	require.NoError(t, conn.Bind("alice", "secret"))
+
+	entries := search(t, conn, baseDN, "(uid=alice)")
+	require.Len(t, entries, 1)
+	assert.Equal(t, "uid=alice,ou=users,"+baseDN, entries[0].DN)
+	assert.Equal(t, "alice@example.com", entries[0].GetAttributeValue("mail"))
+	assert.Equal(t, []string{"cn=vets,ou=groups," + baseDN}, entries[0].GetAttributeValues("memberOf"))
+
+	entries = search(t, conn, baseDN, "(memberOf=CN=vets,OU=groups,"+baseDN+")")
+	assert.Equal(t, []string{"uid=alice,ou=users," + baseDN, "uid=bob,ou=users," + baseDN}, dns(entries))
+
+	entries = search(t, conn, baseDN, "(&(objectClass=groupOfNames)(member=uid=bob,ou=users,"+baseDN+"))", "cn", "memberUid")
+	assert.Equal(t, []string{"cn=nurses,ou=groups," + baseDN, "cn=vets,ou=groups," + baseDN}, dns(entries))
+	assert.Equal(t, []string{"alice", "bob"}, entries[1].GetAttributeValues("memberUid"))
+	assert.Empty(t, entries[1].GetAttributeValues("description"))
+
+	entries = search(t, conn, "ou=users,"+baseDN, "(&(uid=*)(|(uid=c*)(!(mail=*))))", "1.1")
+	assert.Equal(t, []string{"uid=bob,ou=users," + baseDN, "uid=carol,ou=users," + baseDN}, dns(entries))
+
+	assert.Empty(t, search(t, conn, baseDN, "(uid=dave)"))
+
+	res, err := conn.Search(goldap.NewSearchRequest(baseDN, goldap.ScopeSingleLevel, goldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ou=users," + baseDN, "ou=groups," + baseDN}, dns(res.Entries))
+
+	_, err = conn.Search(goldap.NewSearchRequest(baseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 1, 0, false, "(objectClass=person)", nil, nil))
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded), "%v", err)
+
+	_, err = conn.Search(goldap.NewSearchRequest("ou=devices,"+baseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil))
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultNoSuchObject), "%v", err)
+
+	// root DSE
+	res, err = conn.Search(goldap.NewSearchRequest("", goldap.ScopeBaseObject, goldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"namingContexts"}, nil))
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1)
+	assert.Equal(t, baseDN, res.Entries[0].GetAttributeValue("namingContexts"))
+}
+
+func TestServer_Authorization(t *testing.T) {
+	tb := newTestbed(t, ldap.Config{AllowAnonymous: true}, iamtest.DenyActions{
+		group.ActionGroupRead:        true,
+		user.ActionReadAttr("email"): true,
+	})
+	defer tb.Close()
+
+	conn := tb.dial(t)
+	defer conn.Close()
+
+	// anonymous searches are allowed by configuration
+	assert.Len(t, search(t, conn, baseDN, "(objectClass=groupOfNames)"), 2)
+
+	require.NoError(t, conn.Bind("alice", "secret"))
+	assert.Len(t, search(t, conn, baseDN, "(objectClass=groupOfNames)"), 0)
+	assert.Len(t, search(t, conn, baseDN, "(objectClass=person)"), 3)
+
+	// attributes are only exposed if they are readable
+	entries := search(t, conn, baseDN, "(uid=alice)")
+	require.Len(t, entries, 1)
+	assert.Empty(t, entries[0].GetAttributeValue("mail"))
+	assert.Empty(t, search(t, conn, baseDN, "(mail=*)"))
+}
+
+func TestServer_ReadOnly(t *testing.T) {
+	tb := newTestbed(t, ldap.Config{}, enforcer.NewNoOpEnforcer())
+	defer tb.Close()
+
+	conn := tb.dial(t)
+	defer conn.Close()
+
+	require.NoError(t, conn.Bind("alice", "secret"))
+
+	req := goldap.NewModifyRequest("uid=alice,ou=users,"+baseDN, nil)
+	req.Replace("mail", []string{"alice@example.org"})
+	err := conn.Modify(req)
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultUnwillingToPerform), "%v", err)
+
+	err = conn.Del(goldap.NewDelRequest("uid=alice,ou=users,"+baseDN, nil))
+	assert.True(t, goldap.IsErrorWithCode(err, goldap.LDAPResultUnwillingToPerform), "%v", err)
+
+	// the connection is still usable
+	assert.Len(t, search(t, conn, baseDN, "(uid=alice)"), 1)
+}
+
+func TestServer_Limits(t *testing.T) {
+	tb := newTestbed(t, ldap.Config{MaxMessageSize: 1024, MaxConnections: 1}, enforcer.NewNoOpEnforcer())
+	defer tb.Close()
+
+	// closed returns whether the server closed conn without a response.
+	closed := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		return err == io.EOF
+	}
+
+	// a message ID claiming to be 1 GiB large
+	raw, err := net.Dial("tcp", tb.addr)
+	require.NoError(t, err)
+	defer raw.Close()
+	_, err = raw.Write([]byte{0x30, 0x84, 0x40, 0x00, 0x00, 0x06, 0x02, 0x84, 0x40, 0x00, 0x00, 0x00})
+	require.NoError(t, err)
+	assert.True(t, closed(raw))
+
+	conn := tb.dial(t)
+	require.NoError(t, conn.Bind("alice", "secret"))
+
+	// the connection limit has been reached
+	other, err := net.Dial("tcp", tb.addr)
+	require.NoError(t, err)
+	defer other.Close()
+	assert.True(t, closed(other))
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		conn, err := goldap.DialURL("ldap://" + tb.addr)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		return conn.Bind("alice", "secret") == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
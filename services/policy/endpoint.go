@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
 
@@ -80,6 +81,14 @@ type loadPolicyResponse struct {
 	iam.Policy
 }
 
+// Headers implements the go-kit http.Headerer interface and
+// returns the ETag of the policy.
+func (r loadPolicyResponse) Headers() http.Header {
+	h := make(http.Header)
+	h.Set("ETag", common.ETag(r.Version))
+	return h
+}
+
 func makeLoadPolicyEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(loadPolicyRequest)
@@ -92,7 +101,9 @@ func makeLoadPolicyEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
-type listPoliciesRequest struct{}
+type listPoliciesRequest struct {
+	Query iam.ListQuery
+}
 
 // All policies managed by IAM.
 // swagger:model listPoliciesResponse
@@ -103,12 +114,12 @@ type listPoliciesResponse struct {
 
 func makeListPoliciesEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		_ = request.(listPoliciesRequest)
+		req := request.(listPoliciesRequest)
 		policies, err := s.List(ctx)
 		if err != nil {
 			return nil, err
 		}
 
-		return listPoliciesResponse{policies}, nil
+		return listPoliciesResponse{req.Query.Policies(policies)}, nil
 	}
 }
@@ -0,0 +1,69 @@
+package policy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/ory/ladon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iamtest"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/policy"
+)
+
+func TestIntegration_Versions(t *testing.T) {
+	a := iamtest.NewAuthn(t)
+	defer a.Close()
+
+	s := policy.NewService(inmem.NewPolicyRepository())
+	srv := httptest.NewServer(policy.MakeHandler(s, a.Service.ExtractTokenSubject, enforcer.NewNoOpEnforcer(), log.NewNopLogger()))
+	defer srv.Close()
+
+	_, token := a.AccountToken("admin")
+	cli := iamtest.Client{URL: srv.URL, Token: token}
+
+	p := iam.Policy{DefaultPolicy: ladon.DefaultPolicy{
+		Subjects:  []string{"urn:iam::user/1"},
+		Actions:   []string{"iam:user:list"},
+		Resources: []string{"<.*>"},
+		Effect:    ladon.AllowAccess,
+	}}
+
+	body := map[string]interface{}{"name": "admins", "policy": p}
+	require.Equal(t, http.StatusOK, cli.Do(t, "POST", "/v1/policies/", body, nil).StatusCode)
+
+	// the ETag header holds the version of the policy
+	var loaded iam.Policy
+	res := cli.Do(t, "GET", "/v1/policies/admins", nil, &loaded)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	etag := res.Header.Get("ETag")
+	assert.Equal(t, common.ETag(loaded.Version), etag)
+
+	// updates are rejected if the policy has been modified
+	stale := cli
+	stale.Header = http.Header{"If-Match": {common.ETag(loaded.Version + 1)}}
+	assert.Equal(t, http.StatusPreconditionFailed, stale.Do(t, "PUT", "/v1/policies/admins", p, nil).StatusCode)
+	assert.Equal(t, http.StatusPreconditionFailed, stale.Do(t, "DELETE", "/v1/policies/admins", nil, nil).StatusCode)
+
+	current := cli
+	current.Header = http.Header{"If-Match": {etag}}
+	assert.Equal(t, http.StatusNoContent, current.Do(t, "PUT", "/v1/policies/admins", p, nil).StatusCode)
+
+	// the update changed the version
+	res = cli.Do(t, "GET", "/v1/policies/admins", nil, &loaded)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NotEqual(t, etag, res.Header.Get("ETag"))
+	assert.Equal(t, http.StatusPreconditionFailed, current.Do(t, "DELETE", "/v1/policies/admins", nil, nil).StatusCode)
+
+	// If-Match: * does not restrict updates
+	unconditional := cli
+	unconditional.Header = http.Header{"If-Match": {"*"}}
+	assert.Equal(t, http.StatusNoContent, unconditional.Do(t, "DELETE", "/v1/policies/admins", nil, nil).StatusCode)
+	assert.Equal(t, http.StatusNotFound, cli.Do(t, "GET", "/v1/policies/admins", nil, nil).StatusCode)
+}
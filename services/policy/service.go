@@ -3,15 +3,18 @@ package policy
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/mutex"
 )
 
 // Service implements polciy management functionallity.
 type Service interface {
-	// Create creates a new access policy under name.
+	// Create creates a new access policy under name. It returns
+	// common.ConflictError if the policy already exists.
 	Create(ctx context.Context, name string, policy iam.Policy) (iam.PolicyURN, error)
 
 	// Delete deletes a policy.
@@ -20,7 +23,9 @@ type Service interface {
 	// Load loads the policy with the given URN.
 	Load(ctx context.Context, urn iam.PolicyURN) (iam.Policy, error)
 
-	// Update updates an existing policy.
+	// Update updates an existing policy. Like Delete, it returns
+	// common.PreconditionFailedError if the context requires a version
+	// other than the current one (see iam.WithIfMatch).
 	Update(ctx context.Context, urn iam.PolicyURN, p iam.Policy) error
 
 	// List returns a list of all available policies.
@@ -43,9 +48,11 @@ func (s *service) Create(ctx context.Context, name string, policy iam.Policy) (i
 	defer s.m.Unlock()
 
 	policy.ID = fmt.Sprintf("urn:iam::policy/%s", name)
+	policy.Metadata = iam.Metadata{}
+	touch(ctx, &policy.Metadata)
 
 	if err := s.repo.Store(ctx, policy); err != nil {
-		return "", nil
+		return "", err
 	}
 
 	return iam.PolicyURN(policy.ID), nil
@@ -57,6 +64,15 @@ func (s *service) Delete(ctx context.Context, urn iam.PolicyURN) error {
 	}
 	defer s.m.Unlock()
 
+	p, err := s.repo.Load(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	if err := iam.CheckVersion(ctx, "policy", p.Metadata); err != nil {
+		return err
+	}
+
 	return s.repo.Delete(ctx, urn)
 }
 
@@ -75,7 +91,18 @@ func (s *service) Update(ctx context.Context, urn iam.PolicyURN, p iam.Policy) e
 	}
 	defer s.m.Unlock()
 
+	// keep the creation metadata of existing policies
+	existing, err := s.repo.Load(ctx, urn)
+	if err != nil && !common.IsNotFound(err) {
+		return err
+	}
+	if err := iam.CheckVersion(ctx, "policy", existing.Metadata); err != nil {
+		return err
+	}
+	p.Metadata = existing.Metadata
+
 	p.ID = string(urn)
+	touch(ctx, &p.Metadata)
 	if err := s.repo.Store(ctx, p); err != nil {
 		return err
 	}
@@ -102,6 +129,13 @@ func (s *service) List(ctx context.Context) ([]iam.Policy, error) {
 	return policies, nil
 }
 
+// touch records the actor of ctx as the last actor of m. Changes made
+// while impersonating a user are attributed to the impersonator.
+func touch(ctx context.Context, m *iam.Metadata) {
+	actor, _ := enforcer.Actor(ctx)
+	m.Touch(actor, time.Now())
+}
+
 // NewService returns a new policy management service.
 func NewService(repo iam.PolicyRepository) Service {
 	return &service{
@@ -3,8 +3,10 @@ package policy
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
@@ -35,8 +37,9 @@ const (
 func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer.Enforcer, logger log.Logger) http.Handler {
 	opts := []kithttp.ServerOption{
 		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
-		kithttp.ServerErrorEncoder(kithttp.DefaultErrorEncoder),
+		kithttp.ServerErrorEncoder(encodeError),
 		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+		kithttp.ServerBefore(ifMatchToContext),
 	}
 
 	makeEndpoint := func(action string, factory func(s Service) endpoint.Endpoint) endpoint.Endpoint {
@@ -86,7 +89,11 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 
 	// swagger:route GET /v1/policies/  policies listPolicies
 	//
-	// List all access policies managed by IAM.
+	// List all access policies managed by IAM. Policies are sorted by
+	// name, createdAt or updatedAt (sort) and can be filtered by the
+	// creating and last updating subject (createdBy, updatedBy) and by
+	// time (createdAfter, createdBefore, updatedAfter, updatedBefore;
+	// RFC 3339).
 	//
 	//	Produces:
 	//	- application/json
@@ -120,6 +127,8 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//
 	// Load a specific policy.
 	//
+	// The ETag header holds the version of the policy. See If-Match.
+	//
 	//	Produces:
 	//	- application/json
 	//
@@ -150,10 +159,14 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//		description: The ID of the policy to update
 	//	+	in: body
 	//		type: updatePolicyRequest
+	//	+	in: header
+	//		name: If-Match
+	//		description: Only apply the change if the ETag of the policy matches.
 	///
 	//	Responses:
 	//		default: body:genericError
 	//		201: description: Policy updated successfully.
+	//		412: description: The policy has been modified.
 	r.Handle("/v1/policies/{id}", updatePolicyHandler).Methods("PUT")
 
 	// swagger:route DELETE /v1/policies/{id] policies deletePolicy
@@ -169,10 +182,14 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//	+ 	in: path
 	//		name: id
 	//		description: The ID of the policy to delete.
+	//	+	in: header
+	//		name: If-Match
+	//		description: Only apply the change if the ETag of the policy matches.
 	//
 	//	Responses:
 	//		default: body:genericError
 	//		201: description: Policy deleted successfully.
+	//		412: description: The policy has been modified.
 	r.Handle("/v1/policies/{id}", deletePolicyHandler).Methods("DELETE")
 
 	return r
@@ -222,7 +239,22 @@ func decodeUpdatePolicyRequest(ctx context.Context, r *http.Request) (interface{
 }
 
 func decodeListPoliciesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
-	return listPoliciesRequest{}, nil
+	values := r.URL.Query()
+
+	f, err := iam.ParseMetadataFilter(values)
+	if err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	q := iam.ListQuery{
+		MetadataFilter: f,
+		SortBy:         iam.SortField(values.Get("sort")),
+	}
+	if err := q.Validate(); err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	return listPoliciesRequest{Query: q}, nil
 }
 
 func getPolicyURN(r *http.Request, key string) (iam.PolicyURN, error) {
@@ -235,3 +267,50 @@ func getPolicyURN(r *http.Request, key string) (iam.PolicyURN, error) {
 	urn := iam.PolicyURN(fmt.Sprintf("urn:iam::policy/%s", id))
 	return urn, nil
 }
+
+// ifMatchToContext makes updates conditional if r has an If-Match
+// header (see iam.WithIfMatch).
+func ifMatchToContext(ctx context.Context, r *http.Request) context.Context {
+	if versions, ok := common.ParseIfMatch(r.Header.Get("If-Match")); ok {
+		return iam.WithIfMatch(ctx, versions...)
+	}
+	return ctx
+}
+
+// encodeError encodes err as a JSON object and maps typed errors (e.g.
+// from pkg/common, authn and enforcer) to their HTTP status code.
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var h kithttp.Headerer
+	if errors.As(err, &h) {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
+	var body interface{} = map[string]interface{}{
+		"error": err.Error(),
+	}
+
+	var sc kithttp.StatusCoder
+	switch {
+	case err == os.ErrNotExist:
+		w.WriteHeader(http.StatusNotFound)
+		body = map[string]interface{}{"error": "resource not found"}
+	case err == os.ErrExist:
+		w.WriteHeader(http.StatusConflict)
+		body = map[string]interface{}{"error": "resource exists"}
+	case errors.As(err, &sc):
+		w.WriteHeader(sc.StatusCode())
+		if m, ok := sc.(json.Marshaler); ok {
+			body = m
+		}
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(body)
+}
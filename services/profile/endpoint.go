@@ -0,0 +1,55 @@
+package profile
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+func makeProfileEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return s.Profile(ctx)
+	}
+}
+
+func makeUserInfoEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return s.UserInfo(ctx)
+	}
+}
+
+type setAttrRequest struct {
+	Key   string
+	Value interface{}
+}
+
+type deleteAttrRequest struct {
+	Key string
+}
+
+type attrResponse struct{}
+
+func (attrResponse) StatusCode() int {
+	return http.StatusAccepted
+}
+
+func makeSetAttrEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setAttrRequest)
+		if err := s.SetAttr(ctx, req.Key, req.Value); err != nil {
+			return nil, err
+		}
+		return attrResponse{}, nil
+	}
+}
+
+func makeDeleteAttrEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deleteAttrRequest)
+		if err := s.DeleteAttr(ctx, req.Key); err != nil {
+			return nil, err
+		}
+		return attrResponse{}, nil
+	}
+}
@@ -0,0 +1,69 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+func Test_ProfileEndpoints(t *testing.T) {
+	s := &serviceMock{}
+
+	p := Profile{User: alice(), Groups: []iam.GroupURN{}, Permissions: []string{}}
+	s.On("Profile").Once().Return(p, nil)
+	res, err := makeProfileEndpoint(s)(testCtx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, p, res)
+
+	info := UserInfo{Subject: "10", PreferredUsername: "alice"}
+	s.On("UserInfo").Once().Return(info, nil)
+	res, err = makeUserInfoEndpoint(s)(testCtx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, info, res)
+
+	s.AssertExpectations(t)
+}
+
+func Test_AttrEndpoints(t *testing.T) {
+	s := &serviceMock{}
+
+	s.On("SetAttr", "phone", "+43123").Once().Return(nil)
+	res, err := makeSetAttrEndpoint(s)(testCtx, setAttrRequest{Key: "phone", Value: "+43123"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, res.(kithttp.StatusCoder).StatusCode())
+
+	s.On("DeleteAttr", "phone").Once().Return(errors.New("simulated"))
+	res, err = makeDeleteAttrEndpoint(s)(testCtx, deleteAttrRequest{Key: "phone"})
+	assert.Error(t, err)
+	assert.Nil(t, res)
+
+	s.AssertExpectations(t)
+}
+
+type serviceMock struct {
+	mock.Mock
+}
+
+func (s *serviceMock) Profile(_ context.Context) (Profile, error) {
+	args := s.Called()
+	return args.Get(0).(Profile), args.Error(1)
+}
+
+func (s *serviceMock) UserInfo(_ context.Context) (UserInfo, error) {
+	args := s.Called()
+	return args.Get(0).(UserInfo), args.Error(1)
+}
+
+func (s *serviceMock) SetAttr(_ context.Context, key string, value interface{}) error {
+	return s.Called(key, value).Error(0)
+}
+
+func (s *serviceMock) DeleteAttr(_ context.Context, key string) error {
+	return s.Called(key).Error(0)
+}
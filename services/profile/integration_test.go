@@ -0,0 +1,114 @@
+package profile_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iamtest"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/profile"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+type testbed struct {
+	*iamtest.Authn
+	users  user.Service
+	alice  iam.UserURN
+	client iamtest.Client
+}
+
+func newTestbed(t *testing.T, authz enforcer.Enforcer) *testbed {
+	ctx := context.Background()
+	a := iamtest.NewAuthn(t)
+
+	members := inmem.NewMembershipRepository()
+	us := user.NewService(inmem.NewUserRepository(), a.Service, nil)
+	gs := group.NewService(us, inmem.NewGroupRepository(), members, log.NewNopLogger())
+
+	alice, err := us.CreateUser(ctx, "alice", "secret", map[string]interface{}{"email": "alice@example.com"})
+	require.NoError(t, err)
+
+	vets, err := gs.Create(ctx, "vets", "")
+	require.NoError(t, err)
+	require.NoError(t, gs.AddMember(ctx, vets, alice))
+
+	s := profile.NewService(us, members, authz, nil)
+	srv := a.Serve(profile.MakeHandler(s, a.Service.ExtractTokenSubject, authz, log.NewNopLogger()))
+
+	u, err := us.LoadUser(ctx, alice)
+	require.NoError(t, err)
+
+	return &testbed{
+		Authn: a,
+		users: us,
+		alice: alice,
+		client: iamtest.Client{
+			URL:   srv.URL,
+			Token: a.Token(u.AccountID, iamtest.Audience),
+		},
+	}
+}
+
+func TestIntegration_Profile(t *testing.T) {
+	tb := newTestbed(t, iamtest.AllowActions{
+		profile.ActionReadProfile: nil,
+		user.ActionLoadUser:       nil,
+	})
+	defer tb.Close()
+
+	var p profile.Profile
+	require.Equal(t, http.StatusOK, tb.client.Do(t, "GET", "/v1/me", nil, &p).StatusCode)
+	assert.Equal(t, tb.alice, p.ID)
+	assert.Equal(t, "alice", p.Username)
+	assert.Equal(t, "alice@example.com", p.Attributes["email"])
+	assert.Equal(t, []iam.GroupURN{"urn:iam::group/vets"}, p.Groups)
+	assert.Equal(t, []string{user.ActionLoadUser, profile.ActionReadProfile}, p.Permissions)
+
+	var info profile.UserInfo
+	require.Equal(t, http.StatusOK, tb.client.Do(t, "GET", "/userinfo", nil, &info).StatusCode)
+	assert.Equal(t, profile.UserInfo{
+		Subject:           tb.alice.AccountID(),
+		PreferredUsername: "alice",
+		Email:             "alice@example.com",
+		Groups:            []string{"vets"},
+	}, info)
+
+	tb.client.Token = ""
+	assert.Equal(t, http.StatusUnauthorized, tb.client.Do(t, "GET", "/v1/me", nil, nil).StatusCode)
+
+	// authenticated accounts that are not managed by IAM
+	_, tb.client.Token = tb.AccountToken("bob")
+	assert.Equal(t, http.StatusNotFound, tb.client.Do(t, "GET", "/v1/me", nil, nil).StatusCode)
+}
+
+func TestIntegration_SelfService(t *testing.T) {
+	tb := newTestbed(t, iamtest.AllowActions{
+		profile.ActionWriteAttr: {"phone"},
+	})
+	defer tb.Close()
+	ctx := context.Background()
+
+	assert.Equal(t, http.StatusAccepted, tb.client.Do(t, "PUT", "/v1/me/attrs/phone", "+43 123", nil).StatusCode)
+	u, err := tb.users.LoadUser(ctx, tb.alice)
+	require.NoError(t, err)
+	assert.Equal(t, "+43 123", u.Attributes["phone"])
+
+	assert.Equal(t, http.StatusForbidden, tb.client.Do(t, "PUT", "/v1/me/attrs/email", "mallory@example.com", nil).StatusCode)
+	assert.Equal(t, http.StatusForbidden, tb.client.Do(t, "DELETE", "/v1/me/attrs/email", nil, nil).StatusCode)
+
+	assert.Equal(t, http.StatusAccepted, tb.client.Do(t, "DELETE", "/v1/me/attrs/phone", nil, nil).StatusCode)
+	u, err = tb.users.LoadUser(ctx, tb.alice)
+	require.NoError(t, err)
+	assert.NotContains(t, u.Attributes, "phone")
+	assert.Equal(t, "alice@example.com", u.Attributes["email"])
+
+	// reading the profile requires its own permission
+	assert.Equal(t, http.StatusForbidden, tb.client.Do(t, "GET", "/v1/me", nil, nil).StatusCode)
+}
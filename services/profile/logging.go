@@ -0,0 +1,76 @@
+package profile
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+type loggingService struct {
+	l log.Logger
+	Service
+}
+
+// NewLoggingService returns a service that logs method calls of Service
+func NewLoggingService(s Service, logger log.Logger) Service {
+	return &loggingService{
+		l:       logger,
+		Service: s,
+	}
+}
+
+func (s *loggingService) Profile(ctx context.Context) (p Profile, err error) {
+	defer func(begin time.Time) {
+		subject, _ := enforcer.Subject(ctx)
+		s.l.Log(
+			"method", "profile",
+			"subject", subject,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Profile(ctx)
+}
+
+func (s *loggingService) UserInfo(ctx context.Context) (info UserInfo, err error) {
+	defer func(begin time.Time) {
+		subject, _ := enforcer.Subject(ctx)
+		s.l.Log(
+			"method", "user_info",
+			"subject", subject,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.UserInfo(ctx)
+}
+
+func (s *loggingService) SetAttr(ctx context.Context, key string, value interface{}) (err error) {
+	defer func(begin time.Time) {
+		subject, _ := enforcer.Subject(ctx)
+		s.l.Log(
+			"method", "set_attr",
+			"subject", subject,
+			"key", key,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.SetAttr(ctx, key, value)
+}
+
+func (s *loggingService) DeleteAttr(ctx context.Context, key string) (err error) {
+	defer func(begin time.Time) {
+		subject, _ := enforcer.Subject(ctx)
+		s.l.Log(
+			"method", "delete_attr",
+			"subject", subject,
+			"key", key,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.DeleteAttr(ctx, key)
+}
@@ -0,0 +1,215 @@
+// Package profile provides self-service access to the IAM user of
+// the authenticated subject. Applications use it to resolve the
+// profile, group memberships and permissions of the current user with
+// a single call, either using the IAM representation at /v1/me or the
+// OpenID Connect compatible /userinfo endpoint.
+package profile
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/services/attribute"
+	"github.com/tierklinik-dobersberg/identity-server/services/bulk"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/policy"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+// EmailAttr is the user attribute exposed as the email claim of
+// UserInfo.
+const EmailAttr = "email"
+
+// DefaultActions are the actions included in the permission summary
+// of a Profile if no actions are passed to NewService.
+var DefaultActions = []string{
+	user.ActionWriteUser,
+	user.ActionLoadUser,
+	user.ActionListUsers,
+	user.ActionDeleteUser,
+	user.ActionRestoreUser,
+	user.ActionPurgeUser,
+	user.ActionLockUnlockUser,
+	user.ActionRevokeTokens,
+	user.ActionRevokeToken,
+	user.ActionSetUsername,
+	user.ActionSetPassword,
+	user.ActionUpdateUserAttr,
+	user.ActionReconcileUsers,
+	bulk.ActionImportUsers,
+	bulk.ActionExportUsers,
+	user.ActionImpersonate,
+	group.ActionGroupRead,
+	group.ActionGroupWrite,
+	policy.ActionWritePolicy,
+	policy.ActionDeletePolicy,
+	policy.ActionLoadPolicy,
+	policy.ActionListPolicies,
+	attribute.ActionReadAttributes,
+	attribute.ActionWriteAttribute,
+	attribute.ActionDeleteAttribute,
+	ActionReadProfile,
+}
+
+// Profile is the IAM user of the authenticated subject.
+// swagger:model Profile
+type Profile struct {
+	iam.User
+
+	// Groups holds the URNs of all groups the user is a member of.
+	Groups []iam.GroupURN `json:"groups"`
+
+	// Permissions holds the actions the user is allowed to perform
+	// using the current access token.
+	Permissions []string `json:"permissions"`
+}
+
+// UserInfo holds the claims of the authenticated subject as returned
+// by the UserInfo endpoint of OpenID Connect Core 1.0 section 5.3.
+// swagger:model UserInfo
+type UserInfo struct {
+	Subject           string   `json:"sub"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// Service provides access to the profile of the subject associated
+// with the request context (see enforcer.Subject).
+type Service interface {
+	// Profile returns the profile of the current subject.
+	Profile(ctx context.Context) (Profile, error)
+
+	// UserInfo returns the OpenID Connect claims of the current subject.
+	UserInfo(ctx context.Context) (UserInfo, error)
+
+	// SetAttr sets an attribute of the current subject. Callers must
+	// ensure the subject is allowed to perform ActionWriteAttr on key.
+	SetAttr(ctx context.Context, key string, value interface{}) error
+
+	// DeleteAttr deletes an attribute of the current subject. Callers
+	// must ensure the subject is allowed to perform ActionWriteAttr on
+	// key.
+	DeleteAttr(ctx context.Context, key string) error
+}
+
+type service struct {
+	users   user.Service
+	members iam.MembershipRepository
+	authz   enforcer.Enforcer
+	actions []string
+}
+
+// NewService returns a new profile service. The permission summary of
+// a profile lists the subset of actions that authz allows for the
+// current subject. DefaultActions are used if actions is empty.
+func NewService(users user.Service, members iam.MembershipRepository, authz enforcer.Enforcer, actions []string) Service {
+	if len(actions) == 0 {
+		actions = DefaultActions
+	}
+
+	return &service{
+		users:   users,
+		members: members,
+		authz:   authz,
+		actions: actions,
+	}
+}
+
+// subject returns the user URN of the current subject. Subjects that
+// are not IAM users, for example client certificates mapped to a
+// service, don't have a profile.
+func subject(ctx context.Context) (iam.UserURN, error) {
+	s, ok := enforcer.Subject(ctx)
+	if !ok || !iam.UserURN(s).IsValid() {
+		return "", common.NewNotFoundError("profile")
+	}
+	return iam.UserURN(s), nil
+}
+
+func (s *service) load(ctx context.Context) (iam.User, []iam.GroupURN, error) {
+	urn, err := subject(ctx)
+	if err != nil {
+		return iam.User{}, nil, err
+	}
+
+	u, err := s.users.LoadUser(ctx, urn)
+	if err != nil {
+		return iam.User{}, nil, err
+	}
+
+	groups, err := s.members.Memberships(ctx, urn)
+	if err != nil && !common.IsNotFound(err) {
+		return iam.User{}, nil, err
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+
+	return u, groups, nil
+}
+
+func (s *service) Profile(ctx context.Context) (Profile, error) {
+	u, groups, err := s.load(ctx)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	p := Profile{
+		User:        u,
+		Groups:      groups,
+		Permissions: []string{},
+	}
+	if p.Groups == nil {
+		p.Groups = []iam.GroupURN{}
+	}
+
+	policyContext, _ := enforcer.PolicyContext(ctx)
+	for _, action := range s.actions {
+		if s.authz.Enforce(ctx, string(u.ID), action, "", policyContext) == nil {
+			p.Permissions = append(p.Permissions, action)
+		}
+	}
+
+	return p, nil
+}
+
+func (s *service) UserInfo(ctx context.Context) (UserInfo, error) {
+	u, groups, err := s.load(ctx)
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	info := UserInfo{
+		Subject:           strconv.Itoa(u.AccountID),
+		PreferredUsername: u.Username,
+	}
+
+	if email, ok := u.Attributes[EmailAttr].(string); ok {
+		info.Email = email
+	}
+
+	for _, grp := range groups {
+		info.Groups = append(info.Groups, grp.GroupName())
+	}
+
+	return info, nil
+}
+
+func (s *service) SetAttr(ctx context.Context, key string, value interface{}) error {
+	urn, err := subject(ctx)
+	if err != nil {
+		return err
+	}
+	return s.users.SetAttr(ctx, urn, key, value)
+}
+
+func (s *service) DeleteAttr(ctx context.Context, key string) error {
+	urn, err := subject(ctx)
+	if err != nil {
+		return err
+	}
+	return s.users.DeleteAttr(ctx, urn, key)
+}
@@ -0,0 +1,139 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tierklinik-dobersberg/identity-server/mocks"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+var (
+	bg      = context.Background()
+	testCtx = enforcer.WithSubject(bg, "urn:iam::user/10")
+)
+
+// userServiceMock mocks the user.Service methods used by the profile
+// service.
+type userServiceMock struct {
+	mock.Mock
+	user.Service
+}
+
+func (s *userServiceMock) LoadUser(_ context.Context, urn iam.UserURN) (iam.User, error) {
+	args := s.Called(urn)
+	return args.Get(0).(iam.User), args.Error(1)
+}
+
+func (s *userServiceMock) SetAttr(_ context.Context, urn iam.UserURN, key string, value interface{}) error {
+	return s.Called(urn, key, value).Error(0)
+}
+
+func (s *userServiceMock) DeleteAttr(_ context.Context, urn iam.UserURN, key string) error {
+	return s.Called(urn, key).Error(0)
+}
+
+// allowActions allows all actions it contains.
+type allowActions map[string]bool
+
+func (a allowActions) Enforce(_ context.Context, _, action, _ string, _ enforcer.Context) error {
+	if !a[action] {
+		return &enforcer.PermissionDeniedError{Reason: "denied"}
+	}
+	return nil
+}
+
+func alice() iam.User {
+	return iam.User{
+		AccountID:  10,
+		ID:         "urn:iam::user/10",
+		Username:   "alice",
+		Attributes: map[string]interface{}{EmailAttr: "alice@example.com"},
+	}
+}
+
+func setupTestBed(authz enforcer.Enforcer) (Service, *userServiceMock, *mocks.MembershipRepository) {
+	users := &userServiceMock{}
+	members := mocks.NewMembershipRepository()
+	return NewService(users, members, authz, []string{"a", "b", "c"}), users, members
+}
+
+func TestService_Profile(t *testing.T) {
+	s, users, members := setupTestBed(allowActions{"a": true, "c": true})
+
+	users.On("LoadUser", iam.UserURN("urn:iam::user/10")).Return(alice(), nil)
+	members.On("Memberships", iam.UserURN("urn:iam::user/10")).Return([]iam.GroupURN{"urn:iam::group/vets", "urn:iam::group/admins"}, nil)
+
+	p, err := s.Profile(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, alice(), p.User)
+	assert.Equal(t, []iam.GroupURN{"urn:iam::group/admins", "urn:iam::group/vets"}, p.Groups)
+	assert.Equal(t, []string{"a", "c"}, p.Permissions)
+
+	// subjects that are not IAM users don't have a profile
+	_, err = s.Profile(bg)
+	assert.True(t, common.IsNotFound(err))
+	_, err = s.Profile(enforcer.WithSubject(bg, "CN=backup"))
+	assert.True(t, common.IsNotFound(err))
+}
+
+func TestService_Profile_NoGroups(t *testing.T) {
+	s, users, members := setupTestBed(allowActions{})
+
+	users.On("LoadUser", iam.UserURN("urn:iam::user/10")).Return(alice(), nil)
+	members.On("Memberships", iam.UserURN("urn:iam::user/10")).Return([]iam.GroupURN(nil), common.NewNotFoundError("user"))
+
+	p, err := s.Profile(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, []iam.GroupURN{}, p.Groups)
+	assert.Equal(t, []string{}, p.Permissions)
+}
+
+func TestService_Profile_Failed(t *testing.T) {
+	s, users, members := setupTestBed(allowActions{})
+
+	users.On("LoadUser", iam.UserURN("urn:iam::user/10")).Once().Return(iam.User{}, errors.New("simulated"))
+	_, err := s.Profile(testCtx)
+	assert.Error(t, err)
+
+	users.On("LoadUser", iam.UserURN("urn:iam::user/10")).Once().Return(alice(), nil)
+	members.On("Memberships", iam.UserURN("urn:iam::user/10")).Once().Return([]iam.GroupURN(nil), errors.New("simulated"))
+	_, err = s.Profile(testCtx)
+	assert.Error(t, err)
+}
+
+func TestService_UserInfo(t *testing.T) {
+	s, users, members := setupTestBed(allowActions{})
+
+	users.On("LoadUser", iam.UserURN("urn:iam::user/10")).Return(alice(), nil)
+	members.On("Memberships", iam.UserURN("urn:iam::user/10")).Return([]iam.GroupURN{"urn:iam::group/vets"}, nil)
+
+	info, err := s.UserInfo(testCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, UserInfo{
+		Subject:           "10",
+		PreferredUsername: "alice",
+		Email:             "alice@example.com",
+		Groups:            []string{"vets"},
+	}, info)
+}
+
+func TestService_Attrs(t *testing.T) {
+	s, users, _ := setupTestBed(allowActions{})
+
+	users.On("SetAttr", iam.UserURN("urn:iam::user/10"), "phone", "+43123").Once().Return(nil)
+	users.On("DeleteAttr", iam.UserURN("urn:iam::user/10"), "phone").Once().Return(nil)
+
+	assert.NoError(t, s.SetAttr(testCtx, "phone", "+43123"))
+	assert.NoError(t, s.DeleteAttr(testCtx, "phone"))
+	users.AssertExpectations(t)
+
+	assert.True(t, common.IsNotFound(s.SetAttr(bg, "phone", "+43123")))
+	assert.True(t, common.IsNotFound(s.DeleteAttr(bg, "phone")))
+}
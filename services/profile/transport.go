@@ -0,0 +1,193 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+const (
+	// ActionReadProfile allows the subject to read its own profile.
+	ActionReadProfile = "iam:profile:read"
+
+	// ActionWriteAttr allows the subject to set or delete attributes
+	// of its own profile. The attribute key is passed as the resource
+	// so policies can limit the attributes that are user-editable.
+	ActionWriteAttr = "iam:profile:write-attr"
+)
+
+// MakeHandler returns a http.Handler for the profile service.
+func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer.Enforcer, logger log.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+	}
+
+	makeEndpoint := func(action string, factory func(Service) endpoint.Endpoint) endpoint.Endpoint {
+		return endpoint.Chain(
+			authn.NewAuthenticator(extractor),
+			enforcer.NewActionEndpoint(action),
+			enforcer.NewResourceEndpoint(attrResource),
+			enforcer.NewEnforcedEndpoint(authz),
+		)(factory(s))
+	}
+
+	profileHandler := kithttp.NewServer(
+		makeEndpoint(ActionReadProfile, makeProfileEndpoint),
+		kithttp.NopRequestDecoder,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	userInfoHandler := kithttp.NewServer(
+		makeEndpoint(ActionReadProfile, makeUserInfoEndpoint),
+		kithttp.NopRequestDecoder,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	setAttrHandler := kithttp.NewServer(
+		makeEndpoint(ActionWriteAttr, makeSetAttrEndpoint),
+		decodeSetAttrRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	deleteAttrHandler := kithttp.NewServer(
+		makeEndpoint(ActionWriteAttr, makeDeleteAttrEndpoint),
+		decodeDeleteAttrRequest,
+		kithttp.EncodeJSONResponse,
+		opts...,
+	)
+
+	r := mux.NewRouter()
+
+	// swagger:route GET /v1/me profile getProfile
+	//
+	// Returns the IAM user of the authenticated subject including its
+	// group memberships and permissions.
+	//
+	//	Produces:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		200: Profile
+	r.Handle("/v1/me", profileHandler).Methods("GET")
+
+	// swagger:route GET /userinfo profile getUserInfo
+	//
+	// Returns the claims of the authenticated subject as defined by
+	// OpenID Connect.
+	//
+	//	Produces:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		200: UserInfo
+	r.Handle("/userinfo", userInfoHandler).Methods("GET", "POST")
+
+	// swagger:route PUT /v1/me/attrs/{key} profile setProfileAttribute
+	//
+	// Sets an attribute of the authenticated subject. Requires the
+	// iam:profile:write-attr permission on the attribute key.
+	//
+	//	Consumes:
+	//	- application/json
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		202: description: The attribute has been stored successfully.
+	r.Handle("/v1/me/attrs/{key}", setAttrHandler).Methods("PUT")
+
+	// swagger:route DELETE /v1/me/attrs/{key} profile deleteProfileAttribute
+	//
+	// Deletes an attribute of the authenticated subject. Requires the
+	// iam:profile:write-attr permission on the attribute key.
+	//
+	//	Schemes: http, https
+	//
+	//	Responses:
+	//		default: body:genericError
+	//		202: description: The attribute has been deleted successfully.
+	r.Handle("/v1/me/attrs/{key}", deleteAttrHandler).Methods("DELETE")
+
+	return r
+}
+
+// attrResource returns the attribute key of attribute requests as the
+// policy resource.
+func attrResource(_ context.Context, request interface{}) (string, error) {
+	switch req := request.(type) {
+	case setAttrRequest:
+		return req.Key, nil
+	case deleteAttrRequest:
+		return req.Key, nil
+	}
+	return "", nil
+}
+
+func decodeSetAttrRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := setAttrRequest{
+		Key: mux.Vars(r)["key"],
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req.Value); err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	return req, nil
+}
+
+func decodeDeleteAttrRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return deleteAttrRequest{Key: mux.Vars(r)["key"]}, nil
+}
+
+// encodeError encodes err as a JSON object and maps typed errors (e.g.
+// from pkg/common, authn and enforcer) to their HTTP status code.
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	var h kithttp.Headerer
+	if errors.As(err, &h) {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
+	var body interface{} = map[string]interface{}{
+		"error": err.Error(),
+	}
+
+	var sc kithttp.StatusCoder
+	if errors.As(err, &sc) {
+		w.WriteHeader(sc.StatusCode())
+		if m, ok := sc.(json.Marshaler); ok {
+			body = m
+		}
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(body)
+}
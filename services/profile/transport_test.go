@@ -0,0 +1,77 @@
+package profile
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+func Test_decodeSetAttrRequest(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/v1/me/attrs/phone", strings.NewReader(`"+43123"`))
+	r = mux.SetURLVars(r, map[string]string{"key": "phone"})
+
+	req, err := decodeSetAttrRequest(bg, r)
+	assert.NoError(t, err)
+	assert.Equal(t, setAttrRequest{Key: "phone", Value: "+43123"}, req)
+
+	r = httptest.NewRequest("PUT", "/v1/me/attrs/phone", strings.NewReader(`invalid-json`))
+	r = mux.SetURLVars(r, map[string]string{"key": "phone"})
+	_, err = decodeSetAttrRequest(bg, r)
+	assert.True(t, common.IsInvalidArgument(err))
+}
+
+func Test_decodeDeleteAttrRequest(t *testing.T) {
+	r := httptest.NewRequest("DELETE", "/v1/me/attrs/phone", nil)
+	r = mux.SetURLVars(r, map[string]string{"key": "phone"})
+
+	req, err := decodeDeleteAttrRequest(bg, r)
+	assert.NoError(t, err)
+	assert.Equal(t, deleteAttrRequest{Key: "phone"}, req)
+}
+
+func Test_attrResource(t *testing.T) {
+	resource, err := attrResource(bg, setAttrRequest{Key: "phone"})
+	assert.NoError(t, err)
+	assert.Equal(t, "phone", resource)
+
+	resource, err = attrResource(bg, deleteAttrRequest{Key: "email"})
+	assert.NoError(t, err)
+	assert.Equal(t, "email", resource)
+
+	resource, err = attrResource(bg, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, resource)
+}
+
+func Test_MakeHandler(t *testing.T) {
+	extractor := func(string) (string, string, error) { return "", "", nil }
+	r := MakeHandler(&serviceMock{}, extractor, enforcer.NewNoOpEnforcer(), log.NewNopLogger())
+	assert.NotNil(t, r)
+}
+
+func Test_encodeError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{common.NewNotFoundError("profile"), http.StatusNotFound},
+		{common.NewInvalidArgumentError("invalid"), http.StatusBadRequest},
+		{&enforcer.PermissionDeniedError{Reason: "denied"}, http.StatusForbidden},
+		{errors.New("simulated"), http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		encodeError(bg, c.err, w)
+		assert.Equal(t, c.status, w.Code, c.err.Error())
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,209 @@
+package scim
+
+import (
+	"context"
+	"time"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+// authorizer enforces the actions of the user and group management
+// APIs for the subject of a request context. SCIM requests may use
+// multiple actions (e.g. a PATCH that changes the username and locks
+// the user) so each call to the underlying services is authorized
+// separately.
+type authorizer struct {
+	enforce func(ctx context.Context) error
+}
+
+func newAuthorizer(authz enforcer.Enforcer) authorizer {
+	ep := enforcer.NewEnforcedEndpoint(authz)(func(context.Context, interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	return authorizer{
+		enforce: func(ctx context.Context) error {
+			_, err := ep(ctx, nil)
+			return err
+		},
+	}
+}
+
+func (a authorizer) authorize(ctx context.Context, action, resource string) error {
+	ctx = enforcer.WithAction(ctx, action)
+	if resource != "" {
+		ctx = enforcer.WithResource(ctx, resource)
+	}
+	return a.enforce(ctx)
+}
+
+// authorizedUsers authorizes all calls to the user service used by the
+// SCIM API.
+type authorizedUsers struct {
+	user.Service
+	authorizer
+}
+
+func (s authorizedUsers) Users(ctx context.Context) ([]iam.User, error) {
+	if err := s.authorize(ctx, user.ActionListUsers, ""); err != nil {
+		return nil, err
+	}
+	return s.Service.Users(ctx)
+}
+
+func (s authorizedUsers) QueryUsers(ctx context.Context, q iam.UserQuery) (iam.UserPage, error) {
+	if err := s.authorize(ctx, user.ActionListUsers, ""); err != nil {
+		return iam.UserPage{}, err
+	}
+	return s.Service.QueryUsers(ctx, q)
+}
+
+func (s authorizedUsers) LoadUser(ctx context.Context, urn iam.UserURN) (iam.User, error) {
+	if err := s.authorize(ctx, user.ActionLoadUser, string(urn)); err != nil {
+		return iam.User{}, err
+	}
+	return s.Service.LoadUser(ctx, urn)
+}
+
+func (s authorizedUsers) LoadUserByUsername(ctx context.Context, username string) (iam.User, error) {
+	if err := s.authorize(ctx, user.ActionLoadUser, ""); err != nil {
+		return iam.User{}, err
+	}
+	return s.Service.LoadUserByUsername(ctx, username)
+}
+
+func (s authorizedUsers) LoadUserByAttr(ctx context.Context, key, value string) (iam.User, error) {
+	if err := s.authorize(ctx, user.ActionLoadUser, ""); err != nil {
+		return iam.User{}, err
+	}
+	return s.Service.LoadUserByAttr(ctx, key, value)
+}
+
+func (s authorizedUsers) CreateUser(ctx context.Context, username, password string, attrs map[string]interface{}) (iam.UserURN, error) {
+	if err := s.authorize(ctx, user.ActionWriteUser, ""); err != nil {
+		return "", err
+	}
+	return s.Service.CreateUser(ctx, username, password, attrs)
+}
+
+func (s authorizedUsers) DeleteUser(ctx context.Context, urn iam.UserURN) error {
+	if err := s.authorize(ctx, user.ActionDeleteUser, string(urn)); err != nil {
+		return err
+	}
+	return s.Service.DeleteUser(ctx, urn)
+}
+
+func (s authorizedUsers) RestoreUser(ctx context.Context, urn iam.UserURN) error {
+	if err := s.authorize(ctx, user.ActionRestoreUser, string(urn)); err != nil {
+		return err
+	}
+	return s.Service.RestoreUser(ctx, urn)
+}
+
+func (s authorizedUsers) PurgeUser(ctx context.Context, urn iam.UserURN) error {
+	if err := s.authorize(ctx, user.ActionPurgeUser, string(urn)); err != nil {
+		return err
+	}
+	return s.Service.PurgeUser(ctx, urn)
+}
+
+func (s authorizedUsers) PurgeDeletedUsers(ctx context.Context, t time.Time) ([]iam.UserURN, error) {
+	if err := s.authorize(ctx, user.ActionPurgeUser, ""); err != nil {
+		return nil, err
+	}
+	return s.Service.PurgeDeletedUsers(ctx, t)
+}
+
+func (s authorizedUsers) LockUser(ctx context.Context, urn iam.UserURN, locked bool) error {
+	if err := s.authorize(ctx, user.ActionLockUnlockUser, string(urn)); err != nil {
+		return err
+	}
+	return s.Service.LockUser(ctx, urn, locked)
+}
+
+func (s authorizedUsers) SetUsername(ctx context.Context, urn iam.UserURN, username string) error {
+	if err := s.authorize(ctx, user.ActionSetUsername, string(urn)); err != nil {
+		return err
+	}
+	return s.Service.SetUsername(ctx, urn, username)
+}
+
+func (s authorizedUsers) SetPassword(ctx context.Context, urn iam.UserURN, password string) error {
+	if err := s.authorize(ctx, user.ActionSetPassword, string(urn)); err != nil {
+		return err
+	}
+	return s.Service.SetPassword(ctx, urn, password)
+}
+
+func (s authorizedUsers) UpdateAttrs(ctx context.Context, urn iam.UserURN, attrs map[string]interface{}) error {
+	if err := s.authorize(ctx, user.ActionUpdateUserAttr, string(urn)); err != nil {
+		return err
+	}
+	return s.Service.UpdateAttrs(ctx, urn, attrs)
+}
+
+func (s authorizedUsers) PatchAttrs(ctx context.Context, urn iam.UserURN, patch iam.AttrPatch) error {
+	if err := s.authorize(ctx, user.ActionUpdateUserAttr, string(urn)); err != nil {
+		return err
+	}
+	return s.Service.PatchAttrs(ctx, urn, patch)
+}
+
+// authorizedGroups authorizes all calls to the group service used by
+// the SCIM API.
+type authorizedGroups struct {
+	group.Service
+	authorizer
+}
+
+func (s authorizedGroups) Get(ctx context.Context) ([]iam.Group, error) {
+	if err := s.authorize(ctx, group.ActionGroupRead, ""); err != nil {
+		return nil, err
+	}
+	return s.Service.Get(ctx)
+}
+
+func (s authorizedGroups) Load(ctx context.Context, urn iam.GroupURN) (iam.Group, error) {
+	if err := s.authorize(ctx, group.ActionGroupRead, string(urn)); err != nil {
+		return iam.Group{}, err
+	}
+	return s.Service.Load(ctx, urn)
+}
+
+func (s authorizedGroups) GetMembers(ctx context.Context, urn iam.GroupURN) ([]iam.UserURN, error) {
+	if err := s.authorize(ctx, group.ActionGroupRead, string(urn)); err != nil {
+		return nil, err
+	}
+	return s.Service.GetMembers(ctx, urn)
+}
+
+func (s authorizedGroups) Create(ctx context.Context, name, comment string) (iam.GroupURN, error) {
+	if err := s.authorize(ctx, group.ActionGroupWrite, ""); err != nil {
+		return "", err
+	}
+	return s.Service.Create(ctx, name, comment)
+}
+
+func (s authorizedGroups) Delete(ctx context.Context, urn iam.GroupURN) error {
+	if err := s.authorize(ctx, group.ActionGroupWrite, string(urn)); err != nil {
+		return err
+	}
+	return s.Service.Delete(ctx, urn)
+}
+
+func (s authorizedGroups) AddMember(ctx context.Context, grp iam.GroupURN, member iam.UserURN) error {
+	if err := s.authorize(ctx, group.ActionGroupWrite, string(grp)); err != nil {
+		return err
+	}
+	return s.Service.AddMember(ctx, grp, member)
+}
+
+func (s authorizedGroups) DeleteMember(ctx context.Context, grp iam.GroupURN, member iam.UserURN) error {
+	if err := s.authorize(ctx, group.ActionGroupWrite, string(grp)); err != nil {
+		return err
+	}
+	return s.Service.DeleteMember(ctx, grp, member)
+}
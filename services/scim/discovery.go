@@ -0,0 +1,131 @@
+package scim
+
+// Documents served by the discovery endpoints defined in RFC 7644
+// section 4.
+
+func serviceProviderConfig() map[string]interface{} {
+	supported := func(b bool) map[string]interface{} {
+		return map[string]interface{}{"supported": b}
+	}
+
+	return map[string]interface{}{
+		"schemas": []string{SchemaServiceProviderConfig},
+		"patch":   supported(true),
+		"bulk": map[string]interface{}{
+			"supported":      false,
+			"maxOperations":  0,
+			"maxPayloadSize": 0,
+		},
+		"filter": map[string]interface{}{
+			"supported":  true,
+			"maxResults": DefaultCount,
+		},
+		"changePassword": supported(true),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []interface{}{
+			map[string]interface{}{
+				"type":        "oauthbearertoken",
+				"name":        "OAuth Bearer Token",
+				"description": "Authentication using access tokens issued by authn-server",
+				"specUri":     "https://tools.ietf.org/html/rfc6750",
+				"primary":     true,
+			},
+		},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     BasePath + "/ServiceProviderConfig",
+		},
+	}
+}
+
+func resourceTypes() []Resource {
+	resourceType := func(name, schema string) Resource {
+		return Resource{
+			"schemas":  []string{SchemaResourceType},
+			"id":       name,
+			"name":     name,
+			"endpoint": "/" + name + "s",
+			"schema":   schema,
+			"meta": map[string]interface{}{
+				"resourceType": "ResourceType",
+				"location":     BasePath + "/ResourceTypes/" + name,
+			},
+		}
+	}
+
+	return []Resource{
+		resourceType("User", SchemaUser),
+		resourceType("Group", SchemaGroup),
+	}
+}
+
+// attribute returns the definition of a simple attribute. Attributes
+// are optional, single-valued, readWrite and case insensitive unless
+// changed by the caller.
+func attribute(name, typ string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"type":        typ,
+		"multiValued": false,
+		"required":    false,
+		"caseExact":   false,
+		"mutability":  "readWrite",
+		"returned":    "default",
+		"uniqueness":  "none",
+	}
+}
+
+func with(attr map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	attr[key] = value
+	return attr
+}
+
+func multiValued(name string, mutability string, subs ...map[string]interface{}) map[string]interface{} {
+	attr := attribute(name, "complex")
+	attr["multiValued"] = true
+	attr["mutability"] = mutability
+	attr["subAttributes"] = subs
+	return attr
+}
+
+func schemas() []Resource {
+	schema := func(id, name string, attrs ...map[string]interface{}) Resource {
+		return Resource{
+			"schemas":    []string{SchemaSchema},
+			"id":         id,
+			"name":       name,
+			"attributes": attrs,
+			"meta": map[string]interface{}{
+				"resourceType": "Schema",
+				"location":     BasePath + "/Schemas/" + id,
+			},
+		}
+	}
+
+	return []Resource{
+		schema(SchemaUser, "User",
+			// usernames are case sensitive in IAM.
+			with(with(with(attribute("userName", "string"), "required", true), "uniqueness", "server"), "caseExact", true),
+			attribute("active", "boolean"),
+			with(with(attribute("password", "string"), "mutability", "writeOnly"), "returned", "never"),
+			multiValued("emails", "readWrite",
+				attribute("value", "string"),
+				attribute("primary", "boolean"),
+			),
+			multiValued("groups", "readOnly",
+				attribute("value", "string"),
+				attribute("display", "string"),
+				attribute("$ref", "reference"),
+			),
+		),
+		schema(SchemaGroup, "Group",
+			with(with(attribute("displayName", "string"), "required", true), "uniqueness", "server"),
+			multiValued("members", "readWrite",
+				attribute("value", "string"),
+				attribute("display", "string"),
+				attribute("$ref", "reference"),
+			),
+		),
+	}
+}
@@ -0,0 +1,143 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+type listRequest struct {
+	Query Query
+}
+
+func makeListEndpoint(list func(context.Context, Query) (ListResponse, error)) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listRequest)
+		return list(ctx, req.Query)
+	}
+}
+
+type getRequest struct {
+	ID string
+}
+
+// resourceResponse is returned by all endpoints that return a single
+// resource.
+type resourceResponse struct {
+	Resource Resource
+	Status   int
+}
+
+func (r resourceResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Resource)
+}
+
+func (r resourceResponse) StatusCode() int {
+	if r.Status == 0 {
+		return http.StatusOK
+	}
+	return r.Status
+}
+
+// Headers returns the Location header of created resources.
+func (r resourceResponse) Headers() http.Header {
+	h := make(http.Header)
+
+	meta, _ := r.Resource["meta"].(map[string]interface{})
+	if location, ok := meta["location"].(string); ok && r.Status == http.StatusCreated {
+		h.Set("Location", location)
+	}
+
+	return h
+}
+
+func makeGetEndpoint(get func(context.Context, string) (Resource, error)) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getRequest)
+
+		r, err := get(ctx, req.ID)
+		if err != nil {
+			return nil, err
+		}
+		return resourceResponse{Resource: r}, nil
+	}
+}
+
+type createRequest struct {
+	Resource Resource
+}
+
+func makeCreateEndpoint(create func(context.Context, Resource) (Resource, error)) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(createRequest)
+
+		r, err := create(ctx, req.Resource)
+		if err != nil {
+			return nil, err
+		}
+		return resourceResponse{Resource: r, Status: http.StatusCreated}, nil
+	}
+}
+
+type replaceRequest struct {
+	ID       string
+	Resource Resource
+}
+
+func makeReplaceEndpoint(replace func(context.Context, string, Resource) (Resource, error)) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(replaceRequest)
+
+		r, err := replace(ctx, req.ID, req.Resource)
+		if err != nil {
+			return nil, err
+		}
+		return resourceResponse{Resource: r}, nil
+	}
+}
+
+type patchRequest struct {
+	ID         string
+	Operations []PatchOperation
+}
+
+func makePatchEndpoint(patch func(context.Context, string, []PatchOperation) (Resource, error)) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(patchRequest)
+
+		r, err := patch(ctx, req.ID, req.Operations)
+		if err != nil {
+			return nil, err
+		}
+		return resourceResponse{Resource: r}, nil
+	}
+}
+
+type deleteRequest struct {
+	ID string
+}
+
+type deleteResponse struct{}
+
+func (deleteResponse) StatusCode() int {
+	return http.StatusNoContent
+}
+
+func makeDeleteEndpoint(del func(context.Context, string) error) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deleteRequest)
+
+		if err := del(ctx, req.ID); err != nil {
+			return nil, err
+		}
+		return deleteResponse{}, nil
+	}
+}
+
+func makeDocumentEndpoint(doc interface{}) endpoint.Endpoint {
+	return func(context.Context, interface{}) (interface{}, error) {
+		return doc, nil
+	}
+}
@@ -0,0 +1,161 @@
+package scim
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var bg = context.Background()
+
+func Test_ListEndpoint(t *testing.T) {
+	s := &serviceMock{}
+	ep := makeListEndpoint(s.ListUsers)
+
+	q := Query{Filter: `userName eq "alice"`, StartIndex: 1, Count: 10}
+	s.On("ListUsers", q).Once().Return(listOf(nil), nil)
+
+	res, err := ep(bg, listRequest{Query: q})
+	assert.NoError(t, err)
+	assert.Equal(t, listOf(nil), res)
+	s.AssertExpectations(t)
+}
+
+func Test_GetEndpoint(t *testing.T) {
+	s := &serviceMock{}
+	ep := makeGetEndpoint(s.GetUser)
+
+	alice := Resource{"id": "10", "userName": "alice"}
+	s.On("GetUser", "10").Once().Return(alice, nil)
+	s.On("GetUser", "11").Once().Return(Resource(nil), notFound("User", "11"))
+
+	res, err := ep(bg, getRequest{ID: "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, resourceResponse{Resource: alice}, res)
+	assert.Equal(t, http.StatusOK, res.(resourceResponse).StatusCode())
+
+	res, err = ep(bg, getRequest{ID: "11"})
+	assert.Error(t, err)
+	assert.Nil(t, res)
+	s.AssertExpectations(t)
+}
+
+func Test_CreateEndpoint(t *testing.T) {
+	s := &serviceMock{}
+	ep := makeCreateEndpoint(s.CreateUser)
+
+	req := Resource{"userName": "alice"}
+	created := Resource{
+		"id":       "10",
+		"userName": "alice",
+		"meta":     map[string]interface{}{"location": "/scim/v2/Users/10"},
+	}
+	s.On("CreateUser", req).Once().Return(created, nil)
+
+	res, err := ep(bg, createRequest{Resource: req})
+	assert.NoError(t, err)
+
+	r := res.(resourceResponse)
+	assert.Equal(t, http.StatusCreated, r.StatusCode())
+	assert.Equal(t, "/scim/v2/Users/10", r.Headers().Get("Location"))
+	s.AssertExpectations(t)
+
+	// only created resources have a location header
+	assert.Empty(t, resourceResponse{Resource: created}.Headers().Get("Location"))
+}
+
+func Test_ReplaceAndPatchEndpoints(t *testing.T) {
+	s := &serviceMock{}
+
+	vets := Resource{"id": "vets", "displayName": "vets"}
+	s.On("ReplaceGroup", "vets", vets).Once().Return(vets, nil)
+	res, err := makeReplaceEndpoint(s.ReplaceGroup)(bg, replaceRequest{ID: "vets", Resource: vets})
+	assert.NoError(t, err)
+	assert.Equal(t, resourceResponse{Resource: vets}, res)
+
+	ops := []PatchOperation{{Op: "remove", Path: "members"}}
+	s.On("PatchGroup", "vets", ops).Once().Return(Resource(nil), invalidValue("simulated"))
+	res, err = makePatchEndpoint(s.PatchGroup)(bg, patchRequest{ID: "vets", Operations: ops})
+	assert.Error(t, err)
+	assert.Nil(t, res)
+
+	s.AssertExpectations(t)
+}
+
+func Test_DeleteEndpoint(t *testing.T) {
+	s := &serviceMock{}
+	ep := makeDeleteEndpoint(s.DeleteUser)
+
+	s.On("DeleteUser", "10").Once().Return(nil)
+	s.On("DeleteUser", "11").Once().Return(notFound("User", "11"))
+
+	res, err := ep(bg, deleteRequest{ID: "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.(deleteResponse).StatusCode())
+
+	_, err = ep(bg, deleteRequest{ID: "11"})
+	assert.Error(t, err)
+	s.AssertExpectations(t)
+}
+
+type serviceMock struct {
+	mock.Mock
+}
+
+func (s *serviceMock) resource(args mock.Arguments) (Resource, error) {
+	r, _ := args.Get(0).(Resource)
+	return r, args.Error(1)
+}
+
+func (s *serviceMock) ListUsers(_ context.Context, q Query) (ListResponse, error) {
+	args := s.Called(q)
+	return args.Get(0).(ListResponse), args.Error(1)
+}
+
+func (s *serviceMock) GetUser(_ context.Context, id string) (Resource, error) {
+	return s.resource(s.Called(id))
+}
+
+func (s *serviceMock) CreateUser(_ context.Context, r Resource) (Resource, error) {
+	return s.resource(s.Called(r))
+}
+
+func (s *serviceMock) ReplaceUser(_ context.Context, id string, r Resource) (Resource, error) {
+	return s.resource(s.Called(id, r))
+}
+
+func (s *serviceMock) PatchUser(_ context.Context, id string, ops []PatchOperation) (Resource, error) {
+	return s.resource(s.Called(id, ops))
+}
+
+func (s *serviceMock) DeleteUser(_ context.Context, id string) error {
+	return s.Called(id).Error(0)
+}
+
+func (s *serviceMock) ListGroups(_ context.Context, q Query) (ListResponse, error) {
+	args := s.Called(q)
+	return args.Get(0).(ListResponse), args.Error(1)
+}
+
+func (s *serviceMock) GetGroup(_ context.Context, id string) (Resource, error) {
+	return s.resource(s.Called(id))
+}
+
+func (s *serviceMock) CreateGroup(_ context.Context, r Resource) (Resource, error) {
+	return s.resource(s.Called(r))
+}
+
+func (s *serviceMock) ReplaceGroup(_ context.Context, id string, r Resource) (Resource, error) {
+	return s.resource(s.Called(id, r))
+}
+
+func (s *serviceMock) PatchGroup(_ context.Context, id string, ops []PatchOperation) (Resource, error) {
+	return s.resource(s.Called(id, ops))
+}
+
+func (s *serviceMock) DeleteGroup(_ context.Context, id string) error {
+	return s.Called(id).Error(0)
+}
@@ -0,0 +1,438 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// attrPath references a (sub-)attribute of a resource. Attributes of
+// schema extensions are referenced by prefixing them with the URN of
+// the extension, for example
+// "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department".
+type attrPath struct {
+	Schema string
+	Attr   string
+	Sub    string
+}
+
+func parseAttrPath(s string) (attrPath, error) {
+	var p attrPath
+
+	if strings.HasPrefix(strings.ToLower(s), "urn:") {
+		idx := strings.LastIndex(s, ":")
+		p.Schema, s = s[:idx], s[idx+1:]
+
+		// attributes of the core schemas may be fully qualified as well.
+		if strings.EqualFold(p.Schema, SchemaUser) || strings.EqualFold(p.Schema, SchemaGroup) {
+			p.Schema = ""
+		}
+	}
+
+	parts := strings.SplitN(s, ".", 2)
+	p.Attr = parts[0]
+	if len(parts) == 2 {
+		p.Sub = parts[1]
+		if !isAttrName(p.Sub) {
+			return attrPath{}, fmt.Errorf("invalid attribute path %q", s)
+		}
+	}
+
+	if !isAttrName(p.Attr) {
+		return attrPath{}, fmt.Errorf("invalid attribute path %q", s)
+	}
+
+	return p, nil
+}
+
+func isAttrName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_' || r == '-' || r == '$':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// lookupKey returns the key of m that matches name. Attribute names
+// are case insensitive. If m does not contain name, name is returned.
+func lookupKey(m map[string]interface{}, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+// caseExact reports whether values of the attribute referenced by p are
+// case sensitive. Only usernames are case sensitive.
+func (p attrPath) caseExact() bool {
+	return p.Schema == "" && p.Sub == "" && strings.EqualFold(p.Attr, "userName")
+}
+
+// container returns the map holding the attribute referenced by p. If
+// create is set, missing extension objects are created.
+func (p attrPath) container(r map[string]interface{}, create bool) map[string]interface{} {
+	if p.Schema == "" {
+		return r
+	}
+
+	key := lookupKey(r, p.Schema)
+	ext, ok := r[key].(map[string]interface{})
+	if !ok && create {
+		ext = make(map[string]interface{})
+		r[key] = ext
+	}
+	return ext
+}
+
+// values returns all values of the attribute referenced by p. Values of
+// multi-valued attributes are flattened.
+func (p attrPath) values(r map[string]interface{}) []interface{} {
+	c := p.container(r, false)
+	if c == nil {
+		return nil
+	}
+
+	var values []interface{}
+	add := func(v interface{}) {
+		if p.Sub == "" {
+			values = append(values, v)
+			return
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			if sub, ok := m[lookupKey(m, p.Sub)]; ok {
+				values = append(values, sub)
+			}
+		}
+	}
+
+	v, ok := c[lookupKey(c, p.Attr)]
+	if !ok {
+		return nil
+	}
+
+	if list, ok := v.([]interface{}); ok {
+		for _, elem := range list {
+			add(elem)
+		}
+	} else {
+		add(v)
+	}
+
+	return values
+}
+
+// filter is a parsed SCIM filter expression. It matches if all
+// comparisons of any of its terms match. Grouping and the "not"
+// operator are not supported.
+type filter [][]comparison
+
+type comparison struct {
+	path  attrPath
+	op    string
+	value interface{}
+}
+
+var filterOperators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+func invalidFilter(format string, args ...interface{}) *Error {
+	return newError(http.StatusBadRequest, ErrorTypeInvalidFilter, format, args...)
+}
+
+// parseFilter parses a filter expression like `userName eq "alice"`.
+func parseFilter(s string) (filter, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result filter
+		term   []comparison
+	)
+
+	for len(tokens) > 0 {
+		if len(tokens) < 2 || tokens[0].quoted || tokens[1].quoted {
+			return nil, invalidFilter("incomplete filter expression %q", s)
+		}
+
+		path, err := parseAttrPath(tokens[0].text)
+		if err != nil {
+			return nil, invalidFilter("%s", err)
+		}
+
+		c := comparison{path: path, op: strings.ToLower(tokens[1].text)}
+		if !filterOperators[c.op] {
+			return nil, invalidFilter("unsupported operator %q", tokens[1].text)
+		}
+		tokens = tokens[2:]
+
+		if c.op != "pr" {
+			if len(tokens) == 0 {
+				return nil, invalidFilter("missing value for operator %q", c.op)
+			}
+
+			c.value, err = tokens[0].value()
+			if err != nil {
+				return nil, err
+			}
+			tokens = tokens[1:]
+		}
+
+		term = append(term, c)
+
+		if len(tokens) == 0 {
+			break
+		}
+
+		switch strings.ToLower(tokens[0].text) {
+		case "and":
+		case "or":
+			result = append(result, term)
+			term = nil
+		default:
+			return nil, invalidFilter("unexpected %q", tokens[0].text)
+		}
+
+		tokens = tokens[1:]
+		if len(tokens) == 0 {
+			return nil, invalidFilter("incomplete filter expression %q", s)
+		}
+	}
+
+	if len(term) == 0 {
+		return nil, invalidFilter("empty filter")
+	}
+
+	return append(result, term), nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func (t token) value() (interface{}, error) {
+	if t.quoted {
+		return t.text, nil
+	}
+
+	switch strings.ToLower(t.text) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	f, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return nil, invalidFilter("invalid value %q", t.text)
+	}
+	return f, nil
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ':
+			i++
+
+		case s[i] == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, invalidFilter("unterminated string in %q", s)
+			}
+
+			var str string
+			if err := json.Unmarshal([]byte(s[i:end+1]), &str); err != nil {
+				return nil, invalidFilter("invalid string in %q", s)
+			}
+
+			tokens = append(tokens, token{text: str, quoted: true})
+			i = end + 1
+
+		default:
+			end := strings.IndexByte(s[i:], ' ')
+			if end < 0 {
+				end = len(s) - i
+			}
+			tokens = append(tokens, token{text: s[i : i+end]})
+			i += end
+		}
+	}
+
+	return tokens, nil
+}
+
+// userNameEq returns the value of the userName eq comparison required by
+// f. Filters with multiple terms are never reported.
+func (f filter) userNameEq() (string, bool) {
+	if len(f) != 1 {
+		return "", false
+	}
+
+	for _, c := range f[0] {
+		if c.op != "eq" || !c.path.caseExact() {
+			continue
+		}
+		if name, ok := c.value.(string); ok {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// match reports whether r matches f.
+func (f filter) match(r map[string]interface{}) bool {
+	for _, term := range f {
+		matched := true
+		for _, c := range term {
+			if !c.match(r) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparison) match(r map[string]interface{}) bool {
+	values := c.path.values(r)
+	caseExact := c.path.caseExact()
+
+	switch c.op {
+	case "pr":
+		for _, v := range values {
+			if v != nil && v != "" {
+				return true
+			}
+		}
+		return false
+
+	case "ne":
+		for _, v := range values {
+			if compare(v, "eq", c.value, caseExact) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, v := range values {
+		if compare(v, c.op, c.value, caseExact) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare compares v to want using op. Strings are compared case
+// insensitive unless caseExact is set.
+func compare(v interface{}, op string, want interface{}, caseExact bool) bool {
+	switch w := want.(type) {
+	case nil:
+		return op == "eq" && v == nil
+
+	case bool:
+		b, ok := v.(bool)
+		return ok && op == "eq" && b == w
+
+	case float64:
+		f, ok := toFloat(v)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return f == w
+		case "gt":
+			return f > w
+		case "ge":
+			return f >= w
+		case "lt":
+			return f < w
+		case "le":
+			return f <= w
+		}
+		return false
+
+	case string:
+		if v == nil {
+			return false
+		}
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		if !caseExact {
+			s, w = strings.ToLower(s), strings.ToLower(w)
+		}
+
+		switch op {
+		case "eq":
+			return s == w
+		case "co":
+			return strings.Contains(s, w)
+		case "sw":
+			return strings.HasPrefix(s, w)
+		case "ew":
+			return strings.HasSuffix(s, w)
+		case "gt":
+			return s > w
+		case "ge":
+			return s >= w
+		case "lt":
+			return s < w
+		case "le":
+			return s <= w
+		}
+	}
+
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
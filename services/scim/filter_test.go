@@ -0,0 +1,105 @@
+package scim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+	r := map[string]interface{}{
+		"userName": "Alice",
+		"active":   true,
+		"age":      float64(42),
+		"emails": []interface{}{
+			map[string]interface{}{"value": "alice@example.com", "primary": true},
+			map[string]interface{}{"value": "alice@work.example.com"},
+		},
+		"urn:example:params:scim:schemas:extension:1.0:User": map[string]interface{}{
+			"department": "Surgery",
+		},
+	}
+
+	cases := []struct {
+		filter string
+		match  bool
+	}{
+		{`userName eq "Alice"`, true},
+		{`UserName Eq "Alice"`, true},
+		{`userName eq "alice"`, false},
+		{`userName eq "bob"`, false},
+		{`userName ne "bob"`, true},
+		{`userName sw "Al"`, true},
+		{`userName ew "ce"`, true},
+		{`userName co "lic"`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`age gt 40`, true},
+		{`age le 40`, false},
+		{`title pr`, false},
+		{`userName pr`, true},
+		{`emails.value eq "alice@work.example.com"`, true},
+		{`emails ew "example.com"`, false},
+		{`urn:example:params:scim:schemas:extension:1.0:User:department eq "surgery"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "Alice"`, true},
+		{`userName eq "bob" or userName eq "Alice"`, true},
+		{`userName eq "Alice" and active eq false`, false},
+		{`userName eq "a \"quoted\" name"`, false},
+	}
+
+	for _, c := range cases {
+		f, err := parseFilter(c.filter)
+		if !assert.NoError(t, err, c.filter) {
+			continue
+		}
+		assert.Equal(t, c.match, f.match(r), c.filter)
+	}
+}
+
+func TestFilter_userNameEq(t *testing.T) {
+	cases := []struct {
+		filter string
+		name   string
+		ok     bool
+	}{
+		{`userName eq "alice"`, "alice", true},
+		{`active eq true and UserName eq "alice"`, "alice", true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "alice"`, "alice", true},
+		{`userName sw "al"`, "", false},
+		{`userName eq "bob" or userName eq "alice"`, "", false},
+		{`emails.value eq "alice@example.com"`, "", false},
+	}
+
+	for _, c := range cases {
+		f, err := parseFilter(c.filter)
+		if !assert.NoError(t, err, c.filter) {
+			continue
+		}
+		name, ok := f.userNameEq()
+		assert.Equal(t, c.ok, ok, c.filter)
+		assert.Equal(t, c.name, name, c.filter)
+	}
+
+	var none filter
+	_, ok := none.userNameEq()
+	assert.False(t, ok)
+}
+
+func TestParseFilter_Invalid(t *testing.T) {
+	for _, s := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName xx "alice"`,
+		`userName eq alice`,
+		`userName eq "alice" and`,
+		`userName eq "alice" nand active eq true`,
+		`userName eq "alice`,
+		`(userName eq "alice")`,
+	} {
+		_, err := parseFilter(s)
+		if assert.Error(t, err, s) {
+			assert.Equal(t, ErrorTypeInvalidFilter, err.(*Error).Type, s)
+		}
+	}
+}
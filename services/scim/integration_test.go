@@ -0,0 +1,222 @@
+package scim_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iamtest"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/scim"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+type testbed struct {
+	*iamtest.Authn
+	client iamtest.Client
+}
+
+func newTestbed(t *testing.T, authz enforcer.Enforcer) *testbed {
+	a := iamtest.NewAuthn(t)
+
+	members := inmem.NewMembershipRepository()
+	us := user.NewService(inmem.NewUserRepository(), a.Service, nil)
+	gs := group.NewService(us, inmem.NewGroupRepository(), members, log.NewNopLogger())
+
+	s := scim.NewService(us, gs, members, authz)
+	srv := a.Serve(scim.MakeHandler(s, a.Service.ExtractTokenSubject, log.NewNopLogger()))
+
+	_, token := a.AccountToken("admin")
+
+	return &testbed{
+		Authn: a,
+		client: iamtest.Client{
+			URL:         srv.URL + scim.BasePath,
+			Token:       token,
+			ContentType: scim.ContentType,
+		},
+	}
+}
+
+// do sends a SCIM request and checks the content type of the response.
+func (tb *testbed) do(t *testing.T, method, path string, body interface{}) (int, map[string]interface{}) {
+	var result map[string]interface{}
+	res := tb.client.Do(t, method, path, body, &result)
+	if res.StatusCode != http.StatusNoContent {
+		assert.Equal(t, scim.ContentType, res.Header.Get("Content-Type"))
+	}
+
+	return res.StatusCode, result
+}
+
+func TestIntegration_Users(t *testing.T) {
+	tb := newTestbed(t, enforcer.NewNoOpEnforcer())
+	defer tb.Close()
+
+	status, alice := tb.do(t, "POST", "/Users", map[string]interface{}{
+		"schemas":  []string{scim.SchemaUser},
+		"userName": "alice",
+		"password": "secret",
+		"emails":   []interface{}{map[string]interface{}{"value": "alice@example.com", "primary": true}},
+		"title":    "Vet",
+	})
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, "alice", alice["userName"])
+	assert.Equal(t, true, alice["active"])
+	assert.Equal(t, "Vet", alice["title"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"value": "alice@example.com", "primary": true}}, alice["emails"])
+
+	id := alice["id"].(string)
+	accountID, _ := strconv.Atoi(id)
+	assert.True(t, tb.CheckPassword(accountID, "secret"))
+
+	// duplicate usernames
+	status, body := tb.do(t, "POST", "/Users", map[string]interface{}{"userName": "alice"})
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "uniqueness", body["scimType"])
+	assert.Equal(t, "409", body["status"])
+
+	status, body = tb.do(t, "GET", "/Users?filter="+`userName+eq+"alice"`, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1), body["totalResults"])
+	assert.Equal(t, id, body["Resources"].([]interface{})[0].(map[string]interface{})["id"])
+
+	status, body = tb.do(t, "GET", "/Users?startIndex=2&count=1", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1), body["totalResults"])
+	assert.Equal(t, float64(0), body["itemsPerPage"])
+
+	status, body = tb.do(t, "GET", "/Users?filter=userName+xx", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalidFilter", body["scimType"])
+
+	status, body = tb.do(t, "PATCH", "/Users/"+id, map[string]interface{}{
+		"schemas": []string{scim.SchemaPatchOp},
+		"Operations": []interface{}{
+			map[string]interface{}{"op": "replace", "path": "userName", "value": "alice.smith"},
+			map[string]interface{}{"op": "replace", "path": "active", "value": "False"},
+			map[string]interface{}{"op": "remove", "path": "title"},
+		},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "alice.smith", body["userName"])
+	assert.Equal(t, false, body["active"])
+	assert.Nil(t, body["title"])
+
+	account, _ := tb.Account(accountID)
+	assert.Equal(t, "alice.smith", account.Username)
+	assert.True(t, account.Locked)
+
+	status, body = tb.do(t, "PUT", "/Users/"+id, map[string]interface{}{
+		"userName": "alice.smith",
+		"active":   true,
+		"password": "new-secret",
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, true, body["active"])
+	assert.Nil(t, body["emails"])
+	assert.True(t, tb.CheckPassword(accountID, "new-secret"))
+
+	status, _ = tb.do(t, "DELETE", "/Users/"+id, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, body = tb.do(t, "GET", "/Users/"+id, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "404", body["status"])
+}
+
+func TestIntegration_Groups(t *testing.T) {
+	tb := newTestbed(t, enforcer.NewNoOpEnforcer())
+	defer tb.Close()
+
+	_, alice := tb.do(t, "POST", "/Users", map[string]interface{}{"userName": "alice"})
+	_, bob := tb.do(t, "POST", "/Users", map[string]interface{}{"userName": "bob"})
+	aliceID, bobID := alice["id"].(string), bob["id"].(string)
+
+	status, vets := tb.do(t, "POST", "/Groups", map[string]interface{}{
+		"displayName": "vets",
+		"members":     []interface{}{map[string]interface{}{"value": aliceID}},
+	})
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, "vets", vets["id"])
+	assert.Len(t, vets["members"], 1)
+
+	status, _ = tb.do(t, "POST", "/Groups", map[string]interface{}{"displayName": "vets"})
+	assert.Equal(t, http.StatusConflict, status)
+
+	status, body := tb.do(t, "PATCH", "/Groups/vets", map[string]interface{}{
+		"Operations": []interface{}{
+			map[string]interface{}{"op": "add", "path": "members", "value": []interface{}{map[string]interface{}{"value": bobID}}},
+			map[string]interface{}{"op": "remove", "path": `members[value eq "` + aliceID + `"]`},
+		},
+	})
+	require.Equal(t, http.StatusOK, status)
+	members := body["members"].([]interface{})
+	require.Len(t, members, 1)
+	assert.Equal(t, bobID, members[0].(map[string]interface{})["value"])
+
+	_, body = tb.do(t, "GET", "/Users/"+bobID, nil)
+	assert.Equal(t, "vets", body["groups"].([]interface{})[0].(map[string]interface{})["value"])
+
+	status, body = tb.do(t, "PUT", "/Groups/vets", map[string]interface{}{"displayName": "nurses"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "mutability", body["scimType"])
+
+	status, body = tb.do(t, "PUT", "/Groups/vets", map[string]interface{}{
+		"displayName": "vets",
+		"members":     []interface{}{map[string]interface{}{"value": "999"}},
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalidValue", body["scimType"])
+
+	status, body = tb.do(t, "GET", "/Groups?filter="+`displayName+eq+"vets"`, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1), body["totalResults"])
+
+	status, _ = tb.do(t, "DELETE", "/Groups/vets", nil)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, _ = tb.do(t, "GET", "/Groups/vets", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestIntegration_Authorization(t *testing.T) {
+	tb := newTestbed(t, iamtest.DenyActions{user.ActionSetPassword: true, group.ActionGroupRead: true})
+	defer tb.Close()
+
+	status, alice := tb.do(t, "POST", "/Users", map[string]interface{}{"userName": "alice"})
+	require.Equal(t, http.StatusCreated, status)
+
+	status, body := tb.do(t, "PATCH", "/Users/"+alice["id"].(string), map[string]interface{}{
+		"Operations": []interface{}{
+			map[string]interface{}{"op": "add", "path": "password", "value": "secret"},
+		},
+	})
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "403", body["status"])
+
+	status, _ = tb.do(t, "GET", "/Groups", nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	tb.client.Token = ""
+	status, _ = tb.do(t, "GET", "/Users", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// discovery endpoints are public
+	status, body = tb.do(t, "GET", "/ServiceProviderConfig", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"supported": true}, body["patch"])
+
+	status, body = tb.do(t, "GET", "/ResourceTypes", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(2), body["totalResults"])
+
+	status, body = tb.do(t, "GET", "/Schemas/"+scim.SchemaUser, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "User", body["name"])
+}
@@ -0,0 +1,169 @@
+package scim
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type loggingService struct {
+	l log.Logger
+	Service
+}
+
+// NewLoggingService returns a service that logs method calls of Service
+func NewLoggingService(s Service, logger log.Logger) Service {
+	return &loggingService{
+		l:       logger,
+		Service: s,
+	}
+}
+
+func (s *loggingService) ListUsers(ctx context.Context, q Query) (res ListResponse, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "list_users",
+			"filter", q.Filter,
+			"results", res.TotalResults,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.ListUsers(ctx, q)
+}
+
+func (s *loggingService) GetUser(ctx context.Context, id string) (r Resource, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "get_user",
+			"id", id,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.GetUser(ctx, id)
+}
+
+func (s *loggingService) CreateUser(ctx context.Context, res Resource) (r Resource, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "create_user",
+			"id", r["id"],
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.CreateUser(ctx, res)
+}
+
+func (s *loggingService) ReplaceUser(ctx context.Context, id string, res Resource) (r Resource, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "replace_user",
+			"id", id,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.ReplaceUser(ctx, id, res)
+}
+
+func (s *loggingService) PatchUser(ctx context.Context, id string, ops []PatchOperation) (r Resource, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "patch_user",
+			"id", id,
+			"operations", len(ops),
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.PatchUser(ctx, id, ops)
+}
+
+func (s *loggingService) DeleteUser(ctx context.Context, id string) (err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "delete_user",
+			"id", id,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.DeleteUser(ctx, id)
+}
+
+func (s *loggingService) ListGroups(ctx context.Context, q Query) (res ListResponse, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "list_groups",
+			"filter", q.Filter,
+			"results", res.TotalResults,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.ListGroups(ctx, q)
+}
+
+func (s *loggingService) GetGroup(ctx context.Context, id string) (r Resource, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "get_group",
+			"id", id,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.GetGroup(ctx, id)
+}
+
+func (s *loggingService) CreateGroup(ctx context.Context, res Resource) (r Resource, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "create_group",
+			"id", r["id"],
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.CreateGroup(ctx, res)
+}
+
+func (s *loggingService) ReplaceGroup(ctx context.Context, id string, res Resource) (r Resource, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "replace_group",
+			"id", id,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.ReplaceGroup(ctx, id, res)
+}
+
+func (s *loggingService) PatchGroup(ctx context.Context, id string, ops []PatchOperation) (r Resource, err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "patch_group",
+			"id", id,
+			"operations", len(ops),
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.PatchGroup(ctx, id, ops)
+}
+
+func (s *loggingService) DeleteGroup(ctx context.Context, id string) (err error) {
+	defer func(begin time.Time) {
+		s.l.Log(
+			"method", "delete_group",
+			"id", id,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.DeleteGroup(ctx, id)
+}
@@ -0,0 +1,307 @@
+package scim
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// EmailAttr is the user attribute that is exposed as the primary value
+// of the SCIM "emails" attribute.
+const EmailAttr = "email"
+
+// userSpec is the IAM representation of a SCIM user resource.
+type userSpec struct {
+	UserName   string
+	Active     *bool
+	Password   string
+	Attributes map[string]interface{}
+}
+
+// groupSpec is the IAM representation of a SCIM group resource.
+type groupSpec struct {
+	DisplayName string
+	Members     []iam.UserURN
+}
+
+func userLocation(id string) string {
+	return BasePath + "/Users/" + id
+}
+
+func groupLocation(id string) string {
+	return BasePath + "/Groups/" + id
+}
+
+func userURN(id string) (iam.UserURN, bool) {
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", false
+	}
+	return iam.UserURN("urn:iam::user/" + id), true
+}
+
+func groupURN(id string) iam.GroupURN {
+	return iam.GroupURN("urn:iam::group/" + id)
+}
+
+// userToResource converts u to a SCIM user resource. Attributes of u are
+// exposed as SCIM attributes using the same name. Attributes named after
+// a schema URN are exposed as schema extensions.
+func userToResource(u iam.User, groups []iam.GroupURN) Resource {
+	id := strconv.Itoa(u.AccountID)
+	schemas := []string{}
+
+	r := Resource{}
+	for key, value := range u.Attributes {
+		if key == EmailAttr {
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(key), "urn:") {
+			schemas = append(schemas, key)
+		}
+		r[key] = value
+	}
+	sort.Strings(schemas)
+
+	r["schemas"] = append([]string{SchemaUser}, schemas...)
+	r["id"] = id
+	r["userName"] = u.Username
+	r["active"] = u.Locked == nil || !*u.Locked
+	r["meta"] = resourceMeta("User", userLocation(id), u.Metadata)
+
+	if email, ok := u.Attributes[EmailAttr].(string); ok && email != "" {
+		r["emails"] = []interface{}{
+			map[string]interface{}{"value": email, "primary": true},
+		}
+	}
+
+	if len(groups) > 0 {
+		sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+
+		var values []interface{}
+		for _, grp := range groups {
+			name := grp.GroupName()
+			values = append(values, map[string]interface{}{
+				"value":   name,
+				"display": name,
+				"$ref":    groupLocation(name),
+			})
+		}
+		r["groups"] = values
+	}
+
+	return normalize(r)
+}
+
+// resourceToUser converts the SCIM user resource r to its IAM
+// representation. Read-only attributes are ignored.
+func resourceToUser(r Resource) (userSpec, error) {
+	spec := userSpec{
+		Attributes: make(map[string]interface{}),
+	}
+
+	var apply func(key string, value interface{}) error
+	apply = func(key string, value interface{}) error {
+		switch strings.ToLower(key) {
+		case "schemas", "id", "meta", "groups":
+			// read-only or server managed
+
+		case "username":
+			s, ok := value.(string)
+			if !ok {
+				return invalidValue("userName must be a string")
+			}
+			spec.UserName = s
+
+		case "password":
+			s, ok := value.(string)
+			if !ok {
+				return invalidValue("password must be a string")
+			}
+			spec.Password = s
+
+		case "active":
+			active, ok := parseBool(value)
+			if !ok {
+				return invalidValue("active must be a boolean")
+			}
+			spec.Active = &active
+
+		case "emails":
+			if email := primaryValue(value); email != "" {
+				spec.Attributes[EmailAttr] = email
+			}
+
+		case strings.ToLower(SchemaUser):
+			// core attributes may be nested below the
+			// schema URN.
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				return invalidValue("%s must be an object", key)
+			}
+			for k, v := range m {
+				if err := apply(k, v); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if value != nil {
+				spec.Attributes[key] = value
+			}
+		}
+
+		return nil
+	}
+
+	for key, value := range r {
+		if err := apply(key, value); err != nil {
+			return userSpec{}, err
+		}
+	}
+
+	if spec.UserName == "" {
+		return userSpec{}, invalidValue("userName is required")
+	}
+
+	return spec, nil
+}
+
+// resourceMeta returns the SCIM meta attribute of a resource. The
+// creation and modification times are only included if known.
+func resourceMeta(resourceType, location string, m iam.Metadata) map[string]interface{} {
+	meta := map[string]interface{}{
+		"resourceType": resourceType,
+		"location":     location,
+	}
+	if !m.CreatedAt.IsZero() {
+		meta["created"] = m.CreatedAt.Format(time.RFC3339)
+	}
+	if !m.UpdatedAt.IsZero() {
+		meta["lastModified"] = m.UpdatedAt.Format(time.RFC3339)
+	}
+
+	return meta
+}
+
+// groupToResource converts g to a SCIM group resource. Groups are
+// identified by their name.
+func groupToResource(g iam.Group, members []iam.UserURN) Resource {
+	r := Resource{
+		"schemas":     []string{SchemaGroup},
+		"id":          g.Name,
+		"displayName": g.Name,
+		"meta":        resourceMeta("Group", groupLocation(g.Name), g.Metadata),
+	}
+
+	if len(members) > 0 {
+		sort.Slice(members, func(i, j int) bool { return members[i] < members[j] })
+
+		var values []interface{}
+		for _, m := range members {
+			id := m.AccountID()
+			values = append(values, map[string]interface{}{
+				"value": id,
+				"$ref":  userLocation(id),
+			})
+		}
+		r["members"] = values
+	}
+
+	return normalize(r)
+}
+
+// resourceToGroup converts the SCIM group resource r to its IAM
+// representation.
+func resourceToGroup(r Resource) (groupSpec, error) {
+	var spec groupSpec
+
+	for key, value := range r {
+		switch strings.ToLower(key) {
+		case "displayname":
+			s, ok := value.(string)
+			if !ok {
+				return groupSpec{}, invalidValue("displayName must be a string")
+			}
+			spec.DisplayName = s
+
+		case "members":
+			list, ok := value.([]interface{})
+			if !ok && value != nil {
+				return groupSpec{}, invalidValue("members must be a list")
+			}
+
+			for _, elem := range list {
+				m, _ := elem.(map[string]interface{})
+				id, _ := m["value"].(string)
+
+				urn, ok := userURN(id)
+				if !ok {
+					return groupSpec{}, invalidValue("invalid member %v", elem)
+				}
+				spec.Members = append(spec.Members, urn)
+			}
+		}
+	}
+
+	if spec.DisplayName == "" {
+		return groupSpec{}, invalidValue("displayName is required")
+	}
+
+	return spec, nil
+}
+
+// primaryValue returns the primary (or first) value of a multi-valued
+// attribute like "emails".
+func primaryValue(v interface{}) string {
+	list, _ := v.([]interface{})
+
+	var first string
+	for _, elem := range list {
+		m, ok := elem.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		value, _ := m["value"].(string)
+		if primary, _ := parseBool(m["primary"]); primary {
+			return value
+		}
+		if first == "" {
+			first = value
+		}
+	}
+
+	return first
+}
+
+// parseBool parses v as a boolean. Some identity providers send
+// booleans as strings.
+func parseBool(v interface{}) (bool, bool) {
+	switch b := v.(type) {
+	case bool:
+		return b, true
+	case string:
+		parsed, err := strconv.ParseBool(b)
+		return parsed, err == nil
+	}
+	return false, false
+}
+
+// normalize converts r to the types used by encoding/json so it
+// can be patched and compared with decoded requests.
+func normalize(r Resource) Resource {
+	blob, err := json.Marshal(r)
+	if err != nil {
+		return r
+	}
+
+	var result Resource
+	if err := json.Unmarshal(blob, &result); err != nil {
+		return r
+	}
+	return result
+}
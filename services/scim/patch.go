@@ -0,0 +1,276 @@
+package scim
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// patchPath is the target of a patch operation. If filter is set, the
+// operation only applies to the values of the multi-valued attribute
+// that match the filter, for example `members[value eq "2"]`.
+type patchPath struct {
+	attrPath
+	filter filter
+}
+
+func invalidPath(format string, args ...interface{}) *Error {
+	return newError(http.StatusBadRequest, ErrorTypeInvalidPath, format, args...)
+}
+
+func noTarget(format string, args ...interface{}) *Error {
+	return newError(http.StatusBadRequest, ErrorTypeNoTarget, format, args...)
+}
+
+func parsePatchPath(s string) (patchPath, error) {
+	open := strings.IndexByte(s, '[')
+	if open < 0 {
+		p, err := parseAttrPath(s)
+		if err != nil {
+			return patchPath{}, invalidPath("%s", err)
+		}
+		return patchPath{attrPath: p}, nil
+	}
+
+	end := strings.LastIndexByte(s, ']')
+	if end < open {
+		return patchPath{}, invalidPath("invalid path %q", s)
+	}
+
+	p, err := parseAttrPath(s[:open])
+	if err != nil || p.Sub != "" {
+		return patchPath{}, invalidPath("invalid path %q", s)
+	}
+
+	f, err := parseFilter(s[open+1 : end])
+	if err != nil {
+		return patchPath{}, err
+	}
+
+	if rest := s[end+1:]; rest != "" {
+		if !strings.HasPrefix(rest, ".") || !isAttrName(rest[1:]) {
+			return patchPath{}, invalidPath("invalid path %q", s)
+		}
+		p.Sub = rest[1:]
+	}
+
+	return patchPath{attrPath: p, filter: f}, nil
+}
+
+// applyPatch applies ops to r as defined by RFC 7644 section 3.5.2.
+func applyPatch(r map[string]interface{}, ops []PatchOperation) error {
+	for _, op := range ops {
+		var err error
+
+		switch strings.ToLower(op.Op) {
+		case "add":
+			err = patchSet(r, op, true)
+		case "replace":
+			err = patchSet(r, op, false)
+		case "remove":
+			err = patchRemove(r, op)
+		default:
+			err = newError(http.StatusBadRequest, ErrorTypeInvalidSyntax, "unsupported patch operation %q", op.Op)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func patchSet(r map[string]interface{}, op PatchOperation, add bool) error {
+	if op.Path != "" {
+		p, err := parsePatchPath(op.Path)
+		if err != nil {
+			return err
+		}
+		return set(r, p, op.Value, add)
+	}
+
+	// Without a path, value contains the attributes to set.
+	values, ok := op.Value.(map[string]interface{})
+	if !ok {
+		return invalidValue("patch operation without path requires an object value")
+	}
+
+	for key, value := range values {
+		if ext, ok := value.(map[string]interface{}); ok && strings.HasPrefix(strings.ToLower(key), "urn:") {
+			for attr, v := range ext {
+				if err := set(r, patchPath{attrPath: attrPath{Schema: key, Attr: attr}}, v, add); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		p, err := parsePatchPath(key)
+		if err != nil {
+			return err
+		}
+		if err := set(r, p, value, add); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func set(r map[string]interface{}, p patchPath, value interface{}, add bool) error {
+	c := p.container(r, true)
+	key := lookupKey(c, p.Attr)
+
+	if p.filter != nil {
+		list, _ := c[key].([]interface{})
+
+		matched := false
+		for i, elem := range list {
+			m, ok := elem.(map[string]interface{})
+			if !ok || !p.filter.match(m) {
+				continue
+			}
+			matched = true
+
+			if p.Sub == "" {
+				list[i] = value
+			} else {
+				m[lookupKey(m, p.Sub)] = value
+			}
+		}
+
+		if !matched {
+			return noTarget("no value of %q matches the filter", p.Attr)
+		}
+		return nil
+	}
+
+	if p.Sub != "" {
+		switch existing := c[key].(type) {
+		case []interface{}:
+			for _, elem := range existing {
+				if m, ok := elem.(map[string]interface{}); ok {
+					m[lookupKey(m, p.Sub)] = value
+				}
+			}
+		case map[string]interface{}:
+			existing[lookupKey(existing, p.Sub)] = value
+		default:
+			c[key] = map[string]interface{}{p.Sub: value}
+		}
+		return nil
+	}
+
+	if add {
+		switch existing := c[key].(type) {
+		case []interface{}:
+			if values, ok := value.([]interface{}); ok {
+				for _, v := range values {
+					if !contains(existing, v) {
+						existing = append(existing, v)
+					}
+				}
+				c[key] = existing
+				return nil
+			}
+		case map[string]interface{}:
+			if values, ok := value.(map[string]interface{}); ok {
+				for k, v := range values {
+					existing[lookupKey(existing, k)] = v
+				}
+				return nil
+			}
+		}
+	}
+
+	c[key] = value
+	return nil
+}
+
+func patchRemove(r map[string]interface{}, op PatchOperation) error {
+	if op.Path == "" {
+		return noTarget("remove operation requires a path")
+	}
+
+	p, err := parsePatchPath(op.Path)
+	if err != nil {
+		return err
+	}
+
+	c := p.container(r, false)
+	if c == nil {
+		return nil
+	}
+	key := lookupKey(c, p.Attr)
+
+	switch existing := c[key].(type) {
+	case []interface{}:
+		var keep []interface{}
+		for _, elem := range existing {
+			m, _ := elem.(map[string]interface{})
+
+			switch {
+			case p.filter != nil && (m == nil || !p.filter.match(m)):
+				keep = append(keep, elem)
+			case p.Sub != "":
+				delete(m, lookupKey(m, p.Sub))
+				keep = append(keep, elem)
+			case p.filter == nil && op.Value != nil && !matchesValue(elem, op.Value):
+				// Some clients remove values from multi-valued
+				// attributes by listing them in value.
+				keep = append(keep, elem)
+			}
+		}
+
+		if len(keep) == 0 {
+			delete(c, key)
+		} else {
+			c[key] = keep
+		}
+
+	case map[string]interface{}:
+		if p.Sub != "" {
+			delete(existing, lookupKey(existing, p.Sub))
+		} else {
+			delete(c, key)
+		}
+
+	default:
+		delete(c, key)
+	}
+
+	return nil
+}
+
+// matchesValue reports whether elem is one of the values in value.
+// Complex values are matched by their "value" sub-attribute.
+func matchesValue(elem interface{}, value interface{}) bool {
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	for _, v := range values {
+		if reflect.DeepEqual(elem, v) {
+			return true
+		}
+
+		em, ok1 := elem.(map[string]interface{})
+		vm, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 && em["value"] != nil && reflect.DeepEqual(em["value"], vm["value"]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(list []interface{}, v interface{}) bool {
+	for _, elem := range list {
+		if matchesValue(elem, v) {
+			return true
+		}
+	}
+	return false
+}
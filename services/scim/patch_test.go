@@ -0,0 +1,140 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resource(t *testing.T, s string) map[string]interface{} {
+	var r map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(s), &r))
+	return r
+}
+
+func TestApplyPatch(t *testing.T) {
+	const group = `{
+		"displayName": "vets",
+		"members": [{"value": "1"}, {"value": "2"}]
+	}`
+
+	cases := []struct {
+		name     string
+		input    string
+		ops      string
+		expected string
+	}{
+		{
+			"ReplaceAttribute",
+			`{"userName": "alice", "active": true}`,
+			`[{"op": "replace", "path": "active", "value": false}]`,
+			`{"userName": "alice", "active": false}`,
+		},
+		{
+			"ReplaceWithoutPath",
+			`{"userName": "alice", "title": "Dr."}`,
+			`[{"op": "Replace", "value": {"userName": "alice.smith", "nickName": "ali"}}]`,
+			`{"userName": "alice.smith", "title": "Dr.", "nickName": "ali"}`,
+		},
+		{
+			"AddCaseInsensitive",
+			`{"userName": "alice"}`,
+			`[{"op": "add", "path": "USERNAME", "value": "bob"}]`,
+			`{"userName": "bob"}`,
+		},
+		{
+			"AddSubAttribute",
+			`{"name": {"givenName": "Alice"}}`,
+			`[{"op": "add", "path": "name.familyName", "value": "Smith"}]`,
+			`{"name": {"givenName": "Alice", "familyName": "Smith"}}`,
+		},
+		{
+			"AddExtensionAttribute",
+			`{}`,
+			`[{"op": "add", "path": "urn:example:ext:User:department", "value": "Surgery"}]`,
+			`{"urn:example:ext:User": {"department": "Surgery"}}`,
+		},
+		{
+			"AddExtensionWithoutPath",
+			`{}`,
+			`[{"op": "add", "value": {"urn:example:ext:User": {"department": "Surgery"}}}]`,
+			`{"urn:example:ext:User": {"department": "Surgery"}}`,
+		},
+		{
+			"AddMembers",
+			group,
+			`[{"op": "add", "path": "members", "value": [{"value": "2"}, {"value": "3"}]}]`,
+			`{"displayName": "vets", "members": [{"value": "1"}, {"value": "2"}, {"value": "3"}]}`,
+		},
+		{
+			"RemoveMemberByFilter",
+			group,
+			`[{"op": "remove", "path": "members[value eq \"1\"]"}]`,
+			`{"displayName": "vets", "members": [{"value": "2"}]}`,
+		},
+		{
+			"RemoveMemberByValue",
+			group,
+			`[{"op": "remove", "path": "members", "value": [{"value": "2"}]}]`,
+			`{"displayName": "vets", "members": [{"value": "1"}]}`,
+		},
+		{
+			"RemoveAllMembers",
+			group,
+			`[{"op": "remove", "path": "members"}]`,
+			`{"displayName": "vets"}`,
+		},
+		{
+			"ReplaceFilteredSubAttribute",
+			`{"emails": [{"value": "a@example.com", "type": "work"}, {"value": "b@example.com", "type": "home"}]}`,
+			`[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "c@example.com"}]`,
+			`{"emails": [{"value": "c@example.com", "type": "work"}, {"value": "b@example.com", "type": "home"}]}`,
+		},
+		{
+			"RemoveAttribute",
+			`{"userName": "alice", "title": "Dr."}`,
+			`[{"op": "remove", "path": "title"}]`,
+			`{"userName": "alice"}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := resource(t, c.input)
+
+			var ops []PatchOperation
+			require.NoError(t, json.Unmarshal([]byte(c.ops), &ops))
+
+			require.NoError(t, applyPatch(r, ops))
+			assert.Equal(t, resource(t, c.expected), r)
+		})
+	}
+}
+
+func TestApplyPatch_Errors(t *testing.T) {
+	cases := []struct {
+		name    string
+		ops     []PatchOperation
+		errType string
+	}{
+		{"UnknownOperation", []PatchOperation{{Op: "move", Path: "title"}}, ErrorTypeInvalidSyntax},
+		{"RemoveWithoutPath", []PatchOperation{{Op: "remove"}}, ErrorTypeNoTarget},
+		{"InvalidPath", []PatchOperation{{Op: "add", Path: "a b", Value: "x"}}, ErrorTypeInvalidPath},
+		{"InvalidFilter", []PatchOperation{{Op: "remove", Path: "members[value xx \"1\"]"}}, ErrorTypeInvalidFilter},
+		{"NoMatch", []PatchOperation{{Op: "replace", Path: "members[value eq \"9\"].display", Value: "x"}}, ErrorTypeNoTarget},
+		{"ValueNotAnObject", []PatchOperation{{Op: "add", Value: "x"}}, ErrorTypeInvalidValue},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := resource(t, `{"members": [{"value": "1"}]}`)
+
+			err := applyPatch(r, c.ops)
+			if assert.Error(t, err) {
+				assert.Equal(t, c.errType, err.(*Error).Type)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Package scim provides a SCIM 2.0 (RFC 7643, RFC 7644) provisioning API
+// on top of the user and group management services. It allows identity
+// providers to provision users and groups into IAM.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Schema URNs defined by RFC 7643 and RFC 7644.
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// BasePath is the path the SCIM API is served at.
+const BasePath = "/scim/v2"
+
+// Error types (scimType) defined by RFC 7644 section 3.12.
+const (
+	ErrorTypeInvalidFilter = "invalidFilter"
+	ErrorTypeInvalidSyntax = "invalidSyntax"
+	ErrorTypeInvalidPath   = "invalidPath"
+	ErrorTypeInvalidValue  = "invalidValue"
+	ErrorTypeMutability    = "mutability"
+	ErrorTypeUniqueness    = "uniqueness"
+	ErrorTypeNoTarget      = "noTarget"
+)
+
+// DefaultCount is the default and maximum number of resources returned
+// by a single list request.
+const DefaultCount = 100
+
+// Resource is a SCIM resource as sent and received on the wire.
+type Resource map[string]interface{}
+
+// ListResponse is returned when querying resources.
+type ListResponse struct {
+	Schemas      []string   `json:"schemas"`
+	TotalResults int        `json:"totalResults"`
+	StartIndex   int        `json:"startIndex"`
+	ItemsPerPage int        `json:"itemsPerPage"`
+	Resources    []Resource `json:"Resources"`
+}
+
+// PatchOperation is a single operation of a PATCH request.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Query selects a page of resources matching Filter. StartIndex is
+// 1-based and Count is limited to DefaultCount.
+type Query struct {
+	Filter     string
+	StartIndex int
+	Count      int
+}
+
+// Error is a SCIM error response.
+type Error struct {
+	Status int
+	Type   string
+	Detail string
+}
+
+func newError(status int, typ string, format string, args ...interface{}) *Error {
+	return &Error{
+		Status: status,
+		Type:   typ,
+		Detail: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return e.Detail
+	}
+	return fmt.Sprintf("%s: %s", e.Type, e.Detail)
+}
+
+// StatusCode implements the StatusCoder interface of go-kit's http
+// transport.
+func (e *Error) StatusCode() int {
+	return e.Status
+}
+
+// MarshalJSON implements the json.Marshaler interface and encodes e
+// as defined by RFC 7644 section 3.12.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	body := map[string]interface{}{
+		"schemas": []string{SchemaError},
+		"status":  strconv.Itoa(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.Type != "" {
+		body["scimType"] = e.Type
+	}
+	return json.Marshal(body)
+}
+
+func invalidValue(format string, args ...interface{}) *Error {
+	return newError(http.StatusBadRequest, ErrorTypeInvalidValue, format, args...)
+}
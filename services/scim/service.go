@@ -0,0 +1,535 @@
+package scim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+// Service provides SCIM resource management. All methods return *Error
+// for requests that violate the SCIM protocol. Errors of the user and
+// group management services are returned unchanged.
+type Service interface {
+	// ListUsers returns the users matching q.
+	ListUsers(ctx context.Context, q Query) (ListResponse, error)
+
+	// GetUser returns the user with the given id.
+	GetUser(ctx context.Context, id string) (Resource, error)
+
+	// CreateUser creates a new user. If r does not contain a password
+	// a random one is generated.
+	CreateUser(ctx context.Context, r Resource) (Resource, error)
+
+	// ReplaceUser replaces the user with the given id with r.
+	ReplaceUser(ctx context.Context, id string, r Resource) (Resource, error)
+
+	// PatchUser applies ops to the user with the given id.
+	PatchUser(ctx context.Context, id string, ops []PatchOperation) (Resource, error)
+
+	// DeleteUser deletes the user with the given id.
+	DeleteUser(ctx context.Context, id string) error
+
+	// ListGroups returns the groups matching q.
+	ListGroups(ctx context.Context, q Query) (ListResponse, error)
+
+	// GetGroup returns the group with the given id.
+	GetGroup(ctx context.Context, id string) (Resource, error)
+
+	// CreateGroup creates a new group including its members.
+	CreateGroup(ctx context.Context, r Resource) (Resource, error)
+
+	// ReplaceGroup replaces the group with the given id with r.
+	ReplaceGroup(ctx context.Context, id string, r Resource) (Resource, error)
+
+	// PatchGroup applies ops to the group with the given id.
+	PatchGroup(ctx context.Context, id string, ops []PatchOperation) (Resource, error)
+
+	// DeleteGroup deletes the group with the given id.
+	DeleteGroup(ctx context.Context, id string) error
+}
+
+type service struct {
+	users   user.Service
+	groups  group.Service
+	members iam.MembershipRepository
+}
+
+// NewService returns a new SCIM service. All calls to users and groups
+// are authorized using authz with the actions of the user and group
+// management APIs.
+func NewService(users user.Service, groups group.Service, members iam.MembershipRepository, authz enforcer.Enforcer) Service {
+	a := newAuthorizer(authz)
+
+	return &service{
+		users:   authorizedUsers{Service: users, authorizer: a},
+		groups:  authorizedGroups{Service: groups, authorizer: a},
+		members: members,
+	}
+}
+
+func notFound(kind, id string) *Error {
+	return newError(http.StatusNotFound, "", "%s %q not found", kind, id)
+}
+
+func (s *service) ListUsers(ctx context.Context, q Query) (ListResponse, error) {
+	f, err := q.filter()
+	if err != nil {
+		return ListResponse{}, err
+	}
+
+	start, count := q.bounds()
+
+	// users are paged using the username index. userName eq filters
+	// are translated to a prefix query, all other filters are applied
+	// to the SCIM resources. Users outside of the requested page are
+	// only counted.
+	query := iam.UserQuery{
+		SortBy: iam.SortByUsername,
+		Limit:  DefaultCount,
+	}
+	if name, ok := f.userNameEq(); ok {
+		query.UsernamePrefix = name
+	}
+
+	page := []Resource{}
+	total := 0
+	for {
+		users, err := s.users.QueryUsers(ctx, query)
+		if err != nil {
+			return ListResponse{}, err
+		}
+
+		for _, u := range users.Users {
+			var r Resource
+			if f != nil {
+				if r, err = s.userResource(ctx, u); err != nil {
+					return ListResponse{}, err
+				}
+				if !f.match(r) {
+					continue
+				}
+			}
+
+			total++
+			if total < start || len(page) >= count {
+				continue
+			}
+
+			if r == nil {
+				if r, err = s.userResource(ctx, u); err != nil {
+					return ListResponse{}, err
+				}
+			}
+			page = append(page, r)
+		}
+
+		if users.NextCursor == "" {
+			break
+		}
+		query.Cursor = users.NextCursor
+	}
+
+	return listResponse(start, total, page), nil
+}
+
+func (s *service) GetUser(ctx context.Context, id string) (Resource, error) {
+	u, err := s.loadUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.userResource(ctx, u)
+}
+
+func (s *service) CreateUser(ctx context.Context, r Resource) (Resource, error) {
+	spec, err := resourceToUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Password == "" {
+		spec.Password, err = randomPassword()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	urn, err := s.users.CreateUser(ctx, spec.UserName, spec.Password, spec.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Active != nil && !*spec.Active {
+		if err := s.users.LockUser(ctx, urn, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.GetUser(ctx, urn.AccountID())
+}
+
+func (s *service) ReplaceUser(ctx context.Context, id string, r Resource) (Resource, error) {
+	u, err := s.loadUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := resourceToUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.updateUser(ctx, u, spec); err != nil {
+		return nil, err
+	}
+
+	return s.GetUser(ctx, id)
+}
+
+func (s *service) PatchUser(ctx context.Context, id string, ops []PatchOperation) (Resource, error) {
+	u, err := s.loadUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.userResource(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyPatch(r, ops); err != nil {
+		return nil, err
+	}
+
+	spec, err := resourceToUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.updateUser(ctx, u, spec); err != nil {
+		return nil, err
+	}
+
+	return s.GetUser(ctx, id)
+}
+
+func (s *service) DeleteUser(ctx context.Context, id string) error {
+	u, err := s.loadUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.users.DeleteUser(ctx, u.ID)
+}
+
+// updateUser applies the changes between u and spec.
+func (s *service) updateUser(ctx context.Context, u iam.User, spec userSpec) error {
+	if spec.UserName != u.Username {
+		if err := s.users.SetUsername(ctx, u.ID, spec.UserName); err != nil {
+			return err
+		}
+	}
+
+	stored := normalize(u.Attributes)
+	if len(stored)+len(spec.Attributes) > 0 && !reflect.DeepEqual(map[string]interface{}(stored), spec.Attributes) {
+		if err := s.users.UpdateAttrs(ctx, u.ID, spec.Attributes); err != nil {
+			return err
+		}
+	}
+
+	if spec.Password != "" {
+		if err := s.users.SetPassword(ctx, u.ID, spec.Password); err != nil {
+			return err
+		}
+	}
+
+	locked := u.Locked != nil && *u.Locked
+	if spec.Active != nil && *spec.Active == locked {
+		if err := s.users.LockUser(ctx, u.ID, !*spec.Active); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *service) loadUser(ctx context.Context, id string) (iam.User, error) {
+	urn, ok := userURN(id)
+	if !ok {
+		return iam.User{}, notFound("User", id)
+	}
+
+	u, err := s.users.LoadUser(ctx, urn)
+	if common.IsNotFound(err) {
+		return iam.User{}, notFound("User", id)
+	}
+	return u, err
+}
+
+func (s *service) userResource(ctx context.Context, u iam.User) (Resource, error) {
+	groups, err := s.members.Memberships(ctx, u.ID)
+	if err != nil && !common.IsNotFound(err) {
+		return nil, err
+	}
+
+	return userToResource(u, groups), nil
+}
+
+func (s *service) ListGroups(ctx context.Context, q Query) (ListResponse, error) {
+	f, err := q.filter()
+	if err != nil {
+		return ListResponse{}, err
+	}
+
+	groups, err := s.groups.Get(ctx)
+	if err != nil {
+		return ListResponse{}, err
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	var resources []Resource
+	for _, g := range groups {
+		r, err := s.groupResource(ctx, g)
+		if err != nil {
+			return ListResponse{}, err
+		}
+
+		if f == nil || f.match(r) {
+			resources = append(resources, r)
+		}
+	}
+
+	return q.page(resources), nil
+}
+
+func (s *service) GetGroup(ctx context.Context, id string) (Resource, error) {
+	g, err := s.loadGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.groupResource(ctx, g)
+}
+
+func (s *service) CreateGroup(ctx context.Context, r Resource) (Resource, error) {
+	spec, err := resourceToGroup(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// group.Service.Create overwrites existing groups.
+	_, err = s.groups.Load(ctx, groupURN(spec.DisplayName))
+	if err == nil {
+		return nil, newError(http.StatusConflict, ErrorTypeUniqueness, "group %q already exists", spec.DisplayName)
+	}
+	if !common.IsNotFound(err) {
+		return nil, err
+	}
+
+	urn, err := s.groups.Create(ctx, spec.DisplayName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.updateMembers(ctx, urn, nil, spec.Members); err != nil {
+		return nil, err
+	}
+
+	return s.GetGroup(ctx, spec.DisplayName)
+}
+
+func (s *service) ReplaceGroup(ctx context.Context, id string, r Resource) (Resource, error) {
+	g, err := s.loadGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := resourceToGroup(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.updateGroup(ctx, g, spec); err != nil {
+		return nil, err
+	}
+
+	return s.GetGroup(ctx, id)
+}
+
+func (s *service) PatchGroup(ctx context.Context, id string, ops []PatchOperation) (Resource, error) {
+	g, err := s.loadGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.groupResource(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyPatch(r, ops); err != nil {
+		return nil, err
+	}
+
+	spec, err := resourceToGroup(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.updateGroup(ctx, g, spec); err != nil {
+		return nil, err
+	}
+
+	return s.GetGroup(ctx, id)
+}
+
+func (s *service) DeleteGroup(ctx context.Context, id string) error {
+	g, err := s.loadGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.groups.Delete(ctx, g.ID)
+}
+
+// updateGroup applies the changes between g and spec. Groups are
+// identified by their name so it cannot be changed.
+func (s *service) updateGroup(ctx context.Context, g iam.Group, spec groupSpec) error {
+	if spec.DisplayName != g.Name {
+		return newError(http.StatusBadRequest, ErrorTypeMutability, "displayName cannot be changed")
+	}
+
+	current, err := s.groups.GetMembers(ctx, g.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.updateMembers(ctx, g.ID, current, spec.Members)
+}
+
+// updateMembers adds and removes members of grp so that they match
+// desired.
+func (s *service) updateMembers(ctx context.Context, grp iam.GroupURN, current, desired []iam.UserURN) error {
+	keep := make(map[iam.UserURN]bool)
+	for _, m := range desired {
+		keep[m] = true
+	}
+
+	for _, m := range current {
+		if keep[m] {
+			delete(keep, m)
+			continue
+		}
+		if err := s.groups.DeleteMember(ctx, grp, m); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range desired {
+		if !keep[m] {
+			continue
+		}
+		delete(keep, m)
+
+		if err := s.groups.AddMember(ctx, grp, m); err != nil {
+			if common.IsNotFound(err) {
+				return invalidValue("member %q does not exist", m.AccountID())
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *service) loadGroup(ctx context.Context, id string) (iam.Group, error) {
+	if id == "" {
+		return iam.Group{}, notFound("Group", id)
+	}
+
+	g, err := s.groups.Load(ctx, groupURN(id))
+	if common.IsNotFound(err) {
+		return iam.Group{}, notFound("Group", id)
+	}
+	return g, err
+}
+
+func (s *service) groupResource(ctx context.Context, g iam.Group) (Resource, error) {
+	members, err := s.groups.GetMembers(ctx, g.ID)
+	if err != nil && !common.IsNotFound(err) {
+		return nil, err
+	}
+
+	return groupToResource(g, members), nil
+}
+
+func (q Query) filter() (filter, error) {
+	if q.Filter == "" {
+		return nil, nil
+	}
+	return parseFilter(q.Filter)
+}
+
+// bounds returns the 1-based index of the first resource and the
+// maximum number of resources selected by q.
+func (q Query) bounds() (start, count int) {
+	start = q.StartIndex
+	if start < 1 {
+		start = 1
+	}
+
+	count = q.Count
+	if count < 0 {
+		count = 0
+	}
+	if count > DefaultCount {
+		count = DefaultCount
+	}
+
+	return start, count
+}
+
+// page returns the page of resources selected by q.
+func (q Query) page(resources []Resource) ListResponse {
+	start, count := q.bounds()
+
+	page := []Resource{}
+	if start <= len(resources) {
+		end := start - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		page = resources[start-1 : end]
+	}
+
+	return listResponse(start, len(resources), page)
+}
+
+// listResponse returns the list response for page starting at start out
+// of total matching resources.
+func listResponse(start, total int, page []Resource) ListResponse {
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,107 @@
+package scim
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+)
+
+// queryRecorder records all queries passed to QueryUsers.
+type queryRecorder struct {
+	user.Service
+	queries []iam.UserQuery
+}
+
+func (r *queryRecorder) QueryUsers(ctx context.Context, q iam.UserQuery) (iam.UserPage, error) {
+	r.queries = append(r.queries, q)
+	return r.Service.QueryUsers(ctx, q)
+}
+
+func TestService_ListUsers(t *testing.T) {
+	ctx := context.Background()
+
+	repo := inmem.NewUserRepository()
+	for i, name := range []string{"dave", "alice", "carol", "bob", "alice.smith"} {
+		require.NoError(t, repo.Store(ctx, iam.User{
+			AccountID: i + 1,
+			ID:        iam.UserURN(fmt.Sprintf("urn:iam::user/%d", i+1)),
+			Username:  name,
+		}))
+	}
+
+	users := &queryRecorder{Service: user.NewService(repo, nil, nil)}
+	s := &service{users: users, members: inmem.NewMembershipRepository()}
+
+	names := func(res ListResponse) []string {
+		var result []string
+		for _, r := range res.Resources {
+			result = append(result, r["userName"].(string))
+		}
+		return result
+	}
+
+	// users are paged by username and all matches are counted
+	res, err := s.ListUsers(ctx, Query{StartIndex: 2, Count: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice.smith", "bob"}, names(res))
+	assert.Equal(t, 5, res.TotalResults)
+	assert.Equal(t, 2, res.StartIndex)
+	assert.Equal(t, 2, res.ItemsPerPage)
+	require.Len(t, users.queries, 1)
+	assert.Equal(t, iam.SortByUsername, users.queries[0].SortBy)
+
+	// userName eq is translated to a prefix query
+	users.queries = nil
+	res, err = s.ListUsers(ctx, Query{Filter: `userName eq "alice"`, StartIndex: 1, Count: DefaultCount})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, names(res))
+	assert.Equal(t, 1, res.TotalResults)
+	require.Len(t, users.queries, 1)
+	assert.Equal(t, "alice", users.queries[0].UsernamePrefix)
+
+	// other filters are applied to the resources
+	users.queries = nil
+	res, err = s.ListUsers(ctx, Query{Filter: `userName sw "a" or userName eq "bob"`, StartIndex: 1, Count: DefaultCount})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "alice.smith", "bob"}, names(res))
+	require.Len(t, users.queries, 1)
+	assert.Empty(t, users.queries[0].UsernamePrefix)
+
+	res, err = s.ListUsers(ctx, Query{StartIndex: 10, Count: 1})
+	require.NoError(t, err)
+	assert.Empty(t, res.Resources)
+	assert.Equal(t, 5, res.TotalResults)
+}
+
+func TestService_ListUsers_Cursor(t *testing.T) {
+	ctx := context.Background()
+
+	repo := inmem.NewUserRepository()
+	for i := 1; i <= DefaultCount+10; i++ {
+		require.NoError(t, repo.Store(ctx, iam.User{
+			AccountID: i,
+			ID:        iam.UserURN(fmt.Sprintf("urn:iam::user/%d", i)),
+			Username:  fmt.Sprintf("user-%03d", i),
+		}))
+	}
+
+	users := &queryRecorder{Service: user.NewService(repo, nil, nil)}
+	s := &service{users: users, members: inmem.NewMembershipRepository()}
+
+	res, err := s.ListUsers(ctx, Query{StartIndex: DefaultCount, Count: 3})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCount+10, res.TotalResults)
+	require.Len(t, res.Resources, 3)
+	assert.Equal(t, fmt.Sprintf("user-%03d", DefaultCount), res.Resources[0]["userName"])
+
+	// the second page continues the first one
+	require.Len(t, users.queries, 2)
+	assert.Empty(t, users.queries[0].Cursor)
+	assert.NotEmpty(t, users.queries[1].Cursor)
+}
@@ -0,0 +1,243 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+)
+
+var errBadRoute = newError(http.StatusNotFound, "", "unknown resource")
+
+// MakeHandler returns a http.Handler for the SCIM API. Resource
+// endpoints require an authenticated subject while the discovery
+// endpoints are public. Authorization is performed by Service.
+func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, logger log.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+	}
+
+	authenticated := authn.NewAuthenticator(extractor)
+
+	handle := func(ep endpoint.Endpoint, dec kithttp.DecodeRequestFunc) http.Handler {
+		return kithttp.NewServer(authenticated(ep), dec, encodeResponse, opts...)
+	}
+
+	document := func(doc interface{}) http.Handler {
+		return kithttp.NewServer(makeDocumentEndpoint(doc), kithttp.NopRequestDecoder, encodeResponse, opts...)
+	}
+
+	r := mux.NewRouter()
+
+	r.Handle(BasePath+"/Users", handle(makeListEndpoint(s.ListUsers), decodeListRequest)).Methods("GET")
+	r.Handle(BasePath+"/Users", handle(makeCreateEndpoint(s.CreateUser), decodeCreateRequest)).Methods("POST")
+	r.Handle(BasePath+"/Users/{id}", handle(makeGetEndpoint(s.GetUser), decodeGetRequest)).Methods("GET")
+	r.Handle(BasePath+"/Users/{id}", handle(makeReplaceEndpoint(s.ReplaceUser), decodeReplaceRequest)).Methods("PUT")
+	r.Handle(BasePath+"/Users/{id}", handle(makePatchEndpoint(s.PatchUser), decodePatchRequest)).Methods("PATCH")
+	r.Handle(BasePath+"/Users/{id}", handle(makeDeleteEndpoint(s.DeleteUser), decodeDeleteRequest)).Methods("DELETE")
+
+	r.Handle(BasePath+"/Groups", handle(makeListEndpoint(s.ListGroups), decodeListRequest)).Methods("GET")
+	r.Handle(BasePath+"/Groups", handle(makeCreateEndpoint(s.CreateGroup), decodeCreateRequest)).Methods("POST")
+	r.Handle(BasePath+"/Groups/{id}", handle(makeGetEndpoint(s.GetGroup), decodeGetRequest)).Methods("GET")
+	r.Handle(BasePath+"/Groups/{id}", handle(makeReplaceEndpoint(s.ReplaceGroup), decodeReplaceRequest)).Methods("PUT")
+	r.Handle(BasePath+"/Groups/{id}", handle(makePatchEndpoint(s.PatchGroup), decodePatchRequest)).Methods("PATCH")
+	r.Handle(BasePath+"/Groups/{id}", handle(makeDeleteEndpoint(s.DeleteGroup), decodeDeleteRequest)).Methods("DELETE")
+
+	r.Handle(BasePath+"/ServiceProviderConfig", document(serviceProviderConfig())).Methods("GET")
+	r.Handle(BasePath+"/ResourceTypes", document(listOf(resourceTypes()))).Methods("GET")
+	r.Handle(BasePath+"/Schemas", document(listOf(schemas()))).Methods("GET")
+
+	for _, rt := range resourceTypes() {
+		r.Handle(BasePath+"/ResourceTypes/"+rt["id"].(string), document(rt)).Methods("GET")
+	}
+	for _, schema := range schemas() {
+		r.Handle(BasePath+"/Schemas/"+schema["id"].(string), document(schema)).Methods("GET")
+	}
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		encodeError(req.Context(), errBadRoute, w)
+	})
+
+	return r
+}
+
+func listOf(resources []Resource) ListResponse {
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+func decodeListRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := Query{
+		Filter:     r.URL.Query().Get("filter"),
+		StartIndex: 1,
+		Count:      DefaultCount,
+	}
+
+	for param, value := range map[string]*int{"startIndex": &q.StartIndex, "count": &q.Count} {
+		s := r.URL.Query().Get(param)
+		if s == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, invalidValue("invalid %s %q", param, s)
+		}
+		*value = i
+	}
+
+	return listRequest{Query: q}, nil
+}
+
+func decodeGetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := getID(r)
+	if err != nil {
+		return nil, err
+	}
+	return getRequest{ID: id}, nil
+}
+
+func decodeCreateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	res, err := decodeResource(r)
+	if err != nil {
+		return nil, err
+	}
+	return createRequest{Resource: res}, nil
+}
+
+func decodeReplaceRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := getID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := decodeResource(r)
+	if err != nil {
+		return nil, err
+	}
+	return replaceRequest{ID: id, Resource: res}, nil
+}
+
+func decodePatchRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := getID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Schemas    []string         `json:"schemas"`
+		Operations []PatchOperation `json:"Operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, newError(http.StatusBadRequest, ErrorTypeInvalidSyntax, "invalid request body: %s", err)
+	}
+
+	return patchRequest{ID: id, Operations: body.Operations}, nil
+}
+
+func decodeDeleteRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := getID(r)
+	if err != nil {
+		return nil, err
+	}
+	return deleteRequest{ID: id}, nil
+}
+
+func decodeResource(r *http.Request) (Resource, error) {
+	var res Resource
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil || res == nil {
+		return nil, newError(http.StatusBadRequest, ErrorTypeInvalidSyntax, "invalid request body")
+	}
+	return res, nil
+}
+
+func getID(r *http.Request) (string, error) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		return "", errBadRoute
+	}
+	return id, nil
+}
+
+func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	if h, ok := response.(kithttp.Headerer); ok {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
+	code := http.StatusOK
+	if sc, ok := response.(kithttp.StatusCoder); ok {
+		code = sc.StatusCode()
+	}
+
+	if code == http.StatusNoContent {
+		w.WriteHeader(code)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(code)
+	return json.NewEncoder(w).Encode(response)
+}
+
+// encodeError encodes err as a SCIM error response.
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	var h kithttp.Headerer
+	if errors.As(err, &h) {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
+	scimErr := toError(err)
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(scimErr.Status)
+	json.NewEncoder(w).Encode(scimErr)
+}
+
+// toError converts errors of the user and group management services
+// to SCIM errors.
+func toError(err error) *Error {
+	var (
+		se *Error
+		ae *authn.AuthenticationError
+		sc kithttp.StatusCoder
+	)
+
+	switch {
+	case errors.As(err, &se):
+		return se
+	case errors.As(err, &ae):
+		return newError(http.StatusUnauthorized, "", "%s", ae.Description)
+	case common.IsConflict(err):
+		return newError(http.StatusConflict, ErrorTypeUniqueness, "%s", err)
+	case common.IsInvalidArgument(err):
+		return invalidValue("%s", err)
+	case errors.As(err, &sc):
+		return newError(sc.StatusCode(), "", "%s", err)
+	default:
+		return newError(http.StatusInternalServerError, "", "%s", err)
+	}
+}
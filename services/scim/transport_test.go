@@ -0,0 +1,126 @@
+package scim
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+)
+
+func Test_decodeListRequest(t *testing.T) {
+	req, err := decodeListRequest(bg, httptest.NewRequest("GET", "/scim/v2/Users", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, listRequest{Query: Query{StartIndex: 1, Count: DefaultCount}}, req)
+
+	req, err = decodeListRequest(bg, httptest.NewRequest("GET", `/scim/v2/Users?filter=userName+eq+"alice"&startIndex=3&count=5`, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, listRequest{Query: Query{Filter: `userName eq "alice"`, StartIndex: 3, Count: 5}}, req)
+
+	_, err = decodeListRequest(bg, httptest.NewRequest("GET", "/scim/v2/Users?count=many", nil))
+	assert.Equal(t, http.StatusBadRequest, toError(err).Status)
+}
+
+func Test_decodeResourceRequests(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/scim/v2/Users/10", strings.NewReader(`{"userName": "alice"}`))
+	r = mux.SetURLVars(r, map[string]string{"id": "10"})
+
+	req, err := decodeReplaceRequest(bg, r)
+	assert.NoError(t, err)
+	assert.Equal(t, replaceRequest{ID: "10", Resource: Resource{"userName": "alice"}}, req)
+
+	req, err = decodeCreateRequest(bg, httptest.NewRequest("POST", "/scim/v2/Users", strings.NewReader(`{"userName": "alice"}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, createRequest{Resource: Resource{"userName": "alice"}}, req)
+
+	for _, body := range []string{`invalid-json`, `null`} {
+		_, err = decodeCreateRequest(bg, httptest.NewRequest("POST", "/scim/v2/Users", strings.NewReader(body)))
+		assert.Equal(t, ErrorTypeInvalidSyntax, toError(err).Type, body)
+	}
+
+	// requests without an ID in mux.Vars
+	_, err = decodeGetRequest(bg, httptest.NewRequest("GET", "/scim/v2/Users/10", nil))
+	assert.Equal(t, errBadRoute, err)
+	_, err = decodeDeleteRequest(bg, httptest.NewRequest("DELETE", "/scim/v2/Users/10", nil))
+	assert.Equal(t, errBadRoute, err)
+}
+
+func Test_decodePatchRequest(t *testing.T) {
+	r := httptest.NewRequest("PATCH", "/scim/v2/Users/10", strings.NewReader(`{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "active", "value": false}]
+	}`))
+	r = mux.SetURLVars(r, map[string]string{"id": "10"})
+
+	req, err := decodePatchRequest(bg, r)
+	assert.NoError(t, err)
+	assert.Equal(t, patchRequest{
+		ID:         "10",
+		Operations: []PatchOperation{{Op: "replace", Path: "active", Value: false}},
+	}, req)
+
+	r = httptest.NewRequest("PATCH", "/scim/v2/Users/10", strings.NewReader(`invalid-json`))
+	r = mux.SetURLVars(r, map[string]string{"id": "10"})
+	_, err = decodePatchRequest(bg, r)
+	assert.Equal(t, ErrorTypeInvalidSyntax, toError(err).Type)
+}
+
+func Test_encodeResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	assert.NoError(t, encodeResponse(bg, w, resourceResponse{
+		Resource: Resource{"id": "10", "meta": map[string]interface{}{"location": "/scim/v2/Users/10"}},
+		Status:   http.StatusCreated,
+	}))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "/scim/v2/Users/10", w.Header().Get("Location"))
+	assert.JSONEq(t, `{"id": "10", "meta": {"location": "/scim/v2/Users/10"}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	assert.NoError(t, encodeResponse(bg, w, deleteResponse{}))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func Test_toError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		typ    string
+	}{
+		{notFound("User", "10"), http.StatusNotFound, ""},
+		{&authn.AuthenticationError{Description: "missing token"}, http.StatusUnauthorized, ""},
+		{common.NewConflictError("username"), http.StatusConflict, ErrorTypeUniqueness},
+		{common.NewInvalidArgumentError("invalid"), http.StatusBadRequest, ErrorTypeInvalidValue},
+		{&enforcer.PermissionDeniedError{Reason: "denied"}, http.StatusForbidden, ""},
+		{errors.New("simulated"), http.StatusInternalServerError, ""},
+	}
+
+	for _, c := range cases {
+		e := toError(c.err)
+		assert.Equal(t, c.status, e.Status, c.err.Error())
+		assert.Equal(t, c.typ, e.Type, c.err.Error())
+	}
+}
+
+func Test_MakeHandler(t *testing.T) {
+	extractor := func(string) (string, string, error) { return "", "", nil }
+	h := MakeHandler(&serviceMock{}, extractor, log.NewNopLogger())
+
+	// discovery endpoints are public and unknown routes are SCIM errors
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", BasePath+"/ServiceProviderConfig", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", BasePath+"/Unknown", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+}
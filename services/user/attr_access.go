@@ -0,0 +1,287 @@
+package user
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// ActionReadAttr returns the action that allows the subject to read the
+// user attribute key, e.g. iam:user:read-attr:email. The resource is
+// the URN of the user or empty when selecting users by the attribute.
+func ActionReadAttr(key string) string {
+	return ActionReadAttrPrefix + key
+}
+
+// ActionWriteAttr returns the action that allows the subject to set or
+// delete the user attribute key, e.g. iam:user:write-attr:email. The
+// resource is the URN of the user or empty when creating users.
+func ActionWriteAttr(key string) string {
+	return ActionWriteAttrPrefix + key
+}
+
+type attrAccessService struct {
+	authz enforcer.Enforcer
+	Service
+}
+
+// NewAttrAccessService returns a service that enforces the per-attribute
+// actions (see ActionReadAttr and ActionWriteAttr) for the subject of
+// the request context. Attributes the subject is not allowed to read are
+// removed from users returned by LoadUser, Users and QueryUsers. Calls
+// that modify attributes the subject is not allowed to write fail with
+// enforcer.PermissionDeniedError. Calls without a subject, e.g. from
+// the reconciler, are not restricted.
+func NewAttrAccessService(s Service, authz enforcer.Enforcer) Service {
+	if _, ok := authz.(*enforcer.ScopedEnforcer); !ok {
+		authz = enforcer.NewScopedEnforcer(authz, nil)
+	}
+
+	return &attrAccessService{
+		authz:   authz,
+		Service: s,
+	}
+}
+
+// EnforceAttrAction checks if the subject of ctx may perform the
+// attribute action (see ActionReadAttr and ActionWriteAttr) on resource.
+// Impersonated requests must be allowed for the actor as well. Calls
+// without a subject are not restricted. It is used by services that
+// handle user attributes outside of Service, e.g. invitations.
+func EnforceAttrAction(ctx context.Context, authz enforcer.Enforcer, action, resource string) error {
+	subject, ok := enforcer.Subject(ctx)
+	if !ok {
+		return nil
+	}
+
+	policyContext, _ := enforcer.PolicyContext(ctx)
+
+	if actor, ok := enforcer.Actor(ctx); ok {
+		if err := authz.Enforce(ctx, actor, action, resource, policyContext); err != nil {
+			return err
+		}
+	}
+
+	return authz.Enforce(ctx, subject, action, resource, policyContext)
+}
+
+// enforce checks if the subject of ctx may perform action on resource.
+func (s *attrAccessService) enforce(ctx context.Context, action, resource string) error {
+	return EnforceAttrAction(ctx, s.authz, action, resource)
+}
+
+func (s *attrAccessService) canRead(ctx context.Context, urn iam.UserURN, key string) bool {
+	return s.enforce(ctx, ActionReadAttr(key), string(urn)) == nil
+}
+
+// filter removes all attributes from u the subject is not allowed to
+// read.
+func (s *attrAccessService) filter(ctx context.Context, u iam.User) iam.User {
+	if len(u.Attributes) == 0 {
+		return u
+	}
+
+	attrs := make(map[string]interface{}, len(u.Attributes))
+	for key, value := range u.Attributes {
+		if s.canRead(ctx, u.ID, key) {
+			attrs[key] = value
+		}
+	}
+	u.Attributes = attrs
+
+	return u
+}
+
+func (s *attrAccessService) LoadUser(ctx context.Context, urn iam.UserURN) (iam.User, error) {
+	u, err := s.Service.LoadUser(ctx, urn)
+	if err != nil {
+		return u, err
+	}
+	return s.filter(ctx, u), nil
+}
+
+func (s *attrAccessService) LoadUserByUsername(ctx context.Context, username string) (iam.User, error) {
+	u, err := s.Service.LoadUserByUsername(ctx, username)
+	if err != nil {
+		return u, err
+	}
+	return s.filter(ctx, u), nil
+}
+
+// LoadUserByAttr does not find users by attributes the subject is not
+// allowed to read.
+func (s *attrAccessService) LoadUserByAttr(ctx context.Context, key, value string) (iam.User, error) {
+	u, err := s.Service.LoadUserByAttr(ctx, key, value)
+	if err != nil {
+		return u, err
+	}
+	if !s.canRead(ctx, u.ID, key) {
+		return iam.User{}, common.NewNotFoundError("user")
+	}
+	return s.filter(ctx, u), nil
+}
+
+func (s *attrAccessService) Users(ctx context.Context) ([]iam.User, error) {
+	users, err := s.Service.Users(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, u := range users {
+		users[i] = s.filter(ctx, u)
+	}
+
+	return users, nil
+}
+
+// QueryUsers fails with enforcer.PermissionDeniedError if users are
+// selected by attributes the subject is not allowed to read. Selecting
+// users by an attribute requires read access independent of a user so
+// pages are not shortened and do not reveal matching users.
+func (s *attrAccessService) QueryUsers(ctx context.Context, q iam.UserQuery) (iam.UserPage, error) {
+	for key := range q.Attributes {
+		if err := s.enforce(ctx, ActionReadAttr(key), ""); err != nil {
+			return iam.UserPage{}, err
+		}
+	}
+
+	page, err := s.Service.QueryUsers(ctx, q)
+	if err != nil {
+		return page, err
+	}
+
+	for i, u := range page.Users {
+		page.Users[i] = s.filter(ctx, u)
+	}
+
+	return page, nil
+}
+
+func (s *attrAccessService) CreateUser(ctx context.Context, username, password string, attrs map[string]interface{}) (iam.UserURN, error) {
+	for key := range attrs {
+		if err := s.enforce(ctx, ActionWriteAttr(key), ""); err != nil {
+			return "", err
+		}
+	}
+	return s.Service.CreateUser(ctx, username, password, attrs)
+}
+
+// UpdateAttrs requires write access for all attributes that are added,
+// changed or removed. Attributes the subject is not allowed to read are
+// kept if they are missing in attrs so a load-modify-update cycle does
+// not remove them.
+func (s *attrAccessService) UpdateAttrs(ctx context.Context, urn iam.UserURN, attrs map[string]interface{}) error {
+	if _, ok := enforcer.Subject(ctx); !ok {
+		return s.Service.UpdateAttrs(ctx, urn, attrs)
+	}
+
+	u, err := s.Service.LoadUser(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	result := make(map[string]interface{}, len(attrs))
+	for key, value := range attrs {
+		result[key] = value
+	}
+
+	for key, current := range u.Attributes {
+		if _, ok := attrs[key]; !ok && !s.canRead(ctx, urn, key) {
+			result[key] = current
+		}
+	}
+
+	for _, key := range changedAttrs(u.Attributes, result) {
+		if err := s.enforce(ctx, ActionWriteAttr(key), string(urn)); err != nil {
+			return err
+		}
+	}
+
+	// result is based on u so the update must not be applied if
+	// the user has been modified in the meantime.
+	if err := iam.CheckVersion(ctx, "user", u.Metadata); err != nil {
+		return err
+	}
+
+	return s.Service.UpdateAttrs(iam.WithIfMatch(ctx, u.Version), urn, result)
+}
+
+// PatchAttrs requires write access for all attributes that are added,
+// changed or removed by patch. JSON patches may only test, copy or move
+// attributes the subject is allowed to read.
+func (s *attrAccessService) PatchAttrs(ctx context.Context, urn iam.UserURN, patch iam.AttrPatch) error {
+	if _, ok := enforcer.Subject(ctx); !ok {
+		return s.Service.PatchAttrs(ctx, urn, patch)
+	}
+
+	ops, _ := patch.(iam.JSONPatch)
+
+	return s.Service.PatchAttrs(ctx, urn, iam.AttrPatchFunc(func(attrs map[string]interface{}) (map[string]interface{}, error) {
+		for _, op := range ops {
+			for _, p := range op.Reads() {
+				keys := []string{iam.PointerAttr(p)}
+				if keys[0] == "" {
+					keys = keys[:0]
+					for key := range attrs {
+						keys = append(keys, key)
+					}
+				}
+
+				for _, key := range keys {
+					if err := s.enforce(ctx, ActionReadAttr(key), string(urn)); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+
+		before := iam.CopyAttrs(attrs)
+		result, err := patch.Apply(attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range changedAttrs(before, result) {
+			if err := s.enforce(ctx, ActionWriteAttr(key), string(urn)); err != nil {
+				return nil, err
+			}
+		}
+
+		return result, nil
+	}))
+}
+
+// changedAttrs returns the keys of all attributes that differ between
+// before and after.
+func changedAttrs(before, after map[string]interface{}) []string {
+	var changed []string
+	for key, value := range after {
+		if current, ok := before[key]; !ok || !reflect.DeepEqual(current, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+
+	return changed
+}
+
+func (s *attrAccessService) SetAttr(ctx context.Context, urn iam.UserURN, key string, value interface{}) error {
+	if err := s.enforce(ctx, ActionWriteAttr(key), string(urn)); err != nil {
+		return err
+	}
+	return s.Service.SetAttr(ctx, urn, key, value)
+}
+
+func (s *attrAccessService) DeleteAttr(ctx context.Context, urn iam.UserURN, key string) error {
+	if err := s.enforce(ctx, ActionWriteAttr(key), string(urn)); err != nil {
+		return err
+	}
+	return s.Service.DeleteAttr(ctx, urn, key)
+}
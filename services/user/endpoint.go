@@ -2,8 +2,10 @@ package user
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
 
@@ -45,6 +47,16 @@ type loadUserResponse struct {
 
 func (r loadUserResponse) error() error { return r.Err }
 
+// Headers implements the go-kit http.Headerer interface and
+// returns the ETag of the user.
+func (r loadUserResponse) Headers() http.Header {
+	h := make(http.Header)
+	if r.User != nil {
+		h.Set("ETag", common.ETag(r.Version))
+	}
+	return h
+}
+
 func makeLoadUserEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(loadUserRequest)
@@ -57,6 +69,32 @@ func makeLoadUserEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
+type lookupUserRequest struct {
+	Username string
+	Attr     string
+	Value    string
+}
+
+func makeLookupUserEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(lookupUserRequest)
+
+		var (
+			user iam.User
+			err  error
+		)
+		if req.Username != "" {
+			user, err = s.LoadUserByUsername(ctx, req.Username)
+		} else {
+			user, err = s.LoadUserByAttr(ctx, req.Attr, req.Value)
+		}
+		if err != nil {
+			return loadUserResponse{Err: err}, nil
+		}
+		return loadUserResponse{User: &user}, nil
+	}
+}
+
 type deleteUserRequest struct {
 	URN iam.UserURN
 }
@@ -73,6 +111,38 @@ func makeDeleteUserEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
+type restoreUserRequest struct {
+	URN iam.UserURN
+}
+type restoreUserResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r restoreUserResponse) error() error { return r.Err }
+
+func makeRestoreUserEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(restoreUserRequest)
+		return restoreUserResponse{Err: s.RestoreUser(ctx, req.URN)}, nil
+	}
+}
+
+type purgeUserRequest struct {
+	URN iam.UserURN
+}
+type purgeUserResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r purgeUserResponse) error() error { return r.Err }
+
+func makePurgeUserEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(purgeUserRequest)
+		return purgeUserResponse{Err: s.PurgeUser(ctx, req.URN)}, nil
+	}
+}
+
 type lockUserRequest struct {
 	URN    iam.UserURN
 	Locked bool
@@ -90,14 +160,115 @@ func makeLockUserEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
-type listUsersRequest struct{}
+type revokeTokensRequest struct {
+	URN iam.UserURN
+}
+type revokeTokensResponse struct {
+	Err error `json:"error,omitempty"`
+}
 
-// A list of users accounts
+func (r revokeTokensResponse) error() error { return r.Err }
+
+func makeRevokeTokensEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(revokeTokensRequest)
+		return revokeTokensResponse{Err: s.RevokeTokens(ctx, req.URN)}, nil
+	}
+}
+
+type revokeTokenRequest struct {
+	TokenID string
+}
+type revokeTokenResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r revokeTokenResponse) error() error { return r.Err }
+
+func makeRevokeTokenEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(revokeTokenRequest)
+		return revokeTokenResponse{Err: s.RevokeToken(ctx, req.TokenID)}, nil
+	}
+}
+
+// Changes the username of a user account.
+// swagger:parameters setUsername
+type setUsernameRequest struct {
+	// swagger:ignore
+	URN iam.UserURN `json:"-"`
+
+	// The new username
+	// in: body
+	// required: true
+	Username string `json:"username"`
+}
+type setUsernameResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r setUsernameResponse) error() error { return r.Err }
+
+func makeSetUsernameEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setUsernameRequest)
+		return setUsernameResponse{Err: s.SetUsername(ctx, req.URN, req.Username)}, nil
+	}
+}
+
+// Sets a new password for a user account.
+// swagger:parameters setPassword
+type setPasswordRequest struct {
+	// swagger:ignore
+	URN iam.UserURN `json:"-"`
+
+	// The new password
+	// in: body
+	// required: true
+	Password string `json:"password"`
+}
+type setPasswordResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r setPasswordResponse) error() error { return r.Err }
+
+func makeSetPasswordEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setPasswordRequest)
+		return setPasswordResponse{Err: s.SetPassword(ctx, req.URN, req.Password)}, nil
+	}
+}
+
+type expirePasswordRequest struct {
+	URN iam.UserURN
+}
+type expirePasswordResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r expirePasswordResponse) error() error { return r.Err }
+
+func makeExpirePasswordEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(expirePasswordRequest)
+		return expirePasswordResponse{Err: s.ExpirePassword(ctx, req.URN)}, nil
+	}
+}
+
+type listUsersRequest struct {
+	Query iam.UserQuery
+}
+
+// A page of users accounts
 // swagger:model userList
 type listUsersResponse struct {
-	// All users accounts stored in IAM
+	// The users accounts of the page
 	Users []iam.User `json:"users,omitempty"`
 
+	// Cursor to fetch the next page. Empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+
 	// swagger:ignore
 	Err error `json:"error,omitempty"`
 }
@@ -106,9 +277,9 @@ func (r listUsersResponse) error() error { return r.Err }
 
 func makeListUsersEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		_ = request.(listUsersRequest)
-		users, err := s.Users(ctx)
-		return listUsersResponse{Users: users, Err: err}, nil
+		req := request.(listUsersRequest)
+		page, err := s.QueryUsers(ctx, req.Query)
+		return listUsersResponse{Users: page.Users, NextCursor: page.NextCursor, Err: err}, nil
 	}
 }
 
@@ -126,6 +297,8 @@ type updateAttrsResponse struct {
 	Err error `json:"error,omitempty"`
 }
 
+func (r updateAttrsResponse) error() error { return r.Err }
+
 func makeUpdateAttrsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(updateAttrsRequest)
@@ -134,6 +307,31 @@ func makeUpdateAttrsEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
+// Applies a JSON merge patch or JSON patch to the attributes of a user
+// swagger:parameters patchAttributes
+type patchAttrsRequest struct {
+	// swagger:ignore
+	URN iam.UserURN
+
+	// The patch. Its format depends on the Content-Type.
+	// in: body
+	// required: true
+	Patch iam.AttrPatch
+}
+type patchAttrsResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r patchAttrsResponse) error() error { return r.Err }
+
+func makePatchAttrsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(patchAttrsRequest)
+		err := s.PatchAttrs(ctx, req.URN, req.Patch)
+		return patchAttrsResponse{Err: err}, nil
+	}
+}
+
 // Sets a user attribute to a specific value
 // swagger:parameters setAttribute
 type setAttrRequest struct {
@@ -188,3 +386,32 @@ func makeDeleteAttrRequest(s Service) endpoint.Endpoint {
 		return deleteAttrResponse{Err: err}, nil
 	}
 }
+
+// Reconciles IAM users with authn-server accounts.
+// swagger:parameters reconcileUsers
+type reconcileRequest struct {
+	// Create IAM users for unmanaged authn-server accounts.
+	// in: query
+	Adopt bool
+
+	// Number of account IDs to probe for unmanaged accounts.
+	// in: query
+	ScanAhead int
+}
+type reconcileResponse struct {
+	ReconcileReport
+	Err error `json:"error,omitempty"`
+}
+
+func (r reconcileResponse) error() error { return r.Err }
+
+func makeReconcileEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(reconcileRequest)
+		report, err := s.Reconcile(ctx, ReconcileOptions{
+			Adopt:     req.Adopt,
+			ScanAhead: req.ScanAhead,
+		})
+		return reconcileResponse{ReconcileReport: report, Err: err}, nil
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -159,26 +160,47 @@ func Test_lockUserEndpoint(t *testing.T) {
 	})
 }
 
+func Test_passwordEndpoints(t *testing.T) {
+	s := &serviceMock{}
+
+	s.On("SetPassword", iam.UserURN("urn:iam::user/10"), "secret").Once().Return(nil)
+	res, err := makeSetPasswordEndpoint(s)(bg, setPasswordRequest{URN: "urn:iam::user/10", Password: "secret"})
+	assert.NoError(t, err)
+	assert.NoError(t, res.(setPasswordResponse).Err)
+
+	s.On("ExpirePassword", iam.UserURN("urn:iam::user/10")).Once().Return(errors.New("simulated"))
+	res, err = makeExpirePasswordEndpoint(s)(bg, expirePasswordRequest{URN: "urn:iam::user/10"})
+	assert.NoError(t, err)
+	assert.Error(t, res.(expirePasswordResponse).Err)
+
+	s.On("SetUsername", iam.UserURN("urn:iam::user/10"), "alice").Once().Return(nil)
+	res, err = makeSetUsernameEndpoint(s)(bg, setUsernameRequest{URN: "urn:iam::user/10", Username: "alice"})
+	assert.NoError(t, err)
+	assert.NoError(t, res.(setUsernameResponse).Err)
+}
+
 func Test_listUsersEndpoint(t *testing.T) {
 	s := &serviceMock{}
 	ep := makeListUsersEndpoint(s)
 	expectedUser := iam.User{
 		Username: "admin",
 	}
+	q := iam.UserQuery{UsernamePrefix: "a", Limit: 1}
 
-	s.On("Users").Once().Return([]iam.User(nil), errors.New("some-error"))
-	res, err := ep(bg, listUsersRequest{})
+	s.On("QueryUsers", q).Once().Return(iam.UserPage{}, errors.New("some-error"))
+	res, err := ep(bg, listUsersRequest{Query: q})
 	assert.NoError(t, err)
 	assert.NotNil(t, res)
 	assert.Nil(t, res.(listUsersResponse).Users)
 	assert.Error(t, res.(listUsersResponse).Err)
 
-	s.On("Users").Once().Return([]iam.User{expectedUser}, nil)
-	res, err = ep(bg, listUsersRequest{})
+	s.On("QueryUsers", q).Once().Return(iam.UserPage{Users: []iam.User{expectedUser}, NextCursor: "next"}, nil)
+	res, err = ep(bg, listUsersRequest{Query: q})
 	assert.NoError(t, err)
 	assert.NotNil(t, res)
 	assert.NotNil(t, res.(listUsersResponse).Users)
 	assert.Equal(t, []iam.User{expectedUser}, res.(listUsersResponse).Users)
+	assert.Equal(t, "next", res.(listUsersResponse).NextCursor)
 	assert.NoError(t, res.(listUsersResponse).Err)
 }
 
@@ -236,6 +258,25 @@ func Test_deleteAttributeEndpoint(t *testing.T) {
 	assert.Error(t, res.(deleteAttrResponse).Err)
 }
 
+func Test_reconcileEndpoint(t *testing.T) {
+	s := &serviceMock{}
+	ep := makeReconcileEndpoint(s)
+	report := ReconcileReport{Orphaned: []iam.UserURN{"urn:iam::user/10"}}
+
+	s.On("Reconcile", ReconcileOptions{Adopt: true}).Once().Return(report, nil)
+
+	res, err := ep(bg, reconcileRequest{Adopt: true})
+	assert.NoError(t, err)
+	assert.Equal(t, report, res.(reconcileResponse).ReconcileReport)
+	assert.NoError(t, res.(reconcileResponse).Err)
+
+	s.On("Reconcile", ReconcileOptions{}).Once().Return(ReconcileReport{}, errors.New("some-error"))
+
+	res, err = ep(bg, reconcileRequest{})
+	assert.NoError(t, err)
+	assert.Error(t, res.(reconcileResponse).Err)
+}
+
 type serviceMock struct {
 	mock.Mock
 }
@@ -250,19 +291,68 @@ func (s *serviceMock) LoadUser(_ context.Context, urn iam.UserURN) (iam.User, er
 	return args.Get(0).(iam.User), args.Error(1)
 }
 
+func (s *serviceMock) LoadUserByUsername(_ context.Context, username string) (iam.User, error) {
+	args := s.Called(username)
+	return args.Get(0).(iam.User), args.Error(1)
+}
+
+func (s *serviceMock) LoadUserByAttr(_ context.Context, key, value string) (iam.User, error) {
+	args := s.Called(key, value)
+	return args.Get(0).(iam.User), args.Error(1)
+}
+
 func (s *serviceMock) DeleteUser(_ context.Context, urn iam.UserURN) error {
 	return s.Called(urn).Error(0)
 }
 
+func (s *serviceMock) RestoreUser(_ context.Context, urn iam.UserURN) error {
+	return s.Called(urn).Error(0)
+}
+
+func (s *serviceMock) PurgeUser(_ context.Context, urn iam.UserURN) error {
+	return s.Called(urn).Error(0)
+}
+
+func (s *serviceMock) PurgeDeletedUsers(_ context.Context, t time.Time) ([]iam.UserURN, error) {
+	args := s.Called(t)
+	res, _ := args.Get(0).([]iam.UserURN)
+	return res, args.Error(1)
+}
+
 func (s *serviceMock) LockUser(_ context.Context, urn iam.UserURN, locked bool) error {
 	return s.Called(urn, locked).Error(0)
 }
 
+func (s *serviceMock) RevokeTokens(_ context.Context, urn iam.UserURN) error {
+	return s.Called(urn).Error(0)
+}
+
+func (s *serviceMock) RevokeToken(_ context.Context, tokenID string) error {
+	return s.Called(tokenID).Error(0)
+}
+
+func (s *serviceMock) SetUsername(_ context.Context, urn iam.UserURN, username string) error {
+	return s.Called(urn, username).Error(0)
+}
+
+func (s *serviceMock) SetPassword(_ context.Context, urn iam.UserURN, password string) error {
+	return s.Called(urn, password).Error(0)
+}
+
+func (s *serviceMock) ExpirePassword(_ context.Context, urn iam.UserURN) error {
+	return s.Called(urn).Error(0)
+}
+
 func (s *serviceMock) Users(_ context.Context) ([]iam.User, error) {
 	args := s.Called()
 	return args.Get(0).([]iam.User), args.Error(1)
 }
 
+func (s *serviceMock) QueryUsers(_ context.Context, q iam.UserQuery) (iam.UserPage, error) {
+	args := s.Called(q)
+	return args.Get(0).(iam.UserPage), args.Error(1)
+}
+
 func (s *serviceMock) UpdateAttrs(_ context.Context, id iam.UserURN, attrs map[string]interface{}) error {
 	return s.Called(id, attrs).Error(0)
 }
@@ -275,6 +365,20 @@ func (s *serviceMock) DeleteAttr(_ context.Context, urn iam.UserURN, key string)
 	return s.Called(urn, key).Error(0)
 }
 
+func (s *serviceMock) PatchAttrs(_ context.Context, urn iam.UserURN, patch iam.AttrPatch) error {
+	return s.Called(urn, patch).Error(0)
+}
+
+func (s *serviceMock) Reconcile(_ context.Context, opts ReconcileOptions) (ReconcileReport, error) {
+	args := s.Called(opts)
+	return args.Get(0).(ReconcileReport), args.Error(1)
+}
+
+func (s *serviceMock) ProvisionUser(_ context.Context, accountID int, username string, attrs map[string]interface{}, sync bool) (iam.User, bool, error) {
+	args := s.Called(accountID, username, attrs, sync)
+	return args.Get(0).(iam.User), args.Bool(1), args.Error(2)
+}
+
 func (s *serviceMock) OnDelete(_ context.Context, fn OnDeleteFunc) {
 	s.Called(fn)
 }
@@ -0,0 +1,56 @@
+package user
+
+import (
+	"context"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+type activeUserEnforcer struct {
+	enforcer enforcer.Enforcer
+	repo     iam.UserRepository
+}
+
+// NewActiveUserEnforcer returns an enforcer.Enforcer that denies all
+// actions of soft-deleted users and delegates all other decisions to e.
+// Subjects that are not managed users, e.g. certificate subjects, are
+// only subject to e. If the user cannot be loaded the request is denied.
+// The user is loaded once per request (see enforcer.Once).
+func NewActiveUserEnforcer(e enforcer.Enforcer, repo iam.UserRepository) enforcer.Enforcer {
+	return &activeUserEnforcer{
+		enforcer: e,
+		repo:     repo,
+	}
+}
+
+// Enforce implements the enforcer.Enforcer interface.
+func (e *activeUserEnforcer) Enforce(ctx context.Context, subject, action, resource string, context enforcer.Context) error {
+	if urn := iam.UserURN(subject); urn.IsValid() {
+		err := enforcer.Once(ctx, activeUserKey(urn), func() error {
+			return e.checkActive(ctx, urn)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.enforcer.Enforce(ctx, subject, action, resource, context)
+}
+
+// activeUserKey is the request cache key of the active check of a user.
+type activeUserKey iam.UserURN
+
+// checkActive denies all actions if the user urn has been soft-deleted or
+// cannot be loaded.
+func (e *activeUserEnforcer) checkActive(ctx context.Context, urn iam.UserURN) error {
+	u, err := e.repo.Load(ctx, urn)
+	switch {
+	case err == nil && u.DeletedAt != nil:
+		return &enforcer.PermissionDeniedError{Reason: "user has been deleted"}
+	case err != nil && !common.IsNotFound(err):
+		return &enforcer.PermissionDeniedError{Reason: err.Error()}
+	}
+	return nil
+}
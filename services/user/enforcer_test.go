@@ -0,0 +1,44 @@
+package user
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+func TestActiveUserEnforcer(t *testing.T) {
+	r := &userRepoMock{}
+	e := NewActiveUserEnforcer(enforcer.NewNoOpEnforcer(), r)
+
+	deleted := expectedUser(2)
+	deleted.DeletedAt = &time.Time{}
+
+	r.On("Load", iam.UserURN("urn:iam::user/1")).Return(expectedUser(1), nil)
+	r.On("Load", iam.UserURN("urn:iam::user/2")).Return(deleted, nil)
+	r.On("Load", iam.UserURN("urn:iam::user/3")).Return(iam.User{}, common.NewNotFoundError("user"))
+	r.On("Load", iam.UserURN("urn:iam::user/4")).Return(iam.User{}, errors.New("simulated"))
+
+	assert.NoError(t, e.Enforce(bg, "urn:iam::user/1", ActionLoadUser, "", nil))
+	assert.Error(t, e.Enforce(bg, "urn:iam::user/2", ActionLoadUser, "", nil))
+	assert.NoError(t, e.Enforce(bg, "urn:iam::user/3", ActionLoadUser, "", nil))
+	assert.Error(t, e.Enforce(bg, "urn:iam::user/4", ActionLoadUser, "", nil))
+	assert.NoError(t, e.Enforce(bg, "CN=backup", ActionLoadUser, "", nil))
+}
+
+func TestActiveUserEnforcer_RequestCache(t *testing.T) {
+	r := &userRepoMock{}
+	e := NewActiveUserEnforcer(enforcer.NewNoOpEnforcer(), r)
+
+	r.On("Load", iam.UserURN("urn:iam::user/1")).Return(expectedUser(1), nil).Once()
+
+	// the user is only loaded once per request
+	ctx := enforcer.WithRequestCache(bg)
+	assert.NoError(t, e.Enforce(ctx, "urn:iam::user/1", ActionLoadUser, "", nil))
+	assert.NoError(t, e.Enforce(ctx, "urn:iam::user/1", ActionReadAttr("email"), "urn:iam::user/1", nil))
+	r.AssertNumberOfCalls(t, "Load", 1)
+}
@@ -0,0 +1,614 @@
+package user_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/client"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iamtest"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/attribute"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestIntegration_UserLifecycle(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	revocations, err := authn.NewRevocationList(context.Background(), inmem.NewRevocationRepository(), time.Hour)
+	require.NoError(t, err)
+
+	us := user.NewService(inmem.NewUserRepository(), as, revocations)
+	handler := user.MakeHandler(us, revocations.Wrap(as.ExtractTokenSubject), enforcer.NewNoOpEnforcer(), log.NewNopLogger())
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	adminID := authnServer.AddAccount("admin", "password", false)
+	cli := client.NewIdentityClient(srv.URL,
+		client.WithTokenLoader(iamtest.StaticToken(authnServer.Token(adminID, iamtest.Audience))),
+	).Users()
+
+	ctx := context.Background()
+
+	urn, err := cli.CreateUser(ctx, "alice", "secret", map[string]interface{}{"job": "vet"})
+	require.NoError(t, err)
+
+	u, err := cli.LoadUser(ctx, urn)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", u.Username)
+	assert.Equal(t, "vet", u.Attributes["job"])
+
+	account, ok := authnServer.Account(u.AccountID)
+	require.True(t, ok)
+	assert.Equal(t, "alice", account.Username)
+
+	require.NoError(t, cli.SetPassword(ctx, urn, "new-secret"))
+	assert.True(t, authnServer.CheckPassword(u.AccountID, "new-secret"))
+
+	require.NoError(t, cli.ExpirePassword(ctx, urn))
+	assert.True(t, authnServer.PasswordExpired(u.AccountID))
+
+	require.NoError(t, cli.SetUsername(ctx, urn, "alice.smith"))
+	account, _ = authnServer.Account(u.AccountID)
+	assert.Equal(t, "alice.smith", account.Username)
+	u, err = cli.LoadUser(ctx, urn)
+	require.NoError(t, err)
+	assert.Equal(t, "alice.smith", u.Username)
+
+	// admin is already taken
+	assert.Error(t, cli.SetUsername(ctx, urn, "admin"))
+
+	aliceCli := client.NewIdentityClient(srv.URL,
+		client.WithTokenLoader(iamtest.StaticToken(authnServer.Token(u.AccountID, iamtest.Audience))),
+	).Users()
+	_, err = aliceCli.Users(ctx)
+	require.NoError(t, err)
+
+	// single tokens can be revoked by their ID
+	now := time.Now()
+	revoked, err := authnServer.Sign(jwt.Claims{
+		ID:       "alice-1",
+		Issuer:   authnServer.URL,
+		Subject:  strconv.Itoa(u.AccountID),
+		Audience: jwt.Audience{iamtest.Audience},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	})
+	require.NoError(t, err)
+	revokedCli := client.NewIdentityClient(srv.URL, client.WithTokenLoader(iamtest.StaticToken(revoked))).Users()
+	_, err = revokedCli.Users(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, cli.RevokeToken(ctx, "alice-1"))
+	_, err = revokedCli.Users(ctx)
+	assert.Error(t, err)
+	_, err = aliceCli.Users(ctx)
+	assert.NoError(t, err)
+
+	require.NoError(t, cli.LockUser(ctx, urn, true))
+	account, _ = authnServer.Account(u.AccountID)
+	assert.True(t, account.Locked)
+
+	// locking alice revokes her tokens but not the ones
+	// of admin.
+	_, err = aliceCli.Users(ctx)
+	assert.Error(t, err)
+	_, err = cli.Users(ctx)
+	assert.NoError(t, err)
+
+	// deleting alice only locks her account and hides her
+	require.NoError(t, cli.DeleteUser(ctx, urn))
+	account, _ = authnServer.Account(u.AccountID)
+	assert.True(t, account.Locked)
+	assert.False(t, account.Deleted)
+
+	_, err = cli.LoadUser(ctx, urn)
+	assert.Error(t, err)
+	page, err := cli.QueryUsers(ctx, iam.UserQuery{})
+	require.NoError(t, err)
+	assert.Len(t, page.Users, 0)
+
+	page, err = cli.QueryUsers(ctx, iam.UserQuery{Deleted: true})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, urn, page.Users[0].ID)
+	assert.Equal(t, "vet", page.Users[0].Attributes["job"])
+	require.NotNil(t, page.Users[0].DeletedAt)
+
+	// alice was locked before so restoring keeps her locked
+	require.NoError(t, cli.RestoreUser(ctx, urn))
+	u, err = cli.LoadUser(ctx, urn)
+	require.NoError(t, err)
+	assert.Nil(t, u.DeletedAt)
+	account, _ = authnServer.Account(u.AccountID)
+	assert.True(t, account.Locked)
+
+	assert.Error(t, cli.RestoreUser(ctx, urn))
+
+	require.NoError(t, cli.DeleteUser(ctx, urn))
+	require.NoError(t, cli.PurgeUser(ctx, urn))
+	account, _ = authnServer.Account(u.AccountID)
+	assert.True(t, account.Deleted)
+
+	_, err = cli.LoadUser(ctx, urn)
+	assert.Error(t, err)
+	assert.Error(t, cli.RestoreUser(ctx, urn))
+
+	unauthorized := client.NewIdentityClient(srv.URL,
+		client.WithTokenLoader(iamtest.StaticToken("invalid-token")),
+	).Users()
+
+	_, err = unauthorized.Users(ctx)
+	assert.Error(t, err)
+}
+
+func TestIntegration_Reconcile(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	us := user.NewService(inmem.NewUserRepository(), as, nil)
+	ctx := context.Background()
+
+	urn, err := us.CreateUser(ctx, "alice", "secret", nil)
+	require.NoError(t, err)
+
+	u, err := us.LoadUser(ctx, urn)
+	require.NoError(t, err)
+
+	unmanaged := authnServer.AddAccount("bob", "secret", true)
+
+	// lock alice directly on authn-server
+	require.NoError(t, as.LockAccount(ctx, u.AccountID))
+
+	report, err := us.Reconcile(ctx, user.ReconcileOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []iam.UserURN{urn}, report.Updated)
+	assert.Equal(t, []int{unmanaged}, report.Unmanaged)
+	assert.Empty(t, report.Adopted)
+
+	u, err = us.LoadUser(ctx, urn)
+	require.NoError(t, err)
+	require.NotNil(t, u.Locked)
+	assert.True(t, *u.Locked)
+
+	report, err = us.Reconcile(ctx, user.ReconcileOptions{Adopt: true})
+	require.NoError(t, err)
+	assert.Empty(t, report.Updated)
+	require.Len(t, report.Adopted, 1)
+
+	bob, err := us.LoadUser(ctx, report.Adopted[0])
+	require.NoError(t, err)
+	assert.Equal(t, "bob", bob.Username)
+	assert.True(t, *bob.Locked)
+
+	require.NoError(t, as.ArchiveAccount(ctx, u.AccountID))
+
+	report, err = us.Reconcile(ctx, user.ReconcileOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []iam.UserURN{urn}, report.Deleted)
+
+	_, err = us.LoadUser(ctx, urn)
+	assert.Error(t, err)
+
+	// the user is soft-deleted and kept until it is purged
+	page, err := us.QueryUsers(ctx, iam.UserQuery{Deleted: true})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, urn, page.Users[0].ID)
+
+	purged, err := us.PurgeDeletedUsers(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []iam.UserURN{urn}, purged)
+}
+
+func TestIntegration_ListUsers(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	members := inmem.NewMembershipRepository()
+	us := user.NewService(inmem.NewUserRepositoryWithMemberships(members), as, nil)
+	handler := user.MakeHandler(us, as.ExtractTokenSubject, enforcer.NewNoOpEnforcer(), log.NewNopLogger())
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	adminID := authnServer.AddAccount("admin", "password", false)
+	cli := client.NewIdentityClient(srv.URL,
+		client.WithTokenLoader(iamtest.StaticToken(authnServer.Token(adminID, iamtest.Audience))),
+	).Users()
+
+	ctx := context.Background()
+
+	var urns []iam.UserURN
+	for _, name := range []string{"dave", "alice", "carol", "bob", "anna"} {
+		urn, err := us.CreateUser(ctx, name, "secret", map[string]interface{}{"department": "surgery"})
+		require.NoError(t, err)
+		urns = append(urns, urn)
+	}
+	require.NoError(t, us.SetAttr(ctx, urns[2], "department", "lab"))
+	require.NoError(t, us.LockUser(ctx, urns[3], true))
+	require.NoError(t, members.AddMember(ctx, urns[0], "urn:iam::group/vets"))
+
+	list := func(q iam.UserQuery) []string {
+		var names []string
+		it := cli.IterateUsers(ctx, q)
+		for it.Next() {
+			names = append(names, it.User().Username)
+		}
+		require.NoError(t, it.Err())
+		return names
+	}
+
+	assert.Equal(t, []string{"alice", "anna", "bob", "carol", "dave"}, list(iam.UserQuery{Limit: 2}))
+	assert.Equal(t, []string{"dave", "alice", "carol", "bob", "anna"}, list(iam.UserQuery{SortBy: iam.SortByAccountID, Limit: 3}))
+	assert.Equal(t, []string{"alice", "anna"}, list(iam.UserQuery{UsernamePrefix: "a", Limit: 1}))
+	assert.Equal(t, []string{"carol"}, list(iam.UserQuery{Attributes: map[string]string{"department": "lab"}}))
+
+	locked := true
+	assert.Equal(t, []string{"bob"}, list(iam.UserQuery{Locked: &locked}))
+	assert.Equal(t, []string{"dave"}, list(iam.UserQuery{Group: "vets"}))
+
+	page, err := cli.QueryUsers(ctx, iam.UserQuery{Limit: 4})
+	require.NoError(t, err)
+	assert.Len(t, page.Users, 4)
+	assert.NotEmpty(t, page.NextCursor)
+
+	_, err = cli.QueryUsers(ctx, iam.UserQuery{SortBy: "email"})
+	assert.Error(t, err)
+}
+
+func TestIntegration_Metadata(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	us := user.NewService(inmem.NewUserRepository(), as, nil)
+	handler := user.MakeHandler(us, as.ExtractTokenSubject, enforcer.NewNoOpEnforcer(), log.NewNopLogger())
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	adminID := authnServer.AddAccount("admin", "password", false)
+	admin := iam.UserURN(fmt.Sprintf("urn:iam::user/%d", adminID))
+	cli := client.NewIdentityClient(srv.URL,
+		client.WithTokenLoader(iamtest.StaticToken(authnServer.Token(adminID, iamtest.Audience))),
+	).Users()
+
+	ctx := context.Background()
+	start := time.Now()
+
+	alice, err := cli.CreateUser(ctx, "alice", "secret", nil)
+	require.NoError(t, err)
+	bob, err := cli.CreateUser(ctx, "bob", "secret", nil)
+	require.NoError(t, err)
+
+	// changes without a subject are not attributed to anyone
+	carol, err := us.CreateUser(ctx, "carol", "secret", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, us.SetAttr(ctx, bob, "job", "vet"))
+	require.NoError(t, cli.SetAttr(ctx, alice, "job", "vet"))
+
+	u, err := cli.LoadUser(ctx, alice)
+	require.NoError(t, err)
+	assert.Equal(t, string(admin), u.CreatedBy)
+	assert.Equal(t, string(admin), u.UpdatedBy)
+	assert.True(t, u.CreatedAt.After(start))
+	assert.True(t, u.UpdatedAt.After(u.CreatedAt))
+
+	u, err = cli.LoadUser(ctx, bob)
+	require.NoError(t, err)
+	assert.Equal(t, string(admin), u.CreatedBy)
+	assert.Empty(t, u.UpdatedBy)
+
+	list := func(q iam.UserQuery) []iam.UserURN {
+		var urns []iam.UserURN
+		it := cli.IterateUsers(ctx, q)
+		for it.Next() {
+			urns = append(urns, it.User().ID)
+		}
+		require.NoError(t, it.Err())
+		return urns
+	}
+
+	assert.Equal(t, []iam.UserURN{carol, bob, alice}, list(iam.UserQuery{SortBy: iam.SortByUpdatedAt, Limit: 1}))
+	assert.Equal(t, []iam.UserURN{alice, bob}, list(iam.UserQuery{MetadataFilter: iam.MetadataFilter{CreatedBy: string(admin)}}))
+	assert.Equal(t, []iam.UserURN{alice}, list(iam.UserQuery{MetadataFilter: iam.MetadataFilter{UpdatedBy: string(admin)}}))
+	assert.Empty(t, list(iam.UserQuery{MetadataFilter: iam.MetadataFilter{CreatedBefore: start}}))
+}
+
+func TestIntegration_ConditionalUpdates(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	us := user.NewService(inmem.NewUserRepository(), as, nil)
+	handler := user.MakeHandler(us, as.ExtractTokenSubject, enforcer.NewNoOpEnforcer(), log.NewNopLogger())
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	adminID := authnServer.AddAccount("admin", "password", false)
+	token := authnServer.Token(adminID, iamtest.Audience)
+	cli := client.NewIdentityClient(srv.URL, client.WithTokenLoader(iamtest.StaticToken(token))).Users()
+
+	ctx := context.Background()
+
+	alice, err := cli.CreateUser(ctx, "alice", "secret", nil)
+	require.NoError(t, err)
+
+	u, err := cli.LoadUser(ctx, alice)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), u.Version)
+
+	// GET responses carry the version as ETag
+	req := httptest.NewRequest("GET", "/v1/users/"+alice.AccountID(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, common.ETag(u.Version), rec.Header().Get("ETag"))
+
+	require.NoError(t, cli.SetAttr(client.IfMatch(ctx, u.Version), alice, "job", "vet"))
+
+	// the second update is based on an outdated version
+	err = cli.UpdateAttrs(client.IfMatch(ctx, u.Version), alice, map[string]interface{}{"job": "nurse"})
+	assert.Equal(t, client.ErrPreconditionFailed, err)
+	err = cli.DeleteUser(client.IfMatch(ctx, u.Version), alice)
+	assert.Equal(t, client.ErrPreconditionFailed, err)
+
+	u, err = cli.LoadUser(ctx, alice)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), u.Version)
+	assert.Equal(t, map[string]interface{}{"job": "vet"}, u.Attributes)
+
+	// unconditional updates still overwrite
+	require.NoError(t, cli.UpdateAttrs(ctx, alice, map[string]interface{}{"job": "nurse"}))
+	require.NoError(t, cli.DeleteUser(client.IfMatch(ctx, 3), alice))
+}
+
+func TestIntegration_AttributeAccess(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	authz := iamtest.DenyActions{
+		user.ActionReadAttr("salary"):  true,
+		user.ActionWriteAttr("salary"): true,
+		user.ActionWriteAttr("email"):  true,
+	}
+
+	us := user.NewService(inmem.NewUserRepository(), as, nil)
+	handler := user.MakeHandler(user.NewAttrAccessService(us, authz), as.ExtractTokenSubject, authz, log.NewNopLogger())
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	adminID := authnServer.AddAccount("admin", "password", false)
+	cli := client.NewIdentityClient(srv.URL,
+		client.WithTokenLoader(iamtest.StaticToken(authnServer.Token(adminID, iamtest.Audience))),
+	).Users()
+
+	ctx := context.Background()
+
+	// calls without a subject are not restricted
+	urn, err := us.CreateUser(ctx, "alice", "secret", map[string]interface{}{
+		"email":  "alice@example.com",
+		"salary": "A3",
+		"phone":  "+43123",
+	})
+	require.NoError(t, err)
+
+	u, err := cli.LoadUser(ctx, urn)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"email": "alice@example.com", "phone": "+43123"}, u.Attributes)
+
+	users, err := cli.Users(ctx)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.NotContains(t, users[0].Attributes, "salary")
+
+	// users cannot be selected by attributes that are not readable
+	_, err = cli.QueryUsers(ctx, iam.UserQuery{Attributes: map[string]string{"salary": "A3"}})
+	assert.Error(t, err)
+	page, err := cli.QueryUsers(ctx, iam.UserQuery{Attributes: map[string]string{"email": "alice@example.com"}})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.NotContains(t, page.Users[0].Attributes, "salary")
+
+	assert.Error(t, cli.SetAttr(ctx, urn, "salary", "A4"))
+	assert.Error(t, cli.DeleteAttr(ctx, urn, "email"))
+	assert.NoError(t, cli.SetAttr(ctx, urn, "phone", "+43456"))
+
+	_, err = cli.CreateUser(ctx, "bob", "secret", map[string]interface{}{"salary": "B1"})
+	assert.Error(t, err)
+
+	// unchanged and unreadable attributes don't need to be writable
+	require.NoError(t, cli.UpdateAttrs(ctx, urn, map[string]interface{}{"email": "alice@example.com", "phone": "+43789"}))
+
+	u, err = us.LoadUser(ctx, urn)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"email": "alice@example.com", "phone": "+43789", "salary": "A3"}, u.Attributes)
+
+	assert.Error(t, cli.UpdateAttrs(ctx, urn, map[string]interface{}{"phone": "+43789"}))
+	assert.Error(t, cli.UpdateAttrs(ctx, urn, map[string]interface{}{"email": "alice@example.org", "phone": "+43789"}))
+}
+
+func TestIntegration_LookupUser(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	attrs := attribute.NewService(inmem.NewAttributeRepository(), nil)
+	ctx := context.Background()
+	require.NoError(t, attrs.Define(ctx, iam.AttributeDefinition{Name: "email", Type: iam.AttrTypeString, Format: iam.AttrFormatEmail, Unique: true}))
+
+	us := user.NewValidatingService(user.NewService(inmem.NewUserRepository(), as, nil), attrs)
+	handler := user.MakeHandler(us, as.ExtractTokenSubject, enforcer.NewNoOpEnforcer(), log.NewNopLogger())
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	adminID := authnServer.AddAccount("admin", "password", false)
+	cli := client.NewIdentityClient(srv.URL,
+		client.WithTokenLoader(iamtest.StaticToken(authnServer.Token(adminID, iamtest.Audience))),
+	).Users()
+
+	urn, err := cli.CreateUser(ctx, "alice", "secret", map[string]interface{}{"email": "alice@example.com"})
+	require.NoError(t, err)
+
+	u, err := cli.LoadUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, urn, u.ID)
+
+	u, err = cli.LoadUserByAttr(ctx, "email", "alice@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, urn, u.ID)
+
+	_, err = cli.LoadUserByUsername(ctx, "bob")
+	assert.Error(t, err)
+
+	// usernames and unique attributes must not be used twice
+	_, err = cli.CreateUser(ctx, "alice", "secret", nil)
+	assert.Error(t, err)
+	_, err = cli.CreateUser(ctx, "bob", "secret", map[string]interface{}{"email": "alice@example.com"})
+	assert.Error(t, err)
+
+	bob, err := cli.CreateUser(ctx, "bob", "secret", map[string]interface{}{"email": "bob@example.com"})
+	require.NoError(t, err)
+	assert.Error(t, cli.SetAttr(ctx, bob, "email", "alice@example.com"))
+	assert.Error(t, cli.SetUsername(ctx, bob, "alice"))
+
+	// updating a user with its own value is fine
+	assert.NoError(t, cli.SetAttr(ctx, urn, "email", "alice@example.com"))
+
+	// unique attributes are checked while the user service is locked
+	// so only one of many concurrent creates may claim a value.
+	var (
+		wg      sync.WaitGroup
+		l       sync.Mutex
+		created int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := us.CreateUser(ctx, fmt.Sprintf("carol-%d", i), "secret", map[string]interface{}{"email": "carol@example.com"})
+			if err == nil {
+				l.Lock()
+				created++
+				l.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, created)
+}
+
+func TestIntegration_PatchAttrs(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	attrs := attribute.NewService(inmem.NewAttributeRepository(), nil)
+	ctx := context.Background()
+	require.NoError(t, attrs.Define(ctx, iam.AttributeDefinition{Name: "email", Type: iam.AttrTypeString, Format: iam.AttrFormatEmail, Unique: true}))
+	require.NoError(t, attrs.Define(ctx, iam.AttributeDefinition{Name: "address", Type: iam.AttrTypeObject}))
+	require.NoError(t, attrs.Define(ctx, iam.AttributeDefinition{Name: "salary", Type: iam.AttrTypeString}))
+
+	authz := iamtest.DenyActions{
+		user.ActionReadAttr("salary"):  true,
+		user.ActionWriteAttr("salary"): true,
+	}
+
+	us := user.NewValidatingService(user.NewService(inmem.NewUserRepository(), as, nil), attrs)
+	handler := user.MakeHandler(user.NewAttrAccessService(us, authz), as.ExtractTokenSubject, authz, log.NewNopLogger())
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	adminID := authnServer.AddAccount("admin", "password", false)
+	token := authnServer.Token(adminID, iamtest.Audience)
+	cli := client.NewIdentityClient(srv.URL, client.WithTokenLoader(iamtest.StaticToken(token))).Users()
+
+	alice, err := us.CreateUser(ctx, "alice", "secret", map[string]interface{}{
+		"email":   "alice@example.com",
+		"salary":  "A3",
+		"address": map[string]interface{}{"city": "Vienna", "zip": "1010"},
+	})
+	require.NoError(t, err)
+	_, err = us.CreateUser(ctx, "bob", "secret", map[string]interface{}{"email": "bob@example.com"})
+	require.NoError(t, err)
+
+	require.NoError(t, cli.PatchAttrs(ctx, alice, iam.MergePatch{
+		"address": map[string]interface{}{"zip": nil, "street": "Ring"},
+	}))
+	require.NoError(t, cli.PatchAttrs(ctx, alice, iam.JSONPatch{
+		{Op: "test", Path: "/address/city", Value: "Vienna"},
+		{Op: "replace", Path: "/address/city", Value: "Graz"},
+	}))
+
+	u, err := us.LoadUser(ctx, alice)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"email":   "alice@example.com",
+		"salary":  "A3",
+		"address": map[string]interface{}{"city": "Graz", "street": "Ring"},
+	}, u.Attributes)
+
+	// patches are rejected as a whole
+	cases := []iam.AttrPatch{
+		// failed test operation
+		iam.JSONPatch{
+			{Op: "replace", Path: "/address/city", Value: "Linz"},
+			{Op: "test", Path: "/address/city", Value: "Vienna"},
+		},
+		// invalid values
+		iam.MergePatch{"address": nil, "email": "not-an-email"},
+		iam.JSONPatch{{Op: "replace", Path: "/address", Value: "Graz"}},
+		// unique values
+		iam.MergePatch{"email": "bob@example.com"},
+		// attribute access
+		iam.MergePatch{"salary": "A4"},
+		iam.JSONPatch{{Op: "copy", From: "/salary", Path: "/address/salary"}},
+		iam.JSONPatch{{Op: "replace", Path: "", Value: map[string]interface{}{}}},
+	}
+	for i, patch := range cases {
+		assert.Error(t, cli.PatchAttrs(ctx, alice, patch), "patch %d", i)
+	}
+
+	u2, err := us.LoadUser(ctx, alice)
+	require.NoError(t, err)
+	assert.Equal(t, u.Attributes, u2.Attributes)
+	assert.Equal(t, u.Version, u2.Version)
+
+	// patches are conditional as well
+	err = cli.PatchAttrs(client.IfMatch(ctx, u.Version-1), alice, iam.MergePatch{"email": "alice@example.org"})
+	assert.Equal(t, client.ErrPreconditionFailed, err)
+	require.NoError(t, cli.PatchAttrs(client.IfMatch(ctx, u.Version), alice, iam.MergePatch{"email": "alice@example.org"}))
+
+	req := httptest.NewRequest("PATCH", "/v1/users/"+alice.AccountID()+"/attrs/", strings.NewReader(`{"email": null}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
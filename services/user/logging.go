@@ -46,6 +46,32 @@ func (s *loggingService) LoadUser(ctx context.Context, urn iam.UserURN) (user ia
 	return s.Service.LoadUser(ctx, urn)
 }
 
+func (s *loggingService) LoadUserByUsername(ctx context.Context, username string) (user iam.User, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "load_user_by_username",
+			"username", username,
+			"urn", user.ID,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.LoadUserByUsername(ctx, username)
+}
+
+func (s *loggingService) LoadUserByAttr(ctx context.Context, key, value string) (user iam.User, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "load_user_by_attr",
+			"key", key,
+			"urn", user.ID,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.LoadUserByAttr(ctx, key, value)
+}
+
 func (s *loggingService) DeleteUser(ctx context.Context, urn iam.UserURN) (err error) {
 	defer func(begin time.Time) {
 		s.logger.Log(
@@ -58,6 +84,43 @@ func (s *loggingService) DeleteUser(ctx context.Context, urn iam.UserURN) (err e
 	return s.Service.DeleteUser(ctx, urn)
 }
 
+func (s *loggingService) RestoreUser(ctx context.Context, urn iam.UserURN) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "restore_user",
+			"urn", urn,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.RestoreUser(ctx, urn)
+}
+
+func (s *loggingService) PurgeUser(ctx context.Context, urn iam.UserURN) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "purge_user",
+			"urn", urn,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.PurgeUser(ctx, urn)
+}
+
+func (s *loggingService) PurgeDeletedUsers(ctx context.Context, t time.Time) (purged []iam.UserURN, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "purge_deleted_users",
+			"deleted_before", t,
+			"purged", len(purged),
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.PurgeDeletedUsers(ctx, t)
+}
+
 func (s *loggingService) LockUser(ctx context.Context, urn iam.UserURN, locked bool) (err error) {
 	defer func(begin time.Time) {
 		s.logger.Log(
@@ -71,6 +134,66 @@ func (s *loggingService) LockUser(ctx context.Context, urn iam.UserURN, locked b
 	return s.Service.LockUser(ctx, urn, locked)
 }
 
+func (s *loggingService) RevokeTokens(ctx context.Context, urn iam.UserURN) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "revoke_tokens",
+			"urn", urn,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.RevokeTokens(ctx, urn)
+}
+
+func (s *loggingService) RevokeToken(ctx context.Context, tokenID string) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "revoke_token",
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.RevokeToken(ctx, tokenID)
+}
+
+func (s *loggingService) SetUsername(ctx context.Context, urn iam.UserURN, username string) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "set_username",
+			"urn", urn,
+			"username", username,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.SetUsername(ctx, urn, username)
+}
+
+func (s *loggingService) SetPassword(ctx context.Context, urn iam.UserURN, password string) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "set_password",
+			"urn", urn,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.SetPassword(ctx, urn, password)
+}
+
+func (s *loggingService) ExpirePassword(ctx context.Context, urn iam.UserURN) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "expire_password",
+			"urn", urn,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.ExpirePassword(ctx, urn)
+}
+
 func (s *loggingService) Users(ctx context.Context) (users []iam.User, err error) {
 	defer func(begin time.Time) {
 		s.logger.Log(
@@ -82,6 +205,20 @@ func (s *loggingService) Users(ctx context.Context) (users []iam.User, err error
 	return s.Service.Users(ctx)
 }
 
+func (s *loggingService) QueryUsers(ctx context.Context, q iam.UserQuery) (page iam.UserPage, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "query_users",
+			"sort", q.SortBy,
+			"limit", q.Limit,
+			"results", len(page.Users),
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.QueryUsers(ctx, q)
+}
+
 func (s *loggingService) UpdateAttrs(ctx context.Context, urn iam.UserURN, attrs map[string]interface{}) (err error) {
 	defer func(begin time.Time) {
 		s.logger.Log(
@@ -120,6 +257,52 @@ func (s *loggingService) DeleteAttr(ctx context.Context, urn iam.UserURN, key st
 	return s.Service.DeleteAttr(ctx, urn, key)
 }
 
+func (s *loggingService) PatchAttrs(ctx context.Context, urn iam.UserURN, patch iam.AttrPatch) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "patch_attrs",
+			"urn", urn,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.PatchAttrs(ctx, urn, patch)
+}
+
+func (s *loggingService) Reconcile(ctx context.Context, opts ReconcileOptions) (report ReconcileReport, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "reconcile",
+			"adopt", opts.Adopt,
+			"updated", len(report.Updated),
+			"deleted", len(report.Deleted),
+			"orphaned", len(report.Orphaned),
+			"unmanaged", len(report.Unmanaged),
+			"adopted", len(report.Adopted),
+			"failed", len(report.Failed),
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Reconcile(ctx, opts)
+}
+
+func (s *loggingService) ProvisionUser(ctx context.Context, accountID int, username string, attrs map[string]interface{}, sync bool) (user iam.User, created bool, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "provision_user",
+			"accountID", accountID,
+			"username", username,
+			"attribute_count", len(attrs),
+			"sync", sync,
+			"created", created,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.ProvisionUser(ctx, accountID, username, attrs, sync)
+}
+
 func (s *loggingService) OnDelete(ctx context.Context, fn OnDeleteFunc) {
 	defer func(begin time.Time) {
 		s.logger.Log(
@@ -0,0 +1,270 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// DefaultProvisionTimeout is the default time allowed to provision a
+// user while a request is being authenticated.
+const DefaultProvisionTimeout = 10 * time.Second
+
+// GroupAssigner adds users to groups. It is implemented by group.Service.
+type GroupAssigner interface {
+	// AddMember adds member to the group grp.
+	AddMember(ctx context.Context, grp iam.GroupURN, member iam.UserURN) error
+}
+
+// GroupRule assigns users to Group if their token has a claim Claim
+// with the value Value. If the claim is a list, it matches if any
+// element equals Value.
+type GroupRule struct {
+	Claim string
+	Value string
+	Group iam.GroupURN
+}
+
+// ParseGroupRule parses a group rule in the format <claim>=<value>=<group>,
+// for example:
+//
+//	department=IT=urn:iam::group/it-staff
+func ParseGroupRule(rule string) (GroupRule, error) {
+	first := strings.Index(rule, "=")
+	last := strings.LastIndex(rule, "=")
+	if first <= 0 || first == last {
+		return GroupRule{}, fmt.Errorf("invalid group rule %q", rule)
+	}
+
+	r := GroupRule{
+		Claim: rule[:first],
+		Value: rule[first+1 : last],
+		Group: iam.GroupURN(rule[last+1:]),
+	}
+
+	if !r.Group.IsValid() {
+		return GroupRule{}, fmt.Errorf("invalid group rule %q: invalid group %q", rule, r.Group)
+	}
+
+	return r, nil
+}
+
+// matches reports whether claims satisfy the rule.
+func (r GroupRule) matches(claims map[string]interface{}) bool {
+	switch v := claims[r.Claim].(type) {
+	case string:
+		return v == r.Value
+	case []interface{}:
+		for _, e := range v {
+			if s, ok := e.(string); ok && s == r.Value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ProvisionConfig configures just-in-time provisioning of users.
+type ProvisionConfig struct {
+	// UsernameClaim is the token claim holding the username. If the
+	// claim is missing, the username of the authn-server account is
+	// used. Defaults to "username".
+	UsernameClaim string
+
+	// EmailClaim is the token claim holding the email address of
+	// the user. It is stored in the "email" attribute. Defaults to
+	// "email".
+	EmailClaim string
+
+	// Attributes maps user attribute keys to the token claims
+	// holding their values.
+	Attributes map[string]string
+
+	// GroupRules assign users to groups based on their token claims
+	// the first time they are seen, and on every new token if Sync is
+	// enabled. Rules for groups that do not exist are skipped.
+	GroupRules []GroupRule
+
+	// Sync updates the username and attributes of existing users
+	// whenever they present a new token.
+	Sync bool
+
+	// Timeout limits the time spent provisioning a user. Defaults
+	// to DefaultProvisionTimeout.
+	Timeout time.Duration
+}
+
+// Provisioner creates IAM users from the claims of verified access
+// tokens the first time they are seen.
+type Provisioner struct {
+	users  Service
+	groups GroupAssigner
+	cfg    ProvisionConfig
+
+	l sync.Mutex
+	// seen holds the issued-at time of the last token provisioned
+	// for each subject.
+	seen map[string]int64
+}
+
+// NewProvisioner returns a new provisioner that creates users using
+// users and assigns them to groups using groups. groups may be nil if
+// cfg does not contain any group rules.
+func NewProvisioner(users Service, groups GroupAssigner, cfg ProvisionConfig) *Provisioner {
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "username"
+	}
+	if cfg.EmailClaim == "" {
+		cfg.EmailClaim = "email"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultProvisionTimeout
+	}
+
+	p := &Provisioner{
+		users:  users,
+		groups: groups,
+		cfg:    cfg,
+		seen:   make(map[string]int64),
+	}
+
+	users.OnDelete(context.Background(), p.userDeleted)
+
+	return p
+}
+
+func (p *Provisioner) userDeleted(urn iam.UserURN) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	delete(p.seen, urn.AccountID())
+}
+
+// Provision creates or, if enabled, updates the user described by the
+// claims of a verified access token. The "sub" claim must hold the
+// authn-server account ID.
+func (p *Provisioner) Provision(ctx context.Context, claims map[string]interface{}) (iam.User, error) {
+	subject, _ := claims["sub"].(string)
+	accountID, err := strconv.Atoi(subject)
+	if err != nil {
+		return iam.User{}, common.NewInvalidArgumentError(fmt.Sprintf("invalid subject %q", subject))
+	}
+
+	username, _ := claims[p.cfg.UsernameClaim].(string)
+
+	var attrs map[string]interface{}
+	set := func(key string, value interface{}) {
+		if attrs == nil {
+			attrs = make(map[string]interface{})
+		}
+		attrs[key] = value
+	}
+
+	if email, ok := claims[p.cfg.EmailClaim]; ok {
+		set("email", email)
+	}
+	for key, claim := range p.cfg.Attributes {
+		if value, ok := claims[claim]; ok {
+			set(key, value)
+		}
+	}
+
+	user, _, err := p.users.ProvisionUser(ctx, accountID, username, attrs, p.cfg.Sync)
+	if err != nil {
+		return iam.User{}, err
+	}
+
+	p.l.Lock()
+	_, seen := p.seen[subject]
+	p.l.Unlock()
+
+	// rules are applied until they succeeded once so a failure right
+	// after the user has been created is retried with the next token.
+	if p.groups != nil && (!seen || p.cfg.Sync) {
+		for _, rule := range p.cfg.GroupRules {
+			if !rule.matches(claims) {
+				continue
+			}
+
+			// adding an existing member is a no-op.
+			err := p.groups.AddMember(ctx, rule.Group, user.ID)
+			switch {
+			case common.IsNotFound(err):
+				// the group of the rule has been deleted.
+				continue
+			case err != nil:
+				return user, fmt.Errorf("failed to add %s to %s: %w", user.ID, rule.Group, err)
+			}
+		}
+	}
+
+	return user, nil
+}
+
+// Wrap returns a SubjectExtractorFunc that uses fn to verify a token
+// and provisions the user of the token the first time it is seen. If
+// Sync is enabled, users are updated whenever a new token is seen.
+func (p *Provisioner) Wrap(fn authn.SubjectExtractorFunc) authn.SubjectExtractorFunc {
+	return func(token string) (string, string, error) {
+		subject, audience, err := fn(token)
+		if err != nil {
+			return "", "", err
+		}
+
+		// fn verified the token already so it's safe to
+		// just read the claims.
+		parsed, err := jwt.ParseSigned(token)
+		if err != nil {
+			return "", "", err
+		}
+
+		var claims map[string]interface{}
+		if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+			return "", "", err
+		}
+
+		var issuedAt int64
+		if iat, ok := claims["iat"].(float64); ok {
+			issuedAt = int64(iat)
+		}
+
+		p.l.Lock()
+		last, ok := p.seen[subject]
+		p.l.Unlock()
+
+		if ok && (!p.cfg.Sync || last >= issuedAt) {
+			return subject, audience, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+		defer cancel()
+
+		if _, err := p.Provision(ctx, claims); err != nil {
+			switch {
+			case errors.Is(err, ErrUsernameTaken):
+				return "", "", &authn.ProvisioningError{Reason: "username is already taken", Err: err}
+			case errors.Is(err, ErrUserDeleted):
+				return "", "", &authn.ProvisioningError{Reason: "user has been deleted", Err: err}
+			}
+			return "", "", fmt.Errorf("failed to provision user: %w", err)
+		}
+
+		p.l.Lock()
+		if prev, exists := p.seen[subject]; !exists || issuedAt > prev {
+			p.seen[subject] = issuedAt
+		}
+		p.l.Unlock()
+
+		return subject, audience, nil
+	}
+}
@@ -0,0 +1,223 @@
+package user_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iamtest"
+	"github.com/tierklinik-dobersberg/identity-server/repos/inmem"
+	"github.com/tierklinik-dobersberg/identity-server/services/group"
+	"github.com/tierklinik-dobersberg/identity-server/services/user"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestParseGroupRule(t *testing.T) {
+	rule, err := user.ParseGroupRule("department=IT=urn:iam::group/it")
+	require.NoError(t, err)
+	assert.Equal(t, user.GroupRule{Claim: "department", Value: "IT", Group: "urn:iam::group/it"}, rule)
+
+	rule, err = user.ParseGroupRule("expr=a=b=urn:iam::group/it")
+	require.NoError(t, err)
+	assert.Equal(t, "a=b", rule.Value)
+
+	for _, invalid := range []string{
+		"department",
+		"department=urn:iam::group/it",
+		"=IT=urn:iam::group/it",
+		"department=IT=it",
+	} {
+		_, err := user.ParseGroupRule(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// failingAssigner fails the first failures calls to AddMember.
+type failingAssigner struct {
+	user.GroupAssigner
+	failures int
+}
+
+func (f *failingAssigner) AddMember(ctx context.Context, grp iam.GroupURN, member iam.UserURN) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("simulated")
+	}
+	return f.GroupAssigner.AddMember(ctx, grp, member)
+}
+
+func TestProvisioner(t *testing.T) {
+	authnServer := iamtest.NewAuthn(t)
+	defer authnServer.Close()
+	as := authnServer.Service
+
+	ctx := context.Background()
+	us := user.NewService(inmem.NewUserRepository(), as, nil)
+	gs := group.NewService(us, inmem.NewGroupRepository(), inmem.NewMembershipRepository(), log.NewNopLogger())
+
+	it, err := gs.Create(ctx, "it", "")
+	require.NoError(t, err)
+
+	cfg := user.ProvisionConfig{
+		Attributes: map[string]string{"department": "dept"},
+		GroupRules: []user.GroupRule{
+			{Claim: "roles", Value: "admin", Group: it},
+			{Claim: "dept", Value: "HR", Group: "urn:iam::group/does-not-exist"},
+		},
+	}
+
+	id := authnServer.AddAccount("bob", "secret", false)
+	urn := iam.UserURN("urn:iam::user/" + strconv.Itoa(id))
+
+	token := func(id int, issuedAt time.Time, extra map[string]interface{}) string {
+		tok, err := authnServer.Sign(jwt.Claims{
+			Issuer:   authnServer.URL,
+			Subject:  strconv.Itoa(id),
+			Audience: jwt.Audience{iamtest.Audience},
+			IssuedAt: jwt.NewNumericDate(issuedAt),
+			Expiry:   jwt.NewNumericDate(issuedAt.Add(time.Hour)),
+		}, extra)
+		require.NoError(t, err)
+		return tok
+	}
+
+	now := time.Now()
+	first := token(id, now, map[string]interface{}{
+		"email": "bob@example.com",
+		"dept":  "IT",
+		"roles": []string{"admin", "staff"},
+	})
+
+	t.Run("CreateOnFirstSight", func(t *testing.T) {
+		extract := user.NewProvisioner(us, gs, cfg).Wrap(as.ExtractTokenSubject)
+
+		subject, _, err := extract(first)
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(id), subject)
+
+		u, err := us.LoadUser(ctx, urn)
+		require.NoError(t, err)
+		assert.Equal(t, "bob", u.Username)
+		assert.Equal(t, "bob@example.com", u.Attributes["email"])
+		assert.Equal(t, "IT", u.Attributes["department"])
+		require.NotNil(t, u.Locked)
+		assert.False(t, *u.Locked)
+
+		members, err := gs.GetMembers(ctx, it)
+		require.NoError(t, err)
+		assert.Equal(t, []iam.UserURN{urn}, members)
+	})
+
+	second := token(id, now.Add(2*time.Second), map[string]interface{}{
+		"username": "robert",
+		"email":    "robert@example.com",
+		"dept":     "Vet",
+	})
+
+	t.Run("NoSync", func(t *testing.T) {
+		extract := user.NewProvisioner(us, gs, cfg).Wrap(as.ExtractTokenSubject)
+
+		_, _, err := extract(second)
+		require.NoError(t, err)
+
+		u, err := us.LoadUser(ctx, urn)
+		require.NoError(t, err)
+		assert.Equal(t, "bob", u.Username)
+		assert.Equal(t, "IT", u.Attributes["department"])
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		syncCfg := cfg
+		syncCfg.Sync = true
+		extract := user.NewProvisioner(us, gs, syncCfg).Wrap(as.ExtractTokenSubject)
+
+		_, _, err := extract(second)
+		require.NoError(t, err)
+
+		u, err := us.LoadUser(ctx, urn)
+		require.NoError(t, err)
+		assert.Equal(t, "robert", u.Username)
+		assert.Equal(t, "robert@example.com", u.Attributes["email"])
+		assert.Equal(t, "Vet", u.Attributes["department"])
+	})
+
+	t.Run("MissingGroup", func(t *testing.T) {
+		extract := user.NewProvisioner(us, gs, cfg).Wrap(as.ExtractTokenSubject)
+
+		carol := authnServer.AddAccount("carol", "secret", false)
+
+		_, _, err := extract(token(carol, now, map[string]interface{}{"dept": "HR"}))
+		assert.NoError(t, err)
+	})
+
+	t.Run("FailedGroupRule", func(t *testing.T) {
+		groups := &failingAssigner{GroupAssigner: gs, failures: 1}
+		extract := user.NewProvisioner(us, groups, cfg).Wrap(as.ExtractTokenSubject)
+
+		erin := authnServer.AddAccount("erin", "secret", false)
+		tok := token(erin, now, map[string]interface{}{"roles": []string{"admin"}})
+
+		_, _, err := extract(tok)
+		assert.Error(t, err)
+
+		// the user exists already but the group rules are applied
+		// again.
+		_, _, err = extract(tok)
+		require.NoError(t, err)
+
+		members, err := gs.GetMembers(ctx, it)
+		require.NoError(t, err)
+		assert.Contains(t, members, iam.UserURN("urn:iam::user/"+strconv.Itoa(erin)))
+	})
+
+	t.Run("UsernameTaken", func(t *testing.T) {
+		extract := user.NewProvisioner(us, gs, cfg).Wrap(as.ExtractTokenSubject)
+
+		dave := authnServer.AddAccount("dave", "secret", false)
+
+		_, _, err := extract(token(dave, now, map[string]interface{}{"username": "robert"}))
+		var pe *authn.ProvisioningError
+		require.True(t, errors.As(err, &pe), err)
+		assert.Equal(t, "username is already taken", pe.Reason)
+		assert.Equal(t, http.StatusForbidden, pe.StatusCode())
+
+		// the error is not reported as an invalid token
+		ep := authn.NewAuthenticator(extract)(func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		reqCtx := context.WithValue(ctx, kithttp.ContextKeyRequestAuthorization, "Bearer "+token(dave, now, map[string]interface{}{"username": "robert"}))
+		_, err = ep(reqCtx, nil)
+		assert.True(t, errors.As(err, &pe), err)
+		assert.False(t, authn.IsAuthenticationError(err))
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		require.NoError(t, us.DeleteUser(ctx, urn))
+
+		extract := user.NewProvisioner(us, gs, cfg).Wrap(as.ExtractTokenSubject)
+
+		_, _, err := extract(first)
+		var pe *authn.ProvisioningError
+		require.True(t, errors.As(err, &pe), err)
+		assert.Equal(t, "user has been deleted", pe.Reason)
+	})
+
+	t.Run("UnknownAccount", func(t *testing.T) {
+		p := user.NewProvisioner(us, gs, cfg)
+
+		_, err := p.Provision(ctx, map[string]interface{}{"sub": "999"})
+		assert.Error(t, err)
+
+		_, err = us.LoadUser(ctx, "urn:iam::user/999")
+		assert.Error(t, err)
+	})
+}
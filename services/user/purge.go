@@ -0,0 +1,33 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// RunPurger purges users that have been soft-deleted for longer than
+// retention every interval until ctx is cancelled. Purged users are
+// logged to logger.
+func RunPurger(ctx context.Context, s Service, interval, retention time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := s.PurgeDeletedUsers(ctx, time.Now().Add(-retention))
+		for _, urn := range purged {
+			level.Info(logger).Log("msg", "purged deleted user", "urn", urn)
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to purge deleted users", "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+const (
+	// DefaultScanAhead is the default value for ReconcileOptions.ScanAhead.
+	DefaultScanAhead = 10
+
+	// MaxScanAhead is the maximum value for ReconcileOptions.ScanAhead.
+	// Larger values are capped.
+	MaxScanAhead = 1000
+)
+
+// ReconcileOptions configures a reconciliation run.
+type ReconcileOptions struct {
+	// Adopt creates IAM users for all authn-server accounts that
+	// are not yet managed by IAM.
+	Adopt bool `json:"adopt"`
+
+	// ScanAhead configures how many consecutive account IDs above the
+	// highest known account ID are probed on authn-server before the search
+	// for unmanaged accounts stops. authn-server does not provide a way to
+	// list accounts and assigns them sequential IDs so probing is the only
+	// way to detect them. Defaults to DefaultScanAhead and is capped at
+	// MaxScanAhead. Set to a negative value to disable the search for
+	// unmanaged accounts.
+	ScanAhead int `json:"scanAhead"`
+}
+
+// ReconcileReport describes the changes and inconsistencies detected
+// during a reconciliation run.
+// swagger:model reconcileReport
+type ReconcileReport struct {
+	// Updated holds all users whose lock state has been updated.
+	Updated []iam.UserURN `json:"updated,omitempty"`
+
+	// Deleted holds all users that have been soft-deleted because their
+	// authn-server account has been archived. They are removed by
+	// PurgeDeletedUsers.
+	Deleted []iam.UserURN `json:"deleted,omitempty"`
+
+	// Orphaned holds all users that don't have an authn-server account.
+	Orphaned []iam.UserURN `json:"orphaned,omitempty"`
+
+	// Unmanaged holds the IDs of all authn-server accounts that don't
+	// have an IAM user.
+	Unmanaged []int `json:"unmanaged,omitempty"`
+
+	// Adopted holds all users that have been created for unmanaged
+	// authn-server accounts.
+	Adopted []iam.UserURN `json:"adopted,omitempty"`
+
+	// Failed holds an error message for each user or account that could
+	// not be reconciled.
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+func (r *ReconcileReport) fail(what string, err error) {
+	if r.Failed == nil {
+		r.Failed = make(map[string]string)
+	}
+	r.Failed[what] = err.Error()
+}
+
+func (s *service) Reconcile(ctx context.Context, opts ReconcileOptions) (ReconcileReport, error) {
+	var report ReconcileReport
+
+	if opts.ScanAhead == 0 {
+		opts.ScanAhead = DefaultScanAhead
+	}
+	if opts.ScanAhead > MaxScanAhead {
+		opts.ScanAhead = MaxScanAhead
+	}
+
+	// authn-server is queried without holding s.m so other calls are
+	// not blocked for the whole run. Each user is re-loaded and checked
+	// for concurrent modifications before it is updated.
+	if !s.m.TryLock(ctx) {
+		return report, ctx.Err()
+	}
+	users, err := s.repo.Get(ctx)
+	s.m.Unlock()
+	if err != nil {
+		return report, err
+	}
+
+	known := make(map[int]bool, len(users))
+	highestID := 0
+
+	for _, u := range users {
+		known[u.AccountID] = true
+		if u.AccountID > highestID {
+			highestID = u.AccountID
+		}
+
+		// soft-deleted users are locked on purpose and are only
+		// removed once they are purged.
+		if u.DeletedAt != nil {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		account, err := s.authn.GetAccount(ctx, u.AccountID)
+		if common.IsNotFound(err) {
+			report.Orphaned = append(report.Orphaned, u.ID)
+			continue
+		}
+		if common.IsUnavailable(err) {
+			// all remaining users would fail as well.
+			return report, err
+		}
+		if err != nil {
+			report.fail(string(u.ID), err)
+			continue
+		}
+
+		if account.Deleted {
+			if err := s.reconcileUser(ctx, u, s.markArchived); err != nil {
+				report.fail(string(u.ID), err)
+				continue
+			}
+			report.Deleted = append(report.Deleted, u.ID)
+			continue
+		}
+
+		if u.Locked == nil || *u.Locked != account.Locked {
+			locked := account.Locked
+			err := s.reconcileUser(ctx, u, func(ctx context.Context, user iam.User) error {
+				user.Locked = &locked
+				return s.store(ctx, user)
+			})
+			if err != nil {
+				report.fail(string(u.ID), err)
+				continue
+			}
+			report.Updated = append(report.Updated, u.ID)
+		}
+	}
+
+	misses := 0
+	for id := 1; opts.ScanAhead > 0 && (id <= highestID || misses < opts.ScanAhead); id++ {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		if known[id] {
+			continue
+		}
+
+		account, err := s.authn.GetAccount(ctx, id)
+		if common.IsUnavailable(err) {
+			return report, err
+		}
+		if err != nil {
+			// other errors count as misses so the scan ends even
+			// if authn-server keeps failing.
+			if !common.IsNotFound(err) {
+				report.fail(fmt.Sprintf("account/%d", id), err)
+			}
+			if id > highestID {
+				misses++
+			}
+			continue
+		}
+		misses = 0
+
+		if account.Deleted {
+			continue
+		}
+
+		report.Unmanaged = append(report.Unmanaged, id)
+
+		if opts.Adopt {
+			urn, err := s.adopt(ctx, id, account)
+			if err != nil {
+				report.fail(string(urn), err)
+				continue
+			}
+			report.Adopted = append(report.Adopted, urn)
+		}
+	}
+
+	return report, nil
+}
+
+// reconcileUser re-loads snapshot while holding s.m and calls fn with
+// the current user. common.ConflictError is returned if the user has
+// been modified since snapshot was loaded.
+func (s *service) reconcileUser(ctx context.Context, snapshot iam.User, fn func(context.Context, iam.User) error) error {
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	user, err := s.repo.Load(ctx, snapshot.ID)
+	if err != nil {
+		return err
+	}
+
+	if user.Version != snapshot.Version {
+		return common.NewConflictError("version")
+	}
+
+	return fn(ctx, user)
+}
+
+// markArchived soft-deletes user because its authn-server account has
+// been archived. Like DeleteUser, the user is kept until it is purged.
+// The caller must hold s.m.
+func (s *service) markArchived(ctx context.Context, user iam.User) error {
+	now := time.Now()
+	user.DeletedAt = &now
+	if err := s.store(ctx, user); err != nil {
+		return err
+	}
+
+	return s.revokeTokens(ctx, user)
+}
+
+// adopt creates the IAM user for the unmanaged authn-server account
+// unless it has been created in the meantime.
+func (s *service) adopt(ctx context.Context, id int, account authn.Account) (iam.UserURN, error) {
+	urn := iam.UserURN(fmt.Sprintf("urn:iam::user/%d", id))
+
+	if !s.m.TryLock(ctx) {
+		return urn, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	_, err := s.repo.Load(ctx, urn)
+	if err == nil {
+		return urn, common.NewConflictError("user")
+	}
+	if !common.IsNotFound(err) && !os.IsNotExist(err) {
+		return urn, err
+	}
+
+	if err := s.checkUsername(ctx, urn, account.Username); err != nil {
+		return urn, err
+	}
+
+	locked := account.Locked
+	return urn, s.store(ctx, iam.User{
+		AccountID: id,
+		Username:  account.Username,
+		ID:        urn,
+		Locked:    &locked,
+	})
+}
+
+// RunReconciler calls s.Reconcile every interval until ctx is cancelled.
+// Each run is aborted if it does not complete within interval. The
+// results of each run are logged to logger.
+func RunReconciler(ctx context.Context, s Service, interval time.Duration, opts ReconcileOptions, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		runCtx, cancel := context.WithTimeout(ctx, interval)
+		report, err := s.Reconcile(runCtx, opts)
+		cancel()
+		if err != nil {
+			level.Error(logger).Log("msg", "reconciliation failed", "err", err)
+		} else {
+			for _, urn := range report.Orphaned {
+				level.Warn(logger).Log("msg", "user has no authn-server account", "urn", urn)
+			}
+			for _, id := range report.Unmanaged {
+				level.Warn(logger).Log("msg", "authn-server account not managed by IAM", "accountID", id)
+			}
+			for what, msg := range report.Failed {
+				level.Warn(logger).Log("msg", "failed to reconcile", "resource", what, "err", msg)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/mutex"
 )
@@ -19,29 +23,114 @@ import (
 // a Service method
 var ErrInvalidArgument = common.NewInvalidArgumentError("invalid argument")
 
+// ErrNotDeleted is returned when a user that has not been soft-deleted
+// should be restored.
+var ErrNotDeleted = common.NewInvalidArgumentError("user is not deleted")
+
+// ErrAccountArchived is returned when a user cannot be restored
+// because its authn-server account has been archived.
+var ErrAccountArchived = common.NewConflictError("archived account")
+
+// ErrUsernameTaken is returned by ProvisionUser if the username is
+// already used by another user.
+var ErrUsernameTaken = common.NewConflictError("username")
+
+// ErrUserDeleted is returned by ProvisionUser if the user has been
+// soft-deleted or its authn-server account has been archived.
+var ErrUserDeleted = common.NewNotFoundError("user")
+
 // OnDeleteFunc is a callback function that is invoked when a user is deleted.
 // See Service.OnDelete() for more information.
 type OnDeleteFunc func(urn iam.UserURN)
 
+// TokenRevoker revokes access tokens. It is implemented by
+// authn.RevocationList.
+type TokenRevoker interface {
+	// RevokeSubject revokes all tokens that have been issued to
+	// subject before notBefore.
+	RevokeSubject(ctx context.Context, subject string, notBefore time.Time) error
+
+	// RevokeToken revokes the token with the given ID ("jti" claim).
+	// If expires is zero, the maximum token lifetime is assumed.
+	RevokeToken(ctx context.Context, tokenID string, expires time.Time) error
+}
+
 // Service is the interface that provides user management methods.
+// Methods that modify the stored user return
+// common.PreconditionFailedError if the context requires a version
+// other than the current one (see iam.WithIfMatch).
 type Service interface {
 	// CreateUser creates a new user account in the user management system and returns
 	// the new unique user URN.
 	CreateUser(ctx context.Context, username, password string, attrs map[string]interface{}) (iam.UserURN, error)
 
-	// LoadUser returns the read model of a user.
+	// LoadUser returns the read model of a user. Soft-deleted users
+	// are not found by LoadUser and all other methods operating on a
+	// single user except RestoreUser and PurgeUser.
 	LoadUser(ctx context.Context, urn iam.UserURN) (iam.User, error)
 
-	// DeleteUser deletes the user account from IAM and archives it
-	// on authn-server
+	// LoadUserByUsername returns the read model of the user with the
+	// given username.
+	LoadUserByUsername(ctx context.Context, username string) (iam.User, error)
+
+	// LoadUserByAttr returns the read model of the only user that has the
+	// attribute key set to value. Values are compared like the attribute
+	// filters of iam.UserQuery. If more than one user matches
+	// common.ConflictError is returned.
+	LoadUserByAttr(ctx context.Context, key, value string) (iam.User, error)
+
+	// DeleteUser soft-deletes the user. The authn-server account is
+	// locked and all access tokens are revoked but the user including
+	// its attributes and group memberships is kept until it is restored
+	// or purged. Soft-deleted users are only listed by QueryUsers if
+	// iam.UserQuery.Deleted is set.
 	DeleteUser(ctx context.Context, urn iam.UserURN) error
 
-	// LockUser locks or unlocks a user account
+	// RestoreUser restores a soft-deleted user and unlocks its
+	// authn-server account unless the user had been locked before.
+	// ErrAccountArchived is returned if the authn-server account has
+	// been archived in the meantime.
+	RestoreUser(ctx context.Context, urn iam.UserURN) error
+
+	// PurgeUser deletes the user account from IAM and archives it on
+	// authn-server. The user does not need to be soft-deleted first.
+	PurgeUser(ctx context.Context, urn iam.UserURN) error
+
+	// PurgeDeletedUsers purges all users that have been soft-deleted
+	// before t and returns their URNs.
+	PurgeDeletedUsers(ctx context.Context, t time.Time) ([]iam.UserURN, error)
+
+	// LockUser locks or unlocks a user account. Locking a user revokes
+	// all access tokens issued to the user.
 	LockUser(ctx context.Context, urn iam.UserURN, locked bool) error
 
-	// Users returns the read model of all available users.
+	// RevokeTokens revokes all access tokens that have been issued to
+	// the user so far.
+	RevokeTokens(ctx context.Context, urn iam.UserURN) error
+
+	// RevokeToken revokes a single access token by its ID ("jti"
+	// claim).
+	RevokeToken(ctx context.Context, tokenID string) error
+
+	// SetUsername changes the username of a user account on authn-server
+	// and IAM.
+	SetUsername(ctx context.Context, urn iam.UserURN, username string) error
+
+	// SetPassword replaces the password of a user account.
+	SetPassword(ctx context.Context, urn iam.UserURN, password string) error
+
+	// ExpirePassword expires the password of a user account. The user
+	// is required to choose a new password during the next login.
+	ExpirePassword(ctx context.Context, urn iam.UserURN) error
+
+	// Users returns the read model of all users that have not been
+	// soft-deleted.
 	Users(ctx context.Context) ([]iam.User, error)
 
+	// QueryUsers returns a page of users matching q. Pass the
+	// NextCursor of the returned page as q.Cursor to continue.
+	QueryUsers(ctx context.Context, q iam.UserQuery) (iam.UserPage, error)
+
 	// UpdateAttrs replaces all user attributes from user `id` with `attrs`.
 	UpdateAttrs(ctx context.Context, id iam.UserURN, attrs map[string]interface{}) error
 
@@ -51,16 +140,37 @@ type Service interface {
 	// DeleteAttr deletes the attr key from the user identified by `id`
 	DeleteAttr(ctx context.Context, id iam.UserURN, key string) error
 
+	// PatchAttrs applies patch to the attributes of the user identified
+	// by id. The patch is applied to the current attributes while holding
+	// the service lock so concurrent updates are not lost. If the patch
+	// fails, the attributes are not modified.
+	PatchAttrs(ctx context.Context, id iam.UserURN, patch iam.AttrPatch) error
+
 	// OnDelete registers a callback function that is invoked whenever a user
 	// is deleted/archived. Note that the callback function *may* be unregistered
 	// when the provided context is cancelled.
 	OnDelete(ctx context.Context, fn OnDeleteFunc)
+
+	// Reconcile synchronizes the lock and deleted state of all users with
+	// their authn-server accounts and reports users and accounts that
+	// exist on only one side. See ReconcileOptions for more information.
+	Reconcile(ctx context.Context, opts ReconcileOptions) (ReconcileReport, error)
+
+	// ProvisionUser creates the IAM user for an existing authn-server
+	// account unless it exists already. If username is empty, the username
+	// of the authn-server account is used. If the user exists and sync is
+	// set, username and attrs are merged into the existing user. The
+	// returned bool reports whether the user has been created.
+	// ErrUsernameTaken is returned if the username is used by another
+	// user and ErrUserDeleted if the user has been deleted.
+	ProvisionUser(ctx context.Context, accountID int, username string, attrs map[string]interface{}, sync bool) (iam.User, bool, error)
 }
 
 type service struct {
-	authn authn.Service
-	m     *mutex.Mutex
-	repo  iam.UserRepository
+	authn   authn.Service
+	m       *mutex.Mutex
+	repo    iam.UserRepository
+	revoker TokenRevoker
 
 	deleteFnsLock sync.RWMutex
 	deleteFns     map[int64]OnDeleteFunc
@@ -72,14 +182,23 @@ func (s *service) CreateUser(ctx context.Context, username, password string, att
 	}
 	defer s.m.Unlock()
 
-	accountID, err := s.authn.ImportAccount(username, password, false)
+	if err := s.checkUsername(ctx, "", username); err != nil {
+		return "", err
+	}
+	if err := s.checkUnique(ctx, "", attrs); err != nil {
+		return "", err
+	}
+
+	accountID, err := s.authn.ImportAccount(ctx, username, password, false)
 	if err != nil {
 		return "", err
 	}
 	defer func() {
 		if err != nil {
 			// TODO(ppacher): log that!
-			s.authn.ArchiveAccount(accountID)
+			// ctx might already be cancelled so make sure we still
+			// get the chance to roll back.
+			s.authn.ArchiveAccount(context.Background(), accountID)
 		}
 	}()
 
@@ -100,7 +219,7 @@ func (s *service) CreateUser(ctx context.Context, username, password string, att
 		Attributes: attrs,
 	}
 
-	return urn, s.repo.Store(ctx, user)
+	return urn, s.store(ctx, user)
 }
 
 func (s *service) LoadUser(ctx context.Context, urn iam.UserURN) (iam.User, error) {
@@ -112,7 +231,130 @@ func (s *service) LoadUser(ctx context.Context, urn iam.UserURN) (iam.User, erro
 	}
 	defer s.m.Unlock()
 
-	return s.repo.Load(ctx, urn)
+	return s.loadUser(ctx, urn)
+}
+
+// loadUser loads the user identified by urn. Soft-deleted users are
+// reported as not found. The caller must hold s.m.
+func (s *service) loadUser(ctx context.Context, urn iam.UserURN) (iam.User, error) {
+	user, err := s.repo.Load(ctx, urn)
+	if err != nil {
+		return iam.User{}, err
+	}
+
+	if user.DeletedAt != nil {
+		return iam.User{}, common.NewNotFoundError(string(urn))
+	}
+
+	return user, nil
+}
+
+// loadUserForUpdate is like loadUser but returns
+// common.PreconditionFailedError if the user does not have the version
+// required by ctx (see iam.WithIfMatch). The caller must hold s.m.
+func (s *service) loadUserForUpdate(ctx context.Context, urn iam.UserURN) (iam.User, error) {
+	user, err := s.loadUser(ctx, urn)
+	if err != nil {
+		return iam.User{}, err
+	}
+
+	if err := iam.CheckVersion(ctx, "user", user.Metadata); err != nil {
+		return iam.User{}, err
+	}
+
+	return user, nil
+}
+
+func (s *service) LoadUserByUsername(ctx context.Context, username string) (iam.User, error) {
+	if username == "" {
+		return iam.User{}, ErrInvalidArgument
+	}
+	if !s.m.TryLock(ctx) {
+		return iam.User{}, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	user, err := s.repo.LoadByUsername(ctx, username)
+	if err != nil {
+		return iam.User{}, err
+	}
+
+	if user.DeletedAt != nil {
+		return iam.User{}, common.NewNotFoundError("user")
+	}
+
+	return user, nil
+}
+
+func (s *service) LoadUserByAttr(ctx context.Context, key, value string) (iam.User, error) {
+	if key == "" {
+		return iam.User{}, ErrInvalidArgument
+	}
+	if !s.m.TryLock(ctx) {
+		return iam.User{}, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	page, err := s.repo.Query(ctx, iam.UserQuery{
+		Attributes: map[string]string{key: value},
+		Limit:      2,
+	})
+	if err != nil {
+		return iam.User{}, err
+	}
+
+	switch len(page.Users) {
+	case 0:
+		return iam.User{}, common.NewNotFoundError("user")
+	case 1:
+		return page.Users[0], nil
+	default:
+		return iam.User{}, common.NewConflictError(key)
+	}
+}
+
+// checkUsername returns a conflict error if username is used by a user
+// other than urn. Usernames of soft-deleted users stay reserved until
+// they are purged. The caller must hold s.m.
+func (s *service) checkUsername(ctx context.Context, urn iam.UserURN, username string) error {
+	owner, err := s.repo.LoadByUsername(ctx, username)
+	if err == nil && owner.ID != urn {
+		return common.NewConflictError("username")
+	}
+	if err != nil && !common.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// checkUnique returns common.ConflictError if an attribute in attrs
+// that is unique according to ctx (see withUniqueAttrs) is already used
+// by a user other than urn. The caller must hold s.m.
+func (s *service) checkUnique(ctx context.Context, urn iam.UserURN, attrs map[string]interface{}) error {
+	unique, _ := ctx.Value(uniqueAttrsKey{}).([]string)
+
+	for _, key := range unique {
+		value, ok := attrs[key]
+		if !ok || value == nil {
+			continue
+		}
+
+		page, err := s.repo.Query(ctx, iam.UserQuery{
+			Attributes: map[string]string{key: fmt.Sprint(value)},
+			Limit:      2,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, owner := range page.Users {
+			if owner.ID != urn {
+				return common.NewConflictError(key)
+			}
+		}
+	}
+
+	return nil
 }
 
 func (s *service) Users(ctx context.Context) ([]iam.User, error) {
@@ -121,7 +363,28 @@ func (s *service) Users(ctx context.Context) ([]iam.User, error) {
 	}
 	defer s.m.Unlock()
 
-	return s.repo.Get(ctx)
+	all, err := s.repo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users := all[:0]
+	for _, u := range all {
+		if u.DeletedAt == nil {
+			users = append(users, u)
+		}
+	}
+
+	return users, nil
+}
+
+func (s *service) QueryUsers(ctx context.Context, q iam.UserQuery) (iam.UserPage, error) {
+	if !s.m.TryLock(ctx) {
+		return iam.UserPage{}, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	return s.repo.Query(ctx, q)
 }
 
 func (s *service) DeleteUser(ctx context.Context, urn iam.UserURN) error {
@@ -134,15 +397,126 @@ func (s *service) DeleteUser(ctx context.Context, urn iam.UserURN) error {
 	}
 	defer s.m.Unlock()
 
+	user, err := s.loadUserForUpdate(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	if err := s.authn.LockAccount(ctx, user.AccountID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user.DeletedAt = &now
+	if err := s.store(ctx, user); err != nil {
+		return err
+	}
+
+	return s.revokeTokens(ctx, user)
+}
+
+func (s *service) RestoreUser(ctx context.Context, urn iam.UserURN) error {
+	if urn == "" {
+		return ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	user, err := s.repo.Load(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	if err := iam.CheckVersion(ctx, "user", user.Metadata); err != nil {
+		return err
+	}
+
+	if user.DeletedAt == nil {
+		return ErrNotDeleted
+	}
+
+	account, err := s.authn.GetAccount(ctx, user.AccountID)
+	if common.IsNotFound(err) || (err == nil && account.Deleted) {
+		return ErrAccountArchived
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Locked == nil || !*user.Locked {
+		if err := s.authn.UnlockAccount(ctx, user.AccountID); err != nil {
+			return err
+		}
+	}
+
+	user.DeletedAt = nil
+	return s.store(ctx, user)
+}
+
+func (s *service) PurgeUser(ctx context.Context, urn iam.UserURN) error {
+	if urn == "" {
+		return ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
 	user, err := s.repo.Load(ctx, urn)
 	if err != nil {
 		return err
 	}
 
-	if err := s.authn.ArchiveAccount(user.AccountID); err != nil {
+	if err := iam.CheckVersion(ctx, "user", user.Metadata); err != nil {
+		return err
+	}
+
+	return s.purgeUser(ctx, user)
+}
+
+func (s *service) PurgeDeletedUsers(ctx context.Context, t time.Time) ([]iam.UserURN, error) {
+	if !s.m.TryLock(ctx) {
+		return nil, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	page, err := s.repo.Query(ctx, iam.UserQuery{Deleted: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []iam.UserURN
+	for _, u := range page.Users {
+		if !u.DeletedAt.Before(t) {
+			continue
+		}
+
+		if err := s.purgeUser(ctx, u); err != nil {
+			return purged, err
+		}
+		purged = append(purged, u.ID)
+	}
+
+	return purged, nil
+}
+
+// purgeUser archives the authn-server account of user and deletes
+// it. The caller must hold s.m.
+func (s *service) purgeUser(ctx context.Context, user iam.User) error {
+	if err := s.authn.ArchiveAccount(ctx, user.AccountID); err != nil && !common.IsNotFound(err) {
 		return err
 	}
 
+	return s.deleteUser(ctx, user.ID)
+}
+
+// deleteUser notifies all on-delete subscribers and removes
+// the user from the repository. The caller must hold s.m.
+func (s *service) deleteUser(ctx context.Context, urn iam.UserURN) error {
 	// notify all on-delete subscribes even
 	// if the actual delete operation fails
 	s.deleteFnsLock.RLock()
@@ -154,6 +528,16 @@ func (s *service) DeleteUser(ctx context.Context, urn iam.UserURN) error {
 	return s.repo.Delete(ctx, urn)
 }
 
+// store records the actor of ctx as the last actor of user and stores
+// it. Changes made while impersonating a user are attributed to the
+// impersonator. The caller must hold s.m.
+func (s *service) store(ctx context.Context, user iam.User) error {
+	actor, _ := enforcer.Actor(ctx)
+	user.Touch(actor, time.Now())
+
+	return s.repo.Store(ctx, user)
+}
+
 func (s *service) LockUser(ctx context.Context, urn iam.UserURN, locked bool) error {
 	if urn == "" {
 		return ErrInvalidArgument
@@ -164,18 +548,137 @@ func (s *service) LockUser(ctx context.Context, urn iam.UserURN, locked bool) er
 	}
 	defer s.m.Unlock()
 
-	user, err := s.repo.Load(ctx, urn)
+	user, err := s.loadUserForUpdate(ctx, urn)
 	if err != nil {
 		return err
 	}
 
 	if locked {
-		err = s.authn.LockAccount(user.AccountID)
+		err = s.authn.LockAccount(ctx, user.AccountID)
 	} else {
-		err = s.authn.UnlockAccount(user.AccountID)
+		err = s.authn.UnlockAccount(ctx, user.AccountID)
+	}
+	if err != nil {
+		return err
 	}
 
-	return err
+	user.Locked = &locked
+	if err := s.store(ctx, user); err != nil {
+		return err
+	}
+
+	if locked {
+		return s.revokeTokens(ctx, user)
+	}
+
+	return nil
+}
+
+func (s *service) RevokeTokens(ctx context.Context, urn iam.UserURN) error {
+	if urn == "" {
+		return ErrInvalidArgument
+	}
+
+	if s.revoker == nil {
+		return common.ErrNotImplemented
+	}
+
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	user, err := s.loadUser(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	return s.revokeTokens(ctx, user)
+}
+
+func (s *service) RevokeToken(ctx context.Context, tokenID string) error {
+	if tokenID == "" {
+		return ErrInvalidArgument
+	}
+
+	if s.revoker == nil {
+		return common.ErrNotImplemented
+	}
+
+	return s.revoker.RevokeToken(ctx, tokenID, time.Time{})
+}
+
+// revokeTokens revokes all tokens issued to user if s has a
+// TokenRevoker. The caller must hold s.m.
+func (s *service) revokeTokens(ctx context.Context, user iam.User) error {
+	if s.revoker == nil {
+		return nil
+	}
+
+	return s.revoker.RevokeSubject(ctx, strconv.Itoa(user.AccountID), time.Now())
+}
+
+func (s *service) SetUsername(ctx context.Context, urn iam.UserURN, username string) error {
+	if urn == "" || username == "" {
+		return ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	user, err := s.loadUserForUpdate(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkUsername(ctx, urn, username); err != nil {
+		return err
+	}
+
+	if err := s.authn.UpdateUsername(ctx, user.AccountID, username); err != nil {
+		return err
+	}
+
+	user.Username = username
+	return s.store(ctx, user)
+}
+
+func (s *service) SetPassword(ctx context.Context, urn iam.UserURN, password string) error {
+	if urn == "" || password == "" {
+		return ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	user, err := s.loadUser(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	return s.authn.SetPassword(ctx, user.AccountID, password)
+}
+
+func (s *service) ExpirePassword(ctx context.Context, urn iam.UserURN) error {
+	if urn == "" {
+		return ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	user, err := s.loadUser(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	return s.authn.ExpirePassword(ctx, user.AccountID)
 }
 
 func (s *service) UpdateAttrs(ctx context.Context, urn iam.UserURN, attr map[string]interface{}) error {
@@ -188,13 +691,17 @@ func (s *service) UpdateAttrs(ctx context.Context, urn iam.UserURN, attr map[str
 	}
 	defer s.m.Unlock()
 
-	user, err := s.repo.Load(ctx, urn)
+	user, err := s.loadUserForUpdate(ctx, urn)
 	if err != nil {
 		return err
 	}
 
+	if err := s.checkUnique(ctx, urn, attr); err != nil {
+		return err
+	}
+
 	user.Attributes = attr
-	return s.repo.Store(ctx, user)
+	return s.store(ctx, user)
 }
 
 func (s *service) SetAttr(ctx context.Context, urn iam.UserURN, key string, value interface{}) error {
@@ -207,17 +714,21 @@ func (s *service) SetAttr(ctx context.Context, urn iam.UserURN, key string, valu
 	}
 	defer s.m.Unlock()
 
-	user, err := s.repo.Load(ctx, urn)
+	user, err := s.loadUserForUpdate(ctx, urn)
 	if err != nil {
 		return err
 	}
 
+	if err := s.checkUnique(ctx, urn, map[string]interface{}{key: value}); err != nil {
+		return err
+	}
+
 	if user.Attributes == nil {
 		user.Attributes = make(map[string]interface{})
 	}
 
 	user.Attributes[key] = value
-	return s.repo.Store(ctx, user)
+	return s.store(ctx, user)
 }
 
 func (s *service) DeleteAttr(ctx context.Context, urn iam.UserURN, key string) error {
@@ -230,7 +741,7 @@ func (s *service) DeleteAttr(ctx context.Context, urn iam.UserURN, key string) e
 	}
 	defer s.m.Unlock()
 
-	user, err := s.repo.Load(ctx, urn)
+	user, err := s.loadUserForUpdate(ctx, urn)
 	if err != nil {
 		return err
 	}
@@ -240,7 +751,133 @@ func (s *service) DeleteAttr(ctx context.Context, urn iam.UserURN, key string) e
 	}
 
 	delete(user.Attributes, key)
-	return s.repo.Store(ctx, user)
+	return s.store(ctx, user)
+}
+
+func (s *service) PatchAttrs(ctx context.Context, urn iam.UserURN, patch iam.AttrPatch) error {
+	if urn == "" || patch == nil {
+		return ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	user, err := s.loadUserForUpdate(ctx, urn)
+	if err != nil {
+		return err
+	}
+
+	// patches may modify the attributes in place so they must not
+	// be applied to the attributes held by the repository.
+	attrs, err := patch.Apply(iam.CopyAttrs(user.Attributes))
+	if err != nil {
+		return err
+	}
+	if err := s.checkUnique(ctx, urn, attrs); err != nil {
+		return err
+	}
+
+	user.Attributes = attrs
+	return s.store(ctx, user)
+}
+
+func (s *service) ProvisionUser(ctx context.Context, accountID int, username string, attrs map[string]interface{}, sync bool) (iam.User, bool, error) {
+	if accountID <= 0 {
+		return iam.User{}, false, ErrInvalidArgument
+	}
+
+	if !s.m.TryLock(ctx) {
+		return iam.User{}, false, ctx.Err()
+	}
+	defer s.m.Unlock()
+
+	urn := iam.UserURN(fmt.Sprintf("urn:iam::user/%d", accountID))
+
+	user, err := s.repo.Load(ctx, urn)
+	if err == nil {
+		// soft-deleted users are never provisioned again. Their
+		// accounts are locked so they cannot log in anyway.
+		if user.DeletedAt != nil {
+			return iam.User{}, false, ErrUserDeleted
+		}
+
+		if !sync {
+			return user, false, nil
+		}
+
+		changed := false
+		if username != "" && username != user.Username {
+			if err := s.provisionUsername(ctx, urn, username); err != nil {
+				return iam.User{}, false, err
+			}
+			user.Username = username
+			changed = true
+		}
+
+		for key, value := range attrs {
+			if current, ok := user.Attributes[key]; ok && reflect.DeepEqual(current, value) {
+				continue
+			}
+
+			if user.Attributes == nil {
+				user.Attributes = make(map[string]interface{})
+			}
+			user.Attributes[key] = value
+			changed = true
+		}
+
+		if !changed {
+			return user, false, nil
+		}
+
+		return user, false, s.store(ctx, user)
+	}
+	if !common.IsNotFound(err) && !os.IsNotExist(err) {
+		return iam.User{}, false, err
+	}
+
+	account, err := s.authn.GetAccount(ctx, accountID)
+	if err != nil {
+		return iam.User{}, false, err
+	}
+	if account.Deleted {
+		return iam.User{}, false, ErrUserDeleted
+	}
+
+	if username == "" {
+		username = account.Username
+	}
+
+	if err := s.provisionUsername(ctx, urn, username); err != nil {
+		return iam.User{}, false, err
+	}
+
+	locked := account.Locked
+	user = iam.User{
+		AccountID:  accountID,
+		Username:   username,
+		ID:         urn,
+		Locked:     &locked,
+		Attributes: attrs,
+	}
+
+	if err := s.store(ctx, user); err != nil {
+		return iam.User{}, false, err
+	}
+
+	return user, true, nil
+}
+
+// provisionUsername is like checkUsername but returns ErrUsernameTaken
+// if username is used by another user. The caller must hold s.m.
+func (s *service) provisionUsername(ctx context.Context, urn iam.UserURN, username string) error {
+	err := s.checkUsername(ctx, urn, username)
+	if common.IsConflict(err) {
+		return ErrUsernameTaken
+	}
+	return err
 }
 
 func (s *service) OnDelete(ctx context.Context, fn OnDeleteFunc) {
@@ -251,12 +888,14 @@ func (s *service) OnDelete(ctx context.Context, fn OnDeleteFunc) {
 	s.deleteFnsLock.Unlock()
 }
 
-// NewService creates a new user management services
-func NewService(repo iam.UserRepository, authn authn.Service) Service {
+// NewService creates a new user management services. revoker may be nil
+// in which case tokens are not revoked when a user is locked.
+func NewService(repo iam.UserRepository, authn authn.Service, revoker TokenRevoker) Service {
 	return &service{
 		authn:     authn,
 		m:         mutex.New(),
 		repo:      repo,
+		revoker:   revoker,
 		deleteFns: make(map[int64]OnDeleteFunc, 10),
 	}
 }
@@ -4,14 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/tierklinik-dobersberg/identity-server/mocks"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
 
@@ -21,7 +25,7 @@ func setupServiceTestBed() (Service, *userRepoMock, *mocks.AuthnService) {
 	a := mocks.NewAuthnService()
 	r := &userRepoMock{}
 	l := log.NewNopLogger()
-	s := NewService(r, a)
+	s := NewService(r, a, nil)
 
 	return NewLoggingService(l, s), r, a
 }
@@ -43,8 +47,9 @@ func TestService_CreateUser(t *testing.T) {
 			},
 		}
 
+		r.On("LoadByUsername", "admin").Return(iam.User{}, common.NewNotFoundError("user"))
 		r.On("Load", iam.UserURN("urn:iam::user/1")).Once().Return(iam.User{}, common.NewNotFoundError("0"))
-		r.On("Store", expectedUser).Return(nil)
+		r.On("Store", touched(expectedUser)).Return(nil)
 		a.On("ImportAccount", "admin", "password", false).Once().Return(1, nil)
 
 		userUrn, err := svc.CreateUser(bg, "admin", "password", map[string]interface{}{
@@ -63,6 +68,7 @@ func TestService_CreateUser(t *testing.T) {
 
 		svc, r, a := setupServiceTestBed()
 
+		r.On("LoadByUsername", "admin").Return(iam.User{}, common.NewNotFoundError("user"))
 		r.On("Load", iam.UserURN("urn:iam::user/2")).Once().Return(iam.User{}, nil)
 		a.On("ImportAccount", "admin", "password", false).Once().Return(2, nil)
 		a.On("ArchiveAccount", 2).Once().Return(nil)
@@ -73,11 +79,25 @@ func TestService_CreateUser(t *testing.T) {
 		r.AssertExpectations(t)
 	})
 
+	t.Run("Create_UsernameTaken", func(t *testing.T) {
+		t.Parallel()
+
+		svc, r, a := setupServiceTestBed()
+
+		r.On("LoadByUsername", "admin").Once().Return(expectedUser(4), nil)
+
+		_, err := svc.CreateUser(bg, "admin", "password", nil)
+		assert.True(t, common.IsConflict(err))
+		r.AssertExpectations(t)
+		a.AssertNotCalled(t, "ImportAccount", "admin", "password", false)
+	})
+
 	t.Run("Create_UnknownError", func(t *testing.T) {
 		t.Parallel()
 
 		svc, r, a := setupServiceTestBed()
 
+		r.On("LoadByUsername", "admin").Return(iam.User{}, common.NewNotFoundError("user"))
 		r.On("Load", iam.UserURN("urn:iam::user/3")).Once().Return(iam.User{}, errors.New("simulated"))
 		a.On("ImportAccount", "admin", "password", false).Once().Return(3, nil)
 		a.On("ArchiveAccount", 3).Once().Return(nil)
@@ -127,14 +147,18 @@ func TestService_LoadUser(t *testing.T) {
 }
 
 func TestService_DeleteUser(t *testing.T) {
+	isDeleted := mock.MatchedBy(func(u iam.User) bool {
+		return u.ID == "urn:iam::user/10" && u.DeletedAt != nil
+	})
+
 	t.Run("Delete_Sucess", func(t *testing.T) {
 		t.Parallel()
 
 		svc, r, a := setupServiceTestBed()
 
 		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
-		a.On("ArchiveAccount", 10).Once().Return(nil)
-		r.On("Delete", iam.UserURN("urn:iam::user/10")).Once().Return(nil)
+		a.On("LockAccount", 10).Once().Return(nil)
+		r.On("Store", isDeleted).Once().Return(nil)
 
 		assert.NoError(t, svc.DeleteUser(bg, "urn:iam::user/10"))
 		r.AssertExpectations(t)
@@ -163,26 +187,40 @@ func TestService_DeleteUser(t *testing.T) {
 		a.AssertExpectations(t)
 	})
 
-	t.Run("Delete_ArchiveAccount_Failed", func(t *testing.T) {
+	t.Run("Delete_AlreadyDeleted", func(t *testing.T) {
+		t.Parallel()
+
+		svc, r, a := setupServiceTestBed()
+
+		deleted := expectedUser(10)
+		deleted.DeletedAt = &time.Time{}
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(deleted, nil)
+
+		assert.True(t, common.IsNotFound(svc.DeleteUser(bg, "urn:iam::user/10")))
+		r.AssertExpectations(t)
+		a.AssertExpectations(t)
+	})
+
+	t.Run("Delete_LockAccount_Failed", func(t *testing.T) {
 		t.Parallel()
 
 		svc, r, a := setupServiceTestBed()
 		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
-		a.On("ArchiveAccount", 10).Once().Return(errors.New("simulated"))
+		a.On("LockAccount", 10).Once().Return(errors.New("simulated"))
 
 		assert.Error(t, svc.DeleteUser(bg, "urn:iam::user/10"))
 		r.AssertExpectations(t)
 		a.AssertExpectations(t)
 	})
 
-	t.Run("Delete_Delete_Failed", func(t *testing.T) {
+	t.Run("Delete_Store_Failed", func(t *testing.T) {
 		t.Parallel()
 
 		svc, r, a := setupServiceTestBed()
 
 		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
-		a.On("ArchiveAccount", 10).Once().Return(nil)
-		r.On("Delete", iam.UserURN("urn:iam::user/10")).Once().Return(errors.New("delete-failed"))
+		a.On("LockAccount", 10).Once().Return(nil)
+		r.On("Store", isDeleted).Once().Return(errors.New("store-failed"))
 
 		assert.Error(t, svc.DeleteUser(bg, "urn:iam::user/10"))
 		r.AssertExpectations(t)
@@ -190,6 +228,114 @@ func TestService_DeleteUser(t *testing.T) {
 	})
 }
 
+func TestService_RestoreUser(t *testing.T) {
+	deletedUser := func() iam.User {
+		u := expectedUser(10)
+		u.DeletedAt = &time.Time{}
+		return u
+	}
+
+	t.Run("Restore_Success", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(deletedUser(), nil)
+		a.On("GetAccount", 10).Once().Return(authn.Account{ID: 10, Locked: true}, nil)
+		a.On("UnlockAccount", 10).Once().Return(nil)
+		r.On("Store", touched(expectedUser(10))).Once().Return(nil)
+
+		assert.NoError(t, svc.RestoreUser(bg, "urn:iam::user/10"))
+		r.AssertExpectations(t)
+		a.AssertExpectations(t)
+	})
+
+	t.Run("Restore_KeepsLock", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+
+		locked := true
+		u := deletedUser()
+		u.Locked = &locked
+		restored := expectedUser(10)
+		restored.Locked = &locked
+
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(u, nil)
+		a.On("GetAccount", 10).Once().Return(authn.Account{ID: 10, Locked: true}, nil)
+		r.On("Store", touched(restored)).Once().Return(nil)
+
+		assert.NoError(t, svc.RestoreUser(bg, "urn:iam::user/10"))
+		r.AssertExpectations(t)
+		a.AssertExpectations(t)
+	})
+
+	t.Run("Restore_NotDeleted", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+
+		assert.Equal(t, ErrNotDeleted, svc.RestoreUser(bg, "urn:iam::user/10"))
+		r.AssertExpectations(t)
+		a.AssertExpectations(t)
+	})
+
+	t.Run("Restore_AccountArchived", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(deletedUser(), nil)
+		a.On("GetAccount", 10).Once().Return(authn.Account{ID: 10, Deleted: true}, nil)
+
+		assert.Equal(t, ErrAccountArchived, svc.RestoreUser(bg, "urn:iam::user/10"))
+		r.AssertExpectations(t)
+		a.AssertExpectations(t)
+	})
+}
+
+func TestService_PurgeUser(t *testing.T) {
+	t.Run("Purge_Success", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+		a.On("ArchiveAccount", 10).Once().Return(nil)
+		r.On("Delete", iam.UserURN("urn:iam::user/10")).Once().Return(nil)
+
+		assert.NoError(t, svc.PurgeUser(bg, "urn:iam::user/10"))
+		r.AssertExpectations(t)
+		a.AssertExpectations(t)
+	})
+
+	t.Run("Purge_ArchiveAccount_Failed", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+		a.On("ArchiveAccount", 10).Once().Return(errors.New("simulated"))
+
+		assert.Error(t, svc.PurgeUser(bg, "urn:iam::user/10"))
+		r.AssertExpectations(t)
+		a.AssertExpectations(t)
+	})
+
+	t.Run("PurgeDeleted", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+
+		now := time.Now()
+		old := now.Add(-48 * time.Hour)
+		recent := now.Add(-time.Hour)
+
+		u1 := expectedUser(1)
+		u1.DeletedAt = &old
+		u2 := expectedUser(2)
+		u2.DeletedAt = &recent
+
+		r.On("Query", iam.UserQuery{Deleted: true}).Once().Return(iam.UserPage{Users: []iam.User{u1, u2}}, nil)
+		a.On("ArchiveAccount", 1).Once().Return(nil)
+		r.On("Delete", iam.UserURN("urn:iam::user/1")).Once().Return(nil)
+
+		purged, err := svc.PurgeDeletedUsers(bg, now.Add(-24*time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, []iam.UserURN{"urn:iam::user/1"}, purged)
+		r.AssertExpectations(t)
+		a.AssertExpectations(t)
+	})
+}
+
 func TestService_LockUser(t *testing.T) {
 	t.Run("Invalid argument", func(t *testing.T) {
 		svc, _, _ := setupServiceTestBed()
@@ -209,8 +355,14 @@ func TestService_LockUser(t *testing.T) {
 		svc, r, a := setupServiceTestBed()
 		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
 
+		locked := true
+		stored := expectedUser(10)
+		stored.Locked = &locked
+
 		a.On("LockAccount", 10).Once().Return(nil)
+		r.On("Store", touched(stored)).Once().Return(nil)
 		assert.NoError(t, svc.LockUser(bg, "urn:iam::user/10", true))
+		r.AssertExpectations(t)
 	})
 
 	t.Run("LockAccount", func(t *testing.T) {
@@ -225,8 +377,254 @@ func TestService_LockUser(t *testing.T) {
 		svc, r, a := setupServiceTestBed()
 		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
 
+		locked := false
+		stored := expectedUser(10)
+		stored.Locked = &locked
+
+		a.On("UnlockAccount", 10).Once().Return(nil)
+		r.On("Store", touched(stored)).Once().Return(nil)
+		assert.NoError(t, svc.LockUser(bg, "urn:iam::user/10", false))
+		r.AssertExpectations(t)
+	})
+}
+
+type revokerMock struct {
+	mock.Mock
+}
+
+func (r *revokerMock) RevokeSubject(_ context.Context, subject string, notBefore time.Time) error {
+	return r.Called(subject).Error(0)
+}
+
+func (r *revokerMock) RevokeToken(_ context.Context, tokenID string, expires time.Time) error {
+	return r.Called(tokenID, expires).Error(0)
+}
+
+func TestService_RevokeTokens(t *testing.T) {
+	setup := func() (Service, *userRepoMock, *mocks.AuthnService, *revokerMock) {
+		a := mocks.NewAuthnService()
+		r := &userRepoMock{}
+		rv := &revokerMock{}
+		return NewService(r, a, rv), r, a, rv
+	}
+
+	t.Run("Not implemented", func(t *testing.T) {
+		svc, _, _ := setupServiceTestBed()
+		assert.Equal(t, common.ErrNotImplemented, svc.RevokeTokens(bg, "urn:iam::user/10"))
+	})
+
+	t.Run("RevokeTokens", func(t *testing.T) {
+		svc, r, _, rv := setup()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+		rv.On("RevokeSubject", "10").Once().Return(nil)
+
+		assert.NoError(t, svc.RevokeTokens(bg, "urn:iam::user/10"))
+		rv.AssertExpectations(t)
+	})
+
+	t.Run("RevokeToken", func(t *testing.T) {
+		svc, _, _, rv := setup()
+		rv.On("RevokeToken", "jti-1", time.Time{}).Once().Return(nil)
+
+		assert.NoError(t, svc.RevokeToken(bg, "jti-1"))
+		assert.Equal(t, ErrInvalidArgument, svc.RevokeToken(bg, ""))
+		rv.AssertExpectations(t)
+	})
+
+	t.Run("LockUser", func(t *testing.T) {
+		svc, r, a, rv := setup()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Return(expectedUser(10), nil)
+		r.On("Store", mock.Anything).Return(nil)
+		a.On("LockAccount", 10).Once().Return(nil)
 		a.On("UnlockAccount", 10).Once().Return(nil)
+		rv.On("RevokeSubject", "10").Once().Return(errors.New("simulated"))
+
+		assert.Error(t, svc.LockUser(bg, "urn:iam::user/10", true))
+
+		// unlocking must not revoke any tokens
 		assert.NoError(t, svc.LockUser(bg, "urn:iam::user/10", false))
+		rv.AssertExpectations(t)
+	})
+}
+
+func TestService_SetUsername(t *testing.T) {
+	t.Run("Invalid argument", func(t *testing.T) {
+		svc, _, _ := setupServiceTestBed()
+		assert.Error(t, svc.SetUsername(bg, "", "alice"))
+		assert.Error(t, svc.SetUsername(bg, "urn:iam::user/10", ""))
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+		r.On("LoadByUsername", "alice").Once().Return(iam.User{}, common.NewNotFoundError("user"))
+		a.On("UpdateUsername", 10, "alice").Once().Return(common.NewConflictError("username"))
+
+		err := svc.SetUsername(bg, "urn:iam::user/10", "alice")
+		assert.True(t, common.IsConflict(err))
+		r.AssertExpectations(t)
+	})
+
+	t.Run("Taken", func(t *testing.T) {
+		svc, r, _ := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+		r.On("LoadByUsername", "alice").Once().Return(expectedUser(11), nil)
+
+		err := svc.SetUsername(bg, "urn:iam::user/10", "alice")
+		assert.True(t, common.IsConflict(err))
+		r.AssertExpectations(t)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+		r.On("LoadByUsername", "alice").Once().Return(iam.User{}, common.NewNotFoundError("user"))
+
+		stored := expectedUser(10)
+		stored.Username = "alice"
+
+		a.On("UpdateUsername", 10, "alice").Once().Return(nil)
+		r.On("Store", touched(stored)).Once().Return(nil)
+		assert.NoError(t, svc.SetUsername(bg, "urn:iam::user/10", "alice"))
+		r.AssertExpectations(t)
+	})
+}
+
+func TestService_ProvisionUser(t *testing.T) {
+	t.Run("Invalid argument", func(t *testing.T) {
+		svc, _, _ := setupServiceTestBed()
+		_, _, err := svc.ProvisionUser(bg, 0, "alice", nil, false)
+		assert.Error(t, err)
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(iam.User{}, common.NewNotFoundError("10"))
+		a.On("GetAccount", 10).Once().Return(authn.Account{ID: 10, Username: "alice", Locked: true}, nil)
+		r.On("LoadByUsername", "alice").Once().Return(iam.User{}, common.NewNotFoundError("user"))
+
+		locked := true
+		stored := iam.User{
+			AccountID:  10,
+			Username:   "alice",
+			ID:         "urn:iam::user/10",
+			Locked:     &locked,
+			Attributes: map[string]interface{}{"email": "alice@example.com"},
+		}
+		r.On("Store", touched(stored)).Once().Return(nil)
+
+		u, created, err := svc.ProvisionUser(bg, 10, "", map[string]interface{}{"email": "alice@example.com"}, false)
+		assert.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, stored, u)
+		r.AssertExpectations(t)
+		a.AssertExpectations(t)
+	})
+
+	t.Run("Deleted account", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(iam.User{}, common.NewNotFoundError("10"))
+		a.On("GetAccount", 10).Once().Return(authn.Account{ID: 10, Deleted: true}, nil)
+
+		_, _, err := svc.ProvisionUser(bg, 10, "alice", nil, false)
+		assert.Equal(t, ErrUserDeleted, err)
+		assert.True(t, common.IsNotFound(err))
+	})
+
+	t.Run("Soft-deleted user", func(t *testing.T) {
+		svc, r, _ := setupServiceTestBed()
+		deleted := expectedUser(10)
+		now := time.Now()
+		deleted.DeletedAt = &now
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Twice().Return(deleted, nil)
+
+		_, _, err := svc.ProvisionUser(bg, 10, "alice", nil, false)
+		assert.Equal(t, ErrUserDeleted, err)
+
+		_, _, err = svc.ProvisionUser(bg, 10, "alice", nil, true)
+		assert.Equal(t, ErrUserDeleted, err)
+		r.AssertExpectations(t)
+	})
+
+	t.Run("Username taken", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(iam.User{}, common.NewNotFoundError("10"))
+		a.On("GetAccount", 10).Once().Return(authn.Account{ID: 10, Username: "alice"}, nil)
+		r.On("LoadByUsername", "bob").Twice().Return(expectedUser(11), nil)
+
+		_, _, err := svc.ProvisionUser(bg, 10, "bob", nil, false)
+		assert.Equal(t, ErrUsernameTaken, err)
+		assert.True(t, common.IsConflict(err))
+
+		// usernames are checked when syncing as well
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+		_, _, err = svc.ProvisionUser(bg, 10, "bob", nil, true)
+		assert.Equal(t, ErrUsernameTaken, err)
+
+		r.AssertNotCalled(t, "Store", mock.Anything)
+	})
+
+	t.Run("Existing", func(t *testing.T) {
+		svc, r, _ := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+
+		u, created, err := svc.ProvisionUser(bg, 10, "alice", map[string]interface{}{"job": "Vet"}, false)
+		assert.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, expectedUser(10), u)
+		r.AssertExpectations(t)
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		svc, r, _ := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+
+		stored := expectedUser(10)
+		stored.Username = "alice"
+		stored.Attributes["job"] = "Vet"
+		r.On("LoadByUsername", "alice").Once().Return(iam.User{}, common.NewNotFoundError("user"))
+		r.On("Store", touched(stored)).Once().Return(nil)
+
+		_, created, err := svc.ProvisionUser(bg, 10, "alice", map[string]interface{}{"job": "Vet"}, true)
+		assert.NoError(t, err)
+		assert.False(t, created)
+
+		r.AssertExpectations(t)
+
+		// nothing changed so the user is not stored
+		svc, r, _ = setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+
+		_, _, err = svc.ProvisionUser(bg, 10, "", map[string]interface{}{"job": "Developer"}, true)
+		assert.NoError(t, err)
+		r.AssertExpectations(t)
+	})
+}
+
+func TestService_SetPassword(t *testing.T) {
+	t.Run("Invalid argument", func(t *testing.T) {
+		svc, _, _ := setupServiceTestBed()
+		assert.Error(t, svc.SetPassword(bg, "", "secret"))
+		assert.Error(t, svc.SetPassword(bg, "urn:iam::user/10", ""))
+		assert.Error(t, svc.ExpirePassword(bg, ""))
+	})
+
+	t.Run("SetPassword", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+		a.On("SetPassword", 10, "secret").Once().Return(nil)
+
+		assert.NoError(t, svc.SetPassword(bg, "urn:iam::user/10", "secret"))
+		a.AssertExpectations(t)
+	})
+
+	t.Run("ExpirePassword", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Load", iam.UserURN("urn:iam::user/10")).Once().Return(expectedUser(10), nil)
+		a.On("ExpirePassword", 10).Once().Return(errors.New("simulated"))
+
+		assert.Error(t, svc.ExpirePassword(bg, "urn:iam::user/10"))
+		a.AssertExpectations(t)
 	})
 }
 
@@ -259,6 +657,20 @@ func TestService_Users(t *testing.T) {
 	})
 }
 
+func TestService_QueryUsers(t *testing.T) {
+	svc, r, _ := setupServiceTestBed()
+
+	q := iam.UserQuery{UsernamePrefix: "a", Limit: 1}
+	page := iam.UserPage{Users: []iam.User{expectedUser(10)}, NextCursor: "next"}
+
+	r.On("Query", q).Return(page, nil)
+
+	res, err := svc.QueryUsers(bg, q)
+	assert.NoError(t, err)
+	assert.Equal(t, page, res)
+	r.AssertExpectations(t)
+}
+
 func TestService_UpdateAttrs(t *testing.T) {
 	t.Run("UpdateAttr_InvalidArg", func(t *testing.T) {
 		t.Parallel()
@@ -282,6 +694,21 @@ func TestService_UpdateAttrs(t *testing.T) {
 		r.AssertExpectations(t)
 	})
 
+	t.Run("UpdateAttr_VersionMismatch", func(t *testing.T) {
+		t.Parallel()
+
+		svc, r, _ := setupServiceTestBed()
+		urn := iam.UserURN("urn:iam::user/11")
+
+		inputUser := expectedUser(10)
+		inputUser.Version = 3
+
+		r.On("Load", urn).Once().Return(inputUser, nil)
+		err := svc.UpdateAttrs(iam.WithIfMatch(bg, 2), urn, map[string]interface{}{"new": "value"})
+		assert.True(t, common.IsPreconditionFailed(err))
+		r.AssertExpectations(t)
+	})
+
 	t.Run("UpdateAttr_Success", func(t *testing.T) {
 		t.Parallel()
 
@@ -296,12 +723,38 @@ func TestService_UpdateAttrs(t *testing.T) {
 		expectedUser.Attributes = expectedAttrs
 
 		r.On("Load", urn).Once().Return(inputUser, nil)
-		r.On("Store", expectedUser).Once().Return(nil)
+		r.On("Store", touched(expectedUser)).Once().Return(nil)
 
 		err := svc.UpdateAttrs(bg, urn, expectedAttrs)
 		assert.NoError(t, err)
 		r.AssertExpectations(t)
 	})
+
+	t.Run("UpdateAttr_Impersonated", func(t *testing.T) {
+		t.Parallel()
+
+		svc, r, _ := setupServiceTestBed()
+		urn := iam.UserURN("urn:iam::user/11")
+
+		inputUser := expectedUser(10)
+		expectedAttrs := map[string]interface{}{
+			"new": "value",
+		}
+		expectedUser := inputUser
+		expectedUser.Attributes = expectedAttrs
+		expectedUser.CreatedBy = "urn:iam::user/1"
+		expectedUser.UpdatedBy = "urn:iam::user/1"
+
+		r.On("Load", urn).Once().Return(inputUser, nil)
+		r.On("Store", touched(expectedUser)).Once().Return(nil)
+
+		// changes are attributed to the impersonator, not to the
+		// impersonated user.
+		ctx := enforcer.WithActor(enforcer.WithSubject(bg, string(urn)), "urn:iam::user/1")
+		err := svc.UpdateAttrs(ctx, urn, expectedAttrs)
+		assert.NoError(t, err)
+		r.AssertExpectations(t)
+	})
 }
 
 func TestService_SetAttr(t *testing.T) {
@@ -342,7 +795,7 @@ func TestService_SetAttr(t *testing.T) {
 		expectedUser.Attributes = expectedAttrs
 
 		r.On("Load", urn).Once().Return(inputUser, nil)
-		r.On("Store", expectedUser).Once().Return(nil)
+		r.On("Store", touched(expectedUser)).Once().Return(nil)
 
 		err := svc.SetAttr(bg, urn, "new", "value")
 		assert.NoError(t, err)
@@ -364,7 +817,7 @@ func TestService_SetAttr(t *testing.T) {
 		expectedUser.Attributes = expectedAttrs
 
 		r.On("Load", urn).Once().Return(inputUser, nil)
-		r.On("Store", expectedUser).Once().Return(nil)
+		r.On("Store", touched(expectedUser)).Once().Return(nil)
 
 		err := svc.SetAttr(bg, urn, "new", "value")
 		assert.NoError(t, err)
@@ -407,7 +860,7 @@ func TestService_DeleteAttr(t *testing.T) {
 		expectedUser.Attributes = expectedAttrs
 
 		r.On("Load", urn).Once().Return(inputUser, nil)
-		r.On("Store", expectedUser).Once().Return(nil)
+		r.On("Store", touched(expectedUser)).Once().Return(nil)
 
 		err := svc.DeleteAttr(bg, urn, "job")
 		assert.NoError(t, err)
@@ -435,6 +888,60 @@ func TestService_DeleteAttr(t *testing.T) {
 
 }
 
+func TestService_PatchAttrs(t *testing.T) {
+	t.Run("PatchAttrs_InvalidArg", func(t *testing.T) {
+		t.Parallel()
+
+		svc, _, _ := setupServiceTestBed()
+		err := svc.PatchAttrs(bg, "", iam.MergePatch{})
+		assert.Equal(t, ErrInvalidArgument, err)
+
+		err = svc.PatchAttrs(bg, "urn:iam::user/11", nil)
+		assert.Equal(t, ErrInvalidArgument, err)
+	})
+
+	t.Run("PatchAttrs_Failed", func(t *testing.T) {
+		t.Parallel()
+
+		svc, r, _ := setupServiceTestBed()
+		urn := iam.UserURN("urn:iam::user/11")
+
+		inputUser := expectedUser(10)
+		r.On("Load", urn).Once().Return(inputUser, nil)
+
+		err := svc.PatchAttrs(bg, urn, iam.JSONPatch{
+			{Op: "remove", Path: "/job"},
+			{Op: "test", Path: "/job", Value: "Developer"},
+		})
+		assert.True(t, common.IsInvalidArgument(err))
+		assert.Equal(t, "Developer", inputUser.Attributes["job"])
+		r.AssertExpectations(t)
+	})
+
+	t.Run("PatchAttrs_Success", func(t *testing.T) {
+		t.Parallel()
+
+		svc, r, _ := setupServiceTestBed()
+		urn := iam.UserURN("urn:iam::user/11")
+
+		inputUser := expectedUser(10)
+		expectedUser := inputUser
+		expectedUser.Attributes = map[string]interface{}{
+			"address": map[string]interface{}{"city": "Vienna"},
+		}
+
+		r.On("Load", urn).Once().Return(inputUser, nil)
+		r.On("Store", touched(expectedUser)).Once().Return(nil)
+
+		err := svc.PatchAttrs(bg, urn, iam.MergePatch{
+			"job":     nil,
+			"address": map[string]interface{}{"city": "Vienna"},
+		})
+		assert.NoError(t, err)
+		r.AssertExpectations(t)
+	})
+}
+
 func TestService_OnDelete(t *testing.T) {
 	svc, r, a := setupServiceTestBed()
 
@@ -453,11 +960,110 @@ func TestService_OnDelete(t *testing.T) {
 	a.On("ArchiveAccount", 10).Times(2).Return(nil)
 	r.On("Delete", iam.UserURN("urn:iam::user/10")).Times(2).Return(nil)
 
-	assert.NoError(t, svc.DeleteUser(bg, "urn:iam::user/10"))
+	assert.NoError(t, svc.PurgeUser(bg, "urn:iam::user/10"))
 	wg.Wait()
 	assert.Equal(t, iam.UserURN("urn:iam::user/10"), calledWith)
 }
 
+func TestService_Reconcile(t *testing.T) {
+	svc, r, a := setupServiceTestBed()
+
+	locked := true
+	unchanged := expectedUser(1)
+	unchanged.Locked = &locked
+	changed := expectedUser(2)
+	archived := expectedUser(3)
+	orphaned := expectedUser(5)
+
+	a.On("GetAccount", 1).Return(authn.Account{ID: 1, Locked: true}, nil)
+	a.On("GetAccount", 2).Return(authn.Account{ID: 2, Locked: true}, nil)
+	a.On("GetAccount", 3).Return(authn.Account{ID: 3, Deleted: true}, nil)
+	a.On("GetAccount", 4).Return(authn.Account{ID: 4, Username: "unmanaged"}, nil)
+	a.On("GetAccount", 6).Return(authn.Account{ID: 6, Locked: true}, nil)
+	a.On("GetAccount", mock.Anything).Return(authn.Account{}, common.NewNotFoundError("account"))
+
+	// users are re-loaded before they are updated. modified has been
+	// updated since the users were listed and is skipped.
+	modified := expectedUser(6)
+	current := modified
+	current.Version = 1
+	r.On("Get").Return([]iam.User{unchanged, changed, archived, orphaned, modified}, nil)
+	r.On("Load", changed.ID).Return(changed, nil)
+	r.On("Load", archived.ID).Return(archived, nil)
+	r.On("Load", modified.ID).Return(current, nil)
+	r.On("Load", iam.UserURN("urn:iam::user/4")).Return(iam.User{}, common.NewNotFoundError("user"))
+	r.On("LoadByUsername", "unmanaged").Return(iam.User{}, common.NewNotFoundError("user"))
+
+	stored := changed
+	stored.Locked = &locked
+	r.On("Store", touched(stored)).Once().Return(nil)
+
+	// archived accounts are soft-deleted and removed once purged
+	r.On("Store", mock.MatchedBy(func(u iam.User) bool {
+		return u.ID == archived.ID && u.DeletedAt != nil
+	})).Once().Return(nil)
+
+	unmanagedLocked := false
+	r.On("Store", touched(iam.User{
+		AccountID: 4,
+		Username:  "unmanaged",
+		ID:        "urn:iam::user/4",
+		Locked:    &unmanagedLocked,
+	})).Once().Return(nil)
+
+	report, err := svc.Reconcile(bg, ReconcileOptions{Adopt: true, ScanAhead: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, ReconcileReport{
+		Updated:   []iam.UserURN{"urn:iam::user/2"},
+		Deleted:   []iam.UserURN{"urn:iam::user/3"},
+		Orphaned:  []iam.UserURN{"urn:iam::user/5"},
+		Unmanaged: []int{4},
+		Adopted:   []iam.UserURN{"urn:iam::user/4"},
+		Failed:    map[string]string{"urn:iam::user/6": "Detected version conflict"},
+	}, report)
+	r.AssertExpectations(t)
+	r.AssertNotCalled(t, "Delete", archived.ID)
+
+	// accounts 7 and 8 must have been probed before giving up
+	a.AssertCalled(t, "GetAccount", 8)
+	a.AssertNotCalled(t, "GetAccount", 9)
+}
+
+func TestService_Reconcile_Errors(t *testing.T) {
+	t.Run("Unavailable", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Get").Return([]iam.User{expectedUser(1)}, nil)
+		a.On("GetAccount", mock.Anything).Return(authn.Account{}, common.NewUnavailableError("authn-server", errors.New("simulated")))
+
+		// the run is aborted instead of probing accounts forever
+		_, err := svc.Reconcile(bg, ReconcileOptions{})
+		assert.True(t, common.IsUnavailable(err))
+		a.AssertNumberOfCalls(t, "GetAccount", 1)
+	})
+
+	t.Run("Failing accounts", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Get").Return([]iam.User{}, nil)
+		a.On("GetAccount", mock.Anything).Return(authn.Account{}, errors.New("simulated"))
+
+		// other errors count as misses
+		report, err := svc.Reconcile(bg, ReconcileOptions{ScanAhead: 3})
+		assert.NoError(t, err)
+		assert.Len(t, report.Failed, 3)
+		a.AssertNumberOfCalls(t, "GetAccount", 3)
+	})
+
+	t.Run("Scan ahead is capped", func(t *testing.T) {
+		svc, r, a := setupServiceTestBed()
+		r.On("Get").Return([]iam.User{}, nil)
+		a.On("GetAccount", mock.Anything).Return(authn.Account{}, common.NewNotFoundError("account"))
+
+		_, err := svc.Reconcile(bg, ReconcileOptions{ScanAhead: MaxScanAhead * 10})
+		assert.NoError(t, err)
+		a.AssertNumberOfCalls(t, "GetAccount", MaxScanAhead)
+	})
+}
+
 type userRepoMock struct {
 	mock.Mock
 }
@@ -475,11 +1081,36 @@ func (rm *userRepoMock) Load(_ context.Context, urn iam.UserURN) (iam.User, erro
 	return args.Get(0).(iam.User), args.Error(1)
 }
 
+func (rm *userRepoMock) LoadByUsername(_ context.Context, username string) (iam.User, error) {
+	args := rm.Called(username)
+	return args.Get(0).(iam.User), args.Error(1)
+}
+
 func (rm *userRepoMock) Get(_ context.Context) ([]iam.User, error) {
 	args := rm.Called()
 	return args.Get(0).([]iam.User), args.Error(1)
 }
 
+func (rm *userRepoMock) Query(_ context.Context, q iam.UserQuery) (iam.UserPage, error) {
+	args := rm.Called(q)
+	return args.Get(0).(iam.UserPage), args.Error(1)
+}
+
+// touched matches u after the service recorded an update. The update
+// time is ignored.
+func touched(u iam.User) interface{} {
+	return mock.MatchedBy(func(got iam.User) bool {
+		if got.UpdatedAt.IsZero() || got.CreatedAt.IsZero() {
+			return false
+		}
+		if u.CreatedAt.IsZero() {
+			u.CreatedAt = got.CreatedAt
+		}
+		u.UpdatedAt = got.UpdatedAt
+		return reflect.DeepEqual(u, got)
+	})
+}
+
 func expectedUser(id int) iam.User {
 	return iam.User{
 		AccountID: id,
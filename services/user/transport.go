@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
@@ -14,6 +17,7 @@ import (
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
@@ -28,22 +32,63 @@ const (
 	// ActionListUsers allows the subject ot list users.
 	ActionListUsers = "iam:user:list"
 
-	// ActionDeleteUser allows the subject to delete a user.
+	// ActionDeleteUser allows the subject to soft-delete a user.
 	ActionDeleteUser = "iam:user:delete"
 
+	// ActionRestoreUser allows the subject to restore a soft-deleted
+	// user.
+	ActionRestoreUser = "iam:user:restore"
+
+	// ActionPurgeUser allows the subject to permanently delete a user.
+	ActionPurgeUser = "iam:user:purge"
+
 	// ActionLockUnlockUser allows the subject to lock or unlock a user account.
 	ActionLockUnlockUser = "iam:user:lock-unlock"
 
+	// ActionRevokeTokens allows the subject to revoke all access tokens
+	// issued to a user.
+	ActionRevokeTokens = "iam:user:revoke-tokens"
+
+	// ActionRevokeToken allows the subject to revoke a single access
+	// token by its ID.
+	ActionRevokeToken = "iam:user:revoke-token"
+
+	// ActionSetUsername allows the subject to change the username of a
+	// user account.
+	ActionSetUsername = "iam:user:set-username"
+
+	// ActionSetPassword allows the subject to set or expire the password
+	// of a user account.
+	ActionSetPassword = "iam:user:set-password"
+
 	// ActionUpdateUserAttr allows the subject to update a users attributes.
+	// Each attribute key must be writable as well, see ActionWriteAttr.
 	ActionUpdateUserAttr = "iam:user:write-attr"
+
+	// ActionReadAttrPrefix is the prefix of the per-attribute read
+	// action. See ActionReadAttr.
+	ActionReadAttrPrefix = "iam:user:read-attr:"
+
+	// ActionWriteAttrPrefix is the prefix of the per-attribute write
+	// action. See ActionWriteAttr.
+	ActionWriteAttrPrefix = "iam:user:write-attr:"
+
+	// ActionReconcileUsers allows the subject to reconcile users with
+	// authn-server accounts.
+	ActionReconcileUsers = "iam:user:reconcile"
+
+	// ActionImpersonate allows the subject to impersonate a user using
+	// the X-Impersonate-User header. See enforcer.NewImpersonationHandler.
+	ActionImpersonate = enforcer.ActionImpersonate
 )
 
 // MakeHandler returns a http.Handler for the user management service
 func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer.Enforcer, logger log.Logger) http.Handler {
 	opts := []kithttp.ServerOption{
 		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
-		kithttp.ServerErrorEncoder(kithttp.DefaultErrorEncoder),
+		kithttp.ServerErrorEncoder(encodeError),
 		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+		kithttp.ServerBefore(ifMatchToContext),
 	}
 
 	makeEndpoint := func(action string, factory func(s Service) endpoint.Endpoint) endpoint.Endpoint {
@@ -75,6 +120,20 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 		opts...,
 	)
 
+	restoreUserHandler := kithttp.NewServer(
+		makeEndpoint(ActionRestoreUser, makeRestoreUserEndpoint),
+		decodeRestoreUserRequest,
+		encodeStatusOnlyResponse,
+		opts...,
+	)
+
+	purgeUserHandler := kithttp.NewServer(
+		makeEndpoint(ActionPurgeUser, makePurgeUserEndpoint),
+		decodePurgeUserRequest,
+		encodeStatusOnlyResponse,
+		opts...,
+	)
+
 	lockUserHandler := kithttp.NewServer(
 		makeEndpoint(ActionLockUnlockUser, makeLockUserEndpoint),
 		decodeLockUserRequest,
@@ -82,6 +141,41 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 		opts...,
 	)
 
+	revokeTokensHandler := kithttp.NewServer(
+		makeEndpoint(ActionRevokeTokens, makeRevokeTokensEndpoint),
+		decodeRevokeTokensRequest,
+		encodeStatusOnlyResponse,
+		opts...,
+	)
+
+	revokeTokenHandler := kithttp.NewServer(
+		makeEndpoint(ActionRevokeToken, makeRevokeTokenEndpoint),
+		decodeRevokeTokenRequest,
+		encodeStatusOnlyResponse,
+		opts...,
+	)
+
+	setUsernameHandler := kithttp.NewServer(
+		makeEndpoint(ActionSetUsername, makeSetUsernameEndpoint),
+		decodeSetUsernameRequest,
+		encodeStatusOnlyResponse,
+		opts...,
+	)
+
+	setPasswordHandler := kithttp.NewServer(
+		makeEndpoint(ActionSetPassword, makeSetPasswordEndpoint),
+		decodeSetPasswordRequest,
+		encodeStatusOnlyResponse,
+		opts...,
+	)
+
+	expirePasswordHandler := kithttp.NewServer(
+		makeEndpoint(ActionSetPassword, makeExpirePasswordEndpoint),
+		decodeExpirePasswordRequest,
+		encodeStatusOnlyResponse,
+		opts...,
+	)
+
 	listUsersHandler := kithttp.NewServer(
 		makeEndpoint(ActionListUsers, makeListUsersEndpoint),
 		decodeListUserRequest,
@@ -89,6 +183,13 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 		opts...,
 	)
 
+	lookupUserHandler := kithttp.NewServer(
+		makeEndpoint(ActionLoadUser, makeLookupUserEndpoint),
+		decodeLookupUserRequest,
+		encodeResponse,
+		opts...,
+	)
+
 	updateAttrHandler := kithttp.NewServer(
 		makeEndpoint(ActionUpdateUserAttr, makeUpdateAttrsEndpoint),
 		decodeUpdateAttrRequest,
@@ -96,6 +197,13 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 		opts...,
 	)
 
+	patchAttrHandler := kithttp.NewServer(
+		makeEndpoint(ActionUpdateUserAttr, makePatchAttrsEndpoint),
+		decodePatchAttrRequest,
+		encodeStatusOnlyResponse,
+		opts...,
+	)
+
 	setAttrHandler := kithttp.NewServer(
 		makeEndpoint(ActionUpdateUserAttr, makeSetAttrEndpoint),
 		decodeSetAttrRequest,
@@ -110,11 +218,44 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 		opts...,
 	)
 
+	reconcileHandler := kithttp.NewServer(
+		makeEndpoint(ActionReconcileUsers, makeReconcileEndpoint),
+		decodeReconcileRequest,
+		encodeResponse,
+		opts...,
+	)
+
 	r := mux.NewRouter()
 
+	// swagger:route GET /v1/users/?username={username} users lookupUser
+	//
+	// Returns the user account with the given username. If the attr and
+	// value parameters are used instead, the only user with the attribute
+	// attr set to value is returned. 409 is returned if more than one
+	// user matches.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Schemes: http, https
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       200: User
+	r.Handle("/v1/users/", lookupUserHandler).Methods("GET").Queries("username", "{username}")
+	r.Handle("/v1/users/", lookupUserHandler).Methods("GET").Queries("attr", "{attr}", "value", "{value}")
+
 	// swagger:route GET /v1/users/ users listUsers
 	//
-	// List all users accounts stored in IAM.
+	// List users accounts stored in IAM. Results are sorted by username,
+	// accountID, createdAt or updatedAt (sort) and can be filtered by
+	// username prefix (prefix), lock state (locked), group membership
+	// (group), attribute values (attrs.<key>=<value>), the creating and
+	// last updating subject (createdBy, updatedBy) and by time
+	// (createdAfter, createdBefore, updatedAfter, updatedBefore; RFC
+	// 3339). Soft-deleted users are only listed if
+	// deleted=true. If limit is set, the response contains a nextCursor
+	// that must be passed as cursor to fetch the next page.
 	//
 	//     Produces:
 	//     - application/json
@@ -147,10 +288,27 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//       200: User
 	r.Handle("/v1/users/", createUserHandler).Methods("POST")
 
+	// swagger:route POST /v1/users/reconcile users reconcileUsers
+	//
+	// Synchronizes the lock and deleted state of all users with authn-server
+	// and reports users and accounts that exist on only one side.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Schemes: http, https
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       200: reconcileReport
+	r.Handle("/v1/users/reconcile", reconcileHandler).Methods("POST")
+
 	// swagger:route GET /v1/users/{id} user getUser
 	//
 	// Returns a user account identified by it's ID
 	//
+	// The ETag header holds the version of the user. See If-Match.
+	//
 	//     Produces:
 	//	   - application/json
 	//
@@ -167,9 +325,33 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//       200: User
 	r.Handle("/v1/users/{id}", loadUserHandler).Methods("GET")
 
+	// swagger:route DELETE /v1/users/{id}?purge=true user purgeUser
+	//
+	// Permanently deletes a user account from IAM and archives it on
+	// authn-server. Purged users cannot be restored.
+	//
+	//     Schemes: http, https
+	//
+	//     Parameters:
+	//     + name: id
+	//       in: path
+	//       type: number
+	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       202: description:User has been purged successfully
+	//       412: description: The user has been modified.
+	r.Handle("/v1/users/{id}", purgeUserHandler).Methods("DELETE").Queries("purge", "true")
+
 	// swagger:route DELETE /v1/users/{id} user deleteUser
 	//
-	// Deletes a user account from IAM and archives it on authn-server.
+	// Soft-deletes a user account. The authn-server account is locked
+	// but the user including its attributes and group memberships is
+	// kept until it is restored or purged.
 	//
 	//     Schemes: http, https
 	//
@@ -178,12 +360,38 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//       in: path
 	//       type: number
 	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
 	//
 	//     Responses:
 	//       default: body:genericError
 	//       202: description:User has been deleted successfully
+	//       412: description: The user has been modified.
 	r.Handle("/v1/users/{id}", deleteUserHandler).Methods("DELETE")
 
+	// swagger:route POST /v1/users/{id}/restore user restoreUser
+	//
+	// Restores a soft-deleted user account and unlocks it on
+	// authn-server.
+	//
+	//     Schemes: http, https
+	//
+	//     Parameters:
+	//     + name: id
+	//       in: path
+	//       type: number
+	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       202: description:User has been restored successfully
+	//       412: description: The user has been modified.
+	r.Handle("/v1/users/{id}/restore", restoreUserHandler).Methods("POST")
+
 	// swagger:route PUT /v1/users/{id}/locked user lockUser
 	//
 	// Locks a user account.
@@ -195,10 +403,14 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//       in: path
 	//       type: number
 	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
 	//
 	//     Responses:
 	//       default: body:genericError
 	//       202: description:User has been locked successfully
+	//       412: description: The user has been modified.
 	//
 
 	// swagger:route DELETE /v1/users/{id}/locked user lockUser
@@ -212,12 +424,112 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//       in: path
 	//       type: number
 	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
 	//
 	//     Responses:
 	//       default: body:genericError
 	//       202: description:User has been unlocked successfully
+	//       412: description: The user has been modified.
 	r.Handle("/v1/users/{id}/locked", lockUserHandler).Methods("PUT", "DELETE")
 
+	// swagger:route DELETE /v1/users/{id}/tokens user revokeTokens
+	//
+	// Revokes all access tokens that have been issued to a user account.
+	//
+	//     Schemes: http, https
+	//
+	//     Parameters:
+	//     + name: id
+	//       in: path
+	//       type: number
+	//       required: true
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       202: description:All tokens have been revoked successfully
+	r.Handle("/v1/users/{id}/tokens", revokeTokensHandler).Methods("DELETE")
+
+	// swagger:route DELETE /v1/tokens/{jti} user revokeToken
+	//
+	// Revokes a single access token by its ID ("jti" claim).
+	//
+	//     Schemes: http, https
+	//
+	//     Parameters:
+	//     + name: jti
+	//       in: path
+	//       type: string
+	//       required: true
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       202: description:The token has been revoked successfully
+	r.Handle("/v1/tokens/{jti}", revokeTokenHandler).Methods("DELETE")
+
+	// swagger:route PUT /v1/users/{id}/username user setUsername
+	//
+	// Changes the username of a user account on IAM and authn-server.
+	//
+	//     Schemes: http, https
+	//
+	//     Consumes:
+	//     - application/json
+	//
+	//     Parameters:
+	//     + name: id
+	//       in: path
+	//       type: number
+	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       202: description:The username has been changed successfully
+	//       412: description: The user has been modified.
+	r.Handle("/v1/users/{id}/username", setUsernameHandler).Methods("PUT")
+
+	// swagger:route PUT /v1/users/{id}/password user setPassword
+	//
+	// Sets a new password for a user account.
+	//
+	//     Schemes: http, https
+	//
+	//     Consumes:
+	//     - application/json
+	//
+	//     Parameters:
+	//     + name: id
+	//       in: path
+	//       type: number
+	//       required: true
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       202: description:The password has been set successfully
+	r.Handle("/v1/users/{id}/password", setPasswordHandler).Methods("PUT")
+
+	// swagger:route DELETE /v1/users/{id}/password user expirePassword
+	//
+	// Expires the password of a user account. The user is required to
+	// choose a new password during the next login.
+	//
+	//     Schemes: http, https
+	//
+	//     Parameters:
+	//     + name: id
+	//       in: path
+	//       type: number
+	//       required: true
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       202: description:The password has been expired successfully
+	r.Handle("/v1/users/{id}/password", expirePasswordHandler).Methods("DELETE")
+
 	// swagger:route PUT /v1/users/{id}/attrs/ user attributes updateAttributes
 	//
 	// Replaces all attributes of a user account.
@@ -232,12 +544,44 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//       in: path
 	//       type: number
 	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
 	//
 	//     Responses:
 	//       default: body:genericError
 	//       202: description:User attributes have been successfully replaced
+	//       412: description: The user has been modified.
 	r.Handle("/v1/users/{id}/attrs/", updateAttrHandler).Methods("PUT")
 
+	// swagger:route PATCH /v1/users/{id}/attrs/ user attributes patchAttributes
+	//
+	// Applies a JSON merge patch (RFC 7396) or a JSON patch (RFC 6902)
+	// to the attributes of a user account. JSON patch paths may refer
+	// to values nested inside attributes, e.g. /address/city. The patch
+	// is applied as a whole or not at all.
+	//
+	//     Schemes: http, https
+	//
+	//     Consumes:
+	//     - application/merge-patch+json
+	//     - application/json-patch+json
+	//
+	//     Parameters:
+	//     + name: id
+	//       in: path
+	//       type: number
+	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
+	//
+	//     Responses:
+	//       default: body:genericError
+	//       202: description:The patch has been applied successfully
+	//       412: description: The user has been modified.
+	r.Handle("/v1/users/{id}/attrs/", patchAttrHandler).Methods("PATCH")
+
 	// swagger:route PUT /v1/users/{id}/attrs/{key} user attributes setAttribute
 	//
 	// Updates a single user attribute.
@@ -249,10 +593,14 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//       in: path
 	//       type: number
 	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
 	//
 	//     Responses:
 	//       default: body:genericError
 	//       202: description:The user attribute has been successfully stored
+	//       412: description: The user has been modified.
 	r.Handle("/v1/users/{id}/attrs/{key}", setAttrHandler).Methods("PUT")
 
 	// swagger:route DELETE /v1/users/{id}/attrs/{key} user attributes deleteAttribute
@@ -266,10 +614,14 @@ func MakeHandler(s Service, extractor authn.SubjectExtractorFunc, authz enforcer
 	//       in: path
 	//       type: number
 	//       required: true
+	//     + name: If-Match
+	//       in: header
+	//       description: Only apply the change if the ETag of the user matches.
 	//
 	//     Responses:
 	//       default: body:genericError
 	//       202: description:The user attribute has been successfully stored
+	//       412: description: The user has been modified.
 	r.Handle("/v1/users/{id}/attrs/{key}", deleteAttrHandler).Methods("DELETE")
 
 	return r
@@ -292,11 +644,37 @@ func decodeLoadUserRequest(_ context.Context, r *http.Request) (interface{}, err
 	return loadUserRequest{URN: urn}, err
 }
 
+func decodeLookupUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+
+	req := lookupUserRequest{
+		Username: q.Get("username"),
+		Attr:     q.Get("attr"),
+		Value:    q.Get("value"),
+	}
+
+	if (req.Username == "") == (req.Attr == "") {
+		return nil, common.NewInvalidArgumentError("either username or attr must be set")
+	}
+
+	return req, nil
+}
+
 func decodeDeleteUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	urn, err := getURNFromVars(r, "id")
 	return deleteUserRequest{URN: urn}, err
 }
 
+func decodeRestoreUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	urn, err := getURNFromVars(r, "id")
+	return restoreUserRequest{URN: urn}, err
+}
+
+func decodePurgeUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	urn, err := getURNFromVars(r, "id")
+	return purgeUserRequest{URN: urn}, err
+}
+
 func decodeLockUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	urn, err := getURNFromVars(r, "id")
 	if err != nil {
@@ -307,8 +685,154 @@ func decodeLockUserRequest(_ context.Context, r *http.Request) (interface{}, err
 	return lockUserRequest{URN: urn, Locked: locked}, nil
 }
 
+func decodeRevokeTokensRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	urn, err := getURNFromVars(r, "id")
+	return revokeTokensRequest{URN: urn}, err
+}
+
+func decodeRevokeTokenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	jti, ok := vars["jti"]
+	if !ok {
+		return nil, errBadRoute
+	}
+	return revokeTokenRequest{TokenID: jti}, nil
+}
+
+func decodeSetUsernameRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var (
+		req setUsernameRequest
+		err error
+	)
+
+	req.URN, err = getURNFromVars(r, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func decodeSetPasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var (
+		req setPasswordRequest
+		err error
+	)
+
+	req.URN, err = getURNFromVars(r, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func decodeExpirePasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	urn, err := getURNFromVars(r, "id")
+	return expirePasswordRequest{URN: urn}, err
+}
+
+// attrFilterPrefix prefixes query parameters that filter users by
+// attribute values, for example attrs.department=surgery.
+const attrFilterPrefix = "attrs."
+
 func decodeListUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	return listUsersRequest{}, nil
+	var (
+		req listUsersRequest
+		q   = r.URL.Query()
+	)
+
+	f, err := iam.ParseMetadataFilter(q)
+	if err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	req.Query = iam.UserQuery{
+		UsernamePrefix: q.Get("prefix"),
+		SortBy:         iam.UserSortField(q.Get("sort")),
+		Cursor:         q.Get("cursor"),
+		MetadataFilter: f,
+	}
+
+	if v := q.Get("locked"); v != "" {
+		locked, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, common.NewInvalidArgumentError("invalid value for locked")
+		}
+		req.Query.Locked = &locked
+	}
+
+	if v := q.Get("deleted"); v != "" {
+		deleted, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, common.NewInvalidArgumentError("invalid value for deleted")
+		}
+		req.Query.Deleted = deleted
+	}
+
+	if v := q.Get("group"); v != "" {
+		req.Query.Group = iam.GroupURN(v)
+		if !req.Query.Group.IsValid() {
+			req.Query.Group = iam.GroupURN("urn:iam::group/" + v)
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return nil, common.NewInvalidArgumentError("invalid value for limit")
+		}
+		req.Query.Limit = limit
+	}
+
+	for key, values := range q {
+		if !strings.HasPrefix(key, attrFilterPrefix) || len(key) == len(attrFilterPrefix) {
+			continue
+		}
+
+		if req.Query.Attributes == nil {
+			req.Query.Attributes = make(map[string]string)
+		}
+		req.Query.Attributes[strings.TrimPrefix(key, attrFilterPrefix)] = values[0]
+	}
+
+	if err := req.Query.Validate(); err != nil {
+		return nil, common.NewInvalidArgumentError(err.Error())
+	}
+
+	return req, nil
+}
+
+func decodeReconcileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var (
+		req reconcileRequest
+		err error
+		q   = r.URL.Query()
+	)
+
+	if v := q.Get("adopt"); v != "" {
+		req.Adopt, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, ErrInvalidArgument
+		}
+	}
+
+	if v := q.Get("scanAhead"); v != "" {
+		req.ScanAhead, err = strconv.Atoi(v)
+		if err != nil || req.ScanAhead > MaxScanAhead {
+			return nil, ErrInvalidArgument
+		}
+	}
+
+	return req, nil
 }
 
 func decodeUpdateAttrRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -329,6 +853,37 @@ func decodeUpdateAttrRequest(_ context.Context, r *http.Request) (interface{}, e
 	return req, nil
 }
 
+func decodePatchAttrRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var (
+		req patchAttrsRequest
+		err error
+	)
+
+	req.URN, err = getURNFromVars(r, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case iam.MergePatchContentType:
+		var patch iam.MergePatch
+		err = json.NewDecoder(r.Body).Decode(&patch)
+		req.Patch = patch
+	case iam.JSONPatchContentType:
+		var patch iam.JSONPatch
+		err = json.NewDecoder(r.Body).Decode(&patch)
+		req.Patch = patch
+	default:
+		return nil, common.NewInvalidArgumentError(fmt.Sprintf("unsupported patch content type %q", contentType))
+	}
+	if err != nil {
+		return nil, common.NewInvalidArgumentError("invalid patch: " + err.Error())
+	}
+
+	return req, nil
+}
+
 func decodeSetAttrRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var (
 		req setAttrRequest
@@ -392,6 +947,14 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 		return nil
 	}
 
+	if h, ok := response.(kithttp.Headerer); ok {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	return json.NewEncoder(w).Encode(response)
 }
@@ -410,24 +973,56 @@ type genericError struct {
 	Error string `json:"error"`
 }
 
+// ifMatchToContext makes updates conditional if r has an If-Match
+// header (see iam.WithIfMatch).
+func ifMatchToContext(ctx context.Context, r *http.Request) context.Context {
+	if versions, ok := common.ParseIfMatch(r.Header.Get("If-Match")); ok {
+		return iam.WithIfMatch(ctx, versions...)
+	}
+	return ctx
+}
+
 // encode errors from business-logic
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	switch err {
-	case os.ErrNotExist:
+
+	// typed errors (e.g. from pkg/common or authn) may carry
+	// additional headers like WWW-Authenticate.
+	var h kithttp.Headerer
+	if errors.As(err, &h) {
+		for key, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+	}
+
+	var body interface{} = map[string]interface{}{
+		"error": err.Error(),
+	}
+
+	var sc kithttp.StatusCoder
+	switch {
+	case err == os.ErrNotExist:
 		w.WriteHeader(http.StatusNotFound)
-		err = errors.New("resource not found")
-	case os.ErrExist:
+		body = map[string]interface{}{"error": "resource not found"}
+	case err == os.ErrExist:
 		w.WriteHeader(http.StatusConflict)
-		err = errors.New("resource exists")
-	case ErrInvalidArgument:
+		body = map[string]interface{}{"error": "resource exists"}
+	case err == ErrInvalidArgument:
 		w.WriteHeader(http.StatusBadRequest)
+	case errors.As(err, &sc):
+		// typed errors from pkg/common, authn and enforcer
+		// know their status code.
+		w.WriteHeader(sc.StatusCode())
+		if m, ok := sc.(json.Marshaler); ok {
+			body = m
+		}
 	default:
 		w.WriteHeader(http.StatusInternalServerError)
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+
+	json.NewEncoder(w).Encode(body)
 }
 
 func getURNFromVars(r *http.Request, key string) (iam.UserURN, error) {
@@ -2,6 +2,7 @@ package user
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -11,6 +12,8 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/authn"
+	"github.com/tierklinik-dobersberg/identity-server/pkg/common"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/enforcer"
 	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
 )
@@ -115,11 +118,68 @@ func Test_decodeLockUserRequest_Unlocked(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func Test_decodeSetPasswordRequest(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/v1/users/10/password", strings.NewReader(`{"password": "secret"}`))
+	r = mux.SetURLVars(r, map[string]string{"id": "10"})
+
+	req, err := decodeSetPasswordRequest(nil, r)
+	assert.NoError(t, err)
+	assert.Equal(t, setPasswordRequest{URN: "urn:iam::user/10", Password: "secret"}, req)
+
+	r = httptest.NewRequest("PUT", "/v1/users/10/username", strings.NewReader(`{"username": "alice"}`))
+	r = mux.SetURLVars(r, map[string]string{"id": "10"})
+
+	req, err = decodeSetUsernameRequest(nil, r)
+	assert.NoError(t, err)
+	assert.Equal(t, setUsernameRequest{URN: "urn:iam::user/10", Username: "alice"}, req)
+}
+
 func Test_decodeListUserRequest(t *testing.T) {
 	r := httptest.NewRequest("GET", "/v1/users", nil)
 	req, err := decodeListUserRequest(nil, r)
 	assert.NoError(t, err)
 	assert.Equal(t, listUsersRequest{}, req)
+
+	r = httptest.NewRequest("GET", "/v1/users?prefix=al&locked=false&group=vets&attrs.department=surgery&sort=accountID&cursor=AAAAAAAAAAo&limit=10", nil)
+	req, err = decodeListUserRequest(nil, r)
+	assert.NoError(t, err)
+
+	locked := false
+	assert.Equal(t, listUsersRequest{Query: iam.UserQuery{
+		UsernamePrefix: "al",
+		Locked:         &locked,
+		Group:          "urn:iam::group/vets",
+		Attributes:     map[string]string{"department": "surgery"},
+		SortBy:         iam.SortByAccountID,
+		Cursor:         "AAAAAAAAAAo",
+		Limit:          10,
+	}}, req)
+
+	for _, query := range []string{"locked=maybe", "limit=-1", "sort=email", "cursor=!"} {
+		r = httptest.NewRequest("GET", "/v1/users?"+query, nil)
+		_, err = decodeListUserRequest(nil, r)
+		assert.True(t, common.IsInvalidArgument(err), query)
+	}
+}
+
+func Test_decodeReconcileRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/v1/users/reconcile?adopt=true&scanAhead=5", nil)
+	req, err := decodeReconcileRequest(nil, r)
+	assert.NoError(t, err)
+	assert.Equal(t, reconcileRequest{Adopt: true, ScanAhead: 5}, req)
+
+	r = httptest.NewRequest("POST", "/v1/users/reconcile", nil)
+	req, err = decodeReconcileRequest(nil, r)
+	assert.NoError(t, err)
+	assert.Equal(t, reconcileRequest{}, req)
+
+	r = httptest.NewRequest("POST", "/v1/users/reconcile?adopt=maybe", nil)
+	_, err = decodeReconcileRequest(nil, r)
+	assert.Error(t, err)
+
+	r = httptest.NewRequest("POST", "/v1/users/reconcile?scanAhead=1000000", nil)
+	_, err = decodeReconcileRequest(nil, r)
+	assert.Error(t, err)
 }
 
 func Test_decodeUpdateAttrRequest(t *testing.T) {
@@ -209,9 +269,22 @@ func Test_decodeDelAttrRequest(t *testing.T) {
 	assert.Error(t, err) // no user key in mux.Vars
 }
 
+func Test_decodeRevokeTokenRequest(t *testing.T) {
+	r := httptest.NewRequest("DELETE", "/v1/tokens/jti-1", nil)
+	r = mux.SetURLVars(r, map[string]string{"jti": "jti-1"})
+
+	req, err := decodeRevokeTokenRequest(nil, r)
+	assert.NoError(t, err)
+	assert.Equal(t, revokeTokenRequest{TokenID: "jti-1"}, req)
+
+	r = httptest.NewRequest("DELETE", "/v1/tokens/jti-1", nil)
+	_, err = decodeRevokeTokenRequest(nil, r)
+	assert.Error(t, err) // no token ID in mux.Vars
+}
+
 func Test_MakeHandler(t *testing.T) {
 	svc, _, _ := setupServiceTestBed()
-	jwtTokenExtractor := func(string) (string, error) { return "", nil }
+	jwtTokenExtractor := func(string) (string, string, error) { return "", "", nil }
 	r := MakeHandler(svc, jwtTokenExtractor, enforcer.NewNoOpEnforcer(), log.NewNopLogger())
 	assert.NotNil(t, r)
 }
@@ -235,6 +308,38 @@ func Test_encodeError(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 	assert.Equal(t, "{\"error\":\"invalid argument\"}\n", string(w.Body.Bytes()))
 	assert.Equal(t, []string{"application/json; charset=utf-8"}, w.HeaderMap["Content-Type"])
+
+	w = httptest.NewRecorder()
+	encodeError(bg, common.NewNotFoundError("account"), w)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	encodeError(bg, common.NewConflictError("username"), w)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	encodeError(bg, common.NewUnavailableError("authn-server", errors.New("timeout")), w)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "{\"error\":\"authn-server unavailable: timeout\"}\n", string(w.Body.Bytes()))
+
+	w = httptest.NewRecorder()
+	encodeError(bg, authn.ErrMissingToken, w)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="iam"`, w.Header().Get("WWW-Authenticate"))
+
+	w = httptest.NewRecorder()
+	encodeError(bg, fmt.Errorf("wrapped: %w", &authn.AuthenticationError{
+		Code:        authn.ErrorCodeInvalidToken,
+		Description: "token expired",
+		Err:         errors.New("details"),
+	}), w)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="iam", error="invalid_token", error_description="token expired"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, "{\"error\":\"token expired\"}\n", string(w.Body.Bytes()))
+
+	w = httptest.NewRecorder()
+	encodeError(bg, &enforcer.PermissionDeniedError{Reason: "nope"}, w)
+	assert.Equal(t, http.StatusForbidden, w.Code)
 }
 
 func Test_encodeStatusOnlyResponse(t *testing.T) {
@@ -0,0 +1,123 @@
+package user
+
+import (
+	"context"
+
+	"github.com/tierklinik-dobersberg/identity-server/pkg/iam"
+)
+
+// AttributeValidator validates user attributes. It is implemented by
+// the attribute schema service.
+type AttributeValidator interface {
+	// ValidateAttrs validates the complete set of attributes of a user
+	// and returns attrs with defaults applied.
+	ValidateAttrs(ctx context.Context, attrs map[string]interface{}) (map[string]interface{}, error)
+
+	// ValidateAttr validates a single attribute. A nil value validates
+	// that the attribute may be deleted.
+	ValidateAttr(ctx context.Context, key string, value interface{}) error
+
+	// UniqueAttrs returns the names of all attributes whose values
+	// must not be used by more than one user.
+	UniqueAttrs(ctx context.Context) ([]string, error)
+}
+
+type validatingService struct {
+	v AttributeValidator
+	Service
+}
+
+// NewValidatingService returns a service that validates the attributes
+// passed to CreateUser, UpdateAttrs, SetAttr, DeleteAttr and PatchAttrs
+// using v before forwarding the call to s. Values of unique attributes
+// that are already used by another user are rejected with
+// common.ConflictError. Attributes of provisioned users are not
+// validated so logins don't fail because of schema changes.
+func NewValidatingService(s Service, v AttributeValidator) Service {
+	return &validatingService{
+		v:       v,
+		Service: s,
+	}
+}
+
+// unique returns a new context that makes s.Service reject values of
+// unique attributes that are already used by another user. The check
+// is done while s.Service holds its lock so concurrent updates cannot
+// both claim the same value.
+func (s *validatingService) unique(ctx context.Context) (context.Context, error) {
+	unique, err := s.v.UniqueAttrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return withUniqueAttrs(ctx, unique), nil
+}
+
+func (s *validatingService) CreateUser(ctx context.Context, username, password string, attrs map[string]interface{}) (iam.UserURN, error) {
+	attrs, err := s.v.ValidateAttrs(ctx, attrs)
+	if err != nil {
+		return "", err
+	}
+	if ctx, err = s.unique(ctx); err != nil {
+		return "", err
+	}
+	return s.Service.CreateUser(ctx, username, password, attrs)
+}
+
+func (s *validatingService) UpdateAttrs(ctx context.Context, urn iam.UserURN, attrs map[string]interface{}) error {
+	attrs, err := s.v.ValidateAttrs(ctx, attrs)
+	if err != nil {
+		return err
+	}
+	if ctx, err = s.unique(ctx); err != nil {
+		return err
+	}
+	return s.Service.UpdateAttrs(ctx, urn, attrs)
+}
+
+func (s *validatingService) SetAttr(ctx context.Context, urn iam.UserURN, key string, value interface{}) error {
+	if err := s.v.ValidateAttr(ctx, key, value); err != nil {
+		return err
+	}
+	ctx, err := s.unique(ctx)
+	if err != nil {
+		return err
+	}
+	return s.Service.SetAttr(ctx, urn, key, value)
+}
+
+func (s *validatingService) DeleteAttr(ctx context.Context, urn iam.UserURN, key string) error {
+	if err := s.v.ValidateAttr(ctx, key, nil); err != nil {
+		return err
+	}
+	return s.Service.DeleteAttr(ctx, urn, key)
+}
+
+// PatchAttrs validates the attributes resulting from patch before they
+// are stored.
+func (s *validatingService) PatchAttrs(ctx context.Context, urn iam.UserURN, patch iam.AttrPatch) error {
+	if patch == nil {
+		return s.Service.PatchAttrs(ctx, urn, patch)
+	}
+
+	ctx, err := s.unique(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.Service.PatchAttrs(ctx, urn, iam.AttrPatchFunc(func(attrs map[string]interface{}) (map[string]interface{}, error) {
+		attrs, err := patch.Apply(attrs)
+		if err != nil {
+			return nil, err
+		}
+		return s.v.ValidateAttrs(ctx, attrs)
+	}))
+}
+
+type uniqueAttrsKey struct{}
+
+// withUniqueAttrs returns a new context that makes the user service
+// reject values of the attributes keys that are already used by another
+// user (see checkUnique).
+func withUniqueAttrs(ctx context.Context, keys []string) context.Context {
+	return context.WithValue(ctx, uniqueAttrsKey{}, keys)
+}